@@ -0,0 +1,397 @@
+package jsonlotelforwarder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	AttributeTargetResource  = "resource"
+	AttributeTargetScope     = "scope"
+	AttributeTargetSpan      = "span"
+	AttributeTargetDataPoint = "datapoint"
+	AttributeTargetLog       = "log"
+)
+
+var allAttributeTargets = []string{
+	AttributeTargetResource,
+	AttributeTargetScope,
+	AttributeTargetSpan,
+	AttributeTargetDataPoint,
+	AttributeTargetLog,
+}
+
+// AttributeRule is a rule of attributes processor.
+// Action is one of insert, update, upsert and delete.
+// the value is taken from Value, FromEnv (environment variable name) or FromCloudWatch (CloudWatchMetadata name).
+// Type converts the value to string, int, double or bool. without Type, JSON numbers are doubles.
+type AttributeRule struct {
+	Action         string   `json:"action"`
+	Targets        []string `json:"targets"`
+	Key            string   `json:"key"`
+	Value          any      `json:"value,omitempty"`
+	FromEnv        string   `json:"from_env,omitempty"`
+	FromCloudWatch string   `json:"from_cloudwatch,omitempty"`
+	Type           string   `json:"type,omitempty"`
+}
+
+// ParseAttributeRules parses comma separated rules of `action:targets:key[=value]` form,
+// targets are joined by `+`, and the value can be `env:NAME` or `cloudwatch:NAME`.
+// e.g. upsert:resource:deployment.environment=prod,insert:resource+log:team=env:TEAM,delete:span:http.request.header.authorization
+func ParseAttributeRules(s string) ([]*AttributeRule, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var rules []*AttributeRule
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		elems := strings.SplitN(part, ":", 3)
+		if len(elems) != 3 {
+			return nil, fmt.Errorf("invalid attribute rule %q, expected action:targets:key[=value]", part)
+		}
+		rule := &AttributeRule{
+			Action:  elems[0],
+			Targets: strings.Split(elems[1], "+"),
+			Key:     elems[2],
+		}
+		if key, value, ok := strings.Cut(elems[2], "="); ok {
+			rule.Key = key
+			switch {
+			case strings.HasPrefix(value, "env:"):
+				rule.FromEnv = strings.TrimPrefix(value, "env:")
+			case strings.HasPrefix(value, "cloudwatch:"):
+				rule.FromCloudWatch = strings.TrimPrefix(value, "cloudwatch:")
+			default:
+				rule.Value = value
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func (r *AttributeRule) Validate() error {
+	if r.Key == "" {
+		return errors.New("key is required")
+	}
+	switch r.Action {
+	case "insert", "update", "upsert":
+		sources := 0
+		if r.Value != nil {
+			sources++
+		}
+		if r.FromEnv != "" {
+			sources++
+		}
+		if r.FromCloudWatch != "" {
+			sources++
+		}
+		if sources != 1 {
+			return fmt.Errorf("%s %q requires exactly one of value, from_env and from_cloudwatch", r.Action, r.Key)
+		}
+	case "delete":
+	default:
+		return fmt.Errorf("unknown action %q, expected insert, update, upsert or delete", r.Action)
+	}
+	switch r.Type {
+	case "", "string", "int", "double", "bool":
+	default:
+		return fmt.Errorf("unknown type %q, expected string, int, double or bool", r.Type)
+	}
+	if r.Value != nil {
+		if _, err := convertAttributeValue(r.Value, r.Type); err != nil {
+			return fmt.Errorf("%s %q: %w", r.Action, r.Key, err)
+		}
+	}
+	if len(r.Targets) == 0 {
+		return fmt.Errorf("%s %q requires targets", r.Action, r.Key)
+	}
+	for _, target := range r.Targets {
+		if !slices.Contains(allAttributeTargets, target) {
+			return fmt.Errorf("unknown target %q, expected one of %s", target, strings.Join(allAttributeTargets, ","))
+		}
+	}
+	return nil
+}
+
+type AttributesProcessor struct {
+	rules []*AttributeRule
+}
+
+func NewAttributesProcessor(rules []*AttributeRule) (*AttributesProcessor, error) {
+	for i, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return nil, fmt.Errorf("rule[%d]: %w", i, err)
+		}
+	}
+	return &AttributesProcessor{rules: rules}, nil
+}
+
+func (p *AttributesProcessor) Process(ctx context.Context, results []*PaseResult) ([]*PaseResult, error) {
+	for _, result := range results {
+		for _, rule := range p.rules {
+			var value *commonpb.AnyValue
+			if rule.Action != "delete" {
+				var ok bool
+				var err error
+				value, ok, err = rule.lookupValue(result)
+				if err != nil {
+					slog.WarnContext(ctx, "invalid attribute value, skip rule", "action", rule.Action, "key", rule.Key, "error", err)
+					continue
+				}
+				if !ok {
+					slog.DebugContext(ctx, "attribute value not found, skip rule", "action", rule.Action, "key", rule.Key)
+					continue
+				}
+			}
+			for _, target := range rule.Targets {
+				for _, attrs := range AttributesOf(result, target, rule.Action != "delete") {
+					*attrs = applyAttributeRule(*attrs, rule.Action, rule.Key, value)
+				}
+			}
+		}
+	}
+	return results, nil
+}
+
+func (r *AttributeRule) lookupValue(result *PaseResult) (*commonpb.AnyValue, bool, error) {
+	v := r.Value
+	switch {
+	case r.FromEnv != "":
+		env, ok := os.LookupEnv(r.FromEnv)
+		if !ok {
+			return nil, false, nil
+		}
+		v = env
+	case r.FromCloudWatch != "":
+		metadata, ok := result.CloudWatch.Lookup(r.FromCloudWatch)
+		if !ok {
+			return nil, false, nil
+		}
+		v = metadata
+	}
+	v, err := convertAttributeValue(v, r.Type)
+	if err != nil {
+		return nil, false, err
+	}
+	return toAnyValue(v), true, nil
+}
+
+// convertAttributeValue converts the value to the type of the rule, the value is left as is when typ is empty.
+func convertAttributeValue(v any, typ string) (any, error) {
+	switch typ {
+	case "":
+		return v, nil
+	case "string":
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+		return AnyValueString(toAnyValue(v)), nil
+	case "int":
+		switch v := v.(type) {
+		case float64:
+			if v != math.Trunc(v) || math.Abs(v) >= 1<<53 {
+				return nil, fmt.Errorf("%v is not an int", v)
+			}
+			return int64(v), nil
+		case string:
+			return strconv.ParseInt(v, 10, 64)
+		}
+	case "double":
+		switch v := v.(type) {
+		case float64:
+			return v, nil
+		case string:
+			return strconv.ParseFloat(v, 64)
+		}
+	case "bool":
+		switch v := v.(type) {
+		case bool:
+			return v, nil
+		case string:
+			return strconv.ParseBool(v)
+		}
+	}
+	return nil, fmt.Errorf("can not convert %v to %s", v, typ)
+}
+
+func applyAttributeRule(attrs []*commonpb.KeyValue, action string, key string, value *commonpb.AnyValue) []*commonpb.KeyValue {
+	index := slices.IndexFunc(attrs, func(kv *commonpb.KeyValue) bool {
+		return kv.GetKey() == key
+	})
+	// the value is applied to many attributes, so each of them has its own copy to be modified independently.
+	switch action {
+	case "insert":
+		if index == -1 {
+			attrs = append(attrs, &commonpb.KeyValue{Key: key, Value: proto.Clone(value).(*commonpb.AnyValue)})
+		}
+	case "update":
+		if index != -1 {
+			attrs[index].Value = proto.Clone(value).(*commonpb.AnyValue)
+		}
+	case "upsert":
+		if index == -1 {
+			attrs = append(attrs, &commonpb.KeyValue{Key: key, Value: proto.Clone(value).(*commonpb.AnyValue)})
+		} else {
+			attrs[index].Value = proto.Clone(value).(*commonpb.AnyValue)
+		}
+	case "delete":
+		attrs = slices.DeleteFunc(attrs, func(kv *commonpb.KeyValue) bool {
+			return kv.GetKey() == key
+		})
+	}
+	return attrs
+}
+
+// AttributesOf returns pointers to the attribute slices of the target in the result.
+// if create is true, missing resources and scopes are created so that attributes can be added.
+func AttributesOf(result *PaseResult, target string, create bool) []*[]*commonpb.KeyValue {
+	var attrs []*[]*commonpb.KeyValue
+	resourceAttrs := func(resource **resourcepb.Resource) {
+		if *resource == nil {
+			if !create {
+				return
+			}
+			*resource = &resourcepb.Resource{}
+		}
+		attrs = append(attrs, &(*resource).Attributes)
+	}
+	scopeAttrs := func(scope **commonpb.InstrumentationScope) {
+		if *scope == nil {
+			if !create {
+				return
+			}
+			*scope = &commonpb.InstrumentationScope{}
+		}
+		attrs = append(attrs, &(*scope).Attributes)
+	}
+	for _, resourceSpans := range result.Traces.GetResourceSpans() {
+		if target == AttributeTargetResource {
+			resourceAttrs(&resourceSpans.Resource)
+			continue
+		}
+		for _, scopeSpans := range resourceSpans.GetScopeSpans() {
+			switch target {
+			case AttributeTargetScope:
+				scopeAttrs(&scopeSpans.Scope)
+			case AttributeTargetSpan:
+				for _, span := range scopeSpans.GetSpans() {
+					attrs = append(attrs, &span.Attributes)
+				}
+			}
+		}
+	}
+	for _, resourceMetrics := range result.Metrics.GetResourceMetrics() {
+		if target == AttributeTargetResource {
+			resourceAttrs(&resourceMetrics.Resource)
+			continue
+		}
+		for _, scopeMetrics := range resourceMetrics.GetScopeMetrics() {
+			switch target {
+			case AttributeTargetScope:
+				scopeAttrs(&scopeMetrics.Scope)
+			case AttributeTargetDataPoint:
+				for _, metric := range scopeMetrics.GetMetrics() {
+					attrs = append(attrs, dataPointAttributes(metric)...)
+				}
+			}
+		}
+	}
+	for _, resourceLogs := range result.Logs.GetResourceLogs() {
+		if target == AttributeTargetResource {
+			resourceAttrs(&resourceLogs.Resource)
+			continue
+		}
+		for _, scopeLogs := range resourceLogs.GetScopeLogs() {
+			switch target {
+			case AttributeTargetScope:
+				scopeAttrs(&scopeLogs.Scope)
+			case AttributeTargetLog:
+				for _, record := range scopeLogs.GetLogRecords() {
+					attrs = append(attrs, &record.Attributes)
+				}
+			}
+		}
+	}
+	return attrs
+}
+
+func dataPointAttributes(metric *metricspb.Metric) []*[]*commonpb.KeyValue {
+	var attrs []*[]*commonpb.KeyValue
+	switch data := metric.GetData().(type) {
+	case *metricspb.Metric_Gauge:
+		for _, dp := range data.Gauge.GetDataPoints() {
+			attrs = append(attrs, &dp.Attributes)
+		}
+	case *metricspb.Metric_Sum:
+		for _, dp := range data.Sum.GetDataPoints() {
+			attrs = append(attrs, &dp.Attributes)
+		}
+	case *metricspb.Metric_Summary:
+		for _, dp := range data.Summary.GetDataPoints() {
+			attrs = append(attrs, &dp.Attributes)
+		}
+	case *metricspb.Metric_Histogram:
+		for _, dp := range data.Histogram.GetDataPoints() {
+			attrs = append(attrs, &dp.Attributes)
+		}
+	case *metricspb.Metric_ExponentialHistogram:
+		for _, dp := range data.ExponentialHistogram.GetDataPoints() {
+			attrs = append(attrs, &dp.Attributes)
+		}
+	}
+	return attrs
+}
+
+func toAnyValue(v any) *commonpb.AnyValue {
+	switch v := v.(type) {
+	case *commonpb.AnyValue:
+		return v
+	case string:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v}}
+	case bool:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: v}}
+	case int:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: int64(v)}}
+	case int64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: v}}
+	case float64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: v}}
+	case []any:
+		values := make([]*commonpb.AnyValue, 0, len(v))
+		for _, elem := range v {
+			values = append(values, toAnyValue(elem))
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{ArrayValue: &commonpb.ArrayValue{Values: values}}}
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		values := make([]*commonpb.KeyValue, 0, len(v))
+		for _, key := range keys {
+			values = append(values, &commonpb.KeyValue{Key: key, Value: toAnyValue(v[key])})
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_KvlistValue{KvlistValue: &commonpb.KeyValueList{Values: values}}}
+	case nil:
+		return &commonpb.AnyValue{}
+	}
+	return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: fmt.Sprint(v)}}
+}
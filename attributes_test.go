@@ -0,0 +1,151 @@
+package jsonlotelforwarder_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	jsonlotelforwarder "github.com/mashiike/jsonl-otel-forwarder"
+	"github.com/stretchr/testify/require"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+)
+
+func attributesToMap(attrs []*commonpb.KeyValue) map[string]any {
+	m := make(map[string]any, len(attrs))
+	for _, attr := range attrs {
+		switch v := attr.GetValue().GetValue().(type) {
+		case *commonpb.AnyValue_StringValue:
+			m[attr.GetKey()] = v.StringValue
+		case *commonpb.AnyValue_IntValue:
+			m[attr.GetKey()] = v.IntValue
+		case *commonpb.AnyValue_BoolValue:
+			m[attr.GetKey()] = v.BoolValue
+		case *commonpb.AnyValue_DoubleValue:
+			m[attr.GetKey()] = v.DoubleValue
+		default:
+			m[attr.GetKey()] = attr.GetValue().String()
+		}
+	}
+	return m
+}
+
+func TestParseAttributeRules(t *testing.T) {
+	rules, err := jsonlotelforwarder.ParseAttributeRules("upsert:resource:deployment.environment=prod, insert:resource+log:team=env:TEAM,insert:resource:cloud.account.id=cloudwatch:owner,delete:span:secret")
+	require.NoError(t, err)
+	require.Equal(t, []*jsonlotelforwarder.AttributeRule{
+		{Action: "upsert", Targets: []string{"resource"}, Key: "deployment.environment", Value: "prod"},
+		{Action: "insert", Targets: []string{"resource", "log"}, Key: "team", FromEnv: "TEAM"},
+		{Action: "insert", Targets: []string{"resource"}, Key: "cloud.account.id", FromCloudWatch: "owner"},
+		{Action: "delete", Targets: []string{"span"}, Key: "secret"},
+	}, rules)
+
+	_, err = jsonlotelforwarder.ParseAttributeRules("upsert:resource")
+	require.Error(t, err)
+}
+
+func TestNewAttributesProcessor__Invalid(t *testing.T) {
+	cases := []struct {
+		name string
+		rule *jsonlotelforwarder.AttributeRule
+	}{
+		{name: "unknown action", rule: &jsonlotelforwarder.AttributeRule{Action: "replace", Targets: []string{"span"}, Key: "a", Value: "b"}},
+		{name: "unknown target", rule: &jsonlotelforwarder.AttributeRule{Action: "upsert", Targets: []string{"event"}, Key: "a", Value: "b"}},
+		{name: "missing value", rule: &jsonlotelforwarder.AttributeRule{Action: "upsert", Targets: []string{"span"}, Key: "a"}},
+		{name: "missing key", rule: &jsonlotelforwarder.AttributeRule{Action: "delete", Targets: []string{"span"}}},
+		{name: "unknown type", rule: &jsonlotelforwarder.AttributeRule{Action: "upsert", Targets: []string{"span"}, Key: "a", Value: "b", Type: "number"}},
+		{name: "invalid int", rule: &jsonlotelforwarder.AttributeRule{Action: "upsert", Targets: []string{"span"}, Key: "a", Value: 1.5, Type: "int"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := jsonlotelforwarder.NewAttributesProcessor([]*jsonlotelforwarder.AttributeRule{tc.rule})
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestAttributesProcessor__Config(t *testing.T) {
+	t.Setenv("TEST_SERVICE_NAMESPACE", "checkout")
+	config, err := jsonlotelforwarder.LoadConfig("testdata/attributes_config.json")
+	require.NoError(t, err)
+	p, err := jsonlotelforwarder.NewAttributesProcessor(config.Attributes)
+	require.NoError(t, err)
+
+	results := loadParseResults(t, "testdata/trace.json")
+	results[0].CloudWatch = &jsonlotelforwarder.CloudWatchMetadata{
+		Owner:    "123456789012",
+		LogGroup: "/aws/lambda/checkout",
+	}
+	results, err = p.Process(context.Background(), results)
+	require.NoError(t, err)
+	resourceSpans := results[0].Traces.GetResourceSpans()[0]
+	require.Equal(t, map[string]any{
+		"service.name":           "my.service",
+		"deployment.environment": "production",
+		"service.namespace":      "checkout",
+		"cloud.account.id":       "123456789012",
+		"aws.log.group.names":    "/aws/lambda/checkout",
+	}, attributesToMap(resourceSpans.GetResource().GetAttributes()))
+	require.Equal(t, map[string]any{
+		"my.scope.attribute": "some scope attribute",
+		"team":               "platform",
+	}, attributesToMap(resourceSpans.GetScopeSpans()[0].GetScope().GetAttributes()))
+	require.Equal(t, map[string]any{
+		"my.span.attr": "updated",
+	}, attributesToMap(resourceSpans.GetScopeSpans()[0].GetSpans()[0].GetAttributes()))
+}
+
+func TestAttributesProcessor__DataPointsAndLogs(t *testing.T) {
+	rules, err := jsonlotelforwarder.ParseAttributeRules("upsert:datapoint+log:team=sre,delete:datapoint:my.gauge.attr,insert:resource:missing=env:TEST_NOT_DEFINED_ENV")
+	require.NoError(t, err)
+	p, err := jsonlotelforwarder.NewAttributesProcessor(rules)
+	require.NoError(t, err)
+	results := append(loadParseResults(t, "testdata/metrics.json"), loadParseResults(t, "testdata/logs.json")...)
+	results, err = p.Process(context.Background(), results)
+	require.NoError(t, err)
+
+	metrics := results[0].Metrics.GetResourceMetrics()[0]
+	require.NotContains(t, attributesToMap(metrics.GetResource().GetAttributes()), "missing")
+	for _, metric := range metrics.GetScopeMetrics()[0].GetMetrics() {
+		switch metric.GetName() {
+		case "my.gauge":
+			require.Equal(t, map[string]any{"team": "sre"}, attributesToMap(metric.GetGauge().GetDataPoints()[0].GetAttributes()))
+		case "my.counter":
+			require.Equal(t, "sre", attributesToMap(metric.GetSum().GetDataPoints()[0].GetAttributes())["team"])
+		}
+	}
+	for _, record := range results[1].Logs.GetResourceLogs()[0].GetScopeLogs()[0].GetLogRecords() {
+		require.Equal(t, "sre", attributesToMap(record.GetAttributes())["team"])
+	}
+}
+
+func TestAttributesProcessor__ValueTypes(t *testing.T) {
+	t.Setenv("TEST_ATTRIBUTE_PORT", "8080")
+	var rules []*jsonlotelforwarder.AttributeRule
+	require.NoError(t, json.Unmarshal([]byte(`[
+		{"action": "upsert", "targets": ["resource", "span"], "key": "ratio", "value": 1},
+		{"action": "upsert", "targets": ["span"], "key": "retries", "value": 3, "type": "int"},
+		{"action": "upsert", "targets": ["span"], "key": "port", "from_env": "TEST_ATTRIBUTE_PORT", "type": "int"},
+		{"action": "upsert", "targets": ["span"], "key": "version", "value": 2, "type": "string"}
+	]`), &rules))
+	p, err := jsonlotelforwarder.NewAttributesProcessor(rules)
+	require.NoError(t, err)
+	results, err := p.Process(context.Background(), loadParseResults(t, "testdata/trace.json"))
+	require.NoError(t, err)
+	resourceSpans := results[0].Traces.GetResourceSpans()[0]
+	values := make(map[string]*commonpb.AnyValue)
+	for _, attr := range resourceSpans.GetScopeSpans()[0].GetSpans()[0].GetAttributes() {
+		values[attr.GetKey()] = attr.GetValue()
+	}
+	require.Equal(t, 1.0, values["ratio"].GetDoubleValue(), "JSON numbers are doubles without type")
+	require.Equal(t, int64(3), values["retries"].GetIntValue())
+	require.Equal(t, int64(8080), values["port"].GetIntValue())
+	require.Equal(t, "2", values["version"].GetStringValue())
+
+	// the inserted values are not shared, so changing one does not change the others.
+	values["ratio"].Value = &commonpb.AnyValue_DoubleValue{DoubleValue: 0.5}
+	for _, attr := range resourceSpans.GetResource().GetAttributes() {
+		if attr.GetKey() == "ratio" {
+			require.Equal(t, 1.0, attr.GetValue().GetDoubleValue())
+		}
+	}
+}
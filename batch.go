@@ -2,6 +2,9 @@ package jsonlotelforwarder
 
 import (
 	"slices"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
 
 	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
 	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
@@ -10,6 +13,7 @@ import (
 	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
 )
 
+// ToBatchParseResult merges the results into a new PaseResult. the results are not modified.
 func ToBatchParseResult(results ...*PaseResult) *PaseResult {
 	var merged PaseResult
 	for _, result := range results {
@@ -17,25 +21,31 @@ func ToBatchParseResult(results ...*PaseResult) *PaseResult {
 			if merged.Traces == nil {
 				merged.Traces = &tracepb.TracesData{}
 			}
-			merged.Traces.ResourceSpans = ToBatchResourceSpans(merged.Traces.GetResourceSpans(), result.Traces.GetResourceSpans()...)
+			merged.Traces.ResourceSpans = toBatchResourceSpans(merged.Traces.GetResourceSpans(), cloneMessages(result.Traces.GetResourceSpans())...)
 		}
 		if result.Metrics != nil {
 			if merged.Metrics == nil {
 				merged.Metrics = &metricspb.MetricsData{}
 			}
-			merged.Metrics.ResourceMetrics = ToBatchResourceMetrics(merged.Metrics.GetResourceMetrics(), result.Metrics.GetResourceMetrics()...)
+			merged.Metrics.ResourceMetrics = toBatchResourceMetrics(merged.Metrics.GetResourceMetrics(), cloneMessages(result.Metrics.GetResourceMetrics())...)
 		}
 		if result.Logs != nil {
 			if merged.Logs == nil {
 				merged.Logs = &logspb.LogsData{}
 			}
-			merged.Logs.ResourceLogs = ToBatchResourceLogs(merged.Logs.GetResourceLogs(), result.Logs.GetResourceLogs()...)
+			merged.Logs.ResourceLogs = toBatchResourceLogs(merged.Logs.GetResourceLogs(), cloneMessages(result.Logs.GetResourceLogs())...)
 		}
 	}
 	return &merged
 }
 
+// ToBatchResourceSpans returns a new slice that merges elems into dst by resource and scope.
+// dst and elems are not modified, and the returned slice does not share any messages with them.
 func ToBatchResourceSpans(dst []*tracepb.ResourceSpans, elems ...*tracepb.ResourceSpans) []*tracepb.ResourceSpans {
+	return toBatchResourceSpans(cloneMessages(dst), cloneMessages(elems)...)
+}
+
+func toBatchResourceSpans(dst []*tracepb.ResourceSpans, elems ...*tracepb.ResourceSpans) []*tracepb.ResourceSpans {
 	for _, elem := range elems {
 		if elem == nil {
 			continue
@@ -75,7 +85,13 @@ func toBatchScopeSpans(dst []*tracepb.ScopeSpans, elems ...*tracepb.ScopeSpans)
 	return dst
 }
 
+// ToBatchResourceMetrics returns a new slice that merges elems into dst by resource and scope.
+// dst and elems are not modified, and the returned slice does not share any messages with them.
 func ToBatchResourceMetrics(dst []*metricspb.ResourceMetrics, elems ...*metricspb.ResourceMetrics) []*metricspb.ResourceMetrics {
+	return toBatchResourceMetrics(cloneMessages(dst), cloneMessages(elems)...)
+}
+
+func toBatchResourceMetrics(dst []*metricspb.ResourceMetrics, elems ...*metricspb.ResourceMetrics) []*metricspb.ResourceMetrics {
 	for _, elem := range elems {
 		if elem == nil {
 			continue
@@ -162,7 +178,29 @@ func toBatchMetricData(dst *metricspb.Metric, elem *metricspb.Metric) *metricspb
 	return dst
 }
 
+func countDataPoints(metric *metricspb.Metric) int {
+	switch data := metric.GetData().(type) {
+	case *metricspb.Metric_Gauge:
+		return len(data.Gauge.GetDataPoints())
+	case *metricspb.Metric_Sum:
+		return len(data.Sum.GetDataPoints())
+	case *metricspb.Metric_Summary:
+		return len(data.Summary.GetDataPoints())
+	case *metricspb.Metric_Histogram:
+		return len(data.Histogram.GetDataPoints())
+	case *metricspb.Metric_ExponentialHistogram:
+		return len(data.ExponentialHistogram.GetDataPoints())
+	}
+	return 0
+}
+
+// ToBatchResourceLogs returns a new slice that merges elems into dst by resource and scope.
+// dst and elems are not modified, and the returned slice does not share any messages with them.
 func ToBatchResourceLogs(dst []*logspb.ResourceLogs, elems ...*logspb.ResourceLogs) []*logspb.ResourceLogs {
+	return toBatchResourceLogs(cloneMessages(dst), cloneMessages(elems)...)
+}
+
+func toBatchResourceLogs(dst []*logspb.ResourceLogs, elems ...*logspb.ResourceLogs) []*logspb.ResourceLogs {
 	for _, elem := range elems {
 		if elem == nil {
 			continue
@@ -202,6 +240,20 @@ func toBatchScopeLogs(dst []*logspb.ScopeLogs, elems ...*logspb.ScopeLogs) []*lo
 	return dst
 }
 
+func cloneMessages[T proto.Message](msgs []T) []T {
+	if msgs == nil {
+		return nil
+	}
+	cloned := make([]T, 0, len(msgs))
+	for _, msg := range msgs {
+		if msg.ProtoReflect().IsValid() {
+			msg = proto.Clone(msg).(T)
+		}
+		cloned = append(cloned, msg)
+	}
+	return cloned
+}
+
 func EqualResource(resource1 *resourcepb.Resource, resource2 *resourcepb.Resource) bool {
 	if resource1 == nil || resource2 == nil {
 		return resource1 == resource2
@@ -258,19 +310,12 @@ func EqualMetric(metric1 *metricspb.Metric, metric2 *metricspb.Metric) bool {
 	if metric1 == nil || metric2 == nil {
 		return metric1 == metric2
 	}
-	if metric1.GetName() != metric2.GetName() {
-		return false
-	}
-	if metric1.GetDescription() != metric2.GetDescription() {
-		return false
-	}
-	if metric1.GetUnit() != metric2.GetUnit() {
-		return false
-	}
-	if metricTypeString(metric1) != metricTypeString(metric2) {
-		return false
-	}
-	return true
+	return metricIdentity(metric1) == metricIdentity(metric2)
+}
+
+// metricIdentity returns the identity of the metric: name, description, unit and type with temporality.
+func metricIdentity(metric *metricspb.Metric) string {
+	return strings.Join([]string{metric.GetName(), metric.GetDescription(), metric.GetUnit(), metricTypeString(metric)}, "\x00")
 }
 
 func metricTypeString(metric *metricspb.Metric) string {
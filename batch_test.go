@@ -190,3 +190,66 @@ func TestToBatchResourceLogs(t *testing.T) {
 	t.Log("expected:", string(expected))
 	require.JSONEq(t, string(expected), string(bs))
 }
+
+func TestToBatchParseResult__DoesNotMutateInputs(t *testing.T) {
+	load := func(t *testing.T, name string) []*jsonlotelforwarder.PaseResult {
+		t.Helper()
+		bs, err := os.ReadFile(name)
+		require.NoError(t, err)
+		results, ok := jsonlotelforwarder.Parse(bs)
+		require.True(t, ok)
+		return results
+	}
+	snapshot := func(t *testing.T, results []*jsonlotelforwarder.PaseResult) []string {
+		t.Helper()
+		snapshots := make([]string, 0, len(results))
+		for _, result := range results {
+			var bs []byte
+			var err error
+			switch {
+			case result.Traces != nil:
+				bs, err = otlp.MarshalJSON(result.Traces)
+			case result.Metrics != nil:
+				bs, err = otlp.MarshalJSON(result.Metrics)
+			case result.Logs != nil:
+				bs, err = otlp.MarshalJSON(result.Logs)
+			}
+			require.NoError(t, err)
+			snapshots = append(snapshots, string(bs))
+		}
+		return snapshots
+	}
+	var inputs []*jsonlotelforwarder.PaseResult
+	for _, name := range []string{
+		"testdata/trace.json", "testdata/trace2.json",
+		"testdata/metrics.json", "testdata/metrics2.json",
+		"testdata/logs.json", "testdata/logs2.json",
+	} {
+		inputs = append(inputs, load(t, name)...)
+	}
+	before := snapshot(t, inputs)
+	merged := jsonlotelforwarder.ToBatchParseResult(inputs...)
+	require.Equal(t, before, snapshot(t, inputs))
+
+	merged.Traces.ResourceSpans[0].ScopeSpans[0].Spans[0].Name = "mutated"
+	merged.Metrics.ResourceMetrics[0].ScopeMetrics[0].Metrics[0].Name = "mutated"
+	merged.Logs.ResourceLogs[0].ScopeLogs[0].LogRecords[0].SeverityText = "mutated"
+	jsonlotelforwarder.MergeResourceMetricsDataPoints(merged.Metrics.GetResourceMetrics())
+	require.Equal(t, before, snapshot(t, inputs), "merged result must not alias inputs")
+}
+
+func TestToBatchResourceSpans__DoesNotMutateInputs(t *testing.T) {
+	var trace1, trace2 tracepb.TracesData
+	trace1json, err := os.ReadFile("testdata/trace.json")
+	require.NoError(t, err)
+	trace2json, err := os.ReadFile("testdata/trace2.json")
+	require.NoError(t, err)
+	require.NoError(t, otlp.UnmarshalJSON(trace1json, &trace1))
+	require.NoError(t, otlp.UnmarshalJSON(trace2json, &trace2))
+	dst := trace1.GetResourceSpans()
+	actual := jsonlotelforwarder.ToBatchResourceSpans(dst, trace2.GetResourceSpans()...)
+	require.Len(t, dst[0].GetScopeSpans()[0].GetSpans(), 1)
+	require.Len(t, trace2.GetResourceSpans()[0].GetScopeSpans()[0].GetSpans(), 1)
+	require.Len(t, actual[0].GetScopeSpans()[0].GetSpans(), 2)
+	require.NotSame(t, dst[0], actual[0])
+}
@@ -0,0 +1,197 @@
+package jsonlotelforwarder
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+type BufferOptions struct {
+	MaxAge   time.Duration
+	MaxItems int
+	MaxBytes int
+}
+
+// Buffer holds parse results across invocations and flushes them as one batched result.
+// it flushes when the oldest result exceeds MaxAge, or the buffered items or bytes exceed the limits.
+// Add blocks while the buffer is full and a flush is in progress, so producers are back-pressured.
+type Buffer struct {
+	opts    BufferOptions
+	flushFn func(context.Context, *PaseResult) error
+
+	mu      sync.Mutex
+	pending []*PaseResult
+	items   int
+	bytes   int
+	oldest  time.Time
+
+	flushMu   sync.Mutex
+	startOnce sync.Once
+	closeOnce sync.Once
+	// started is true while the background goroutine that closes done runs.
+	started bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func NewBuffer(opts BufferOptions, flushFn func(context.Context, *PaseResult) error) *Buffer {
+	return &Buffer{
+		opts:    opts,
+		flushFn: flushFn,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// Start starts the background goroutine that flushes the buffer by age. calling Start again has no effect.
+func (b *Buffer) Start(ctx context.Context) {
+	b.startOnce.Do(func() { b.start(ctx) })
+}
+
+func (b *Buffer) start(ctx context.Context) {
+	if b.opts.MaxAge <= 0 {
+		return
+	}
+	interval := b.opts.MaxAge / 4
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	b.mu.Lock()
+	b.started = true
+	b.mu.Unlock()
+	go func() {
+		defer close(b.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-b.stop:
+				return
+			case <-ticker.C:
+				if !b.expired() {
+					continue
+				}
+				if err := b.Flush(ctx); err != nil {
+					slog.ErrorContext(ctx, "failed to flush buffer by max age", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+func (b *Buffer) expired() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.pending) > 0 && time.Since(b.oldest) >= b.opts.MaxAge
+}
+
+func (b *Buffer) full() bool {
+	if b.opts.MaxItems > 0 && b.items >= b.opts.MaxItems {
+		return true
+	}
+	if b.opts.MaxBytes > 0 && b.bytes >= b.opts.MaxBytes {
+		return true
+	}
+	return false
+}
+
+// Add appends the results to the buffer, and flushes the buffer if it is full.
+func (b *Buffer) Add(ctx context.Context, results ...*PaseResult) error {
+	b.mu.Lock()
+	for _, result := range results {
+		if result.Skip() {
+			continue
+		}
+		if len(b.pending) == 0 {
+			b.oldest = time.Now()
+		}
+		b.pending = append(b.pending, result)
+		b.items += CountItems(result)
+		b.bytes += resultSize(result)
+	}
+	full := b.full()
+	b.mu.Unlock()
+	if !full {
+		return nil
+	}
+	slog.DebugContext(ctx, "buffer is full, flush")
+	return b.Flush(ctx)
+}
+
+// Flush exports all buffered results as batched results, one per route.
+func (b *Buffer) Flush(ctx context.Context) error {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+	b.mu.Lock()
+	pending := b.pending
+	items, bytes := b.items, b.bytes
+	b.pending = nil
+	b.items = 0
+	b.bytes = 0
+	b.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+	slog.InfoContext(ctx, "flush buffer", "results", len(pending), "items", items, "bytes", bytes)
+	return flushByRoute(pending, func(batch *PaseResult) error {
+		return b.flushFn(ctx, batch)
+	})
+}
+
+// Close stops the background goroutine if started, and flushes the remaining results.
+// it is safe to call Close without Start, or more than once.
+func (b *Buffer) Close(ctx context.Context) error {
+	b.closeOnce.Do(func() { close(b.stop) })
+	b.mu.Lock()
+	started := b.started
+	b.mu.Unlock()
+	if started {
+		<-b.done
+	}
+	return b.Flush(ctx)
+}
+
+// CountItems returns the number of spans, data points and log records in the result.
+func CountItems(result *PaseResult) int {
+	if result == nil {
+		return 0
+	}
+	var n int
+	for _, resourceSpans := range result.Traces.GetResourceSpans() {
+		for _, scopeSpans := range resourceSpans.GetScopeSpans() {
+			n += len(scopeSpans.GetSpans())
+		}
+	}
+	for _, resourceMetrics := range result.Metrics.GetResourceMetrics() {
+		for _, scopeMetrics := range resourceMetrics.GetScopeMetrics() {
+			for _, metric := range scopeMetrics.GetMetrics() {
+				n += countDataPoints(metric)
+			}
+		}
+	}
+	for _, resourceLogs := range result.Logs.GetResourceLogs() {
+		for _, scopeLogs := range resourceLogs.GetScopeLogs() {
+			n += len(scopeLogs.GetLogRecords())
+		}
+	}
+	return n
+}
+
+func resultSize(result *PaseResult) int {
+	var n int
+	if result.Traces != nil {
+		n += proto.Size(result.Traces)
+	}
+	if result.Metrics != nil {
+		n += proto.Size(result.Metrics)
+	}
+	if result.Logs != nil {
+		n += proto.Size(result.Logs)
+	}
+	return n
+}
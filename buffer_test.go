@@ -0,0 +1,150 @@
+package jsonlotelforwarder_test
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	jsonlotelforwarder "github.com/mashiike/jsonl-otel-forwarder"
+	"github.com/stretchr/testify/require"
+)
+
+type flushRecorder struct {
+	mu      sync.Mutex
+	flushed []*jsonlotelforwarder.PaseResult
+	block   chan struct{}
+}
+
+func (r *flushRecorder) Flush(_ context.Context, result *jsonlotelforwarder.PaseResult) error {
+	if r.block != nil {
+		<-r.block
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.flushed = append(r.flushed, result)
+	return nil
+}
+
+func (r *flushRecorder) Flushed() []*jsonlotelforwarder.PaseResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*jsonlotelforwarder.PaseResult{}, r.flushed...)
+}
+
+func loadParseResults(t *testing.T, name string) []*jsonlotelforwarder.PaseResult {
+	t.Helper()
+	bs, err := os.ReadFile(name)
+	require.NoError(t, err)
+	results, ok := jsonlotelforwarder.Parse(bs)
+	require.True(t, ok)
+	return results
+}
+
+func TestBuffer__MaxItems(t *testing.T) {
+	recorder := &flushRecorder{}
+	buffer := jsonlotelforwarder.NewBuffer(jsonlotelforwarder.BufferOptions{MaxItems: 2}, recorder.Flush)
+	ctx := context.Background()
+	buffer.Start(ctx)
+	require.NoError(t, buffer.Add(ctx, loadParseResults(t, "testdata/trace.json")...))
+	require.Empty(t, recorder.Flushed())
+	require.NoError(t, buffer.Add(ctx, loadParseResults(t, "testdata/trace2.json")...))
+	flushed := recorder.Flushed()
+	require.Len(t, flushed, 1)
+	require.Equal(t, 2, jsonlotelforwarder.CountItems(flushed[0]))
+	require.Len(t, flushed[0].Traces.GetResourceSpans(), 1)
+	require.NoError(t, buffer.Close(ctx))
+	require.Len(t, recorder.Flushed(), 1, "no more flush on close when buffer is empty")
+}
+
+func TestBuffer__MaxBytes(t *testing.T) {
+	recorder := &flushRecorder{}
+	buffer := jsonlotelforwarder.NewBuffer(jsonlotelforwarder.BufferOptions{MaxBytes: 1}, recorder.Flush)
+	ctx := context.Background()
+	buffer.Start(ctx)
+	require.NoError(t, buffer.Add(ctx, loadParseResults(t, "testdata/logs.json")...))
+	require.Len(t, recorder.Flushed(), 1)
+	require.NoError(t, buffer.Close(ctx))
+}
+
+func TestBuffer__MaxAge(t *testing.T) {
+	recorder := &flushRecorder{}
+	buffer := jsonlotelforwarder.NewBuffer(jsonlotelforwarder.BufferOptions{MaxAge: 50 * time.Millisecond}, recorder.Flush)
+	ctx := context.Background()
+	buffer.Start(ctx)
+	require.NoError(t, buffer.Add(ctx, loadParseResults(t, "testdata/metrics.json")...))
+	require.NoError(t, buffer.Add(ctx, loadParseResults(t, "testdata/logs.json")...))
+	require.Eventually(t, func() bool {
+		return len(recorder.Flushed()) == 1
+	}, time.Second, 10*time.Millisecond)
+	flushed := recorder.Flushed()
+	require.NotNil(t, flushed[0].Metrics)
+	require.NotNil(t, flushed[0].Logs)
+	require.NoError(t, buffer.Close(ctx))
+}
+
+func TestBuffer__FlushOnClose(t *testing.T) {
+	recorder := &flushRecorder{}
+	buffer := jsonlotelforwarder.NewBuffer(jsonlotelforwarder.BufferOptions{MaxAge: time.Hour}, recorder.Flush)
+	ctx := context.Background()
+	buffer.Start(ctx)
+	require.NoError(t, buffer.Add(ctx, loadParseResults(t, "testdata/trace.json")...))
+	require.Empty(t, recorder.Flushed())
+	require.NoError(t, buffer.Close(ctx))
+	require.Len(t, recorder.Flushed(), 1)
+}
+
+func TestBuffer__CloseWithoutStart(t *testing.T) {
+	recorder := &flushRecorder{}
+	buffer := jsonlotelforwarder.NewBuffer(jsonlotelforwarder.BufferOptions{MaxAge: time.Hour}, recorder.Flush)
+	ctx := context.Background()
+	require.NoError(t, buffer.Add(ctx, loadParseResults(t, "testdata/trace.json")...))
+	require.NoError(t, buffer.Close(ctx), "Close does not wait for the goroutine that is not started")
+	require.Len(t, recorder.Flushed(), 1)
+	require.NoError(t, buffer.Close(ctx), "Close can be called more than once")
+	require.Len(t, recorder.Flushed(), 1)
+}
+
+func TestBuffer__BackPressure(t *testing.T) {
+	recorder := &flushRecorder{block: make(chan struct{})}
+	buffer := jsonlotelforwarder.NewBuffer(jsonlotelforwarder.BufferOptions{MaxItems: 1}, recorder.Flush)
+	ctx := context.Background()
+	buffer.Start(ctx)
+	added := make(chan struct{})
+	go func() {
+		defer close(added)
+		require.NoError(t, buffer.Add(ctx, loadParseResults(t, "testdata/trace.json")...))
+	}()
+	select {
+	case <-added:
+		t.Fatal("Add must block while buffer is full and flushing")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(recorder.block)
+	select {
+	case <-added:
+	case <-time.After(time.Second):
+		t.Fatal("Add must return after flush completed")
+	}
+	require.Len(t, recorder.Flushed(), 1)
+	require.NoError(t, buffer.Close(ctx))
+}
+
+func TestBuffer__FlushByRoute(t *testing.T) {
+	recorder := &flushRecorder{}
+	buffer := jsonlotelforwarder.NewBuffer(jsonlotelforwarder.BufferOptions{MaxAge: time.Hour}, recorder.Flush)
+	ctx := context.Background()
+	buffer.Start(ctx)
+	routed := loadParseResults(t, "testdata/trace2.json")
+	for _, result := range routed {
+		result.Exporters = []string{"team-a"}
+	}
+	require.NoError(t, buffer.Add(ctx, loadParseResults(t, "testdata/trace.json")...))
+	require.NoError(t, buffer.Add(ctx, routed...))
+	require.NoError(t, buffer.Close(ctx))
+	flushed := recorder.Flushed()
+	require.Len(t, flushed, 2, "results routed to different exporters are not batched together")
+	require.Empty(t, flushed[0].Exporters)
+	require.Equal(t, []string{"team-a"}, flushed[1].Exporters)
+}
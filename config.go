@@ -0,0 +1,46 @@
+package jsonlotelforwarder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Config is the processors configuration loaded from --config file.
+type Config struct {
+	Timestamps *TimestampsConfig `json:"timestamps,omitempty"`
+	Validation *ValidationConfig `json:"validation,omitempty"`
+	Attributes []*AttributeRule  `json:"attributes,omitempty"`
+	Filter     *FilterConfig     `json:"filter,omitempty"`
+	Redaction  *RedactionConfig  `json:"redaction,omitempty"`
+
+	SpanMetrics  *SpanMetricsConfig  `json:"span_metrics,omitempty"`
+	LogMetrics   *LogMetricsConfig   `json:"log_metrics,omitempty"`
+	HeadSampling *HeadSamplingConfig `json:"head_sampling,omitempty"`
+	TailSampling *TailSamplingConfig `json:"tail_sampling,omitempty"`
+	Temporality  *TemporalityConfig  `json:"temporality,omitempty"`
+	RateLimit    *RateLimitConfig    `json:"rate_limit,omitempty"`
+
+	MetricsTransform []*MetricsTransformRule `json:"metrics_transform,omitempty"`
+
+	Exporters []*ExporterConfig `json:"exporters,omitempty"`
+	Routing   *RoutingConfig    `json:"routing,omitempty"`
+}
+
+func LoadConfig(path string) (*Config, error) {
+	if path == "" {
+		return &Config{}, nil
+	}
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file: %w", err)
+	}
+	var config Config
+	dec := json.NewDecoder(bytes.NewReader(bs))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&config); err != nil {
+		return nil, fmt.Errorf("parse config file: %w", err)
+	}
+	return &config, nil
+}
@@ -0,0 +1,269 @@
+package jsonlotelforwarder
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// Explanation describes what the forwarder does with an input, or a buffer flush, in dry-run mode.
+// methods of nil Explanation do nothing, so the forwarder calls them regardless of --dry-run.
+type Explanation struct {
+	Input            int64             `json:"input,omitempty"`
+	Flush            bool              `json:"flush,omitempty"`
+	Source           string            `json:"source,omitempty"`
+	LogGroup         string            `json:"log_group,omitempty"`
+	LogStream        string            `json:"log_stream,omitempty"`
+	LogEvents        int               `json:"log_events,omitempty"`
+	SkippedLogEvents int               `json:"skipped_log_events,omitempty"`
+	Skipped          bool              `json:"skipped,omitempty"`
+	SkipReason       string            `json:"skip_reason,omitempty"`
+	Parsed           []*ResultSummary  `json:"parsed,omitempty"`
+	Processed        []*ResultSummary  `json:"processed,omitempty"`
+	Batch            *BatchExplanation `json:"batch,omitempty"`
+	Exports          []*ResultSummary  `json:"exports,omitempty"`
+}
+
+// ResultSummary counts the telemetry of a signal. TraceIDs are hex encoded in order of appearance.
+type ResultSummary struct {
+	Signal     string   `json:"signal"`
+	Resources  int      `json:"resources"`
+	Spans      int      `json:"spans,omitempty"`
+	DataPoints int      `json:"data_points,omitempty"`
+	LogRecords int      `json:"log_records,omitempty"`
+	TraceIDs   []string `json:"trace_ids,omitempty"`
+	Exporters  []string `json:"exporters,omitempty"`
+}
+
+// BatchExplanation describes what --batch and --merge-data-points merged.
+type BatchExplanation struct {
+	Results          int `json:"results"`
+	Batches          int `json:"batches"`
+	ResourcesBefore  int `json:"resources_before"`
+	ResourcesAfter   int `json:"resources_after"`
+	DataPointsBefore int `json:"data_points_before,omitempty"`
+	DataPointsAfter  int `json:"data_points_after,omitempty"`
+}
+
+// explainPayload sets the source of the payload, and the reason when Parse skips it.
+func (e *Explanation) explainPayload(data []byte, parsed bool) {
+	if e == nil {
+		return
+	}
+	e.Source = "otlp"
+	if !json.Valid(data) {
+		e.skip("invalid JSON")
+		return
+	}
+	var subscriptionFilter CloudWatchSubscriptionFilterEvent
+	if err := json.Unmarshal(data, &subscriptionFilter); err == nil && subscriptionFilter.AWSLogs != nil {
+		e.Source = "cloudwatch"
+		logsData, ok := subscriptionFilter.GetLogsData()
+		if !ok {
+			e.skip("failed to decode awslogs data, expected base64 encoded gzip JSON")
+			return
+		}
+		e.LogGroup, e.LogStream, e.LogEvents = logsData.LogGroup, logsData.LogStream, len(logsData.LogEvents)
+		for _, logEvent := range logsData.LogEvents {
+			if results, ok := Parse([]byte(logEvent.Message)); !ok || !slices.ContainsFunc(results, func(r *PaseResult) bool { return !r.Skip() }) {
+				e.SkippedLogEvents++
+			}
+		}
+		switch {
+		case parsed:
+		case logsData.MessageType == "CONTROL_MESSAGE":
+			e.skip("control message of the subscription filter")
+		case len(logsData.LogEvents) == 0:
+			e.skip("no log events")
+		default:
+			e.skip("no log event is OTLP JSON traces, metrics or logs")
+		}
+		return
+	}
+	if !parsed {
+		e.skip("not OTLP JSON traces, metrics or logs")
+	}
+}
+
+func (e *Explanation) skip(reason string) {
+	if e == nil {
+		return
+	}
+	e.Skipped, e.SkipReason = true, reason
+}
+
+func (e *Explanation) parsed(results []*PaseResult) {
+	if e == nil {
+		return
+	}
+	e.Parsed = summarizeResults(results)
+}
+
+func (e *Explanation) processed(results []*PaseResult) {
+	if e == nil {
+		return
+	}
+	e.Processed = summarizeResults(results)
+	if len(results) == 0 {
+		e.skip("all telemetry is dropped by processors")
+	}
+}
+
+// batching counts the results before batching, which may share messages with the batches.
+func (e *Explanation) batching(results []*PaseResult) {
+	if e == nil {
+		return
+	}
+	e.Batch = &BatchExplanation{Results: len(results)}
+	for _, summary := range summarizeResults(results) {
+		e.Batch.ResourcesBefore += summary.Resources
+		e.Batch.DataPointsBefore += summary.DataPoints
+	}
+}
+
+func (e *Explanation) batched(batches []*PaseResult) {
+	if e == nil || e.Batch == nil {
+		return
+	}
+	e.Batch.Batches = len(batches)
+	for _, summary := range summarizeResults(batches) {
+		e.Batch.ResourcesAfter += summary.Resources
+		e.Batch.DataPointsAfter += summary.DataPoints
+	}
+}
+
+// exports sets the exporters that would receive each signal of the results.
+func (e *Explanation) exports(exporters []*namedExporter, results []*PaseResult) {
+	if e == nil {
+		return
+	}
+	for _, result := range results {
+		if result.Skip() {
+			continue
+		}
+		for _, r := range splitBySignal(result) {
+			summary := summarizeResult(r)[0]
+			summary.Exporters = nil
+			for _, exporter := range exporters {
+				if !exporter.accepts(summary.Signal) {
+					continue
+				}
+				if len(r.Exporters) > 0 && !slices.Contains(r.Exporters, exporter.name) {
+					continue
+				}
+				summary.Exporters = append(summary.Exporters, exporter.name)
+			}
+			e.Exports = append(e.Exports, summary)
+		}
+	}
+}
+
+// dryRun writes explanations as JSON lines.
+type dryRun struct {
+	mu     sync.Mutex
+	w      io.Writer
+	inputs int64
+}
+
+func newDryRun(w io.Writer) *dryRun {
+	if w == nil {
+		w = os.Stdout
+	}
+	return &dryRun{w: w}
+}
+
+func (d *dryRun) newInput() *Explanation {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.inputs++
+	return &Explanation{Input: d.inputs}
+}
+
+func (d *dryRun) newFlush() *Explanation {
+	if d == nil {
+		return nil
+	}
+	return &Explanation{Flush: true}
+}
+
+func (d *dryRun) write(e *Explanation) error {
+	if d == nil || e == nil {
+		return nil
+	}
+	bs, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("marshal explanation: %w", err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, err := d.w.Write(append(bs, '\n')); err != nil {
+		return fmt.Errorf("write explanation: %w", err)
+	}
+	return nil
+}
+
+func summarizeResults(results []*PaseResult) []*ResultSummary {
+	var summaries []*ResultSummary
+	for _, result := range results {
+		summaries = append(summaries, summarizeResult(result)...)
+	}
+	return summaries
+}
+
+func summarizeResult(result *PaseResult) []*ResultSummary {
+	var summaries []*ResultSummary
+	if result.Traces != nil {
+		summaries = append(summaries, summarizeTraces(result.Traces))
+	}
+	if result.Metrics != nil {
+		summaries = append(summaries, summarizeMetrics(result.Metrics))
+	}
+	if result.Logs != nil {
+		summaries = append(summaries, summarizeLogs(result.Logs))
+	}
+	for _, summary := range summaries {
+		summary.Exporters = result.Exporters
+	}
+	return summaries
+}
+
+func summarizeTraces(traces *tracepb.TracesData) *ResultSummary {
+	summary := &ResultSummary{Signal: "traces", Resources: len(traces.GetResourceSpans())}
+	seen := make(map[string]bool)
+	for _, resourceSpans := range traces.GetResourceSpans() {
+		for _, scopeSpans := range resourceSpans.GetScopeSpans() {
+			for _, span := range scopeSpans.GetSpans() {
+				summary.Spans++
+				traceID := hex.EncodeToString(span.GetTraceId())
+				if !seen[traceID] {
+					seen[traceID] = true
+					summary.TraceIDs = append(summary.TraceIDs, traceID)
+				}
+			}
+		}
+	}
+	return summary
+}
+
+func summarizeMetrics(metrics *metricspb.MetricsData) *ResultSummary {
+	summary := &ResultSummary{Signal: "metrics", Resources: len(metrics.GetResourceMetrics())}
+	summary.DataPoints = CountItems(&PaseResult{Metrics: metrics})
+	return summary
+}
+
+func summarizeLogs(logs *logspb.LogsData) *ResultSummary {
+	summary := &ResultSummary{Signal: "logs", Resources: len(logs.GetResourceLogs())}
+	summary.LogRecords = CountItems(&PaseResult{Logs: logs})
+	return summary
+}
@@ -0,0 +1,107 @@
+package jsonlotelforwarder_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	jsonlotelforwarder "github.com/mashiike/jsonl-otel-forwarder"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForwarder__DryRun(t *testing.T) {
+	var buf bytes.Buffer
+	opts := jsonlotelforwarder.DefaultOptions()
+	opts.DryRun = true
+	opts.DryRunWriter = &buf
+	opts.Batch = true
+	opts.FilterLogs = "severity >= TRACE"
+	forwarder, err := jsonlotelforwarder.New(opts)
+	require.NoError(t, err)
+
+	trace, err := os.ReadFile("testdata/trace.json")
+	require.NoError(t, err)
+	logs, err := os.ReadFile("testdata/logs.json")
+	require.NoError(t, err)
+	for _, payload := range [][]byte{
+		trace,
+		[]byte(`{"message":"hello"}`),
+		EncodeSubscriptionFilterEvent(t, [][]byte{trace, []byte("START RequestId: 1"), trace}),
+		logs,
+	} {
+		_, err := forwarder.Invoke(context.Background(), payload)
+		require.NoError(t, err)
+	}
+
+	var explanations []*jsonlotelforwarder.Explanation
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var e jsonlotelforwarder.Explanation
+		require.NoError(t, dec.Decode(&e))
+		explanations = append(explanations, &e)
+	}
+	require.Len(t, explanations, 4)
+
+	traceSummary := loadParseResults(t, "testdata/trace.json")
+	spans := 0
+	for _, resourceSpans := range traceSummary[0].Traces.GetResourceSpans() {
+		for _, scopeSpans := range resourceSpans.GetScopeSpans() {
+			spans += len(scopeSpans.GetSpans())
+		}
+	}
+
+	e := explanations[0]
+	require.Equal(t, int64(1), e.Input)
+	require.Equal(t, "otlp", e.Source)
+	require.False(t, e.Skipped)
+	require.Len(t, e.Parsed, 1)
+	require.Equal(t, "traces", e.Parsed[0].Signal)
+	require.Equal(t, spans, e.Parsed[0].Spans)
+	require.NotEmpty(t, e.Parsed[0].TraceIDs)
+	require.Len(t, e.Exports, 1)
+	require.Equal(t, []string{"default"}, e.Exports[0].Exporters)
+
+	e = explanations[1]
+	require.True(t, e.Skipped)
+	require.Equal(t, "not OTLP JSON traces, metrics or logs", e.SkipReason)
+
+	e = explanations[2]
+	require.Equal(t, "cloudwatch", e.Source)
+	require.Equal(t, "test-log-group", e.LogGroup)
+	require.Equal(t, 3, e.LogEvents)
+	require.Equal(t, 1, e.SkippedLogEvents)
+	require.Len(t, e.Parsed, 2)
+	require.Equal(t, &jsonlotelforwarder.BatchExplanation{
+		Results:         2,
+		Batches:         1,
+		ResourcesBefore: 2 * len(traceSummary[0].Traces.GetResourceSpans()),
+		ResourcesAfter:  len(traceSummary[0].Traces.GetResourceSpans()),
+	}, e.Batch, "the same resources of the log events are merged")
+	require.Equal(t, 2*spans, e.Exports[0].Spans)
+
+	e = explanations[3]
+	require.True(t, e.Skipped)
+	require.Equal(t, "all telemetry is dropped by processors", e.SkipReason)
+	require.Equal(t, "logs", e.Parsed[0].Signal)
+	require.Empty(t, e.Processed)
+}
+
+func TestForwarder__DryRunDoesNotWriteTemporalityState(t *testing.T) {
+	opts := jsonlotelforwarder.DefaultOptions()
+	opts.DryRun = true
+	opts.DryRunWriter = &bytes.Buffer{}
+	opts.Temporality = "cumulative"
+	opts.TemporalityStateFile = filepath.Join(t.TempDir(), "temporality.json")
+	forwarder, err := jsonlotelforwarder.New(opts)
+	require.NoError(t, err)
+	metrics, err := os.ReadFile("testdata/metrics.json")
+	require.NoError(t, err)
+	for range 2 {
+		_, err := forwarder.Invoke(context.Background(), metrics)
+		require.NoError(t, err)
+	}
+	require.NoFileExists(t, opts.TemporalityStateFile)
+}
@@ -6,6 +6,8 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"log/slog"
+	"strings"
+	"time"
 )
 
 type CloudWatchSubscriptionFilterEvent struct {
@@ -32,29 +34,78 @@ type CloudWatchLogsLogEvent struct {
 }
 
 func (e *CloudWatchSubscriptionFilterEvent) GetLogEvents() []string {
-	if e.AWSLogs == nil {
+	parsed, ok := e.GetLogsData()
+	if !ok {
 		return []string{}
 	}
+	events := make([]string, 0, len(parsed.LogEvents))
+	for _, event := range parsed.LogEvents {
+		events = append(events, event.Message)
+	}
+	return events
+}
+
+func (e *CloudWatchSubscriptionFilterEvent) GetLogsData() (*CloudWatchLogsData, bool) {
+	if e.AWSLogs == nil {
+		return nil, false
+	}
 	data, err := base64.StdEncoding.DecodeString(e.AWSLogs.Data)
 	if err != nil {
 		slog.Warn("failed to decode base64", "error", err)
-		return []string{}
+		return nil, false
 	}
 	reader, err := gzip.NewReader(bytes.NewReader(data))
 	if err != nil {
 		slog.Warn("failed to create gzip reader", "error", err)
-		return []string{}
+		return nil, false
 	}
 	dec := json.NewDecoder(reader)
 	var parsed CloudWatchLogsData
 	if err := dec.Decode(&parsed); err != nil {
 		slog.Warn("failed to decode json", "error", err)
-		return []string{}
+		return nil, false
 	}
 	slog.Info("parsed log events", "owner", parsed.Owner, "logGroup", parsed.LogGroup, "logStream", parsed.LogStream, "messageType", parsed.MessageType, "subscriptionFilters", parsed.SubscriptionFilters, "logEvents", len(parsed.LogEvents))
-	events := make([]string, 0, len(parsed.LogEvents))
-	for _, event := range parsed.LogEvents {
-		events = append(events, event.Message)
+	return &parsed, true
+}
+
+// CloudWatchMetadata is the metadata of the CloudWatch Logs event that the telemetry was delivered with.
+type CloudWatchMetadata struct {
+	Owner               string
+	LogGroup            string
+	LogStream           string
+	SubscriptionFilters []string
+	EventID             string
+	Timestamp           time.Time
+}
+
+func newCloudWatchMetadata(data *CloudWatchLogsData, event CloudWatchLogsLogEvent) *CloudWatchMetadata {
+	return &CloudWatchMetadata{
+		Owner:               data.Owner,
+		LogGroup:            data.LogGroup,
+		LogStream:           data.LogStream,
+		SubscriptionFilters: data.SubscriptionFilters,
+		EventID:             event.ID,
+		Timestamp:           time.UnixMilli(event.Timestamp),
 	}
-	return events
+}
+
+// Lookup returns the metadata value by name, e.g. owner, log_group, log_stream, subscription_filters, event_id.
+func (m *CloudWatchMetadata) Lookup(name string) (string, bool) {
+	if m == nil {
+		return "", false
+	}
+	switch strings.ToLower(strings.ReplaceAll(name, "-", "_")) {
+	case "owner", "account", "account_id":
+		return m.Owner, m.Owner != ""
+	case "log_group", "loggroup":
+		return m.LogGroup, m.LogGroup != ""
+	case "log_stream", "logstream":
+		return m.LogStream, m.LogStream != ""
+	case "subscription_filters", "subscriptionfilters":
+		return strings.Join(m.SubscriptionFilters, ","), len(m.SubscriptionFilters) > 0
+	case "event_id", "id":
+		return m.EventID, m.EventID != ""
+	}
+	return "", false
 }
@@ -65,3 +65,22 @@ func TestCloudWatchSubscriptionFilterEvent(t *testing.T) {
 		"{\"eventVersion\":\"1.03\",\"userIdentity\":{\"type\":\"Root\"}",
 	}, acutal)
 }
+
+func TestCloudWatchSubscriptionFilterEvent__Metadata(t *testing.T) {
+	trace, err := os.ReadFile("testdata/trace.json")
+	require.NoError(t, err)
+	payload := EncodeSubscriptionFilterEvent(t, [][]byte{trace})
+	results, ok := jsonlotelforwarder.Parse(payload)
+	require.True(t, ok)
+	require.Len(t, results, 1)
+	metadata := results[0].CloudWatch
+	require.NotNil(t, metadata)
+	require.Equal(t, "123456789012", metadata.Owner)
+	require.Equal(t, "test-log-group", metadata.LogGroup)
+	require.Equal(t, "test-log-stream", metadata.LogStream)
+	require.Equal(t, "eventId-0", metadata.EventID)
+	require.Equal(t, int64(1440442987000), metadata.Timestamp.UnixMilli())
+	v, ok := metadata.Lookup("log-group")
+	require.True(t, ok)
+	require.Equal(t, "test-log-group", v)
+}
@@ -0,0 +1,576 @@
+package jsonlotelforwarder
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mashiike/go-otlp-helper/otlp"
+)
+
+// Exporter sends parse results to a destination.
+// Export is called with a result that has only one signal, and may be called concurrently.
+type Exporter interface {
+	Start(ctx context.Context) error
+	Export(ctx context.Context, result *PaseResult) error
+	Stop(ctx context.Context) error
+}
+
+// ExporterConfig is a named destination in the config file.
+// the endpoint and header values can refer to environment variables as ${NAME}.
+// Type is `otlp` by default, `file` writes OTLP JSON lines to Path, `prometheus_remote_write` sends metrics to the remote write endpoint,
+// `loki` sends logs to the Loki push API with the stream labels of Labels, `zipkin` sends traces as Zipkin v2 JSON spans,
+// and `kafka` produces OTLP messages to the Topics of Brokers.
+type ExporterConfig struct {
+	Name     string            `json:"name"`
+	Type     string            `json:"type,omitempty"`
+	Endpoint string            `json:"endpoint"`
+	Protocol string            `json:"protocol,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	Gzip     bool              `json:"gzip,omitempty"`
+	Timeout  string            `json:"timeout,omitempty"`
+	// Signals are the signals sent to the exporter, all signals of --signals when empty.
+	Signals      []string            `json:"signals,omitempty"`
+	Retry        *RetryConfig        `json:"retry,omitempty"`
+	TenantHeader *TenantHeaderConfig `json:"tenant_header,omitempty"`
+
+	Path     string `json:"path,omitempty"`
+	Pretty   bool   `json:"pretty,omitempty"`
+	MaxBytes int64  `json:"max_bytes,omitempty"`
+	MaxFiles int    `json:"max_files,omitempty"`
+
+	Labels []string `json:"labels,omitempty"`
+
+	Brokers      []string          `json:"brokers,omitempty"`
+	Topics       map[string]string `json:"topics,omitempty"`
+	Encoding     string            `json:"encoding,omitempty"`
+	PartitionKey string            `json:"partition_key,omitempty"`
+	ClientID     string            `json:"client_id,omitempty"`
+	TLS          bool              `json:"tls,omitempty"`
+}
+
+// RetryConfig is the exponential backoff of failed exports.
+type RetryConfig struct {
+	MaxAttempts     int    `json:"max_attempts,omitempty"`
+	InitialInterval string `json:"initial_interval,omitempty"`
+	MaxInterval     string `json:"max_interval,omitempty"`
+}
+
+var defaultRetryConfig = RetryConfig{
+	MaxAttempts:     3,
+	InitialInterval: "500ms",
+	MaxInterval:     "5s",
+}
+
+type retryPolicy struct {
+	maxAttempts     int
+	initialInterval time.Duration
+	maxInterval     time.Duration
+}
+
+func newRetryPolicy(config *RetryConfig) (retryPolicy, error) {
+	merged := defaultRetryConfig
+	if config != nil {
+		if config.MaxAttempts != 0 {
+			merged.MaxAttempts = config.MaxAttempts
+		}
+		if config.InitialInterval != "" {
+			merged.InitialInterval = config.InitialInterval
+		}
+		if config.MaxInterval != "" {
+			merged.MaxInterval = config.MaxInterval
+		}
+	}
+	if merged.MaxAttempts < 1 {
+		return retryPolicy{}, fmt.Errorf("max_attempts must be greater than 0")
+	}
+	initialInterval, err := time.ParseDuration(merged.InitialInterval)
+	if err != nil {
+		return retryPolicy{}, fmt.Errorf("initial_interval: %w", err)
+	}
+	maxInterval, err := time.ParseDuration(merged.MaxInterval)
+	if err != nil {
+		return retryPolicy{}, fmt.Errorf("max_interval: %w", err)
+	}
+	return retryPolicy{maxAttempts: merged.MaxAttempts, initialInterval: initialInterval, maxInterval: maxInterval}, nil
+}
+
+// namedExporter creates the exporter for each invocation, and accounts its failures by name.
+type namedExporter struct {
+	name    string
+	signals []string
+	retry   retryPolicy
+	new     func() (Exporter, error)
+}
+
+func (e *namedExporter) accepts(signal string) bool {
+	return containsSignal(e.signals, signal)
+}
+
+// newExporters returns the exporters of the config file, or the exporter configured by flags and environment variables.
+// the file exporters of --file-path flags are added to both, and take over their signals from the default exporter.
+func newExporters(options *Options, config *Config) ([]*namedExporter, error) {
+	paths, signalsByPath := options.fileExporterPaths()
+	fileExporters := make([]*namedExporter, 0, len(paths))
+	var fileSignals []string
+	for _, path := range paths {
+		name := "file"
+		if len(paths) > 1 {
+			name = "file-" + strings.Join(signalsByPath[path], "-")
+		}
+		e, err := newNamedFileExporter(options.fileExporterConfig(path), signalsByPath[path])
+		if err != nil {
+			return nil, fmt.Errorf("file %s: %w", path, err)
+		}
+		e.name = name
+		fileExporters = append(fileExporters, e)
+		fileSignals = append(fileSignals, signalsByPath[path]...)
+	}
+	if len(config.Exporters) == 0 {
+		tenantHeader := options.tenantHeaderConfig()
+		if tenantHeader.Enabled() {
+			if err := tenantHeader.validate(); err != nil {
+				return nil, fmt.Errorf("tenant header: %w", err)
+			}
+		}
+		signalHeaders, err := options.otlpSignalHeaders()
+		if err != nil {
+			return nil, err
+		}
+		var signals []string
+		for _, signal := range options.SignalsList() {
+			if !containsSignal(fileSignals, signal) {
+				signals = append(signals, signal)
+			}
+		}
+		if len(signals) == 0 {
+			return fileExporters, nil
+		}
+		return append([]*namedExporter{
+			{
+				name:    "default",
+				signals: signals,
+				retry:   retryPolicy{maxAttempts: 1},
+				new: func() (Exporter, error) {
+					return newOTLPExporter(options.clientOptions, tenantHeader, signalHeaders)
+				},
+			},
+		}, fileExporters...), nil
+	}
+	exporters := make([]*namedExporter, 0, len(config.Exporters)+len(fileExporters))
+	names := make(map[string]bool, len(config.Exporters))
+	for i, c := range config.Exporters {
+		if c.Name == "" {
+			return nil, fmt.Errorf("exporters[%d]: name is required", i)
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("exporters[%d]: duplicate name %q", i, c.Name)
+		}
+		names[c.Name] = true
+		var (
+			e   *namedExporter
+			err error
+		)
+		switch strings.ToLower(c.Type) {
+		case "", "otlp":
+			e, err = newNamedOTLPExporter(options, c)
+		case "file":
+			e, err = newNamedFileExporterFromConfig(options, c)
+		case "prometheus_remote_write":
+			e, err = newNamedPrometheusRemoteWriteExporter(c)
+		case "loki":
+			e, err = newNamedLokiExporter(c)
+		case "zipkin":
+			e, err = newNamedZipkinExporter(c)
+		case "kafka":
+			e, err = newNamedKafkaExporter(options, c)
+		default:
+			err = fmt.Errorf("unknown type %q, expected otlp, file, prometheus_remote_write, loki, zipkin or kafka", c.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("exporter %s: %w", c.Name, err)
+		}
+		exporters = append(exporters, e)
+	}
+	for _, e := range fileExporters {
+		if names[e.name] {
+			return nil, fmt.Errorf("exporter %s of --file-path: duplicate name", e.name)
+		}
+		exporters = append(exporters, e)
+	}
+	return exporters, nil
+}
+
+// exporterSignals returns the signals of the exporter config, all signals of --signals when empty.
+func exporterSignals(options *Options, c *ExporterConfig) ([]string, error) {
+	signals := c.Signals
+	if len(signals) == 0 {
+		signals = options.SignalsList()
+	}
+	for _, signal := range signals {
+		if !containsSignal([]string{"traces", "metrics", "logs"}, signal) {
+			return nil, fmt.Errorf("unknown signal %q", signal)
+		}
+	}
+	return signals, nil
+}
+
+func newNamedOTLPExporter(options *Options, c *ExporterConfig) (*namedExporter, error) {
+	if c.Endpoint == "" {
+		return nil, fmt.Errorf("endpoint is required")
+	}
+	opts := []otlp.ClientOption{
+		otlp.WithEndpoint(os.ExpandEnv(c.Endpoint)),
+		otlp.WithUserAgent("jsonl-otel-forwarder/" + Version),
+		otlp.WithGzip(c.Gzip),
+	}
+	if c.Protocol != "" {
+		opts = append(opts, otlp.WithProtocol(c.Protocol))
+	}
+	if len(c.Headers) > 0 {
+		opts = append(opts, otlp.WithHeaders(expandHeaders(c.Headers)))
+	}
+	timeout, err := parseExporterTimeout(c)
+	if err != nil {
+		return nil, err
+	}
+	if timeout > 0 {
+		opts = append(opts, otlp.WithExportTimeout(timeout))
+	}
+	if _, err := otlp.NewClient("http://localhost:4317", opts...); err != nil {
+		return nil, err
+	}
+	retry, err := newRetryPolicy(c.Retry)
+	if err != nil {
+		return nil, fmt.Errorf("retry: %w", err)
+	}
+	if c.TenantHeader.Enabled() {
+		if err := c.TenantHeader.validate(); err != nil {
+			return nil, fmt.Errorf("tenant_header: %w", err)
+		}
+	}
+	signals, err := exporterSignals(options, c)
+	if err != nil {
+		return nil, err
+	}
+	return &namedExporter{
+		name:    c.Name,
+		signals: signals,
+		retry:   retry,
+		new: func() (Exporter, error) {
+			return newOTLPExporter(opts, c.TenantHeader, nil)
+		},
+	}, nil
+}
+
+// expandHeaders replaces ${NAME} in the header values with the environment variables.
+func expandHeaders(headers map[string]string) map[string]string {
+	if len(headers) == 0 {
+		return nil
+	}
+	expanded := make(map[string]string, len(headers))
+	for key, value := range headers {
+		expanded[key] = os.ExpandEnv(value)
+	}
+	return expanded
+}
+
+// parseExporterTimeout returns the timeout of the exporter config, 0 when empty.
+func parseExporterTimeout(c *ExporterConfig) (time.Duration, error) {
+	if c.Timeout == "" {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(c.Timeout)
+	if err != nil {
+		return 0, fmt.Errorf("timeout: %w", err)
+	}
+	return timeout, nil
+}
+
+const defaultHTTPExportTimeout = 30 * time.Second
+
+// newHTTPExportClient returns the client of HTTP exporters other than OTLP.
+func newHTTPExportClient(client *http.Client, timeout time.Duration) *http.Client {
+	if client != nil {
+		return client
+	}
+	if timeout == 0 {
+		timeout = defaultHTTPExportTimeout
+	}
+	return &http.Client{Timeout: timeout}
+}
+
+// newHTTPExportBody returns the request body, compressed with gzip when enabled.
+func newHTTPExportBody(bs []byte, enabled bool) (*bytes.Buffer, error) {
+	var body bytes.Buffer
+	if !enabled {
+		body.Write(bs)
+		return &body, nil
+	}
+	gz := gzip.NewWriter(&body)
+	if _, err := gz.Write(bs); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return &body, nil
+}
+
+// doHTTPExport sends the request of HTTP exporters other than OTLP.
+// only server errors and throttling are retried, as the other requests would be rejected again.
+func doHTTPExport(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	err = fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	if resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests {
+		return err
+	}
+	return &permanentError{err: err}
+}
+
+// startedExporter is the exporter of an invocation.
+type startedExporter struct {
+	*namedExporter
+	Exporter
+	selfMetrics *SelfMetrics
+}
+
+func (f *Forwarder) startExporters(ctx context.Context) ([]*startedExporter, error) {
+	var (
+		started []*startedExporter
+		errs    []error
+	)
+	for _, e := range f.exporters {
+		exporter, err := e.new()
+		if err == nil {
+			err = exporter.Start(ctx)
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to start exporter", "exporter", e.name, "error", err)
+			f.selfMetrics.Add("exporters."+e.name+".failed_starts", 1)
+			errs = append(errs, fmt.Errorf("start exporter %s: %w", e.name, err))
+			continue
+		}
+		started = append(started, &startedExporter{namedExporter: e, Exporter: exporter, selfMetrics: f.selfMetrics})
+	}
+	if len(started) == 0 {
+		return nil, errors.Join(errs...)
+	}
+	return started, nil
+}
+
+func stopExporters(ctx context.Context, exporters []*startedExporter) error {
+	var errs []error
+	for _, e := range exporters {
+		if err := e.Stop(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("stop exporter %s: %w", e.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// permanentError is the export error that is not retried, e.g. the request rejected by the destination.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// export exports the result with retry. failures of an exporter do not affect the others.
+func (e *startedExporter) export(ctx context.Context, result *PaseResult) error {
+	interval := e.retry.initialInterval
+	var (
+		err       error
+		permanent *permanentError
+	)
+	for attempt := 1; ; attempt++ {
+		if err = e.Export(ctx, result); err == nil {
+			e.selfMetrics.Add("exporters."+e.name+".exports", 1)
+			return nil
+		}
+		if attempt >= e.retry.maxAttempts || ctx.Err() != nil || errors.As(err, &permanent) {
+			break
+		}
+		slog.WarnContext(ctx, "retry export", "exporter", e.name, "attempt", attempt, "interval", interval, "error", err)
+		e.selfMetrics.Add("exporters."+e.name+".retries", 1)
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			err = errors.Join(err, ctx.Err())
+		case <-timer.C:
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		interval = min(interval*2, e.retry.maxInterval)
+	}
+	e.selfMetrics.Add("exporters."+e.name+".failed_exports", 1)
+	return fmt.Errorf("exporter %s: %w", e.name, err)
+}
+
+// exportToAll exports the result to all exporters accepting the signal and the route in parallel.
+func exportToAll(ctx context.Context, exporters []*startedExporter, result *PaseResult) error {
+	signal := resultSignal(result)
+	var (
+		mu   sync.Mutex
+		errs []error
+		wg   sync.WaitGroup
+	)
+	for _, e := range exporters {
+		if !e.accepts(signal) {
+			continue
+		}
+		if len(result.Exporters) > 0 && !slices.Contains(result.Exporters, e.name) {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := e.export(ctx, result); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// OTLPExporter exports parse results with the OTLP client.
+type OTLPExporter struct {
+	opts   []otlp.ClientOption
+	client *otlp.Client
+	tenant *TenantHeaderConfig
+	// signalHeaders are the headers of each signal in opts, overridden by the tenant clients.
+	signalHeaders map[string]map[string]string
+
+	mu            sync.Mutex
+	tenantClients map[string]*otlp.Client
+	tenants       tenantExports
+}
+
+func NewOTLPExporter(opts ...otlp.ClientOption) (*OTLPExporter, error) {
+	return newOTLPExporter(opts, nil, nil)
+}
+
+// newOTLPExporter returns the exporter. signalHeaders are the signal headers set in opts, that the tenant clients add the tenant header to.
+func newOTLPExporter(opts []otlp.ClientOption, tenant *TenantHeaderConfig, signalHeaders map[string]map[string]string) (*OTLPExporter, error) {
+	opts = append(opts[:len(opts):len(opts)], otlp.WithLogger(slog.Default()))
+	client, err := otlp.NewClient("http://localhost:4317", opts...)
+	if err != nil {
+		return nil, fmt.Errorf("create otlp client: %w", err)
+	}
+	if !tenant.Enabled() {
+		tenant = nil
+	}
+	return &OTLPExporter{
+		opts:          opts,
+		client:        client,
+		tenant:        tenant,
+		signalHeaders: signalHeaders,
+		tenantClients: make(map[string]*otlp.Client),
+	}, nil
+}
+
+func (e *OTLPExporter) Start(ctx context.Context) error {
+	slog.InfoContext(ctx, "start otlp client")
+	if err := e.client.Start(ctx); err != nil {
+		return fmt.Errorf("start otlp client: %w", err)
+	}
+	return nil
+}
+
+func (e *OTLPExporter) Stop(ctx context.Context) error {
+	slog.InfoContext(ctx, "stop otlp client")
+	var errs []error
+	if err := e.client.Stop(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("stop otlp client: %w", err))
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for tenant, client := range e.tenantClients {
+		if err := client.Stop(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("stop otlp client of tenant %s: %w", tenant, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (e *OTLPExporter) Export(ctx context.Context, result *PaseResult) error {
+	if e.tenant == nil {
+		return e.upload(ctx, e.client, result)
+	}
+	tenants, byTenant := splitByResource(result, e.tenant.tenantOf)
+	return e.tenants.export(result, tenants, func(tenant string) error {
+		client, err := e.tenantClient(ctx, tenant)
+		if err != nil {
+			return err
+		}
+		slog.DebugContext(ctx, "upload with tenant header", "header", e.tenant.Header, "tenant", tenant)
+		return e.upload(ctx, client, byTenant[tenant])
+	})
+}
+
+func (e *OTLPExporter) upload(ctx context.Context, client *otlp.Client, result *PaseResult) error {
+	if result.Traces != nil {
+		recourceSpans := result.Traces.GetResourceSpans()
+		slog.InfoContext(ctx, "upload traces", "resource_spans", len(recourceSpans), "trace_ids", distinctListTraceIDs(recourceSpans))
+		if err := client.UploadTraces(ctx, recourceSpans); err != nil {
+			return fmt.Errorf("upload traces: %w", err)
+		}
+		slog.DebugContext(ctx, "uploaded traces", "resource_spans", len(recourceSpans))
+	}
+	if result.Metrics != nil {
+		resourceMetrics := result.Metrics.GetResourceMetrics()
+		slog.InfoContext(ctx, "upload metrics", "resource_metrics", len(resourceMetrics))
+		if err := client.UploadMetrics(ctx, resourceMetrics); err != nil {
+			return fmt.Errorf("upload metrics: %w", err)
+		}
+		slog.DebugContext(ctx, "uploaded metrics", "resource_metrics", len(resourceMetrics))
+	}
+	if result.Logs != nil {
+		resourceLogs := result.Logs.GetResourceLogs()
+		slog.InfoContext(ctx, "upload logs", "resource_logs", len(resourceLogs))
+		if err := client.UploadLogs(ctx, resourceLogs); err != nil {
+			return fmt.Errorf("upload logs: %w", err)
+		}
+		slog.DebugContext(ctx, "uploaded logs", "resource_logs", len(resourceLogs))
+	}
+	return nil
+}
+
+// containsSignal reports whether the signals contain the signal, in singular or plural form.
+func containsSignal(signals []string, signal string) bool {
+	for _, s := range signals {
+		if strings.EqualFold(strings.TrimSuffix(strings.TrimSpace(s), "s"), strings.TrimSuffix(signal, "s")) {
+			return true
+		}
+	}
+	return false
+}
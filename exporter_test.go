@@ -0,0 +1,146 @@
+package jsonlotelforwarder_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	otlpmux "github.com/mashiike/go-otlp-helper/otlp"
+	"github.com/mashiike/go-otlp-helper/otlp/otlptest"
+	jsonlotelforwarder "github.com/mashiike/jsonl-otel-forwarder"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T, config any) string {
+	t.Helper()
+	bs, err := json.Marshal(config)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(path, bs, 0644))
+	return path
+}
+
+func TestForwarder__Exporters(t *testing.T) {
+	var (
+		mu            sync.Mutex
+		primaryLogs   int
+		primaryTraces int
+		primaryKey    string
+		secondaryLogs int
+		secondaryErrs int
+	)
+	primary := otlpmux.NewServerMux()
+	primary.Trace().HandleFunc(func(ctx context.Context, request *otlpmux.TraceRequest) (*otlpmux.TraceResponse, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		primaryTraces++
+		return &otlpmux.TraceResponse{}, nil
+	})
+	primary.Logs().HandleFunc(func(ctx context.Context, request *otlpmux.LogsRequest) (*otlpmux.LogsResponse, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		primaryLogs++
+		headers, _ := otlpmux.HeadersFromContext(ctx)
+		primaryKey = headers.Get("Api-Key")
+		return &otlpmux.LogsResponse{}, nil
+	})
+	primaryServer := otlptest.NewServer(primary)
+	defer primaryServer.Close()
+
+	secondary := otlpmux.NewServerMux()
+	secondary.Logs().HandleFunc(func(ctx context.Context, request *otlpmux.LogsRequest) (*otlpmux.LogsResponse, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if secondaryErrs < 1 {
+			secondaryErrs++
+			return nil, errors.New("unavailable")
+		}
+		secondaryLogs++
+		return &otlpmux.LogsResponse{}, nil
+	})
+	secondaryServer := otlptest.NewServer(secondary)
+	defer secondaryServer.Close()
+
+	t.Setenv("PRIMARY_API_KEY", "dummy")
+	opts := jsonlotelforwarder.DefaultOptions()
+	opts.ConfigFile = writeConfig(t, map[string]any{
+		"exporters": []map[string]any{
+			{"name": "primary", "endpoint": primaryServer.URL, "protocol": "grpc", "headers": map[string]string{"Api-Key": "${PRIMARY_API_KEY}"}},
+			{"name": "secondary", "endpoint": secondaryServer.URL, "protocol": "grpc", "signals": []string{"logs"}, "retry": map[string]any{"max_attempts": 2, "initial_interval": "1ms"}},
+		},
+	})
+	forwarder, err := jsonlotelforwarder.New(opts)
+	require.NoError(t, err)
+
+	trace, err := os.ReadFile("testdata/trace.json")
+	require.NoError(t, err)
+	logs, err := os.ReadFile("testdata/logs.json")
+	require.NoError(t, err)
+	for _, payload := range [][]byte{trace, logs} {
+		resp, err := forwarder.Invoke(context.Background(), payload)
+		require.NoError(t, err)
+		require.JSONEq(t, `{"success":true}`, string(resp))
+	}
+	require.Equal(t, 1, primaryTraces)
+	require.Equal(t, 1, primaryLogs)
+	require.Equal(t, "dummy", primaryKey)
+	require.Equal(t, 1, secondaryLogs, "secondary receives only logs after retry")
+	require.Equal(t, int64(2), forwarder.SelfMetrics().Get("exporters.primary.exports"))
+	require.Equal(t, int64(1), forwarder.SelfMetrics().Get("exporters.secondary.exports"))
+	require.Equal(t, int64(1), forwarder.SelfMetrics().Get("exporters.secondary.retries"))
+}
+
+func TestForwarder__ExportersFailureIsIndependent(t *testing.T) {
+	var calls int
+	healthy := otlpmux.NewServerMux()
+	healthy.Logs().HandleFunc(func(ctx context.Context, request *otlpmux.LogsRequest) (*otlpmux.LogsResponse, error) {
+		calls++
+		return &otlpmux.LogsResponse{}, nil
+	})
+	healthyServer := otlptest.NewServer(healthy)
+	defer healthyServer.Close()
+	broken := otlpmux.NewServerMux()
+	broken.Logs().HandleFunc(func(ctx context.Context, request *otlpmux.LogsRequest) (*otlpmux.LogsResponse, error) {
+		return nil, errors.New("unavailable")
+	})
+	brokenServer := otlptest.NewServer(broken)
+	defer brokenServer.Close()
+
+	opts := jsonlotelforwarder.DefaultOptions()
+	opts.ConfigFile = writeConfig(t, map[string]any{
+		"exporters": []map[string]any{
+			{"name": "healthy", "endpoint": healthyServer.URL, "protocol": "grpc"},
+			{"name": "broken", "endpoint": brokenServer.URL, "protocol": "grpc", "retry": map[string]any{"max_attempts": 3, "initial_interval": "1ms", "max_interval": "2ms"}},
+		},
+	})
+	forwarder, err := jsonlotelforwarder.New(opts)
+	require.NoError(t, err)
+	logs, err := os.ReadFile("testdata/logs.json")
+	require.NoError(t, err)
+	resp, err := forwarder.Invoke(context.Background(), logs)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"success":true}`, string(resp))
+	require.Equal(t, 1, calls)
+	require.Equal(t, int64(1), forwarder.SelfMetrics().Get("exporters.healthy.exports"))
+	require.Equal(t, int64(2), forwarder.SelfMetrics().Get("exporters.broken.retries"))
+	require.Equal(t, int64(1), forwarder.SelfMetrics().Get("exporters.broken.failed_exports"))
+}
+
+func TestNew__InvalidExporters(t *testing.T) {
+	for _, exporters := range [][]map[string]any{
+		{{"endpoint": "http://localhost:4317"}},
+		{{"name": "a", "endpoint": "http://localhost:4317"}, {"name": "a", "endpoint": "http://localhost:4318"}},
+		{{"name": "a"}},
+		{{"name": "a", "endpoint": "http://localhost:4317", "signals": []string{"profiles"}}},
+		{{"name": "a", "endpoint": "http://localhost:4317", "retry": map[string]any{"initial_interval": "soon"}}},
+	} {
+		opts := jsonlotelforwarder.DefaultOptions()
+		opts.ConfigFile = writeConfig(t, map[string]any{"exporters": exporters})
+		_, err := jsonlotelforwarder.New(opts)
+		require.Error(t, err)
+	}
+}
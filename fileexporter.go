@@ -0,0 +1,226 @@
+package jsonlotelforwarder
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mashiike/go-otlp-helper/otlp"
+	"google.golang.org/protobuf/proto"
+)
+
+// FileExporterConfig is the configuration of the exporter that writes OTLP JSON lines.
+// Path `-` or `stdout` writes to stdout, and the path with `.gz` suffix is written with gzip.
+// when MaxBytes is set, the file is rotated to `<path without .gz>.1[.gz]` ... `.<MaxFiles>[.gz]` before it exceeds MaxBytes.
+// the bytes of gzip files are counted before compression in an invocation, so rotated gzip files are usually smaller than MaxBytes.
+type FileExporterConfig struct {
+	Path     string `json:"path"`
+	Pretty   bool   `json:"pretty,omitempty"`
+	MaxBytes int64  `json:"max_bytes,omitempty"`
+	MaxFiles int    `json:"max_files,omitempty"`
+}
+
+const defaultFileExporterMaxFiles = 5
+
+func (c *FileExporterConfig) stdout() bool {
+	return c.Path == "-" || c.Path == "stdout"
+}
+
+func (c *FileExporterConfig) gzip() bool {
+	return !c.stdout() && strings.HasSuffix(c.Path, ".gz")
+}
+
+func (c *FileExporterConfig) validate() error {
+	if c.Path == "" {
+		return fmt.Errorf("path is required")
+	}
+	if c.MaxBytes < 0 || c.MaxFiles < 0 {
+		return fmt.Errorf("max_bytes and max_files must not be negative")
+	}
+	if c.stdout() && c.MaxBytes > 0 {
+		return fmt.Errorf("stdout can not be rotated")
+	}
+	return nil
+}
+
+// FileExporter writes parse results as OTLP JSON lines, which can be read by the forwarder again.
+type FileExporter struct {
+	config FileExporterConfig
+
+	mu      sync.Mutex
+	file    *os.File
+	writer  io.Writer
+	gz      *gzip.Writer
+	written int64
+}
+
+func NewFileExporter(config *FileExporterConfig) (*FileExporter, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return &FileExporter{config: *config}, nil
+}
+
+func (e *FileExporter) Start(_ context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.open()
+}
+
+func (e *FileExporter) Stop(_ context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.close()
+}
+
+func (e *FileExporter) Export(ctx context.Context, result *PaseResult) error {
+	var messages []proto.Message
+	if result.Traces != nil {
+		messages = append(messages, result.Traces)
+	}
+	if result.Metrics != nil {
+		messages = append(messages, result.Metrics)
+	}
+	if result.Logs != nil {
+		messages = append(messages, result.Logs)
+	}
+	var buf bytes.Buffer
+	for _, message := range messages {
+		bs, err := otlp.MarshalJSON(message)
+		if err != nil {
+			return fmt.Errorf("marshal %s: %w", resultSignal(result), err)
+		}
+		if e.config.Pretty {
+			if err := json.Indent(&buf, bs, "", "  "); err != nil {
+				return fmt.Errorf("indent %s: %w", resultSignal(result), err)
+			}
+		} else {
+			buf.Write(bs)
+		}
+		buf.WriteByte('\n')
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.config.MaxBytes > 0 && e.written > 0 && e.written+int64(buf.Len()) > e.config.MaxBytes {
+		if err := e.rotate(); err != nil {
+			return fmt.Errorf("rotate %s: %w", e.config.Path, err)
+		}
+	}
+	slog.DebugContext(ctx, "write telemetry", "path", e.config.Path, "signal", resultSignal(result), "bytes", buf.Len())
+	n, err := e.writer.Write(buf.Bytes())
+	e.written += int64(n)
+	if err != nil {
+		return fmt.Errorf("write %s: %w", e.config.Path, err)
+	}
+	return nil
+}
+
+func newNamedFileExporter(config *FileExporterConfig, signals []string) (*namedExporter, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return &namedExporter{
+		signals: signals,
+		retry:   retryPolicy{maxAttempts: 1},
+		new: func() (Exporter, error) {
+			return NewFileExporter(config)
+		},
+	}, nil
+}
+
+func newNamedFileExporterFromConfig(options *Options, c *ExporterConfig) (*namedExporter, error) {
+	signals, err := exporterSignals(options, c)
+	if err != nil {
+		return nil, err
+	}
+	e, err := newNamedFileExporter(&FileExporterConfig{
+		Path:     os.ExpandEnv(c.Path),
+		Pretty:   c.Pretty,
+		MaxBytes: c.MaxBytes,
+		MaxFiles: c.MaxFiles,
+	}, signals)
+	if err != nil {
+		return nil, err
+	}
+	e.name = c.Name
+	return e, nil
+}
+
+// open opens the file in append mode. the written bytes of the existing file count toward MaxBytes.
+func (e *FileExporter) open() error {
+	if e.config.stdout() {
+		e.writer = os.Stdout
+		return nil
+	}
+	file, err := os.OpenFile(e.config.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", e.config.Path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("stat %s: %w", e.config.Path, err)
+	}
+	e.file = file
+	e.written = info.Size()
+	e.writer = file
+	if e.config.gzip() {
+		// each invocation appends a gzip member, which is read as one stream by gzip readers.
+		e.gz = gzip.NewWriter(file)
+		e.writer = e.gz
+	}
+	return nil
+}
+
+func (e *FileExporter) close() error {
+	var errs []error
+	if e.gz != nil {
+		errs = append(errs, e.gz.Close())
+		e.gz = nil
+	}
+	if e.file != nil {
+		errs = append(errs, e.file.Close())
+		e.file = nil
+	}
+	return errors.Join(errs...)
+}
+
+// rotatedPath returns the path of the n-th rotated file, e.g. telemetry.jsonl.1.gz.
+func (e *FileExporter) rotatedPath(n int) string {
+	base, ext := e.config.Path, ""
+	if e.config.gzip() {
+		base, ext = strings.TrimSuffix(base, ".gz"), ".gz"
+	}
+	return base + "." + strconv.Itoa(n) + ext
+}
+
+func (e *FileExporter) rotate() error {
+	if err := e.close(); err != nil {
+		return err
+	}
+	maxFiles := e.config.MaxFiles
+	if maxFiles == 0 {
+		maxFiles = defaultFileExporterMaxFiles
+	}
+	if err := os.Remove(e.rotatedPath(maxFiles)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for n := maxFiles - 1; n >= 1; n-- {
+		if err := os.Rename(e.rotatedPath(n), e.rotatedPath(n+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if err := os.Rename(e.config.Path, e.rotatedPath(1)); err != nil {
+		return err
+	}
+	return e.open()
+}
@@ -0,0 +1,121 @@
+package jsonlotelforwarder_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	jsonlotelforwarder "github.com/mashiike/jsonl-otel-forwarder"
+	"github.com/stretchr/testify/require"
+)
+
+func readLines(t *testing.T, name string) []string {
+	t.Helper()
+	f, err := os.Open(name)
+	require.NoError(t, err)
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(name, ".gz") {
+		gz, err := gzip.NewReader(f)
+		require.NoError(t, err)
+		r = gz
+	}
+	bs, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return strings.Split(strings.TrimSuffix(string(bs), "\n"), "\n")
+}
+
+func TestForwarder__FileExporter(t *testing.T) {
+	dir := t.TempDir()
+	opts := jsonlotelforwarder.DefaultOptions()
+	opts.Signals = "traces,logs"
+	opts.FilePath = filepath.Join(dir, "telemetry.jsonl.gz")
+	opts.FileTracesPath = filepath.Join(dir, "traces.jsonl")
+	forwarder, err := jsonlotelforwarder.New(opts)
+	require.NoError(t, err)
+
+	for _, name := range []string{"testdata/trace.json", "testdata/logs.json", "testdata/logs2.json"} {
+		bs, err := os.ReadFile(name)
+		require.NoError(t, err)
+		_, err = forwarder.Invoke(context.Background(), bs)
+		require.NoError(t, err)
+	}
+
+	traces := readLines(t, opts.FileTracesPath)
+	require.Len(t, traces, 1)
+	results, ok := jsonlotelforwarder.Parse([]byte(traces[0]))
+	require.True(t, ok, "written lines are read by the forwarder again")
+	require.Equal(t, loadParseResults(t, "testdata/trace.json")[0].Traces.GetResourceSpans()[0].GetResource().GetAttributes(),
+		results[0].Traces.GetResourceSpans()[0].GetResource().GetAttributes())
+
+	logs := readLines(t, opts.FilePath)
+	require.Len(t, logs, 2, "gzip members appended by each invocation are read as one stream")
+	for _, line := range logs {
+		require.True(t, strings.HasPrefix(line, `{"resourceLogs":`))
+	}
+}
+
+func TestFileExporter__Rotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs.jsonl")
+	result := loadParseResults(t, "testdata/logs.json")[0]
+	exporter, err := jsonlotelforwarder.NewFileExporter(&jsonlotelforwarder.FileExporterConfig{Path: path, MaxBytes: 1, MaxFiles: 2})
+	require.NoError(t, err)
+	ctx := context.Background()
+	require.NoError(t, exporter.Start(ctx))
+	for i := 0; i < 4; i++ {
+		require.NoError(t, exporter.Export(ctx, result))
+	}
+	require.NoError(t, exporter.Stop(ctx))
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		require.Len(t, readLines(t, name), 1)
+	}
+	_, err = os.Stat(path + ".3")
+	require.ErrorIs(t, err, os.ErrNotExist, "files older than max files are removed")
+}
+
+func TestFileExporter__Pretty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs.json")
+	exporter, err := jsonlotelforwarder.NewFileExporter(&jsonlotelforwarder.FileExporterConfig{Path: path, Pretty: true})
+	require.NoError(t, err)
+	ctx := context.Background()
+	require.NoError(t, exporter.Start(ctx))
+	require.NoError(t, exporter.Export(ctx, loadParseResults(t, "testdata/logs.json")[0]))
+	require.NoError(t, exporter.Stop(ctx))
+
+	bs, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.True(t, bytes.HasPrefix(bs, []byte("{\n  \"resourceLogs\": [")))
+}
+
+func TestNew__FileExporterConfig(t *testing.T) {
+	dir := t.TempDir()
+	opts := jsonlotelforwarder.DefaultOptions()
+	opts.ConfigFile = writeConfig(t, map[string]any{
+		"exporters": []map[string]any{
+			{"name": "archive", "type": "file", "path": filepath.Join(dir, "archive.jsonl"), "signals": []string{"logs"}},
+		},
+	})
+	forwarder, err := jsonlotelforwarder.New(opts)
+	require.NoError(t, err)
+	logs, err := os.ReadFile("testdata/logs.json")
+	require.NoError(t, err)
+	_, err = forwarder.Invoke(context.Background(), logs)
+	require.NoError(t, err)
+	require.Len(t, readLines(t, filepath.Join(dir, "archive.jsonl")), 1)
+
+	for _, exporter := range []map[string]any{
+		{"name": "archive", "type": "file"},
+		{"name": "archive", "type": "file", "path": "-", "max_bytes": 1024},
+		{"name": "archive", "type": "s3", "path": "archive.jsonl"},
+	} {
+		opts.ConfigFile = writeConfig(t, map[string]any{"exporters": []map[string]any{exporter}})
+		_, err = jsonlotelforwarder.New(opts)
+		require.Error(t, err)
+	}
+}
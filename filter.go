@@ -0,0 +1,189 @@
+package jsonlotelforwarder
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// FilterConfig is the configuration of filter processor.
+// spans, data points and log records matching any of the expressions are dropped.
+type FilterConfig struct {
+	Spans      []string `json:"spans,omitempty"`
+	DataPoints []string `json:"datapoints,omitempty"`
+	Logs       []string `json:"logs,omitempty"`
+}
+
+func (c *FilterConfig) Enabled() bool {
+	return c != nil && (len(c.Spans) > 0 || len(c.DataPoints) > 0 || len(c.Logs) > 0)
+}
+
+type FilterProcessor struct {
+	spans       []*FilterExpr
+	dataPoints  []*FilterExpr
+	logs        []*FilterExpr
+	selfMetrics *SelfMetrics
+}
+
+func NewFilterProcessor(config *FilterConfig, selfMetrics *SelfMetrics) (*FilterProcessor, error) {
+	compile := func(name string, srcs []string) ([]*FilterExpr, error) {
+		exprs := make([]*FilterExpr, 0, len(srcs))
+		for i, src := range srcs {
+			expr, err := CompileFilterExpr(src)
+			if err != nil {
+				return nil, fmt.Errorf("%s[%d] %q: %w", name, i, src, err)
+			}
+			exprs = append(exprs, expr)
+		}
+		return exprs, nil
+	}
+	p := &FilterProcessor{selfMetrics: selfMetrics}
+	var err error
+	if p.spans, err = compile("spans", config.Spans); err != nil {
+		return nil, err
+	}
+	if p.dataPoints, err = compile("datapoints", config.DataPoints); err != nil {
+		return nil, err
+	}
+	if p.logs, err = compile("logs", config.Logs); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func matchAny(exprs []*FilterExpr, item *FilterItem) bool {
+	for _, expr := range exprs {
+		if expr.Match(item) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *FilterProcessor) Process(_ context.Context, results []*PaseResult) ([]*PaseResult, error) {
+	for _, result := range results {
+		if len(p.spans) > 0 {
+			p.selfMetrics.Add("filter.dropped_spans", int64(FilterSpans(result, func(item *FilterItem) bool {
+				return !matchAny(p.spans, item)
+			})))
+		}
+		if len(p.dataPoints) > 0 {
+			p.selfMetrics.Add("filter.dropped_data_points", int64(FilterDataPoints(result, func(item *FilterItem) bool {
+				return !matchAny(p.dataPoints, item)
+			})))
+		}
+		if len(p.logs) > 0 {
+			p.selfMetrics.Add("filter.dropped_log_records", int64(FilterLogRecords(result, func(item *FilterItem) bool {
+				return !matchAny(p.logs, item)
+			})))
+		}
+		PruneResult(result)
+	}
+	return results, nil
+}
+
+// FilterSpans keeps the spans that keep returns true, and returns the number of dropped spans.
+func FilterSpans(result *PaseResult, keep func(item *FilterItem) bool) int {
+	var dropped int
+	for _, resourceSpans := range result.Traces.GetResourceSpans() {
+		for _, scopeSpans := range resourceSpans.GetScopeSpans() {
+			before := len(scopeSpans.GetSpans())
+			scopeSpans.Spans = slices.DeleteFunc(scopeSpans.GetSpans(), func(span *tracepb.Span) bool {
+				return !keep(&FilterItem{Resource: resourceSpans.GetResource(), Scope: scopeSpans.GetScope(), Span: span})
+			})
+			dropped += before - len(scopeSpans.GetSpans())
+		}
+	}
+	return dropped
+}
+
+// FilterDataPoints keeps the data points that keep returns true, and returns the number of dropped data points.
+func FilterDataPoints(result *PaseResult, keep func(item *FilterItem) bool) int {
+	var dropped int
+	for _, resourceMetrics := range result.Metrics.GetResourceMetrics() {
+		for _, scopeMetrics := range resourceMetrics.GetScopeMetrics() {
+			for _, metric := range scopeMetrics.GetMetrics() {
+				dropped += filterMetricDataPoints(metric, func(dp dataPoint) bool {
+					return keep(&FilterItem{Resource: resourceMetrics.GetResource(), Scope: scopeMetrics.GetScope(), Metric: metric, DataPoint: dp})
+				})
+			}
+		}
+	}
+	return dropped
+}
+
+func filterMetricDataPoints(metric *metricspb.Metric, keep func(dp dataPoint) bool) int {
+	before := countDataPoints(metric)
+	switch data := metric.GetData().(type) {
+	case *metricspb.Metric_Gauge:
+		data.Gauge.DataPoints = slices.DeleteFunc(data.Gauge.GetDataPoints(), func(dp *metricspb.NumberDataPoint) bool { return !keep(dp) })
+	case *metricspb.Metric_Sum:
+		data.Sum.DataPoints = slices.DeleteFunc(data.Sum.GetDataPoints(), func(dp *metricspb.NumberDataPoint) bool { return !keep(dp) })
+	case *metricspb.Metric_Summary:
+		data.Summary.DataPoints = slices.DeleteFunc(data.Summary.GetDataPoints(), func(dp *metricspb.SummaryDataPoint) bool { return !keep(dp) })
+	case *metricspb.Metric_Histogram:
+		data.Histogram.DataPoints = slices.DeleteFunc(data.Histogram.GetDataPoints(), func(dp *metricspb.HistogramDataPoint) bool { return !keep(dp) })
+	case *metricspb.Metric_ExponentialHistogram:
+		data.ExponentialHistogram.DataPoints = slices.DeleteFunc(data.ExponentialHistogram.GetDataPoints(), func(dp *metricspb.ExponentialHistogramDataPoint) bool { return !keep(dp) })
+	}
+	return before - countDataPoints(metric)
+}
+
+// FilterLogRecords keeps the log records that keep returns true, and returns the number of dropped log records.
+func FilterLogRecords(result *PaseResult, keep func(item *FilterItem) bool) int {
+	var dropped int
+	for _, resourceLogs := range result.Logs.GetResourceLogs() {
+		for _, scopeLogs := range resourceLogs.GetScopeLogs() {
+			before := len(scopeLogs.GetLogRecords())
+			scopeLogs.LogRecords = slices.DeleteFunc(scopeLogs.GetLogRecords(), func(record *logspb.LogRecord) bool {
+				return !keep(&FilterItem{Resource: resourceLogs.GetResource(), Scope: scopeLogs.GetScope(), LogRecord: record})
+			})
+			dropped += before - len(scopeLogs.GetLogRecords())
+		}
+	}
+	return dropped
+}
+
+// PruneResult removes empty metrics, scopes and resources, and clears the signals that have nothing to export.
+func PruneResult(result *PaseResult) {
+	if result.Traces != nil {
+		result.Traces.ResourceSpans = slices.DeleteFunc(result.Traces.GetResourceSpans(), func(resourceSpans *tracepb.ResourceSpans) bool {
+			resourceSpans.ScopeSpans = slices.DeleteFunc(resourceSpans.GetScopeSpans(), func(scopeSpans *tracepb.ScopeSpans) bool {
+				return len(scopeSpans.GetSpans()) == 0
+			})
+			return len(resourceSpans.GetScopeSpans()) == 0
+		})
+		if len(result.Traces.GetResourceSpans()) == 0 {
+			result.Traces = nil
+		}
+	}
+	if result.Metrics != nil {
+		result.Metrics.ResourceMetrics = slices.DeleteFunc(result.Metrics.GetResourceMetrics(), func(resourceMetrics *metricspb.ResourceMetrics) bool {
+			resourceMetrics.ScopeMetrics = slices.DeleteFunc(resourceMetrics.GetScopeMetrics(), func(scopeMetrics *metricspb.ScopeMetrics) bool {
+				scopeMetrics.Metrics = slices.DeleteFunc(scopeMetrics.GetMetrics(), func(metric *metricspb.Metric) bool {
+					return countDataPoints(metric) == 0
+				})
+				return len(scopeMetrics.GetMetrics()) == 0
+			})
+			return len(resourceMetrics.GetScopeMetrics()) == 0
+		})
+		if len(result.Metrics.GetResourceMetrics()) == 0 {
+			result.Metrics = nil
+		}
+	}
+	if result.Logs != nil {
+		result.Logs.ResourceLogs = slices.DeleteFunc(result.Logs.GetResourceLogs(), func(resourceLogs *logspb.ResourceLogs) bool {
+			resourceLogs.ScopeLogs = slices.DeleteFunc(resourceLogs.GetScopeLogs(), func(scopeLogs *logspb.ScopeLogs) bool {
+				return len(scopeLogs.GetLogRecords()) == 0
+			})
+			return len(resourceLogs.GetScopeLogs()) == 0
+		})
+		if len(result.Logs.GetResourceLogs()) == 0 {
+			result.Logs = nil
+		}
+	}
+}
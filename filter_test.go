@@ -0,0 +1,42 @@
+package jsonlotelforwarder_test
+
+import (
+	"context"
+	"testing"
+
+	jsonlotelforwarder "github.com/mashiike/jsonl-otel-forwarder"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterProcessor(t *testing.T) {
+	selfMetrics := jsonlotelforwarder.NewSelfMetrics()
+	p, err := jsonlotelforwarder.NewFilterProcessor(&jsonlotelforwarder.FilterConfig{
+		Spans:      []string{`kind == SERVER and name =~ "server span"`},
+		DataPoints: []string{`name glob "my.gauge"`, `name == "my.histogram"`},
+		Logs:       []string{`severity < WARN and body =~ "Example"`},
+	}, selfMetrics)
+	require.NoError(t, err)
+	results := loadParseResults(t, "testdata/trace.json")
+	results = append(results, loadParseResults(t, "testdata/metrics.json")...)
+	results = append(results, loadParseResults(t, "testdata/logs.json")...)
+
+	results, err = p.Process(context.Background(), results)
+	require.NoError(t, err)
+	require.True(t, results[0].Skip(), "all spans are dropped")
+	metrics := results[1].Metrics.GetResourceMetrics()[0].GetScopeMetrics()[0].GetMetrics()
+	require.Len(t, metrics, 1)
+	require.Equal(t, "my.counter", metrics[0].GetName())
+	require.True(t, results[2].Skip(), "all log records are dropped")
+	require.Equal(t, map[string]int64{
+		"filter.dropped_spans":       1,
+		"filter.dropped_data_points": 2,
+		"filter.dropped_log_records": 1,
+	}, selfMetrics.Snapshot())
+}
+
+func TestNewFilterProcessor__Invalid(t *testing.T) {
+	_, err := jsonlotelforwarder.NewFilterProcessor(&jsonlotelforwarder.FilterConfig{
+		Logs: []string{`severity <`},
+	}, nil)
+	require.Error(t, err)
+}
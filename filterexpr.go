@@ -0,0 +1,653 @@
+package jsonlotelforwarder
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// FilterExpr is a compiled filter expression, evaluated per span, data point or log record.
+//
+// the expression compares fields with literals, and combines comparisons with and, or, not and parentheses.
+//
+//	name == "GET /health" or attributes["http.route"] =~ "^/internal/"
+//	severity < INFO
+//	kind == SERVER and duration < 5ms
+//	name glob "system.cpu.*"
+//
+// fields: name, kind, status, duration, severity, severity_text, body, metric.type, scope.name,
+// attributes["key"], resource.attributes["key"] and scope.attributes["key"].
+// operators: ==, !=, <, <=, >, >=, =~ (regexp match), !~ (regexp not match) and glob.
+type FilterExpr struct {
+	src  string
+	node filterNode
+}
+
+func CompileFilterExpr(src string) (*FilterExpr, error) {
+	tokens, err := lexFilterExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.eof() {
+		return nil, fmt.Errorf("unexpected token %q at %d", p.peek().text, p.peek().pos)
+	}
+	return &FilterExpr{src: src, node: node}, nil
+}
+
+func (e *FilterExpr) String() string {
+	return e.src
+}
+
+// FilterItem is the item that a filter expression is evaluated with.
+// only one of Span, DataPoint and LogRecord is set.
+type FilterItem struct {
+	Resource  *resourcepb.Resource
+	Scope     *commonpb.InstrumentationScope
+	Span      *tracepb.Span
+	Metric    *metricspb.Metric
+	DataPoint dataPoint
+	LogRecord *logspb.LogRecord
+}
+
+func (e *FilterExpr) Match(item *FilterItem) bool {
+	return e.node.eval(item)
+}
+
+type filterTokenKind int
+
+const (
+	filterTokenEOF filterTokenKind = iota
+	filterTokenIdent
+	filterTokenString
+	filterTokenNumber
+	filterTokenOperator
+	filterTokenLParen
+	filterTokenRParen
+	filterTokenLBracket
+	filterTokenRBracket
+)
+
+type filterToken struct {
+	kind filterTokenKind
+	text string
+	pos  int
+}
+
+func lexFilterExpr(src string) ([]filterToken, error) {
+	var tokens []filterToken
+	runes := []rune(src)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, filterToken{kind: filterTokenLParen, text: "(", pos: i})
+			i++
+		case r == ')':
+			tokens = append(tokens, filterToken{kind: filterTokenRParen, text: ")", pos: i})
+			i++
+		case r == '[':
+			tokens = append(tokens, filterToken{kind: filterTokenLBracket, text: "[", pos: i})
+			i++
+		case r == ']':
+			tokens = append(tokens, filterToken{kind: filterTokenRBracket, text: "]", pos: i})
+			i++
+		case r == '"' || r == '\'':
+			j := i + 1
+			for j < len(runes) && runes[j] != r {
+				if runes[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(runes) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			text := string(runes[i+1 : j])
+			if r == '"' {
+				unquoted, err := strconv.Unquote(string(runes[i : j+1]))
+				if err != nil {
+					return nil, fmt.Errorf("invalid string at %d: %w", i, err)
+				}
+				text = unquoted
+			}
+			tokens = append(tokens, filterToken{kind: filterTokenString, text: text, pos: i})
+			i = j + 1
+		case strings.ContainsRune("=!<>", r):
+			j := i + 1
+			if j < len(runes) && (runes[j] == '=' || runes[j] == '~') {
+				j++
+			}
+			op := string(runes[i:j])
+			switch op {
+			case "==", "!=", "<", "<=", ">", ">=", "=~", "!~":
+			default:
+				return nil, fmt.Errorf("unknown operator %q at %d", op, i)
+			}
+			tokens = append(tokens, filterToken{kind: filterTokenOperator, text: op, pos: i})
+			i = j
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			j := i + 1
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || unicode.IsLetter(runes[j]) || runes[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, filterToken{kind: filterTokenNumber, text: string(runes[i:j]), pos: i})
+			i = j
+		case unicode.IsLetter(r) || r == '_':
+			j := i + 1
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_' || runes[j] == '.') {
+				j++
+			}
+			text := string(runes[i:j])
+			kind := filterTokenIdent
+			if strings.EqualFold(text, "glob") {
+				kind = filterTokenOperator
+				text = "glob"
+			}
+			tokens = append(tokens, filterToken{kind: kind, text: text, pos: i})
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected character %q at %d", r, i)
+		}
+	}
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+func (p *filterParser) eof() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *filterParser) peek() filterToken {
+	if p.eof() {
+		return filterToken{kind: filterTokenEOF, text: "<EOF>", pos: -1}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() filterToken {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *filterParser) isKeyword(keyword string) bool {
+	t := p.peek()
+	return t.kind == filterTokenIdent && strings.EqualFold(t.text, keyword)
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &filterOrNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("and") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &filterAndNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseNot() (filterNode, error) {
+	if p.isKeyword("not") {
+		p.next()
+		node, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &filterNotNode{node: node}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *filterParser) parsePrimary() (filterNode, error) {
+	if p.peek().kind == filterTokenLParen {
+		p.next()
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != filterTokenRParen {
+			return nil, fmt.Errorf("expected ) at %d, got %q", t.pos, t.text)
+		}
+		return node, nil
+	}
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	op := p.next()
+	if op.kind != filterTokenOperator {
+		return nil, fmt.Errorf("expected operator at %d, got %q", op.pos, op.text)
+	}
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return newFilterCompareNode(left, op.text, right)
+}
+
+func (p *filterParser) parseOperand() (filterOperand, error) {
+	t := p.next()
+	switch t.kind {
+	case filterTokenString:
+		return &filterLiteral{v: t.text}, nil
+	case filterTokenNumber:
+		if d, err := time.ParseDuration(t.text); err == nil && strings.IndexFunc(t.text, unicode.IsLetter) >= 0 {
+			return &filterLiteral{v: float64(d.Nanoseconds())}, nil
+		}
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at %d", t.text, t.pos)
+		}
+		return &filterLiteral{v: f}, nil
+	case filterTokenIdent:
+		name := strings.ToLower(t.text)
+		if p.peek().kind == filterTokenLBracket {
+			p.next()
+			key := p.next()
+			if key.kind != filterTokenString {
+				return nil, fmt.Errorf("expected attribute key string at %d, got %q", key.pos, key.text)
+			}
+			if end := p.next(); end.kind != filterTokenRBracket {
+				return nil, fmt.Errorf("expected ] at %d, got %q", end.pos, end.text)
+			}
+			switch name {
+			case "attributes", "resource.attributes", "scope.attributes":
+				return &filterAttributeField{from: name, key: key.text}, nil
+			}
+			return nil, fmt.Errorf("unknown attributes field %q at %d", t.text, t.pos)
+		}
+		if field, ok := filterFields[name]; ok {
+			return &filterField{name: name, get: field}, nil
+		}
+		if v, ok := filterBoolConstants[strings.ToUpper(t.text)]; ok {
+			return &filterLiteral{v: v}, nil
+		}
+		for _, constants := range filterConstants {
+			if _, ok := constants[strings.ToUpper(t.text)]; ok {
+				return &filterConstant{name: strings.ToUpper(t.text), pos: t.pos}, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown identifier %q at %d", t.text, t.pos)
+	}
+	return nil, fmt.Errorf("expected operand at %d, got %q", t.pos, t.text)
+}
+
+type filterNode interface {
+	eval(item *FilterItem) bool
+}
+
+type filterOrNode struct{ left, right filterNode }
+
+func (n *filterOrNode) eval(item *FilterItem) bool { return n.left.eval(item) || n.right.eval(item) }
+
+type filterAndNode struct{ left, right filterNode }
+
+func (n *filterAndNode) eval(item *FilterItem) bool { return n.left.eval(item) && n.right.eval(item) }
+
+type filterNotNode struct{ node filterNode }
+
+func (n *filterNotNode) eval(item *FilterItem) bool { return !n.node.eval(item) }
+
+type filterOperand interface {
+	value(item *FilterItem) (any, bool)
+}
+
+type filterLiteral struct {
+	v any
+}
+
+func (l *filterLiteral) value(*FilterItem) (any, bool) { return l.v, true }
+
+type filterFieldFunc func(item *FilterItem) (any, bool)
+
+// filterField is a field of the item. the name resolves the constants compared with the field.
+type filterField struct {
+	name string
+	get  filterFieldFunc
+}
+
+func (f *filterField) value(item *FilterItem) (any, bool) { return f.get(item) }
+
+// filterConstant is a constant identifier, e.g. ERROR, resolved by the field on the other side of the comparison,
+// because the same name has different values for status and severity.
+type filterConstant struct {
+	name string
+	pos  int
+}
+
+func (c *filterConstant) value(*FilterItem) (any, bool) { return nil, false }
+
+// resolve returns the literal of the constant for the field compared with.
+func (c *filterConstant) resolve(other filterOperand) (filterOperand, error) {
+	field, ok := other.(*filterField)
+	if !ok {
+		return nil, fmt.Errorf("constant %s at %d must be compared with kind, status or severity", c.name, c.pos)
+	}
+	v, ok := filterConstants[filterFieldConstants[field.name]][c.name]
+	if !ok {
+		return nil, fmt.Errorf("constant %s at %d is not a value of %s", c.name, c.pos, field.name)
+	}
+	return &filterLiteral{v: v}, nil
+}
+
+type filterAttributeField struct {
+	from string
+	key  string
+}
+
+func (f *filterAttributeField) value(item *FilterItem) (any, bool) {
+	var attrs []*commonpb.KeyValue
+	switch f.from {
+	case "resource.attributes":
+		attrs = item.Resource.GetAttributes()
+	case "scope.attributes":
+		attrs = item.Scope.GetAttributes()
+	default:
+		switch {
+		case item.Span != nil:
+			attrs = item.Span.GetAttributes()
+		case item.DataPoint != nil:
+			attrs = item.DataPoint.GetAttributes()
+		case item.LogRecord != nil:
+			attrs = item.LogRecord.GetAttributes()
+		}
+	}
+	for _, attr := range attrs {
+		if attr.GetKey() == f.key {
+			return anyValueToFilterValue(attr.GetValue())
+		}
+	}
+	return nil, false
+}
+
+func anyValueToFilterValue(v *commonpb.AnyValue) (any, bool) {
+	switch v := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return v.StringValue, true
+	case *commonpb.AnyValue_IntValue:
+		return float64(v.IntValue), true
+	case *commonpb.AnyValue_DoubleValue:
+		return v.DoubleValue, true
+	case *commonpb.AnyValue_BoolValue:
+		return strconv.FormatBool(v.BoolValue), true
+	case nil:
+		return nil, false
+	}
+	return AnyValueString(v), true
+}
+
+// AnyValueString returns the string representation of the value, used for log bodies and non string attributes.
+func AnyValueString(v *commonpb.AnyValue) string {
+	switch v := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return v.StringValue
+	case *commonpb.AnyValue_IntValue:
+		return strconv.FormatInt(v.IntValue, 10)
+	case *commonpb.AnyValue_DoubleValue:
+		return strconv.FormatFloat(v.DoubleValue, 'f', -1, 64)
+	case *commonpb.AnyValue_BoolValue:
+		return strconv.FormatBool(v.BoolValue)
+	case *commonpb.AnyValue_BytesValue:
+		return string(v.BytesValue)
+	case *commonpb.AnyValue_ArrayValue:
+		elems := make([]string, 0, len(v.ArrayValue.GetValues()))
+		for _, elem := range v.ArrayValue.GetValues() {
+			elems = append(elems, AnyValueString(elem))
+		}
+		return "[" + strings.Join(elems, ",") + "]"
+	case *commonpb.AnyValue_KvlistValue:
+		elems := make([]string, 0, len(v.KvlistValue.GetValues()))
+		for _, kv := range v.KvlistValue.GetValues() {
+			elems = append(elems, kv.GetKey()+"="+AnyValueString(kv.GetValue()))
+		}
+		return "{" + strings.Join(elems, ",") + "}"
+	}
+	return ""
+}
+
+var filterFields = map[string]filterFieldFunc{
+	"name": func(item *FilterItem) (any, bool) {
+		switch {
+		case item.Span != nil:
+			return item.Span.GetName(), true
+		case item.Metric != nil:
+			return item.Metric.GetName(), true
+		}
+		return nil, false
+	},
+	"kind": func(item *FilterItem) (any, bool) {
+		if item.Span == nil {
+			return nil, false
+		}
+		return float64(item.Span.GetKind()), true
+	},
+	"status": func(item *FilterItem) (any, bool) {
+		if item.Span == nil {
+			return nil, false
+		}
+		return float64(item.Span.GetStatus().GetCode()), true
+	},
+	"duration": func(item *FilterItem) (any, bool) {
+		if item.Span == nil {
+			return nil, false
+		}
+		return float64(item.Span.GetEndTimeUnixNano()) - float64(item.Span.GetStartTimeUnixNano()), true
+	},
+	"severity": func(item *FilterItem) (any, bool) {
+		if item.LogRecord == nil {
+			return nil, false
+		}
+		return float64(item.LogRecord.GetSeverityNumber()), true
+	},
+	"severity_text": func(item *FilterItem) (any, bool) {
+		if item.LogRecord == nil {
+			return nil, false
+		}
+		return item.LogRecord.GetSeverityText(), true
+	},
+	"body": func(item *FilterItem) (any, bool) {
+		if item.LogRecord == nil {
+			return nil, false
+		}
+		return AnyValueString(item.LogRecord.GetBody()), true
+	},
+	"metric.type": func(item *FilterItem) (any, bool) {
+		if item.Metric == nil {
+			return nil, false
+		}
+		return metricTypeString(item.Metric), true
+	},
+	"scope.name": func(item *FilterItem) (any, bool) {
+		return item.Scope.GetName(), item.Scope != nil
+	},
+}
+
+func init() {
+	filterFields["metric.name"] = filterFields["name"]
+	filterFields["span.name"] = filterFields["name"]
+	filterFields["severity_number"] = filterFields["severity"]
+	filterFields["status.code"] = filterFields["status"]
+}
+
+// filterConstants are the constants of each field kind, looked up by filterFieldConstants.
+var filterConstants = map[string]map[string]any{
+	"kind": {
+		"UNSPECIFIED": float64(tracepb.Span_SPAN_KIND_UNSPECIFIED),
+		"INTERNAL":    float64(tracepb.Span_SPAN_KIND_INTERNAL),
+		"SERVER":      float64(tracepb.Span_SPAN_KIND_SERVER),
+		"CLIENT":      float64(tracepb.Span_SPAN_KIND_CLIENT),
+		"PRODUCER":    float64(tracepb.Span_SPAN_KIND_PRODUCER),
+		"CONSUMER":    float64(tracepb.Span_SPAN_KIND_CONSUMER),
+	},
+	"status": {
+		"UNSET": float64(tracepb.Status_STATUS_CODE_UNSET),
+		"OK":    float64(tracepb.Status_STATUS_CODE_OK),
+		"ERROR": float64(tracepb.Status_STATUS_CODE_ERROR),
+	},
+	"severity": {
+		"UNSPECIFIED": float64(logspb.SeverityNumber_SEVERITY_NUMBER_UNSPECIFIED),
+		"TRACE":       float64(logspb.SeverityNumber_SEVERITY_NUMBER_TRACE),
+		"DEBUG":       float64(logspb.SeverityNumber_SEVERITY_NUMBER_DEBUG),
+		"INFO":        float64(logspb.SeverityNumber_SEVERITY_NUMBER_INFO),
+		"WARN":        float64(logspb.SeverityNumber_SEVERITY_NUMBER_WARN),
+		"ERROR":       float64(logspb.SeverityNumber_SEVERITY_NUMBER_ERROR),
+		"FATAL":       float64(logspb.SeverityNumber_SEVERITY_NUMBER_FATAL),
+	},
+}
+
+var filterFieldConstants = map[string]string{
+	"kind":            "kind",
+	"status":          "status",
+	"status.code":     "status",
+	"severity":        "severity",
+	"severity_number": "severity",
+}
+
+var filterBoolConstants = map[string]any{
+	"TRUE":  "true",
+	"FALSE": "false",
+}
+
+type filterCompareNode struct {
+	left  filterOperand
+	op    string
+	right filterOperand
+	re    *regexp.Regexp
+}
+
+func newFilterCompareNode(left filterOperand, op string, right filterOperand) (*filterCompareNode, error) {
+	var err error
+	if c, ok := left.(*filterConstant); ok {
+		if left, err = c.resolve(right); err != nil {
+			return nil, err
+		}
+	}
+	if c, ok := right.(*filterConstant); ok {
+		if right, err = c.resolve(left); err != nil {
+			return nil, err
+		}
+	}
+	n := &filterCompareNode{left: left, op: op, right: right}
+	switch op {
+	case "=~", "!~", "glob":
+		lit, ok := right.(*filterLiteral)
+		if !ok {
+			return nil, fmt.Errorf("right side of %s must be a string literal", op)
+		}
+		pattern, ok := lit.v.(string)
+		if !ok {
+			return nil, fmt.Errorf("right side of %s must be a string literal", op)
+		}
+		if op == "glob" {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid glob %q: %w", pattern, err)
+			}
+			return n, nil
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regexp %q: %w", pattern, err)
+		}
+		n.re = re
+	}
+	return n, nil
+}
+
+func (n *filterCompareNode) eval(item *FilterItem) bool {
+	left, lok := n.left.value(item)
+	right, rok := n.right.value(item)
+	if !lok || !rok {
+		return n.op == "!=" || n.op == "!~"
+	}
+	switch n.op {
+	case "=~", "!~":
+		matched := n.re.MatchString(fmt.Sprint(left))
+		return matched == (n.op == "=~")
+	case "glob":
+		matched, _ := path.Match(right.(string), fmt.Sprint(left))
+		return matched
+	}
+	lf, lnum := left.(float64)
+	rf, rnum := right.(float64)
+	if lnum && rnum {
+		switch n.op {
+		case "==":
+			return lf == rf
+		case "!=":
+			return lf != rf
+		case "<":
+			return lf < rf
+		case "<=":
+			return lf <= rf
+		case ">":
+			return lf > rf
+		case ">=":
+			return lf >= rf
+		}
+		return false
+	}
+	ls, rs := fmt.Sprint(left), fmt.Sprint(right)
+	if lnum {
+		ls = strconv.FormatFloat(lf, 'f', -1, 64)
+	}
+	if rnum {
+		rs = strconv.FormatFloat(rf, 'f', -1, 64)
+	}
+	switch n.op {
+	case "==":
+		return ls == rs
+	case "!=":
+		return ls != rs
+	case "<":
+		return ls < rs
+	case "<=":
+		return ls <= rs
+	case ">":
+		return ls > rs
+	case ">=":
+		return ls >= rs
+	}
+	return false
+}
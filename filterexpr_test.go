@@ -0,0 +1,108 @@
+package jsonlotelforwarder_test
+
+import (
+	"testing"
+
+	jsonlotelforwarder "github.com/mashiike/jsonl-otel-forwarder"
+	"github.com/stretchr/testify/require"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+func stringAttr(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+}
+
+func TestFilterExpr(t *testing.T) {
+	resource := &resourcepb.Resource{Attributes: []*commonpb.KeyValue{stringAttr("service.name", "checkout")}}
+	span := &jsonlotelforwarder.FilterItem{
+		Resource: resource,
+		Span: &tracepb.Span{
+			Name:              "GET /health",
+			Kind:              tracepb.Span_SPAN_KIND_SERVER,
+			StartTimeUnixNano: 1000000000,
+			EndTimeUnixNano:   1003000000,
+			Attributes: []*commonpb.KeyValue{
+				stringAttr("http.route", "/health"),
+				{Key: "http.status_code", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: 200}}},
+			},
+			Status: &tracepb.Status{Code: tracepb.Status_STATUS_CODE_OK},
+		},
+	}
+	log := &jsonlotelforwarder.FilterItem{
+		Resource: resource,
+		LogRecord: &logspb.LogRecord{
+			SeverityNumber: logspb.SeverityNumber_SEVERITY_NUMBER_DEBUG,
+			SeverityText:   "DEBUG",
+			Body:           &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "cache hit for key=42"}},
+		},
+	}
+	metric := &metricspb.Metric{Name: "system.cpu.utilization", Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{}}}
+	dataPoint := &jsonlotelforwarder.FilterItem{
+		Resource:  resource,
+		Metric:    metric,
+		DataPoint: &metricspb.NumberDataPoint{Attributes: []*commonpb.KeyValue{stringAttr("cpu", "0")}},
+	}
+	cases := []struct {
+		expr string
+		item *jsonlotelforwarder.FilterItem
+		want bool
+	}{
+		{expr: `name == "GET /health"`, item: span, want: true},
+		{expr: `attributes["http.route"] == "/health"`, item: span, want: true},
+		{expr: `attributes["http.status_code"] >= 500`, item: span, want: false},
+		{expr: `attributes["http.status_code"] == 200`, item: span, want: true},
+		{expr: `attributes["missing"] == "x"`, item: span, want: false},
+		{expr: `attributes["missing"] != "x"`, item: span, want: true},
+		{expr: `resource.attributes["service.name"] =~ "^check"`, item: span, want: true},
+		{expr: `kind == SERVER and duration < 5ms`, item: span, want: true},
+		{expr: `kind == CLIENT or duration > 1s`, item: span, want: false},
+		{expr: `not (status == ERROR)`, item: span, want: true},
+		{expr: `name !~ "health"`, item: span, want: false},
+		{expr: `severity < INFO`, item: log, want: true},
+		{expr: `severity >= WARN`, item: log, want: false},
+		{expr: `severity >= ERROR`, item: log, want: false},
+		{expr: `severity < ERROR`, item: log, want: true},
+		{expr: `ERROR <= severity_number`, item: log, want: false},
+		{expr: `status.code != ERROR`, item: span, want: true},
+		{expr: `body =~ "cache (hit|miss)"`, item: log, want: true},
+		{expr: `severity_text == 'DEBUG'`, item: log, want: true},
+		{expr: `name glob "system.cpu.*"`, item: dataPoint, want: true},
+		{expr: `metric.name glob "system.memory.*"`, item: dataPoint, want: false},
+		{expr: `name glob "system.*" and attributes["cpu"] == "0"`, item: dataPoint, want: true},
+		{expr: `metric.type == "Gauge"`, item: dataPoint, want: true},
+		{expr: `kind == SERVER`, item: log, want: false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.expr, func(t *testing.T) {
+			expr, err := jsonlotelforwarder.CompileFilterExpr(tc.expr)
+			require.NoError(t, err)
+			require.Equal(t, tc.want, expr.Match(tc.item))
+		})
+	}
+}
+
+func TestCompileFilterExpr__Invalid(t *testing.T) {
+	cases := []string{
+		`name ==`,
+		`name = "a"`,
+		`unknown == "a"`,
+		`(name == "a"`,
+		`name == "a" extra`,
+		`name =~ "("`,
+		`name glob 1`,
+		`"unterminated`,
+		`events["a"] == "b"`,
+		`kind == ERROR`,
+		`attributes["level"] == ERROR`,
+	}
+	for _, src := range cases {
+		t.Run(src, func(t *testing.T) {
+			_, err := jsonlotelforwarder.CompileFilterExpr(src)
+			require.Error(t, err)
+		})
+	}
+}
@@ -4,10 +4,12 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"strings"
+	"sync"
 
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/mashiike/go-otlp-helper/otlp"
@@ -17,16 +19,45 @@ import (
 )
 
 type Forwarder struct {
-	options *Options
+	options     *Options
+	processors  []namedProcessor
+	exporters   []*namedExporter
+	selfMetrics *SelfMetrics
+	dryRun      *dryRun
 }
 
 func New(options *Options) (*Forwarder, error) {
 	if err := options.Validate(); err != nil {
 		return nil, fmt.Errorf("validate options: %w", err)
 	}
-	return &Forwarder{
-		options: options,
-	}, nil
+	config, err := LoadConfig(options.ConfigFile)
+	if err != nil {
+		return nil, fmt.Errorf("load config: %w", err)
+	}
+	exporters, err := newExporters(options, config)
+	if err != nil {
+		return nil, fmt.Errorf("create exporters: %w", err)
+	}
+	selfMetrics := NewSelfMetrics()
+	processors, err := newProcessors(options, config, exporters, selfMetrics)
+	if err != nil {
+		return nil, fmt.Errorf("create processors: %w", err)
+	}
+	f := &Forwarder{
+		options:     options,
+		processors:  processors,
+		exporters:   exporters,
+		selfMetrics: selfMetrics,
+	}
+	if options.DryRun {
+		f.dryRun = newDryRun(options.DryRunWriter)
+	}
+	return f, nil
+}
+
+// SelfMetrics returns the counters about the forwarder itself.
+func (f *Forwarder) SelfMetrics() *SelfMetrics {
+	return f.selfMetrics
 }
 
 func (f *Forwarder) Run(ctx context.Context) {
@@ -34,6 +65,10 @@ func (f *Forwarder) Run(ctx context.Context) {
 		lambda.Start(f.Invoke)
 		return
 	}
+	if f.options.Buffer {
+		f.runWithBuffer(ctx)
+		return
+	}
 	dec := json.NewDecoder(os.Stdin)
 	for dec.More() {
 		var payload json.RawMessage
@@ -48,80 +83,221 @@ func (f *Forwarder) Run(ctx context.Context) {
 	}
 }
 
+func (f *Forwarder) runWithBuffer(ctx context.Context) {
+	buffer := f.NewBuffer()
+	buffer.Start(ctx)
+	defer func() {
+		if err := buffer.Close(context.WithoutCancel(ctx)); err != nil {
+			slog.Error("failed to flush buffer", "error", err)
+			os.Exit(1)
+		}
+	}()
+	dec := json.NewDecoder(os.Stdin)
+	for dec.More() {
+		var payload json.RawMessage
+		if err := dec.Decode(&payload); err != nil {
+			slog.Error("failed to decode payload", "error", err)
+			os.Exit(1)
+		}
+		explanation := f.dryRun.newInput()
+		results, err := f.parseAndProcess(ctx, payload, explanation)
+		f.explain(ctx, explanation)
+		if err != nil {
+			slog.Error("failed to process", "error", err)
+			os.Exit(1)
+		}
+		if err := buffer.Add(ctx, results...); err != nil {
+			slog.Error("failed to add to buffer", "error", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// NewBuffer returns a Buffer that exports flushed results with this forwarder.
+func (f *Forwarder) NewBuffer() *Buffer {
+	return NewBuffer(f.options.BufferOptions, func(ctx context.Context, result *PaseResult) error {
+		defer f.selfMetrics.Report(ctx)
+		explanation := f.dryRun.newFlush()
+		defer f.explain(ctx, explanation)
+		_, err := f.invokeAsExportTelemetry(ctx, []*PaseResult{f.mergeDataPoints(result)}, explanation)
+		return err
+	})
+}
+
+func (f *Forwarder) mergeDataPoints(result *PaseResult) *PaseResult {
+	if f.options.MergeDataPoints && result.Metrics != nil {
+		result.Metrics.ResourceMetrics = MergeResourceMetricsDataPoints(result.Metrics.GetResourceMetrics())
+	}
+	return result
+}
+
 func (f *Forwarder) Invoke(ctx context.Context, payload json.RawMessage) (json.RawMessage, error) {
+	defer f.selfMetrics.Report(ctx)
+	explanation := f.dryRun.newInput()
+	defer f.explain(ctx, explanation)
+	results, err := f.parseAndProcess(ctx, payload, explanation)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return json.RawMessage(`{"skip":true}`), nil
+	}
+	return f.invokeAsExportTelemetry(ctx, results, explanation)
+}
+
+// parseAndProcess returns the processed results of the payload, or no results when the payload is skipped.
+func (f *Forwarder) parseAndProcess(ctx context.Context, payload []byte, explanation *Explanation) ([]*PaseResult, error) {
 	results, ok := Parse(payload)
+	explanation.explainPayload(payload, ok)
 	if !ok {
-		return json.RawMessage(`{"skip":true}`), nil
+		return nil, nil
+	}
+	explanation.parsed(results)
+	results, err := f.process(ctx, results)
+	if err != nil {
+		return nil, fmt.Errorf("process: %w", err)
 	}
-	return f.invokeAsExportTelemetry(ctx, results)
+	explanation.processed(results)
+	return results, nil
 }
 
-func (f *Forwarder) invokeAsExportTelemetry(ctx context.Context, results []*PaseResult) (json.RawMessage, error) {
+func (f *Forwarder) explain(ctx context.Context, explanation *Explanation) {
+	if err := f.dryRun.write(explanation); err != nil {
+		slog.ErrorContext(ctx, "failed to explain", "error", err)
+	}
+}
+
+func (f *Forwarder) invokeAsExportTelemetry(ctx context.Context, results []*PaseResult, explanation *Explanation) (json.RawMessage, error) {
 	if f.options.Batch {
 		slog.InfoContext(ctx, "to batch parse results", "results", len(results))
-		results = []*PaseResult{
-			ToBatchParseResult(results...),
+		explanation.batching(results)
+		results = toBatchParseResultsByRoute(results)
+		for _, result := range results {
+			f.mergeDataPoints(result)
 		}
+		explanation.batched(results)
 	}
-	opts := f.options.clientOptions
-	opts = append(opts, otlp.WithLogger(slog.Default()))
-	client, err := otlp.NewClient("http://localhost:4317", opts...)
+	if f.dryRun != nil {
+		explanation.exports(f.exporters, results)
+		return json.RawMessage(`{"success":true,"dry_run":true}`), nil
+	}
+	exporters, err := f.startExporters(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("create otlp client: %w", err)
+		return nil, err
+	}
+	if err := f.exportResults(ctx, exporters, results); err != nil {
+		slog.ErrorContext(ctx, "failed to export some telemetry", "error", err)
 	}
-	slog.InfoContext(ctx, "start otlp client", "endpoint", "http://localhost:4317")
-	if err := client.Start(ctx); err != nil {
-		return nil, fmt.Errorf("start otlp client: %w", err)
+	if err := stopExporters(ctx, exporters); err != nil {
+		return nil, err
+	}
+	return json.RawMessage(`{"success":true}`), nil
+}
+
+func (f *Forwarder) exportResults(ctx context.Context, exporters []*startedExporter, results []*PaseResult) error {
+	concurrency := f.options.ExportConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	var (
+		mu        sync.Mutex
+		errs      []error
+		wg        sync.WaitGroup
+		total     int
+		sem       = make(chan struct{}, concurrency)
+		lanes     = make(map[string][]*PaseResult)
+		laneOrder []string
+	)
+	export := func(result *PaseResult) {
+		sem <- struct{}{}
+		defer func() { <-sem }()
+		if err := exportToAll(ctx, exporters, result); err != nil {
+			slog.ErrorContext(ctx, "failed to export telemetry", "error", err)
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+		}
 	}
 	for _, result := range results {
 		if result.Skip() {
 			continue
 		}
-		if err := f.exportResult(ctx, client, result); err != nil {
-			slog.ErrorContext(ctx, "failed to export telemetry", "error", err)
-			continue
+		for _, r := range splitBySignal(result) {
+			total++
+			s := resultSignal(r)
+			if concurrency == 1 {
+				export(r)
+				continue
+			}
+			if !f.options.OrderedSignal(s) {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					export(r)
+				}()
+				continue
+			}
+			if _, ok := lanes[s]; !ok {
+				laneOrder = append(laneOrder, s)
+			}
+			lanes[s] = append(lanes[s], r)
 		}
 	}
-	slog.InfoContext(ctx, "stop otlp client")
-	if err := client.Stop(ctx); err != nil {
-		return nil, fmt.Errorf("stop trace client: %w", err)
+	for _, s := range laneOrder {
+		wg.Add(1)
+		go func(lane []*PaseResult) {
+			defer wg.Done()
+			for _, r := range lane {
+				export(r)
+			}
+		}(lanes[s])
 	}
-	return json.RawMessage(`{"success":true}`), nil
+	wg.Wait()
+	if len(errs) > 0 {
+		return fmt.Errorf("%d of %d exports failed: %w", len(errs), total, errors.Join(errs...))
+	}
+	return nil
 }
 
-func (f *Forwarder) exportResult(ctx context.Context, client *otlp.Client, result *PaseResult) error {
-	if result.Traces != nil && f.options.EnableTraces() {
-		recourceSpans := result.Traces.GetResourceSpans()
-		slog.InfoContext(ctx, "upload traces", "resource_spans", len(recourceSpans), "trace_ids", distinctListTraceIDs(recourceSpans))
-		if err := client.UploadTraces(ctx, recourceSpans); err != nil {
-			return fmt.Errorf("upload traces: %w", err)
-		}
-		slog.DebugContext(ctx, "uploaded traces", "resource_spans", len(recourceSpans))
-		return nil
-	}
-	if result.Metrics != nil && f.options.EnableMetrics() {
-		resourceMetrics := result.Metrics.GetResourceMetrics()
-		slog.InfoContext(ctx, "upload metrics", "resource_metrics", len(resourceMetrics))
-		if err := client.UploadMetrics(ctx, resourceMetrics); err != nil {
-			return fmt.Errorf("upload metrics: %w", err)
-		}
-		slog.DebugContext(ctx, "uploaded metrics", "resource_metrics", len(resourceMetrics))
-		return nil
-	}
-	if result.Logs != nil && f.options.EnableLogs() {
-		resourceLogs := result.Logs.GetResourceLogs()
-		slog.InfoContext(ctx, "upload logs", "resource_logs", len(resourceLogs))
-		if err := client.UploadLogs(ctx, resourceLogs); err != nil {
-			return fmt.Errorf("upload logs: %w", err)
-		}
-		slog.DebugContext(ctx, "uploaded logs", "resource_logs", len(resourceLogs))
-		return nil
+func splitBySignal(result *PaseResult) []*PaseResult {
+	var results []*PaseResult
+	if result.Traces != nil {
+		results = append(results, &PaseResult{Traces: result.Traces, Exporters: result.Exporters})
 	}
-	return nil
+	if result.Metrics != nil {
+		results = append(results, &PaseResult{Metrics: result.Metrics, Exporters: result.Exporters})
+	}
+	if result.Logs != nil {
+		results = append(results, &PaseResult{Logs: result.Logs, Exporters: result.Exporters})
+	}
+	return results
+}
+
+func resultSignal(result *PaseResult) string {
+	switch {
+	case result.Traces != nil:
+		return "traces"
+	case result.Metrics != nil:
+		return "metrics"
+	case result.Logs != nil:
+		return "logs"
+	}
+	return ""
 }
 
 func distinctListTraceIDs(resourceSpans []*tracepb.ResourceSpans) []string {
-	traceIDs := make(map[string]struct{})
+	spansByTraceID, _ := groupSpansByTraceID(resourceSpans)
+	keys := make([]string, 0, len(spansByTraceID))
+	for key := range spansByTraceID {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// groupSpansByTraceID groups spans by base64 encoded trace ID, and returns the trace IDs in order of appearance.
+func groupSpansByTraceID(resourceSpans []*tracepb.ResourceSpans) (map[string][]*tracepb.Span, []string) {
+	spansByTraceID := make(map[string][]*tracepb.Span)
+	var order []string
 	for _, resourceSpan := range resourceSpans {
 		for _, scopeSpan := range resourceSpan.GetScopeSpans() {
 			for _, span := range scopeSpan.GetSpans() {
@@ -130,21 +306,25 @@ func distinctListTraceIDs(resourceSpans []*tracepb.ResourceSpans) []string {
 				if len(traceID) != 16 {
 					slog.Warn("invalid trace id length", "trace_id", traceIDStr, "length", len(traceID))
 				}
-				traceIDs[traceIDStr] = struct{}{}
+				if _, ok := spansByTraceID[traceIDStr]; !ok {
+					order = append(order, traceIDStr)
+				}
+				spansByTraceID[traceIDStr] = append(spansByTraceID[traceIDStr], span)
 			}
 		}
 	}
-	keys := make([]string, 0, len(traceIDs))
-	for key := range traceIDs {
-		keys = append(keys, key)
-	}
-	return keys
+	return spansByTraceID, order
 }
 
 type PaseResult struct {
 	Traces  *tracepb.TracesData
 	Metrics *metricspb.MetricsData
 	Logs    *logspb.LogsData
+
+	// CloudWatch is set when the result was delivered by CloudWatch Logs subscription filter.
+	CloudWatch *CloudWatchMetadata
+	// Exporters are the names of the exporters that the result is routed to, all exporters when empty.
+	Exporters []string
 }
 
 func (r *PaseResult) Skip() bool {
@@ -157,13 +337,13 @@ func Parse(data []byte) ([]*PaseResult, bool) {
 	}
 	var subscriptionFilter CloudWatchSubscriptionFilterEvent
 	if err := json.Unmarshal(data, &subscriptionFilter); err == nil && subscriptionFilter.AWSLogs != nil {
-		logEvents := subscriptionFilter.GetLogEvents()
-		if len(logEvents) == 0 {
+		logsData, ok := subscriptionFilter.GetLogsData()
+		if !ok || len(logsData.LogEvents) == 0 {
 			return nil, false
 		}
 		var results []*PaseResult
-		for _, logEvent := range logEvents {
-			tempResults, ok := Parse([]byte(logEvent))
+		for _, logEvent := range logsData.LogEvents {
+			tempResults, ok := Parse([]byte(logEvent.Message))
 			if !ok {
 				continue
 			}
@@ -171,6 +351,7 @@ func Parse(data []byte) ([]*PaseResult, bool) {
 				if tempResult.Skip() {
 					continue
 				}
+				tempResult.CloudWatch = newCloudWatchMetadata(logsData, logEvent)
 				results = append(results, tempResult)
 			}
 		}
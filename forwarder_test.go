@@ -2,8 +2,11 @@ package jsonlotelforwarder_test
 
 import (
 	"context"
+	"errors"
 	"os"
+	"sync"
 	"testing"
+	"time"
 
 	otlpmux "github.com/mashiike/go-otlp-helper/otlp"
 	"github.com/mashiike/go-otlp-helper/otlp/otlptest"
@@ -173,3 +176,246 @@ func TestForworder__SubscriptionFilter__Trace(t *testing.T) {
 	t.Log("expected:", string(expectedRemarshal))
 	require.JSONEq(t, string(expectedRemarshal), string(actual))
 }
+
+func TestForwarder__ExportConcurrency(t *testing.T) {
+	const latency = 100 * time.Millisecond
+	var (
+		mu          sync.Mutex
+		inFlight    int
+		maxInFlight int
+		received    int
+	)
+	mux := otlpmux.NewServerMux()
+	mux.Trace().HandleFunc(func(ctx context.Context, request *otlpmux.TraceRequest) (*otlpmux.TraceResponse, error) {
+		mu.Lock()
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		mu.Unlock()
+		time.Sleep(latency)
+		mu.Lock()
+		inFlight--
+		received++
+		mu.Unlock()
+		return &otlpmux.TraceResponse{}, nil
+	})
+	server := otlptest.NewServer(mux)
+	defer server.Close()
+	trace, err := os.ReadFile("testdata/trace.json")
+	require.NoError(t, err)
+	records := make([][]byte, 0, 6)
+	for i := 0; i < 6; i++ {
+		records = append(records, trace)
+	}
+	payload := EncodeSubscriptionFilterEvent(t, records)
+	t.Setenv("FORWARDER_OTLP_ENDPOINT", server.URL)
+	t.Setenv("FORWARDER_OTLP_PROTOCOL", "grpc")
+	opts := jsonlotelforwarder.DefaultOptions()
+	opts.ExportConcurrency = 3
+	forwarder, err := jsonlotelforwarder.New(opts)
+	require.NoError(t, err)
+	start := time.Now()
+	resp, err := forwarder.Invoke(context.Background(), payload)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"success":true}`, string(resp))
+	elapsed := time.Since(start)
+	require.Equal(t, 6, received)
+	require.LessOrEqual(t, maxInFlight, 3)
+	require.Greater(t, maxInFlight, 1)
+	require.Less(t, elapsed, 6*latency, "exports must run concurrently")
+}
+
+func TestForwarder__ExportOrderedSignals(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		inFlight int
+		order    []uint64
+	)
+	mux := otlpmux.NewServerMux()
+	mux.Metrics().HandleFunc(func(ctx context.Context, request *otlpmux.MetricsRequest) (*otlpmux.MetricsResponse, error) {
+		mu.Lock()
+		inFlight++
+		assert.Equal(t, 1, inFlight, "ordered signal must be uploaded one by one")
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		order = append(order, request.GetResourceMetrics()[0].GetScopeMetrics()[0].GetMetrics()[0].GetSum().GetDataPoints()[0].GetStartTimeUnixNano())
+		mu.Unlock()
+		return &otlpmux.MetricsResponse{}, nil
+	})
+	server := otlptest.NewServer(mux)
+	defer server.Close()
+	metrics, err := os.ReadFile("testdata/metrics.json")
+	require.NoError(t, err)
+	metrics2, err := os.ReadFile("testdata/metrics2.json")
+	require.NoError(t, err)
+	payload := EncodeSubscriptionFilterEvent(t, [][]byte{metrics, metrics2, metrics, metrics2})
+	t.Setenv("FORWARDER_OTLP_ENDPOINT", server.URL)
+	t.Setenv("FORWARDER_OTLP_PROTOCOL", "grpc")
+	opts := jsonlotelforwarder.DefaultOptions()
+	opts.ExportConcurrency = 4
+	opts.ExportOrderedSignals = "metrics"
+	forwarder, err := jsonlotelforwarder.New(opts)
+	require.NoError(t, err)
+	_, err = forwarder.Invoke(context.Background(), payload)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{
+		1544712660300000000,
+		1544712660400000000,
+		1544712660300000000,
+		1544712660400000000,
+	}, order)
+}
+
+func TestForwarder__ExportErrorsAreAggregated(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		calls int
+	)
+	mux := otlpmux.NewServerMux()
+	mux.Logs().HandleFunc(func(ctx context.Context, request *otlpmux.LogsRequest) (*otlpmux.LogsResponse, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		return nil, errors.New("unavailable")
+	})
+	server := otlptest.NewServer(mux)
+	defer server.Close()
+	logs, err := os.ReadFile("testdata/logs.json")
+	require.NoError(t, err)
+	payload := EncodeSubscriptionFilterEvent(t, [][]byte{logs, logs, logs})
+	t.Setenv("FORWARDER_OTLP_ENDPOINT", server.URL)
+	t.Setenv("FORWARDER_OTLP_PROTOCOL", "grpc")
+	opts := jsonlotelforwarder.DefaultOptions()
+	opts.ExportConcurrency = 2
+	forwarder, err := jsonlotelforwarder.New(opts)
+	require.NoError(t, err)
+	resp, err := forwarder.Invoke(context.Background(), payload)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"success":true}`, string(resp))
+	require.Equal(t, 3, calls, "a failed export must not stop the others")
+}
+
+func TestForwarder__BatchMixedSignals(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		received []string
+	)
+	mux := otlpmux.NewServerMux()
+	mux.Trace().HandleFunc(func(ctx context.Context, request *otlpmux.TraceRequest) (*otlpmux.TraceResponse, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, "traces")
+		return &otlpmux.TraceResponse{}, nil
+	})
+	mux.Metrics().HandleFunc(func(ctx context.Context, request *otlpmux.MetricsRequest) (*otlpmux.MetricsResponse, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, "metrics")
+		return &otlpmux.MetricsResponse{}, nil
+	})
+	server := otlptest.NewServer(mux)
+	defer server.Close()
+	trace, err := os.ReadFile("testdata/trace.json")
+	require.NoError(t, err)
+	metrics, err := os.ReadFile("testdata/metrics.json")
+	require.NoError(t, err)
+	payload := EncodeSubscriptionFilterEvent(t, [][]byte{trace, metrics})
+	t.Setenv("FORWARDER_OTLP_ENDPOINT", server.URL)
+	t.Setenv("FORWARDER_OTLP_PROTOCOL", "grpc")
+	opts := jsonlotelforwarder.DefaultOptions()
+	opts.Batch = true
+	forwarder, err := jsonlotelforwarder.New(opts)
+	require.NoError(t, err)
+	_, err = forwarder.Invoke(context.Background(), payload)
+	require.NoError(t, err)
+	require.Equal(t, []string{"traces", "metrics"}, received)
+}
+
+func TestForwarder__Attributes(t *testing.T) {
+	mux := otlpmux.NewServerMux()
+	var actual *otlpmux.TraceRequest
+	mux.Trace().HandleFunc(func(ctx context.Context, request *otlpmux.TraceRequest) (*otlpmux.TraceResponse, error) {
+		actual = request
+		return &otlpmux.TraceResponse{}, nil
+	})
+	server := otlptest.NewServer(mux)
+	defer server.Close()
+	trace, err := os.ReadFile("testdata/trace.json")
+	require.NoError(t, err)
+	payload := EncodeSubscriptionFilterEvent(t, [][]byte{trace})
+	t.Setenv("FORWARDER_OTLP_ENDPOINT", server.URL)
+	t.Setenv("FORWARDER_OTLP_PROTOCOL", "grpc")
+	opts := jsonlotelforwarder.DefaultOptions()
+	opts.Attributes = "upsert:resource:deployment.environment=prod,insert:resource:aws.log.group.names=cloudwatch:log_group"
+	forwarder, err := jsonlotelforwarder.New(opts)
+	require.NoError(t, err)
+	_, err = forwarder.Invoke(context.Background(), payload)
+	require.NoError(t, err)
+	require.NotNil(t, actual)
+	require.Equal(t, map[string]any{
+		"service.name":           "my.service",
+		"deployment.environment": "prod",
+		"aws.log.group.names":    "test-log-group",
+	}, attributesToMap(actual.GetResourceSpans()[0].GetResource().GetAttributes()))
+}
+
+func TestForwarder__FilterDropsEverything(t *testing.T) {
+	mux := otlpmux.NewServerMux()
+	var called bool
+	mux.Trace().HandleFunc(func(ctx context.Context, request *otlpmux.TraceRequest) (*otlpmux.TraceResponse, error) {
+		called = true
+		return &otlpmux.TraceResponse{}, nil
+	})
+	server := otlptest.NewServer(mux)
+	defer server.Close()
+	trace, err := os.ReadFile("testdata/trace.json")
+	require.NoError(t, err)
+	t.Setenv("FORWARDER_OTLP_ENDPOINT", server.URL)
+	t.Setenv("FORWARDER_OTLP_PROTOCOL", "grpc")
+	opts := jsonlotelforwarder.DefaultOptions()
+	opts.FilterSpans = `resource.attributes["service.name"] == "my.service"`
+	forwarder, err := jsonlotelforwarder.New(opts)
+	require.NoError(t, err)
+	resp, err := forwarder.Invoke(context.Background(), trace)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"skip":true}`, string(resp))
+	require.False(t, called)
+	require.Equal(t, int64(1), forwarder.SelfMetrics().Get("filter.dropped_spans"))
+}
+
+func TestForwarder__SpanMetricsBeforeSampling(t *testing.T) {
+	mux := otlpmux.NewServerMux()
+	var traceCalled bool
+	mux.Trace().HandleFunc(func(ctx context.Context, request *otlpmux.TraceRequest) (*otlpmux.TraceResponse, error) {
+		traceCalled = true
+		return &otlpmux.TraceResponse{}, nil
+	})
+	var metricNames []string
+	mux.Metrics().HandleFunc(func(ctx context.Context, request *otlpmux.MetricsRequest) (*otlpmux.MetricsResponse, error) {
+		for _, resourceMetrics := range request.GetResourceMetrics() {
+			for _, scopeMetrics := range resourceMetrics.GetScopeMetrics() {
+				for _, metric := range scopeMetrics.GetMetrics() {
+					metricNames = append(metricNames, metric.GetName())
+				}
+			}
+		}
+		return &otlpmux.MetricsResponse{}, nil
+	})
+	server := otlptest.NewServer(mux)
+	defer server.Close()
+	trace, err := os.ReadFile("testdata/trace.json")
+	require.NoError(t, err)
+	t.Setenv("FORWARDER_OTLP_ENDPOINT", server.URL)
+	t.Setenv("FORWARDER_OTLP_PROTOCOL", "grpc")
+	opts := jsonlotelforwarder.DefaultOptions()
+	opts.SpanMetrics = true
+	opts.HeadSamplingTraces = "0"
+	forwarder, err := jsonlotelforwarder.New(opts)
+	require.NoError(t, err)
+	resp, err := forwarder.Invoke(context.Background(), trace)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"success":true}`, string(resp))
+	require.False(t, traceCalled)
+	require.Equal(t, []string{"traces.span.metrics.calls", "traces.span.metrics.errors", "traces.span.metrics.duration"}, metricNames)
+}
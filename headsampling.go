@@ -0,0 +1,129 @@
+package jsonlotelforwarder
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"slices"
+
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+)
+
+// HeadSamplingConfig is the configuration of consistent probabilistic head sampling.
+// the decision depends only on the trace ID (or the `rv` of tracestate) and the attribute value,
+// so every forwarder instance makes the same decision for the same trace.
+type HeadSamplingConfig struct {
+	Traces *HeadSamplingSignalConfig `json:"traces,omitempty"`
+	Logs   *HeadSamplingSignalConfig `json:"logs,omitempty"`
+}
+
+// HeadSamplingSignalConfig is the sampling percentage of a signal.
+// Attribute is only for logs: the hash of the log record attribute value is used instead of the trace ID.
+type HeadSamplingSignalConfig struct {
+	Percentage float64 `json:"percentage"`
+	Attribute  string  `json:"attribute,omitempty"`
+}
+
+func (c *HeadSamplingConfig) Enabled() bool {
+	return c != nil && (c.Traces != nil || c.Logs != nil)
+}
+
+type HeadSamplingProcessor struct {
+	traces        *uint64
+	logs          *uint64
+	logsAttribute string
+	selfMetrics   *SelfMetrics
+}
+
+func NewHeadSamplingProcessor(config *HeadSamplingConfig, selfMetrics *SelfMetrics) (*HeadSamplingProcessor, error) {
+	p := &HeadSamplingProcessor{selfMetrics: selfMetrics}
+	threshold := func(name string, c *HeadSamplingSignalConfig) (*uint64, error) {
+		if c == nil {
+			return nil, nil
+		}
+		if c.Percentage < 0 || c.Percentage > 100 {
+			return nil, fmt.Errorf("%s: percentage must be between 0 and 100", name)
+		}
+		threshold := probabilityToThreshold(c.Percentage / 100)
+		return &threshold, nil
+	}
+	var err error
+	if p.traces, err = threshold("traces", config.Traces); err != nil {
+		return nil, err
+	}
+	if config.Traces != nil && config.Traces.Attribute != "" {
+		return nil, fmt.Errorf("traces: attribute is not supported, traces are sampled by trace ID")
+	}
+	if p.logs, err = threshold("logs", config.Logs); err != nil {
+		return nil, err
+	}
+	if config.Logs != nil {
+		p.logsAttribute = config.Logs.Attribute
+	}
+	return p, nil
+}
+
+func (p *HeadSamplingProcessor) Process(_ context.Context, results []*PaseResult) ([]*PaseResult, error) {
+	for _, result := range results {
+		if p.traces != nil {
+			p.selfMetrics.Add("head_sampling.dropped_spans", int64(FilterSpans(result, func(item *FilterItem) bool {
+				return p.sampleSpan(item)
+			})))
+		}
+		if p.logs != nil {
+			p.selfMetrics.Add("head_sampling.dropped_log_records", int64(FilterLogRecords(result, func(item *FilterItem) bool {
+				return p.sampleLogRecord(item)
+			})))
+		}
+		PruneResult(result)
+	}
+	return results, nil
+}
+
+// sampleSpan keeps the span when its randomness is greater than or equal to the threshold.
+// a span already sampled upstream with the higher threshold is decided by that threshold, and
+// kept spans record the effective threshold as `th` of tracestate.
+func (p *HeadSamplingProcessor) sampleSpan(item *FilterItem) bool {
+	span := item.Span
+	ts := parseOTelTraceState(span.GetTraceState())
+	randomness := TraceIDRandomness(span.GetTraceId())
+	if ts.hasRandomness {
+		randomness = ts.randomness
+	}
+	threshold := *p.traces
+	if ts.hasThreshold && ts.threshold >= threshold {
+		threshold = ts.threshold
+	}
+	if randomness < threshold {
+		return false
+	}
+	if !ts.hasThreshold || ts.threshold != threshold {
+		span.TraceState = withSamplingThreshold(span.GetTraceState(), threshold)
+	}
+	return true
+}
+
+// sampleLogRecord keeps the log record by the hash of the attribute value, or by the trace ID.
+// log records that have neither are kept, because there is no consistent key to decide.
+func (p *HeadSamplingProcessor) sampleLogRecord(item *FilterItem) bool {
+	record := item.LogRecord
+	if p.logsAttribute != "" {
+		i := slices.IndexFunc(record.GetAttributes(), func(kv *commonpb.KeyValue) bool {
+			return kv.GetKey() == p.logsAttribute
+		})
+		if i >= 0 {
+			return attributeRandomness(record.GetAttributes()[i].GetValue()) >= *p.logs
+		}
+	}
+	if len(record.GetTraceId()) > 0 {
+		return TraceIDRandomness(record.GetTraceId()) >= *p.logs
+	}
+	return true
+}
+
+// attributeRandomness returns the 56 bits of FNV-1a hash of the attribute value.
+func attributeRandomness(value *commonpb.AnyValue) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(AnyValueString(value)))
+	return h.Sum64() & (maxSamplingThreshold - 1)
+}
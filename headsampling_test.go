@@ -0,0 +1,80 @@
+package jsonlotelforwarder_test
+
+import (
+	"context"
+	"testing"
+
+	jsonlotelforwarder "github.com/mashiike/jsonl-otel-forwarder"
+	"github.com/stretchr/testify/require"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+func TestHeadSamplingProcessor__Traces(t *testing.T) {
+	selfMetrics := jsonlotelforwarder.NewSelfMetrics()
+	p, err := jsonlotelforwarder.NewHeadSamplingProcessor(&jsonlotelforwarder.HeadSamplingConfig{
+		Traces: &jsonlotelforwarder.HeadSamplingSignalConfig{Percentage: 50},
+	}, selfMetrics)
+	require.NoError(t, err)
+	result := newTraceResult(
+		&tracepb.Span{TraceId: traceID(0x01), Name: "low"},
+		&tracepb.Span{TraceId: traceID(0xff), Name: "high", TraceState: "vendor=x"},
+		&tracepb.Span{TraceId: traceID(0x01), Name: "explicit-randomness", TraceState: "ot=rv:ffffffffffffff"},
+		&tracepb.Span{TraceId: traceID(0xff), Name: "upstream-sampled", TraceState: "ot=th:c,vendor=x"},
+	)
+	results, err := p.Process(context.Background(), []*jsonlotelforwarder.PaseResult{result})
+	require.NoError(t, err)
+	traceStates := map[string]string{}
+	for _, span := range results[0].Traces.GetResourceSpans()[0].GetScopeSpans()[0].GetSpans() {
+		traceStates[span.GetName()] = span.GetTraceState()
+	}
+	require.Equal(t, map[string]string{
+		"high":                "ot=th:8,vendor=x",
+		"explicit-randomness": "ot=th:8;rv:ffffffffffffff",
+		"upstream-sampled":    "ot=th:c,vendor=x",
+	}, traceStates)
+	require.EqualValues(t, 1, selfMetrics.Get("head_sampling.dropped_spans"))
+}
+
+func TestHeadSamplingProcessor__LogsByAttribute(t *testing.T) {
+	newRecord := func(userID string) *logspb.LogRecord {
+		record := &logspb.LogRecord{Body: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: userID}}}
+		if userID != "" {
+			record.Attributes = []*commonpb.KeyValue{stringAttr("user.id", userID)}
+		}
+		return record
+	}
+	var records []*logspb.LogRecord
+	for _, userID := range []string{"alice", "bob", "carol", "dave", "erin", "frank"} {
+		records = append(records, newRecord(userID), newRecord(userID))
+	}
+	records = append(records, newRecord(""))
+	result := &jsonlotelforwarder.PaseResult{
+		Logs: &logspb.LogsData{ResourceLogs: []*logspb.ResourceLogs{{ScopeLogs: []*logspb.ScopeLogs{{LogRecords: records}}}}},
+	}
+	p, err := jsonlotelforwarder.NewHeadSamplingProcessor(&jsonlotelforwarder.HeadSamplingConfig{
+		Logs: &jsonlotelforwarder.HeadSamplingSignalConfig{Percentage: 50, Attribute: "user.id"},
+	}, nil)
+	require.NoError(t, err)
+	results, err := p.Process(context.Background(), []*jsonlotelforwarder.PaseResult{result})
+	require.NoError(t, err)
+	kept := map[string]int{}
+	for _, record := range results[0].Logs.GetResourceLogs()[0].GetScopeLogs()[0].GetLogRecords() {
+		kept[record.GetBody().GetStringValue()]++
+	}
+	require.Equal(t, 1, kept[""], "log records without the attribute are kept")
+	for userID, n := range kept {
+		if userID != "" {
+			require.Equal(t, 2, n, "the same decision for the same attribute value: %s", userID)
+		}
+	}
+	require.Less(t, len(kept), 7)
+}
+
+func TestHeadSamplingProcessor__Invalid(t *testing.T) {
+	_, err := jsonlotelforwarder.NewHeadSamplingProcessor(&jsonlotelforwarder.HeadSamplingConfig{
+		Traces: &jsonlotelforwarder.HeadSamplingSignalConfig{Percentage: 150},
+	}, nil)
+	require.Error(t, err)
+}
@@ -0,0 +1,349 @@
+package jsonlotelforwarder
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mashiike/go-otlp-helper/otlp"
+	"github.com/twmb/franz-go/pkg/kgo"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// KafkaExporterConfig is the configuration of the exporter that produces OTLP messages to Kafka topics.
+type KafkaExporterConfig struct {
+	Brokers []string
+	// Topics are the topics of each signal, DefaultKafkaTopics for the signals without topic.
+	Topics map[string]string
+	// Encoding is `otlp_proto` by default, or `otlp_json`.
+	Encoding string
+	// PartitionKey is `trace_id` or `service_name`, the messages have no key and are spread over partitions when empty.
+	PartitionKey string
+	ClientID     string
+	Timeout      time.Duration
+	Gzip         bool
+	TLS          bool
+}
+
+// DefaultKafkaTopics are the topics of signals by default, same as the Kafka exporter of OpenTelemetry Collector.
+var DefaultKafkaTopics = map[string]string{
+	"traces":  "otlp_spans",
+	"metrics": "otlp_metrics",
+	"logs":    "otlp_logs",
+}
+
+func (c *KafkaExporterConfig) validate() error {
+	if len(c.Brokers) == 0 {
+		return fmt.Errorf("brokers is required")
+	}
+	for signal := range c.Topics {
+		if !containsSignal([]string{"traces", "metrics", "logs"}, signal) {
+			return fmt.Errorf("topics: unknown signal %q", signal)
+		}
+	}
+	switch c.Encoding {
+	case "", "otlp_proto", "otlp_json":
+	default:
+		return fmt.Errorf("unknown encoding %q, expected otlp_proto or otlp_json", c.Encoding)
+	}
+	switch c.PartitionKey {
+	case "", "trace_id", "service_name":
+	default:
+		return fmt.Errorf("unknown partition_key %q, expected trace_id or service_name", c.PartitionKey)
+	}
+	return nil
+}
+
+func (c *KafkaExporterConfig) topic(signal string) string {
+	for s, topic := range c.Topics {
+		if containsSignal([]string{s}, signal) && topic != "" {
+			return topic
+		}
+	}
+	return DefaultKafkaTopics[signal]
+}
+
+// KafkaExporter produces a message of OTLP TracesData, MetricsData or LogsData per partition key.
+// with `trace_id`, the spans and log records are grouped by trace ID, and the log records without trace ID and metrics have no key.
+// with `service_name`, the resources are grouped by service.name.
+type KafkaExporter struct {
+	config KafkaExporterConfig
+	client *kgo.Client
+
+	mu sync.Mutex
+	// produced are the keys of the records acknowledged for each result, so a retried export of the same result
+	// produces only the records that failed.
+	produced map[*PaseResult]map[string]bool
+}
+
+func NewKafkaExporter(config *KafkaExporterConfig) (*KafkaExporter, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return &KafkaExporter{config: *config}, nil
+}
+
+func (e *KafkaExporter) Start(ctx context.Context) error {
+	clientID := e.config.ClientID
+	if clientID == "" {
+		clientID = "jsonl-otel-forwarder"
+	}
+	opts := []kgo.Opt{
+		kgo.SeedBrokers(e.config.Brokers...),
+		kgo.ClientID(clientID),
+		kgo.SoftwareNameAndVersion("jsonl-otel-forwarder", Version),
+		kgo.WithLogger(kafkaLogger{}),
+	}
+	if e.config.Timeout > 0 {
+		opts = append(opts, kgo.ProduceRequestTimeout(e.config.Timeout), kgo.RecordDeliveryTimeout(e.config.Timeout))
+	}
+	if e.config.Gzip {
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.GzipCompression()))
+	}
+	if e.config.TLS {
+		opts = append(opts, kgo.DialTLSConfig(&tls.Config{MinVersion: tls.VersionTLS12}))
+	}
+	client, err := kgo.NewClient(opts...)
+	if err != nil {
+		return fmt.Errorf("create kafka client: %w", err)
+	}
+	slog.InfoContext(ctx, "start kafka client", "brokers", e.config.Brokers)
+	e.client = client
+	return nil
+}
+
+func (e *KafkaExporter) Stop(ctx context.Context) error {
+	if e.client == nil {
+		return nil
+	}
+	slog.InfoContext(ctx, "stop kafka client")
+	e.client.Close()
+	e.client = nil
+	return nil
+}
+
+func (e *KafkaExporter) Export(ctx context.Context, result *PaseResult) error {
+	signal := resultSignal(result)
+	e.mu.Lock()
+	if e.produced == nil {
+		e.produced = make(map[*PaseResult]map[string]bool)
+	}
+	produced, ok := e.produced[result]
+	if !ok {
+		produced = make(map[string]bool)
+		e.produced[result] = produced
+	}
+	e.mu.Unlock()
+	keys, byKey := e.partition(result)
+	records := make([]*kgo.Record, 0, len(keys))
+	recordKeys := make(map[*kgo.Record]string, len(keys))
+	for _, key := range keys {
+		if produced[key] {
+			continue
+		}
+		value, err := e.marshal(byKey[key])
+		if err != nil {
+			return &permanentError{err: fmt.Errorf("marshal %s: %w", signal, err)}
+		}
+		record := &kgo.Record{Topic: e.config.topic(signal), Value: value}
+		if key != "" {
+			record.Key = []byte(key)
+		}
+		records = append(records, record)
+		recordKeys[record] = key
+	}
+	if len(records) == 0 {
+		e.forget(result)
+		return nil
+	}
+	slog.InfoContext(ctx, "produce to kafka", "topic", records[0].Topic, "signal", signal, "records", len(records))
+	var (
+		failed   int
+		firstErr error
+	)
+	// the results are in the order of acknowledgement, not of records.
+	for _, r := range e.client.ProduceSync(ctx, records...) {
+		if r.Err != nil {
+			failed++
+			firstErr = cmp.Or(firstErr, r.Err)
+			continue
+		}
+		produced[recordKeys[r.Record]] = true
+	}
+	if failed > 0 {
+		return fmt.Errorf("produce %d of %d records to kafka topic %s: %w", failed, len(records), records[0].Topic, firstErr)
+	}
+	e.forget(result)
+	return nil
+}
+
+// forget removes the acknowledged records of the result, after all records are produced.
+func (e *KafkaExporter) forget(result *PaseResult) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.produced, result)
+}
+
+// partition returns the keys of messages in order, and the telemetry of each key.
+func (e *KafkaExporter) partition(result *PaseResult) ([]string, map[string]*PaseResult) {
+	switch {
+	case e.config.PartitionKey == "service_name":
+		return splitByResource(result, resourceServiceName)
+	case e.config.PartitionKey == "trace_id" && result.Traces != nil:
+		return splitTracesByTraceID(result.Traces)
+	case e.config.PartitionKey == "trace_id" && result.Logs != nil:
+		return splitLogsByTraceID(result.Logs)
+	}
+	return []string{""}, map[string]*PaseResult{"": result}
+}
+
+func (e *KafkaExporter) marshal(result *PaseResult) ([]byte, error) {
+	var message proto.Message
+	switch {
+	case result.Traces != nil:
+		message = result.Traces
+	case result.Metrics != nil:
+		message = result.Metrics
+	case result.Logs != nil:
+		message = result.Logs
+	}
+	if e.config.Encoding == "otlp_json" {
+		return otlp.MarshalJSON(message)
+	}
+	return proto.Marshal(message)
+}
+
+// splitTracesByTraceID groups the spans by hex encoded trace ID, keeping their resources and scopes.
+func splitTracesByTraceID(traces *tracepb.TracesData) ([]string, map[string]*PaseResult) {
+	var (
+		keys  []string
+		byKey = make(map[string]*PaseResult)
+	)
+	for _, resourceSpans := range traces.GetResourceSpans() {
+		for _, scopeSpans := range resourceSpans.GetScopeSpans() {
+			for _, span := range scopeSpans.GetSpans() {
+				key := hex.EncodeToString(span.GetTraceId())
+				r, ok := byKey[key]
+				if !ok {
+					r = &PaseResult{Traces: &tracepb.TracesData{}}
+					byKey[key] = r
+					keys = append(keys, key)
+				}
+				r.Traces.ResourceSpans = toBatchResourceSpans(r.Traces.ResourceSpans, &tracepb.ResourceSpans{
+					Resource:  resourceSpans.GetResource(),
+					SchemaUrl: resourceSpans.GetSchemaUrl(),
+					ScopeSpans: []*tracepb.ScopeSpans{{
+						Scope:     scopeSpans.GetScope(),
+						SchemaUrl: scopeSpans.GetSchemaUrl(),
+						Spans:     []*tracepb.Span{span},
+					}},
+				})
+			}
+		}
+	}
+	return keys, byKey
+}
+
+// splitLogsByTraceID groups the log records by hex encoded trace ID, keeping their resources and scopes.
+// the log records without trace ID are grouped with the empty key.
+func splitLogsByTraceID(logs *logspb.LogsData) ([]string, map[string]*PaseResult) {
+	var (
+		keys  []string
+		byKey = make(map[string]*PaseResult)
+	)
+	for _, resourceLogs := range logs.GetResourceLogs() {
+		for _, scopeLogs := range resourceLogs.GetScopeLogs() {
+			for _, record := range scopeLogs.GetLogRecords() {
+				key := hex.EncodeToString(record.GetTraceId())
+				r, ok := byKey[key]
+				if !ok {
+					r = &PaseResult{Logs: &logspb.LogsData{}}
+					byKey[key] = r
+					keys = append(keys, key)
+				}
+				r.Logs.ResourceLogs = toBatchResourceLogs(r.Logs.ResourceLogs, &logspb.ResourceLogs{
+					Resource:  resourceLogs.GetResource(),
+					SchemaUrl: resourceLogs.GetSchemaUrl(),
+					ScopeLogs: []*logspb.ScopeLogs{{
+						Scope:      scopeLogs.GetScope(),
+						SchemaUrl:  scopeLogs.GetSchemaUrl(),
+						LogRecords: []*logspb.LogRecord{record},
+					}},
+				})
+			}
+		}
+	}
+	return keys, byKey
+}
+
+func newNamedKafkaExporter(options *Options, c *ExporterConfig) (*namedExporter, error) {
+	signals, err := exporterSignals(options, c)
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := parseExporterTimeout(c)
+	if err != nil {
+		return nil, err
+	}
+	config := &KafkaExporterConfig{
+		Topics:       c.Topics,
+		Encoding:     c.Encoding,
+		PartitionKey: c.PartitionKey,
+		ClientID:     c.ClientID,
+		Timeout:      timeout,
+		Gzip:         c.Gzip,
+		TLS:          c.TLS,
+	}
+	for _, broker := range c.Brokers {
+		config.Brokers = append(config.Brokers, os.ExpandEnv(broker))
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	retry, err := newRetryPolicy(c.Retry)
+	if err != nil {
+		return nil, fmt.Errorf("retry: %w", err)
+	}
+	return &namedExporter{
+		name:    c.Name,
+		signals: signals,
+		retry:   retry,
+		new: func() (Exporter, error) {
+			return NewKafkaExporter(config)
+		},
+	}, nil
+}
+
+// kafkaLogger writes the logs of the kafka client with slog.
+type kafkaLogger struct{}
+
+func (kafkaLogger) Level() kgo.LogLevel {
+	if slog.Default().Enabled(context.Background(), slog.LevelDebug) {
+		return kgo.LogLevelDebug
+	}
+	return kgo.LogLevelWarn
+}
+
+func (kafkaLogger) Log(level kgo.LogLevel, msg string, keyvals ...any) {
+	var l slog.Level
+	switch level {
+	case kgo.LogLevelError:
+		l = slog.LevelError
+	case kgo.LogLevelWarn:
+		l = slog.LevelWarn
+	case kgo.LogLevelInfo:
+		l = slog.LevelInfo
+	default:
+		l = slog.LevelDebug
+	}
+	slog.Log(context.Background(), l, "kafka: "+strings.TrimSpace(msg), keyvals...)
+}
@@ -0,0 +1,168 @@
+package jsonlotelforwarder_test
+
+import (
+	"context"
+	"encoding/hex"
+	"os"
+	"testing"
+	"time"
+
+	jsonlotelforwarder "github.com/mashiike/jsonl-otel-forwarder"
+	"github.com/stretchr/testify/require"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func consumeKafka(t *testing.T, brokers []string, topic string, n int) []*kgo.Record {
+	t.Helper()
+	client, err := kgo.NewClient(kgo.SeedBrokers(brokers...), kgo.ConsumeTopics(topic), kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()))
+	require.NoError(t, err)
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var records []*kgo.Record
+	for len(records) < n {
+		fetches := client.PollFetches(ctx)
+		require.NoError(t, ctx.Err())
+		records = append(records, fetches.Records()...)
+	}
+	return records
+}
+
+func TestForwarder__KafkaExporter(t *testing.T) {
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(3, "traces", "otlp_logs"))
+	require.NoError(t, err)
+	defer cluster.Close()
+
+	opts := jsonlotelforwarder.DefaultOptions()
+	opts.Signals = "traces,logs"
+	opts.ConfigFile = writeConfig(t, map[string]any{
+		"exporters": []map[string]any{
+			{
+				"name":          "kafka",
+				"type":          "kafka",
+				"brokers":       cluster.ListenAddrs(),
+				"topics":        map[string]string{"traces": "traces"},
+				"partition_key": "trace_id",
+			},
+		},
+	})
+	forwarder, err := jsonlotelforwarder.New(opts)
+	require.NoError(t, err)
+	for _, name := range []string{"testdata/trace.json", "testdata/trace2.json", "testdata/logs.json"} {
+		bs, err := os.ReadFile(name)
+		require.NoError(t, err)
+		_, err = forwarder.Invoke(context.Background(), bs)
+		require.NoError(t, err)
+	}
+
+	traceRecords := consumeKafka(t, cluster.ListenAddrs(), "traces", 2)
+	require.Len(t, traceRecords, 2)
+	for _, record := range traceRecords {
+		var traces tracepb.TracesData
+		require.NoError(t, proto.Unmarshal(record.Value, &traces), "messages are OTLP protobuf")
+		require.NotEmpty(t, traces.GetResourceSpans())
+		for _, resourceSpans := range traces.GetResourceSpans() {
+			for _, scopeSpans := range resourceSpans.GetScopeSpans() {
+				for _, span := range scopeSpans.GetSpans() {
+					require.Equal(t, string(record.Key), hex.EncodeToString(span.GetTraceId()), "the spans of a message have the trace ID of the key")
+				}
+			}
+		}
+	}
+	logRecords := consumeKafka(t, cluster.ListenAddrs(), "otlp_logs", 1)
+	require.Len(t, logRecords, 1)
+	require.Equal(t, "5b8efff798038103d269b633813fc60c", string(logRecords[0].Key))
+}
+
+func TestKafkaExporter__RetryFailedRecords(t *testing.T) {
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(2), kfake.SeedTopics(2, "otlp_spans"))
+	require.NoError(t, err)
+	defer cluster.Close()
+	// the partitions have different leaders, and the first produce request to the leader of partition 0 fails,
+	// so the records of partition 1 are acknowledged.
+	require.NoError(t, cluster.MoveTopicPartition("otlp_spans", 0, 0))
+	require.NoError(t, cluster.MoveTopicPartition("otlp_spans", 1, 1))
+	cluster.ControlKey(int16(kmsg.Produce), func(kreq kmsg.Request) (kmsg.Response, error, bool) {
+		if cluster.CurrentNode() != 0 {
+			return nil, nil, false
+		}
+		req := kreq.(*kmsg.ProduceRequest)
+		resp := req.ResponseKind().(*kmsg.ProduceResponse)
+		for _, topic := range req.Topics {
+			rt := kmsg.NewProduceResponseTopic()
+			rt.Topic = topic.Topic
+			for _, partition := range topic.Partitions {
+				rp := kmsg.NewProduceResponseTopicPartition()
+				rp.Partition = partition.Partition
+				rp.ErrorCode = kerr.InvalidRecord.Code
+				rt.Partitions = append(rt.Partitions, rp)
+			}
+			resp.Topics = append(resp.Topics, rt)
+		}
+		return resp, nil, true
+	})
+
+	exporter, err := jsonlotelforwarder.NewKafkaExporter(&jsonlotelforwarder.KafkaExporterConfig{
+		Brokers:      cluster.ListenAddrs(),
+		PartitionKey: "trace_id",
+	})
+	require.NoError(t, err)
+	ctx := context.Background()
+	require.NoError(t, exporter.Start(ctx))
+	defer exporter.Stop(ctx)
+	var spans []*tracepb.Span
+	for i := range 8 {
+		spans = append(spans, &tracepb.Span{TraceId: traceID(byte(i + 1)), Name: "span"})
+	}
+	result := newTraceResult(spans...)
+	err = exporter.Export(ctx, result)
+	require.Error(t, err)
+	require.NotContains(t, err.Error(), "produce 8 of 8 records", "some records are acknowledged")
+	require.NoError(t, exporter.Export(ctx, result), "the retry produces the failed records")
+
+	records := consumeKafka(t, cluster.ListenAddrs(), "otlp_spans", len(spans))
+	keys := make(map[string]int)
+	for _, record := range records {
+		keys[string(record.Key)]++
+	}
+	require.Len(t, records, len(spans), "the acknowledged records are not produced again")
+	require.Len(t, keys, len(spans))
+}
+
+func TestKafkaExporter__Encoding(t *testing.T) {
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(1, "otlp_metrics"))
+	require.NoError(t, err)
+	defer cluster.Close()
+
+	exporter, err := jsonlotelforwarder.NewKafkaExporter(&jsonlotelforwarder.KafkaExporterConfig{
+		Brokers:      cluster.ListenAddrs(),
+		Encoding:     "otlp_json",
+		PartitionKey: "service_name",
+	})
+	require.NoError(t, err)
+	ctx := context.Background()
+	require.NoError(t, exporter.Start(ctx))
+	require.NoError(t, exporter.Export(ctx, loadParseResults(t, "testdata/metrics.json")[0]))
+	require.NoError(t, exporter.Stop(ctx))
+
+	records := consumeKafka(t, cluster.ListenAddrs(), "otlp_metrics", 1)
+	require.Equal(t, "my.service", string(records[0].Key))
+	results, ok := jsonlotelforwarder.Parse(records[0].Value)
+	require.True(t, ok, "messages are OTLP JSON read by the forwarder")
+	require.NotNil(t, results[0].Metrics)
+
+	for _, config := range []*jsonlotelforwarder.KafkaExporterConfig{
+		{},
+		{Brokers: []string{"localhost:9092"}, Encoding: "avro"},
+		{Brokers: []string{"localhost:9092"}, PartitionKey: "span_id"},
+		{Brokers: []string{"localhost:9092"}, Topics: map[string]string{"profiles": "otlp_profiles"}},
+	} {
+		_, err := jsonlotelforwarder.NewKafkaExporter(config)
+		require.Error(t, err)
+	}
+}
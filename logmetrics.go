@@ -0,0 +1,426 @@
+package jsonlotelforwarder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+)
+
+// DefaultLogMetricsBuckets are the explicit bounds of the extracted histograms, same as OpenTelemetry SDK defaults.
+var DefaultLogMetricsBuckets = []float64{0, 5, 10, 25, 50, 75, 100, 250, 500, 750, 1000, 2500, 5000, 7500, 10000}
+
+// LogMetricsConfig is the configuration of log metrics connector.
+// it counts log records per resource, severity and Dimensions, and extracts numeric values from log bodies.
+type LogMetricsConfig struct {
+	Enabled    bool                   `json:"enabled"`
+	Namespace  string                 `json:"namespace,omitempty"`
+	Dimensions []string               `json:"dimensions,omitempty"`
+	Extract    []*LogMetricsExtractor `json:"extract,omitempty"`
+}
+
+// LogMetricsExtractor extracts a numeric value from log bodies, by the first capture group (or the group named value) of Regex,
+// or by JSONPath such as `$.latency.ms` of JSON string or map bodies. Type is gauge (default) or histogram.
+type LogMetricsExtractor struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Unit        string    `json:"unit,omitempty"`
+	Type        string    `json:"type,omitempty"`
+	Regex       string    `json:"regex,omitempty"`
+	JSONPath    string    `json:"json_path,omitempty"`
+	Buckets     []float64 `json:"buckets,omitempty"`
+	Dimensions  []string  `json:"dimensions,omitempty"`
+}
+
+type logMetricsExtractor struct {
+	*LogMetricsExtractor
+	typ   string
+	re    *regexp.Regexp
+	group int
+	path  []string
+}
+
+type LogMetricsConnector struct {
+	namespace   string
+	dimensions  []string
+	extractors  []*logMetricsExtractor
+	selfMetrics *SelfMetrics
+}
+
+func NewLogMetricsConnector(config *LogMetricsConfig, selfMetrics *SelfMetrics) (*LogMetricsConnector, error) {
+	c := &LogMetricsConnector{
+		namespace:   config.Namespace,
+		dimensions:  config.Dimensions,
+		selfMetrics: selfMetrics,
+	}
+	if c.namespace == "" {
+		c.namespace = "logs.metrics"
+	}
+	for i, extractor := range config.Extract {
+		compiled, err := extractor.compile()
+		if err != nil {
+			return nil, fmt.Errorf("extract[%d]: %w", i, err)
+		}
+		c.extractors = append(c.extractors, compiled)
+	}
+	return c, nil
+}
+
+func (e *LogMetricsExtractor) compile() (*logMetricsExtractor, error) {
+	if e.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	compiled := &logMetricsExtractor{LogMetricsExtractor: e, typ: e.Type}
+	switch e.Type {
+	case "":
+		compiled.typ = "gauge"
+	case "gauge":
+	case "histogram":
+		if !slices.IsSorted(e.Buckets) {
+			return nil, fmt.Errorf("buckets must be sorted")
+		}
+	default:
+		return nil, fmt.Errorf("unknown type %q, expected gauge or histogram", e.Type)
+	}
+	switch {
+	case e.Regex != "" && e.JSONPath != "":
+		return nil, fmt.Errorf("either regex or json_path is allowed")
+	case e.Regex != "":
+		re, err := regexp.Compile(e.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("regex: %w", err)
+		}
+		compiled.re = re
+		compiled.group = re.SubexpIndex("value")
+		if compiled.group < 0 {
+			if re.NumSubexp() == 0 {
+				return nil, fmt.Errorf("regex requires a capture group")
+			}
+			compiled.group = 1
+		}
+	case e.JSONPath != "":
+		path := strings.TrimPrefix(strings.TrimPrefix(e.JSONPath, "$"), ".")
+		if path == "" {
+			return nil, fmt.Errorf("json_path requires keys, e.g. $.latency_ms")
+		}
+		compiled.path = strings.Split(path, ".")
+	default:
+		return nil, fmt.Errorf("regex or json_path is required")
+	}
+	return compiled, nil
+}
+
+// extract returns the numeric value in the log body.
+func (e *logMetricsExtractor) extract(body *commonpb.AnyValue) (float64, bool) {
+	if e.re != nil {
+		match := e.re.FindStringSubmatch(AnyValueString(body))
+		if match == nil {
+			return 0, false
+		}
+		v, err := strconv.ParseFloat(match[e.group], 64)
+		return v, err == nil
+	}
+	var v any
+	if body.GetKvlistValue() != nil {
+		v = anyValueToInterface(body)
+	} else {
+		if err := json.Unmarshal([]byte(body.GetStringValue()), &v); err != nil {
+			return 0, false
+		}
+	}
+	for _, key := range e.path {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return 0, false
+		}
+		if v, ok = m[key]; !ok {
+			return 0, false
+		}
+	}
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func anyValueToInterface(value *commonpb.AnyValue) any {
+	switch v := value.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return v.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return v.BoolValue
+	case *commonpb.AnyValue_IntValue:
+		return v.IntValue
+	case *commonpb.AnyValue_DoubleValue:
+		return v.DoubleValue
+	case *commonpb.AnyValue_ArrayValue:
+		values := make([]any, 0, len(v.ArrayValue.GetValues()))
+		for _, elem := range v.ArrayValue.GetValues() {
+			values = append(values, anyValueToInterface(elem))
+		}
+		return values
+	case *commonpb.AnyValue_KvlistValue:
+		m := make(map[string]any, len(v.KvlistValue.GetValues()))
+		for _, kv := range v.KvlistValue.GetValues() {
+			m[kv.GetKey()] = anyValueToInterface(kv.GetValue())
+		}
+		return m
+	}
+	return nil
+}
+
+// SeverityName returns the severity text, or the short name of the severity number, e.g. ERROR for ERROR2.
+func SeverityName(record *logspb.LogRecord) string {
+	if record.GetSeverityText() != "" {
+		return record.GetSeverityText()
+	}
+	n := record.GetSeverityNumber()
+	if n == logspb.SeverityNumber_SEVERITY_NUMBER_UNSPECIFIED {
+		return "UNSPECIFIED"
+	}
+	names := []string{"TRACE", "DEBUG", "INFO", "WARN", "ERROR", "FATAL"}
+	i := int(n-1) / 4
+	if i >= len(names) {
+		return "UNSPECIFIED"
+	}
+	return names[i]
+}
+
+// resourceServiceName returns service.name of the resource, or unknown_service.
+func resourceServiceName(resource *resourcepb.Resource) string {
+	for _, attr := range resource.GetAttributes() {
+		if attr.GetKey() == "service.name" {
+			return AnyValueString(attr.GetValue())
+		}
+	}
+	return "unknown_service"
+}
+
+// lookupAttributes returns the attributes of keys, in the order of keys.
+func lookupAttributes(attrs []*commonpb.KeyValue, keys []string) []*commonpb.KeyValue {
+	var found []*commonpb.KeyValue
+	for _, key := range keys {
+		i := slices.IndexFunc(attrs, func(kv *commonpb.KeyValue) bool {
+			return kv.GetKey() == key
+		})
+		if i >= 0 {
+			found = append(found, attrs[i])
+		}
+	}
+	return found
+}
+
+type logMetricsSeries struct {
+	attributes []*commonpb.KeyValue
+	start      uint64
+	end        uint64
+	count      uint64
+	last       float64
+	sum        float64
+	min        float64
+	max        float64
+	counts     []uint64
+}
+
+func (s *logMetricsSeries) observe(timestamp uint64, value float64, bounds []float64) {
+	if s.count == 0 || timestamp < s.start {
+		s.start = timestamp
+	}
+	if timestamp >= s.end {
+		s.end = timestamp
+		s.last = value
+	}
+	if s.count == 0 || value < s.min {
+		s.min = value
+	}
+	if s.count == 0 || value > s.max {
+		s.max = value
+	}
+	s.count++
+	s.sum += value
+	if bounds != nil {
+		if s.counts == nil {
+			s.counts = make([]uint64, len(bounds)+1)
+		}
+		i, _ := slices.BinarySearch(bounds, value)
+		s.counts[i]++
+	}
+}
+
+// logMetricsSet is the series of a metric, keyed by AttributesKey in the order of appearance.
+type logMetricsSet struct {
+	series map[string]*logMetricsSeries
+	order  []string
+}
+
+func (set *logMetricsSet) get(attrs []*commonpb.KeyValue) *logMetricsSeries {
+	if set.series == nil {
+		set.series = make(map[string]*logMetricsSeries)
+	}
+	key := AttributesKey(attrs)
+	series, ok := set.series[key]
+	if !ok {
+		series = &logMetricsSeries{attributes: attrs}
+		set.series[key] = series
+		set.order = append(set.order, key)
+	}
+	return series
+}
+
+// Process appends the metrics generated from each logs result, next to it.
+func (c *LogMetricsConnector) Process(_ context.Context, results []*PaseResult) ([]*PaseResult, error) {
+	generated := make([]*PaseResult, 0, len(results))
+	for _, result := range results {
+		generated = append(generated, result)
+		if result.Logs == nil {
+			continue
+		}
+		metrics := c.generate(result.Logs)
+		if metrics == nil {
+			continue
+		}
+		generated = append(generated, &PaseResult{Metrics: metrics, CloudWatch: result.CloudWatch})
+	}
+	return generated, nil
+}
+
+func (c *LogMetricsConnector) generate(logs *logspb.LogsData) *metricspb.MetricsData {
+	type logMetricsResource struct {
+		resource  *resourcepb.Resource
+		records   logMetricsSet
+		extracted []logMetricsSet
+	}
+	resources := make(map[string]*logMetricsResource)
+	var resourceOrder []string
+	for _, resourceLogs := range logs.GetResourceLogs() {
+		key := AttributesKey(resourceLogs.GetResource().GetAttributes())
+		s, ok := resources[key]
+		if !ok {
+			s = &logMetricsResource{
+				resource:  &resourcepb.Resource{Attributes: cloneMessages(resourceLogs.GetResource().GetAttributes())},
+				extracted: make([]logMetricsSet, len(c.extractors)),
+			}
+			resources[key] = s
+			resourceOrder = append(resourceOrder, key)
+		}
+		for _, scopeLogs := range resourceLogs.GetScopeLogs() {
+			for _, record := range scopeLogs.GetLogRecords() {
+				timestamp := record.GetTimeUnixNano()
+				if timestamp == 0 {
+					timestamp = record.GetObservedTimeUnixNano()
+				}
+				attrs := append([]*commonpb.KeyValue{{Key: "severity", Value: toAnyValue(SeverityName(record))}}, lookupAttributes(record.GetAttributes(), c.dimensions)...)
+				s.records.get(attrs).observe(timestamp, 1, nil)
+				c.selfMetrics.Add("log_metrics.log_records", 1)
+				for i, extractor := range c.extractors {
+					value, ok := extractor.extract(record.GetBody())
+					if !ok {
+						continue
+					}
+					var bounds []float64
+					if extractor.typ == "histogram" {
+						bounds = extractor.bounds()
+					}
+					s.extracted[i].get(lookupAttributes(record.GetAttributes(), extractor.Dimensions)).observe(timestamp, value, bounds)
+					c.selfMetrics.Add("log_metrics.extracted_values."+extractor.Name, 1)
+				}
+			}
+		}
+	}
+	metrics := &metricspb.MetricsData{}
+	for _, key := range resourceOrder {
+		s := resources[key]
+		if len(s.records.order) == 0 {
+			continue
+		}
+		generated := []*metricspb.Metric{c.countMetric(&s.records)}
+		for i, extractor := range c.extractors {
+			if len(s.extracted[i].order) == 0 {
+				continue
+			}
+			generated = append(generated, extractor.metric(&s.extracted[i]))
+		}
+		metrics.ResourceMetrics = append(metrics.ResourceMetrics, &metricspb.ResourceMetrics{
+			Resource: s.resource,
+			ScopeMetrics: []*metricspb.ScopeMetrics{
+				{
+					Scope:   &commonpb.InstrumentationScope{Name: "jsonl-otel-forwarder/logmetrics", Version: Version},
+					Metrics: generated,
+				},
+			},
+		})
+	}
+	if len(metrics.ResourceMetrics) == 0 {
+		return nil
+	}
+	return metrics
+}
+
+func (c *LogMetricsConnector) countMetric(set *logMetricsSet) *metricspb.Metric {
+	sum := &metricspb.Sum{AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA, IsMonotonic: true}
+	for _, key := range set.order {
+		series := set.series[key]
+		sum.DataPoints = append(sum.DataPoints, &metricspb.NumberDataPoint{
+			Attributes:        cloneMessages(series.attributes),
+			StartTimeUnixNano: series.start,
+			TimeUnixNano:      series.end,
+			Value:             &metricspb.NumberDataPoint_AsInt{AsInt: int64(series.count)},
+		})
+	}
+	return &metricspb.Metric{Name: c.namespace + ".count", Description: "number of log records", Unit: "{log_record}", Data: &metricspb.Metric_Sum{Sum: sum}}
+}
+
+func (e *logMetricsExtractor) bounds() []float64 {
+	if len(e.Buckets) > 0 {
+		return e.Buckets
+	}
+	return DefaultLogMetricsBuckets
+}
+
+func (e *logMetricsExtractor) metric(set *logMetricsSet) *metricspb.Metric {
+	metric := &metricspb.Metric{Name: e.Name, Description: e.Description, Unit: e.Unit}
+	if e.typ == "histogram" {
+		histogram := &metricspb.Histogram{AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA}
+		for _, key := range set.order {
+			series := set.series[key]
+			histogram.DataPoints = append(histogram.DataPoints, &metricspb.HistogramDataPoint{
+				Attributes:        cloneMessages(series.attributes),
+				StartTimeUnixNano: series.start,
+				TimeUnixNano:      series.end,
+				Count:             series.count,
+				Sum:               &series.sum,
+				Min:               &series.min,
+				Max:               &series.max,
+				BucketCounts:      series.counts,
+				ExplicitBounds:    slices.Clone(e.bounds()),
+			})
+		}
+		metric.Data = &metricspb.Metric_Histogram{Histogram: histogram}
+		return metric
+	}
+	gauge := &metricspb.Gauge{}
+	for _, key := range set.order {
+		series := set.series[key]
+		gauge.DataPoints = append(gauge.DataPoints, &metricspb.NumberDataPoint{
+			Attributes:   cloneMessages(series.attributes),
+			TimeUnixNano: series.end,
+			Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: series.last},
+		})
+	}
+	metric.Data = &metricspb.Metric_Gauge{Gauge: gauge}
+	return metric
+}
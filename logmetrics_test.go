@@ -0,0 +1,70 @@
+package jsonlotelforwarder_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/mashiike/go-otlp-helper/otlp"
+	jsonlotelforwarder "github.com/mashiike/jsonl-otel-forwarder"
+	"github.com/stretchr/testify/require"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+)
+
+func TestLogMetricsConnector(t *testing.T) {
+	config, err := jsonlotelforwarder.LoadConfig("testdata/log_metrics_config.json")
+	require.NoError(t, err)
+	selfMetrics := jsonlotelforwarder.NewSelfMetrics()
+	c, err := jsonlotelforwarder.NewLogMetricsConnector(config.LogMetrics, selfMetrics)
+	require.NoError(t, err)
+	input := loadParseResults(t, "testdata/log_metrics_logs.json")
+	results, err := c.Process(context.Background(), input)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	require.Same(t, input[0], results[0], "logs are left as is")
+	bs, err := otlp.MarshalIndentJSON(results[1].Metrics, "  ")
+	require.NoError(t, err)
+	t.Log("actual:", string(bs))
+	expected, err := os.ReadFile("testdata/log_metrics.json")
+	require.NoError(t, err)
+	require.JSONEq(t, string(expected), string(bs))
+	require.Equal(t, map[string]int64{
+		"log_metrics.log_records":                          5,
+		"log_metrics.extracted_values.http.server.latency": 3,
+		"log_metrics.extracted_values.db.query.duration":   2,
+	}, selfMetrics.Snapshot())
+}
+
+func TestSeverityName(t *testing.T) {
+	cases := []struct {
+		record   *logspb.LogRecord
+		expected string
+	}{
+		{record: &logspb.LogRecord{}, expected: "UNSPECIFIED"},
+		{record: &logspb.LogRecord{SeverityNumber: logspb.SeverityNumber_SEVERITY_NUMBER_INFO}, expected: "INFO"},
+		{record: &logspb.LogRecord{SeverityNumber: logspb.SeverityNumber_SEVERITY_NUMBER_ERROR3}, expected: "ERROR"},
+		{record: &logspb.LogRecord{SeverityNumber: logspb.SeverityNumber_SEVERITY_NUMBER_FATAL4}, expected: "FATAL"},
+		{record: &logspb.LogRecord{SeverityNumber: logspb.SeverityNumber_SEVERITY_NUMBER_INFO, SeverityText: "Information"}, expected: "Information"},
+	}
+	for _, tc := range cases {
+		require.Equal(t, tc.expected, jsonlotelforwarder.SeverityName(tc.record))
+	}
+}
+
+func TestNewLogMetricsConnector__Invalid(t *testing.T) {
+	cases := []*jsonlotelforwarder.LogMetricsExtractor{
+		{Regex: "latency=([0-9]+)"},
+		{Name: "latency"},
+		{Name: "latency", Regex: "latency=[0-9]+"},
+		{Name: "latency", Regex: "(", JSONPath: "$.latency"},
+		{Name: "latency", Type: "summary", JSONPath: "$.latency"},
+		{Name: "latency", Type: "histogram", JSONPath: "$.latency", Buckets: []float64{100, 10}},
+	}
+	for _, extractor := range cases {
+		_, err := jsonlotelforwarder.NewLogMetricsConnector(&jsonlotelforwarder.LogMetricsConfig{
+			Enabled: true,
+			Extract: []*jsonlotelforwarder.LogMetricsExtractor{extractor},
+		}, nil)
+		require.Error(t, err, extractor)
+	}
+}
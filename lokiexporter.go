@@ -0,0 +1,282 @@
+package jsonlotelforwarder
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+)
+
+// LokiExporterConfig is the configuration of the exporter that sends logs with the Loki push API.
+type LokiExporterConfig struct {
+	Endpoint string
+	Headers  map[string]string
+	Timeout  time.Duration
+	Gzip     bool
+	// Labels are the attribute keys of stream labels, looked up in log record attributes and then resource attributes.
+	// DefaultLokiLabels when empty.
+	Labels       []string
+	TenantHeader *TenantHeaderConfig
+	// HTTPClient overrides the HTTP client, Timeout is ignored when set.
+	HTTPClient *http.Client
+}
+
+// DefaultLokiLabels are the attribute keys of stream labels by default.
+var DefaultLokiLabels = []string{"service.namespace", "service.name", "deployment.environment"}
+
+// LokiExporter converts logs to Loki streams, and sends them to the push API. traces and metrics are ignored.
+// the attributes of Labels and the severity as `level` are the stream labels, and the line is a JSON object of
+// the body, trace and span IDs, and the remaining log record and resource attributes.
+type LokiExporter struct {
+	config  LokiExporterConfig
+	client  *http.Client
+	tenants tenantExports
+}
+
+func NewLokiExporter(config *LokiExporterConfig) (*LokiExporter, error) {
+	if config.Endpoint == "" {
+		return nil, fmt.Errorf("endpoint is required")
+	}
+	if config.TenantHeader.Enabled() {
+		if err := config.TenantHeader.validate(); err != nil {
+			return nil, fmt.Errorf("tenant_header: %w", err)
+		}
+	}
+	e := &LokiExporter{config: *config, client: newHTTPExportClient(config.HTTPClient, config.Timeout)}
+	if len(e.config.Labels) == 0 {
+		e.config.Labels = DefaultLokiLabels
+	}
+	return e, nil
+}
+
+func (e *LokiExporter) Start(_ context.Context) error {
+	return nil
+}
+
+func (e *LokiExporter) Stop(_ context.Context) error {
+	return nil
+}
+
+func (e *LokiExporter) Export(ctx context.Context, result *PaseResult) error {
+	if result.Logs == nil {
+		return nil
+	}
+	if !e.config.TenantHeader.Enabled() {
+		return e.push(ctx, "", result.Logs)
+	}
+	tenants, byTenant := splitByResource(&PaseResult{Logs: result.Logs}, e.config.TenantHeader.tenantOf)
+	return e.tenants.export(result, tenants, func(tenant string) error {
+		return e.push(ctx, tenant, byTenant[tenant].Logs)
+	})
+}
+
+func (e *LokiExporter) push(ctx context.Context, tenant string, logs *logspb.LogsData) error {
+	request := ToLokiPushRequest(logs, e.config.Labels)
+	if len(request.Streams) == 0 {
+		slog.DebugContext(ctx, "no streams to push to loki")
+		return nil
+	}
+	bs, err := json.Marshal(request)
+	if err != nil {
+		return &permanentError{err: fmt.Errorf("marshal loki push request: %w", err)}
+	}
+	body, err := newHTTPExportBody(bs, e.config.Gzip)
+	if err != nil {
+		return &permanentError{err: fmt.Errorf("gzip loki push request: %w", err)}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.config.Endpoint, body)
+	if err != nil {
+		return &permanentError{err: fmt.Errorf("create loki push request: %w", err)}
+	}
+	for key, value := range e.config.Headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.config.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	req.Header.Set("User-Agent", "jsonl-otel-forwarder/"+Version)
+	if tenant != "" {
+		req.Header.Set(e.config.TenantHeader.Header, tenant)
+	}
+	slog.InfoContext(ctx, "push logs to loki", "streams", len(request.Streams), "bytes", body.Len(), "tenant", tenant)
+	if err := doHTTPExport(e.client, req); err != nil {
+		return fmt.Errorf("loki push: %w", err)
+	}
+	return nil
+}
+
+func newNamedLokiExporter(c *ExporterConfig) (*namedExporter, error) {
+	for _, signal := range c.Signals {
+		if !containsSignal([]string{"logs"}, signal) {
+			return nil, fmt.Errorf("unsupported signal %q, only logs are sent to loki", signal)
+		}
+	}
+	timeout, err := parseExporterTimeout(c)
+	if err != nil {
+		return nil, err
+	}
+	config := &LokiExporterConfig{
+		Endpoint:     os.ExpandEnv(c.Endpoint),
+		Headers:      expandHeaders(c.Headers),
+		Timeout:      timeout,
+		Gzip:         c.Gzip,
+		Labels:       c.Labels,
+		TenantHeader: c.TenantHeader,
+	}
+	if _, err := NewLokiExporter(config); err != nil {
+		return nil, err
+	}
+	retry, err := newRetryPolicy(c.Retry)
+	if err != nil {
+		return nil, fmt.Errorf("retry: %w", err)
+	}
+	return &namedExporter{
+		name:    c.Name,
+		signals: []string{"logs"},
+		retry:   retry,
+		new: func() (Exporter, error) {
+			return NewLokiExporter(config)
+		},
+	}, nil
+}
+
+// LokiPushRequest is the JSON body of the Loki push API.
+type LokiPushRequest struct {
+	Streams []*LokiStream `json:"streams"`
+}
+
+// LokiStream is the log lines of a label set. Values are pairs of the timestamp in Unix nanoseconds and the line, in order of timestamps.
+type LokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// lokiLine is the JSON object of a log line.
+type lokiLine struct {
+	Body       any            `json:"body,omitempty"`
+	TraceID    string         `json:"traceid,omitempty"`
+	SpanID     string         `json:"spanid,omitempty"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+	Resources  map[string]any `json:"resources,omitempty"`
+}
+
+// ToLokiPushRequest groups log records into streams by the attributes of labels and the severity.
+// the label names are sanitized, e.g. service_name for service.name, and the attributes used as labels are omitted from the lines.
+func ToLokiPushRequest(logs *logspb.LogsData, labels []string) *LokiPushRequest {
+	var (
+		request  = &LokiPushRequest{}
+		byLabels = make(map[string]*LokiStream)
+	)
+	for _, resourceLogs := range logs.GetResourceLogs() {
+		resourceAttrs := resourceLogs.GetResource().GetAttributes()
+		for _, scopeLogs := range resourceLogs.GetScopeLogs() {
+			for _, record := range scopeLogs.GetLogRecords() {
+				stream := make(map[string]string, len(labels)+1)
+				used := make(map[string]bool, len(labels))
+				for _, key := range labels {
+					value, ok := lookupAttribute(record.GetAttributes(), key)
+					if !ok {
+						value, ok = lookupAttribute(resourceAttrs, key)
+					}
+					if ok && value != "" {
+						stream[sanitizePrometheusLabelName(key)] = value
+						used[key] = true
+					}
+				}
+				if level := lokiLevel(record); level != "" {
+					stream["level"] = level
+				}
+				line, err := json.Marshal(&lokiLine{
+					Body:       anyValueToInterface(record.GetBody()),
+					TraceID:    hex.EncodeToString(record.GetTraceId()),
+					SpanID:     hex.EncodeToString(record.GetSpanId()),
+					Attributes: lokiLineAttributes(record.GetAttributes(), used),
+					Resources:  lokiLineAttributes(resourceAttrs, used),
+				})
+				if err != nil {
+					slog.Warn("failed to marshal loki log line", "error", err)
+					continue
+				}
+				key := lokiStreamKey(stream)
+				s, ok := byLabels[key]
+				if !ok {
+					s = &LokiStream{Stream: stream}
+					byLabels[key] = s
+					request.Streams = append(request.Streams, s)
+				}
+				s.Values = append(s.Values, [2]string{strconv.FormatUint(lokiTimestamp(record), 10), string(line)})
+			}
+		}
+	}
+	for _, s := range request.Streams {
+		sort.SliceStable(s.Values, func(i, j int) bool {
+			a, _ := strconv.ParseUint(s.Values[i][0], 10, 64)
+			b, _ := strconv.ParseUint(s.Values[j][0], 10, 64)
+			return a < b
+		})
+	}
+	return request
+}
+
+// lokiLevel returns the lower case short name of the severity number, e.g. info for INFO2, or the severity text.
+func lokiLevel(record *logspb.LogRecord) string {
+	if record.GetSeverityNumber() != logspb.SeverityNumber_SEVERITY_NUMBER_UNSPECIFIED {
+		if name := SeverityName(&logspb.LogRecord{SeverityNumber: record.GetSeverityNumber()}); name != "UNSPECIFIED" {
+			return strings.ToLower(name)
+		}
+	}
+	return strings.ToLower(record.GetSeverityText())
+}
+
+// lokiTimestamp returns the time of the record, the observed time, or now.
+func lokiTimestamp(record *logspb.LogRecord) uint64 {
+	if record.GetTimeUnixNano() != 0 {
+		return record.GetTimeUnixNano()
+	}
+	if record.GetObservedTimeUnixNano() != 0 {
+		return record.GetObservedTimeUnixNano()
+	}
+	return uint64(time.Now().UnixNano())
+}
+
+func lokiLineAttributes(attrs []*commonpb.KeyValue, used map[string]bool) map[string]any {
+	var m map[string]any
+	for _, attr := range attrs {
+		if used[attr.GetKey()] {
+			continue
+		}
+		if m == nil {
+			m = make(map[string]any, len(attrs))
+		}
+		m[attr.GetKey()] = anyValueToInterface(attr.GetValue())
+	}
+	return m
+}
+
+func lokiStreamKey(stream map[string]string) string {
+	names := make([]string, 0, len(stream))
+	for name := range stream {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(stream[name]))
+		b.WriteByte(',')
+	}
+	return b.String()
+}
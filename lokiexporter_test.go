@@ -0,0 +1,142 @@
+package jsonlotelforwarder_test
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+
+	jsonlotelforwarder "github.com/mashiike/jsonl-otel-forwarder"
+	"github.com/stretchr/testify/require"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+)
+
+func TestToLokiPushRequest(t *testing.T) {
+	logs := loadParseResults(t, "testdata/logs.json")[0].Logs
+	request := jsonlotelforwarder.ToLokiPushRequest(logs, []string{"service.name", "string.attribute"})
+	require.Len(t, request.Streams, 1)
+	stream := request.Streams[0]
+	require.Equal(t, map[string]string{
+		"service_name":     "my.service",
+		"string_attribute": "some string",
+		"level":            "info",
+	}, stream.Stream)
+	require.Len(t, stream.Values, 1)
+	require.Equal(t, "1544712660300000000", stream.Values[0][0])
+
+	var line map[string]any
+	require.NoError(t, json.Unmarshal([]byte(stream.Values[0][1]), &line))
+	require.Equal(t, "Example log record", line["body"])
+	require.Equal(t, "5b8efff798038103d269b633813fc60c", line["traceid"])
+	require.Equal(t, "eee19b7ec3c1b174", line["spanid"])
+	attributes := line["attributes"].(map[string]any)
+	require.NotContains(t, attributes, "string.attribute", "the attributes of labels are omitted from the line")
+	require.Equal(t, true, attributes["boolean.attribute"])
+	require.NotContains(t, line, "resources")
+}
+
+func TestForwarder__LokiExporter(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests int
+		pushed   []*jsonlotelforwarder.LokiPushRequest
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if requests == 1 {
+			http.Error(w, "too many outstanding requests", http.StatusTooManyRequests)
+			return
+		}
+		require.Equal(t, "/loki/api/v1/push", r.URL.Path)
+		require.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+		require.Equal(t, "team-a", r.Header.Get("X-Scope-OrgID"))
+		gz, err := gzip.NewReader(r.Body)
+		require.NoError(t, err)
+		var request jsonlotelforwarder.LokiPushRequest
+		require.NoError(t, json.NewDecoder(gz).Decode(&request))
+		pushed = append(pushed, &request)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	opts := jsonlotelforwarder.DefaultOptions()
+	opts.ConfigFile = writeConfig(t, map[string]any{
+		"exporters": []map[string]any{
+			{
+				"name":          "loki",
+				"type":          "loki",
+				"endpoint":      server.URL + "/loki/api/v1/push",
+				"gzip":          true,
+				"retry":         map[string]any{"initial_interval": "1ms"},
+				"tenant_header": map[string]any{"header": "X-Scope-OrgID", "attribute": "service.namespace", "default": "team-a"},
+			},
+		},
+	})
+	forwarder, err := jsonlotelforwarder.New(opts)
+	require.NoError(t, err)
+	for _, name := range []string{"testdata/logs.json", "testdata/metrics.json"} {
+		bs, err := os.ReadFile(name)
+		require.NoError(t, err)
+		_, err = forwarder.Invoke(context.Background(), bs)
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, 2, requests, "throttled push is retried, and metrics are not sent")
+	require.Len(t, pushed, 1)
+	require.Equal(t, map[string]string{"service_name": "my.service", "level": "info"}, pushed[0].Streams[0].Stream)
+
+	opts.ConfigFile = writeConfig(t, map[string]any{
+		"exporters": []map[string]any{
+			{"name": "loki", "type": "loki", "endpoint": server.URL, "signals": []string{"traces"}},
+		},
+	})
+	_, err = jsonlotelforwarder.New(opts)
+	require.Error(t, err)
+}
+
+func TestLokiExporter__RetryFailedTenants(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		tenants []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		tenant := r.Header.Get("X-Scope-OrgID")
+		tenants = append(tenants, tenant)
+		switch {
+		case tenant == "team-c":
+			http.Error(w, "entry too far behind", http.StatusBadRequest)
+		case tenant == "team-b" && slices.Index(tenants, tenant) == len(tenants)-1:
+			http.Error(w, "too many outstanding requests", http.StatusTooManyRequests)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+	exporter, err := jsonlotelforwarder.NewLokiExporter(&jsonlotelforwarder.LokiExporterConfig{
+		Endpoint:     server.URL,
+		TenantHeader: &jsonlotelforwarder.TenantHeaderConfig{Header: "X-Scope-OrgID", Attribute: "service.namespace"},
+	})
+	require.NoError(t, err)
+	result := &jsonlotelforwarder.PaseResult{Logs: &logspb.LogsData{ResourceLogs: []*logspb.ResourceLogs{
+		newResourceLogs(stringAttr("service.namespace", "team-a")),
+		newResourceLogs(stringAttr("service.namespace", "team-b")),
+		newResourceLogs(stringAttr("service.namespace", "team-c")),
+	}}}
+
+	err = exporter.Export(context.Background(), result)
+	require.ErrorContains(t, err, "tenant team-b: loki push: 429 Too Many Requests")
+	require.ErrorContains(t, err, "tenant team-c: loki push: 400 Bad Request")
+	err = exporter.Export(context.Background(), result)
+	require.ErrorContains(t, err, "tenant team-c: loki push: 400 Bad Request", "the permanent error is reported without sending again")
+	require.NotContains(t, err.Error(), "tenant team-b")
+	require.Equal(t, []string{"team-a", "team-b", "team-c", "team-b"}, tenants)
+}
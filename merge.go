@@ -0,0 +1,190 @@
+package jsonlotelforwarder
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+)
+
+// MergeResourceMetricsDataPoints de-duplicates data points that share the same attributes and timestamps in each metric.
+// gauges and cumulative sums keep the latest data point, delta sums are added, and delta histograms and exponential
+// histograms merge buckets. histograms with different bounds, scales or zero thresholds are kept unmerged.
+func MergeResourceMetricsDataPoints(resourceMetrics []*metricspb.ResourceMetrics) []*metricspb.ResourceMetrics {
+	for _, resourceMetric := range resourceMetrics {
+		for _, scopeMetric := range resourceMetric.GetScopeMetrics() {
+			for _, metric := range scopeMetric.GetMetrics() {
+				MergeMetricDataPoints(metric)
+			}
+		}
+	}
+	return resourceMetrics
+}
+
+// MergeMetricDataPoints de-duplicates data points of the metric in place.
+func MergeMetricDataPoints(metric *metricspb.Metric) *metricspb.Metric {
+	switch data := metric.GetData().(type) {
+	case *metricspb.Metric_Gauge:
+		data.Gauge.DataPoints = mergeNumberDataPoints(data.Gauge.GetDataPoints(), metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_UNSPECIFIED)
+	case *metricspb.Metric_Sum:
+		data.Sum.DataPoints = mergeNumberDataPoints(data.Sum.GetDataPoints(), data.Sum.GetAggregationTemporality())
+	case *metricspb.Metric_Histogram:
+		data.Histogram.DataPoints = mergeHistogramDataPoints(data.Histogram.GetDataPoints(), data.Histogram.GetAggregationTemporality())
+	case *metricspb.Metric_ExponentialHistogram:
+		data.ExponentialHistogram.DataPoints = mergeExponentialHistogramDataPoints(
+			data.ExponentialHistogram.GetDataPoints(),
+			data.ExponentialHistogram.GetAggregationTemporality(),
+		)
+	case *metricspb.Metric_Summary:
+		data.Summary.DataPoints = mergeDataPointsKeepLatest(data.Summary.GetDataPoints(), metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_UNSPECIFIED)
+	}
+	return metric
+}
+
+type dataPoint interface {
+	GetAttributes() []*commonpb.KeyValue
+	GetStartTimeUnixNano() uint64
+	GetTimeUnixNano() uint64
+}
+
+// dataPointKey returns the identity of the data point used for merging.
+// cumulative data points are identified by the start time, because the latest one contains all previous ones.
+func dataPointKey(dp dataPoint, temporality metricspb.AggregationTemporality) string {
+	attrs := AttributesKey(dp.GetAttributes())
+	switch temporality {
+	case metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE:
+		return fmt.Sprintf("%s|%d", attrs, dp.GetStartTimeUnixNano())
+	case metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA:
+		return fmt.Sprintf("%s|%d|%d", attrs, dp.GetStartTimeUnixNano(), dp.GetTimeUnixNano())
+	default:
+		return fmt.Sprintf("%s|%d", attrs, dp.GetTimeUnixNano())
+	}
+}
+
+// AttributesKey returns a string that identifies the attribute set regardless of the order of the attributes.
+func AttributesKey(attrs []*commonpb.KeyValue) string {
+	keys := make([]string, 0, len(attrs))
+	for _, attr := range attrs {
+		keys = append(keys, attr.GetKey()+"="+attr.GetValue().String())
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+func mergeDataPointsKeepLatest[T dataPoint](dps []T, temporality metricspb.AggregationTemporality) []T {
+	return mergeDataPoints(dps, temporality, func(dst, elem T) (T, bool) {
+		if elem.GetTimeUnixNano() >= dst.GetTimeUnixNano() {
+			return elem, true
+		}
+		return dst, true
+	})
+}
+
+// mergeDataPoints merges the data points that share the key. when merge returns false, the data points can not be
+// merged, and both are kept.
+func mergeDataPoints[T dataPoint](dps []T, temporality metricspb.AggregationTemporality, merge func(dst, elem T) (T, bool)) []T {
+	if len(dps) <= 1 {
+		return dps
+	}
+	merged := make([]T, 0, len(dps))
+	index := make(map[string]int, len(dps))
+	for _, dp := range dps {
+		key := dataPointKey(dp, temporality)
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(merged)
+			merged = append(merged, dp)
+			continue
+		}
+		if m, ok := merge(merged[i], dp); ok {
+			merged[i] = m
+			continue
+		}
+		merged = append(merged, dp)
+	}
+	return merged
+}
+
+func mergeNumberDataPoints(dps []*metricspb.NumberDataPoint, temporality metricspb.AggregationTemporality) []*metricspb.NumberDataPoint {
+	if temporality != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA {
+		return mergeDataPointsKeepLatest(dps, temporality)
+	}
+	return mergeDataPoints(dps, temporality, func(dst, elem *metricspb.NumberDataPoint) (*metricspb.NumberDataPoint, bool) {
+		switch v := dst.GetValue().(type) {
+		case *metricspb.NumberDataPoint_AsInt:
+			if e, ok := elem.GetValue().(*metricspb.NumberDataPoint_AsInt); ok {
+				v.AsInt += e.AsInt
+				break
+			}
+			dst.Value = &metricspb.NumberDataPoint_AsDouble{AsDouble: float64(v.AsInt) + numberDataPointValue(elem)}
+		case *metricspb.NumberDataPoint_AsDouble:
+			v.AsDouble += numberDataPointValue(elem)
+		default:
+			dst.Value = elem.GetValue()
+		}
+		dst.Exemplars = append(dst.GetExemplars(), elem.GetExemplars()...)
+		return dst, true
+	})
+}
+
+func numberDataPointValue(dp *metricspb.NumberDataPoint) float64 {
+	switch v := dp.GetValue().(type) {
+	case *metricspb.NumberDataPoint_AsInt:
+		return float64(v.AsInt)
+	case *metricspb.NumberDataPoint_AsDouble:
+		return v.AsDouble
+	}
+	return 0
+}
+
+func mergeHistogramDataPoints(dps []*metricspb.HistogramDataPoint, temporality metricspb.AggregationTemporality) []*metricspb.HistogramDataPoint {
+	if temporality != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA {
+		return mergeDataPointsKeepLatest(dps, temporality)
+	}
+	return mergeDataPoints(dps, temporality, func(dst, elem *metricspb.HistogramDataPoint) (*metricspb.HistogramDataPoint, bool) {
+		if !slices.Equal(dst.GetExplicitBounds(), elem.GetExplicitBounds()) || len(dst.GetBucketCounts()) != len(elem.GetBucketCounts()) {
+			slog.Warn("can not merge histogram data points with different bucket bounds, keep both",
+				"dst_bounds", dst.GetExplicitBounds(), "elem_bounds", elem.GetExplicitBounds())
+			return nil, false
+		}
+		for i := range dst.BucketCounts {
+			dst.BucketCounts[i] += elem.BucketCounts[i]
+		}
+		dst.Count += elem.GetCount()
+		if dst.Sum != nil || elem.Sum != nil {
+			sum := dst.GetSum() + elem.GetSum()
+			dst.Sum = &sum
+		}
+		if elem.Min != nil && (dst.Min == nil || elem.GetMin() < dst.GetMin()) {
+			dst.Min = elem.Min
+		}
+		if elem.Max != nil && (dst.Max == nil || elem.GetMax() > dst.GetMax()) {
+			dst.Max = elem.Max
+		}
+		dst.Exemplars = append(dst.GetExemplars(), elem.GetExemplars()...)
+		return dst, true
+	})
+}
+
+func mergeExponentialHistogramDataPoints(dps []*metricspb.ExponentialHistogramDataPoint, temporality metricspb.AggregationTemporality) []*metricspb.ExponentialHistogramDataPoint {
+	if temporality != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA {
+		return mergeDataPointsKeepLatest(dps, temporality)
+	}
+	return mergeDataPoints(dps, temporality, func(dst, elem *metricspb.ExponentialHistogramDataPoint) (*metricspb.ExponentialHistogramDataPoint, bool) {
+		if dst.GetScale() != elem.GetScale() || dst.GetZeroThreshold() != elem.GetZeroThreshold() {
+			slog.Warn("can not merge exponential histogram data points with different scales or zero thresholds, keep both",
+				"dst_scale", dst.GetScale(), "elem_scale", elem.GetScale())
+			return nil, false
+		}
+		merged, ok := exponentialHistogramTemporalityOps.add(dst, elem)
+		if !ok {
+			return nil, false
+		}
+		merged.Exemplars = append(dst.GetExemplars(), elem.GetExemplars()...)
+		return merged, true
+	})
+}
@@ -0,0 +1,156 @@
+package jsonlotelforwarder_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/mashiike/go-otlp-helper/otlp"
+	jsonlotelforwarder "github.com/mashiike/jsonl-otel-forwarder"
+	"github.com/stretchr/testify/require"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestMergeResourceMetricsDataPoints(t *testing.T) {
+	var metrics1, metrics2 metricspb.MetricsData
+	metricsjson, err := os.ReadFile("testdata/metrics.json")
+	require.NoError(t, err)
+	require.NoError(t, otlp.UnmarshalJSON(metricsjson, &metrics1))
+	require.NoError(t, otlp.UnmarshalJSON(metricsjson, &metrics2))
+	batched := jsonlotelforwarder.ToBatchResourceMetrics(metrics1.GetResourceMetrics(), metrics2.GetResourceMetrics()...)
+	actual := jsonlotelforwarder.MergeResourceMetricsDataPoints(batched)
+	bs, err := otlp.MarshalIndentJSON(&metricspb.MetricsData{ResourceMetrics: actual}, "  ")
+	require.NoError(t, err)
+	t.Log("actual:", string(bs))
+	expected, err := os.ReadFile("testdata/merged_metrics.json")
+	require.NoError(t, err)
+	require.JSONEq(t, string(expected), string(bs))
+}
+
+func TestMergeMetricDataPoints(t *testing.T) {
+	attrs := func(value string) []*commonpb.KeyValue {
+		return []*commonpb.KeyValue{
+			{Key: "key", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}},
+		}
+	}
+	intPoint := func(value string, start, end uint64, v int64) *metricspb.NumberDataPoint {
+		return &metricspb.NumberDataPoint{
+			Attributes:        attrs(value),
+			StartTimeUnixNano: start,
+			TimeUnixNano:      end,
+			Value:             &metricspb.NumberDataPoint_AsInt{AsInt: v},
+		}
+	}
+	sum := func(temporality metricspb.AggregationTemporality, dps ...*metricspb.NumberDataPoint) *metricspb.Metric {
+		return &metricspb.Metric{
+			Name: "sum",
+			Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+				AggregationTemporality: temporality,
+				IsMonotonic:            true,
+				DataPoints:             dps,
+			}},
+		}
+	}
+	cases := []struct {
+		name     string
+		metric   *metricspb.Metric
+		expected []int64
+	}{
+		{
+			name: "cumulative keep latest",
+			metric: sum(metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+				intPoint("a", 1, 3, 5),
+				intPoint("a", 1, 2, 3),
+				intPoint("b", 1, 2, 7),
+			),
+			expected: []int64{5, 7},
+		},
+		{
+			name: "delta add same window",
+			metric: sum(metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+				intPoint("a", 1, 2, 5),
+				intPoint("a", 1, 2, 3),
+				intPoint("a", 2, 3, 7),
+			),
+			expected: []int64{8, 7},
+		},
+		{
+			name: "attribute order does not matter",
+			metric: sum(metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+				&metricspb.NumberDataPoint{
+					Attributes:        append(attrs("a"), &commonpb.KeyValue{Key: "other", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: 1}}}),
+					StartTimeUnixNano: 1, TimeUnixNano: 2,
+					Value: &metricspb.NumberDataPoint_AsInt{AsInt: 1},
+				},
+				&metricspb.NumberDataPoint{
+					Attributes:        append([]*commonpb.KeyValue{{Key: "other", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: 1}}}}, attrs("a")...),
+					StartTimeUnixNano: 1, TimeUnixNano: 2,
+					Value: &metricspb.NumberDataPoint_AsInt{AsInt: 2},
+				},
+			),
+			expected: []int64{3},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := jsonlotelforwarder.MergeMetricDataPoints(tc.metric)
+			values := make([]int64, 0)
+			for _, dp := range actual.GetSum().GetDataPoints() {
+				values = append(values, dp.GetAsInt())
+			}
+			require.Equal(t, tc.expected, values)
+		})
+	}
+}
+
+func TestMergeMetricDataPoints__Histograms(t *testing.T) {
+	delta := metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+	histogram := &metricspb.Metric{
+		Name: "histogram",
+		Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+			AggregationTemporality: delta,
+			DataPoints: []*metricspb.HistogramDataPoint{
+				{StartTimeUnixNano: 1, TimeUnixNano: 2, Count: 3, Sum: proto.Float64(30), ExplicitBounds: []float64{10}, BucketCounts: []uint64{1, 2}},
+				{StartTimeUnixNano: 1, TimeUnixNano: 2, Count: 2, Sum: proto.Float64(5), ExplicitBounds: []float64{5}, BucketCounts: []uint64{2, 0}},
+				{StartTimeUnixNano: 1, TimeUnixNano: 2, Count: 1, Sum: proto.Float64(20), ExplicitBounds: []float64{10}, BucketCounts: []uint64{0, 1}},
+			},
+		}},
+	}
+	dps := jsonlotelforwarder.MergeMetricDataPoints(histogram).GetHistogram().GetDataPoints()
+	require.Len(t, dps, 2, "the data points with different bounds are kept")
+	require.Equal(t, []uint64{1, 3}, dps[0].GetBucketCounts())
+	require.Equal(t, uint64(4), dps[0].GetCount())
+	require.Equal(t, 50.0, dps[0].GetSum())
+	require.Equal(t, []uint64{2, 0}, dps[1].GetBucketCounts())
+	require.Equal(t, uint64(2), dps[1].GetCount())
+
+	newPoint := func(scale int32, offset int32, counts ...uint64) *metricspb.ExponentialHistogramDataPoint {
+		var count uint64
+		for _, c := range counts {
+			count += c
+		}
+		return &metricspb.ExponentialHistogramDataPoint{
+			StartTimeUnixNano: 1, TimeUnixNano: 2, Scale: scale, Count: count,
+			Positive: &metricspb.ExponentialHistogramDataPoint_Buckets{Offset: offset, BucketCounts: counts},
+		}
+	}
+	exponential := &metricspb.Metric{
+		Name: "exponential",
+		Data: &metricspb.Metric_ExponentialHistogram{ExponentialHistogram: &metricspb.ExponentialHistogram{
+			AggregationTemporality: delta,
+			DataPoints: []*metricspb.ExponentialHistogramDataPoint{
+				newPoint(1, 0, 1, 2),
+				newPoint(1, 1, 3),
+				newPoint(2, 0, 4),
+			},
+		}},
+	}
+	edps := jsonlotelforwarder.MergeMetricDataPoints(exponential).GetExponentialHistogram().GetDataPoints()
+	require.Len(t, edps, 2, "the data points with different scales are kept")
+	require.Equal(t, int32(0), edps[0].GetPositive().GetOffset())
+	require.Equal(t, []uint64{1, 5}, edps[0].GetPositive().GetBucketCounts(), "the buckets are added")
+	require.Equal(t, uint64(6), edps[0].GetCount())
+	require.Equal(t, int32(2), edps[1].GetScale())
+	require.Equal(t, uint64(4), edps[1].GetCount())
+}
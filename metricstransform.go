@@ -0,0 +1,399 @@
+package jsonlotelforwarder
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+)
+
+// MetricsTransformRule is a rule of metrics transform processor, applied to the metrics whose name matches the Metric glob pattern.
+// the operations are applied in the order of combine, rename, unit conversion and attribute aggregation.
+type MetricsTransformRule struct {
+	Metric  string                   `json:"metric"`
+	Combine *MetricsTransformCombine `json:"combine,omitempty"`
+	Rename  string                   `json:"rename,omitempty"`
+	// Unit converts data point values from the metric unit, e.g. ms to s or By to MiBy.
+	Unit string `json:"unit,omitempty"`
+	// KeepAttributes or DropAttributes removes data point attributes, and the data points that share the rest are aggregated.
+	KeepAttributes []string `json:"keep_attributes,omitempty"`
+	DropAttributes []string `json:"drop_attributes,omitempty"`
+	// Aggregation is sum (default), mean, min or max of number data points. histograms are always added up.
+	Aggregation string `json:"aggregation,omitempty"`
+}
+
+// MetricsTransformCombine combines the matched metrics in a scope into the metric Name.
+// the data points have Attribute of the first capture group of Pattern, or the original metric name.
+type MetricsTransformCombine struct {
+	Name      string `json:"name"`
+	Attribute string `json:"attribute"`
+	Pattern   string `json:"pattern,omitempty"`
+}
+
+// ParseMetricsRename parses comma separated `old=new` metric names.
+func ParseMetricsRename(s string) ([]*MetricsTransformRule, error) {
+	var rules []*MetricsTransformRule
+	for _, part := range splitList(s) {
+		from, to, ok := strings.Cut(part, "=")
+		if !ok || from == "" || to == "" {
+			return nil, fmt.Errorf("invalid metrics rename %q, expected old=new", part)
+		}
+		rules = append(rules, &MetricsTransformRule{Metric: from, Rename: to})
+	}
+	return rules, nil
+}
+
+type unitScale struct {
+	dimension string
+	factor    float64
+}
+
+var metricUnits = map[string]unitScale{
+	"ns":   {dimension: "time", factor: 1e-9},
+	"us":   {dimension: "time", factor: 1e-6},
+	"ms":   {dimension: "time", factor: 1e-3},
+	"s":    {dimension: "time", factor: 1},
+	"min":  {dimension: "time", factor: 60},
+	"h":    {dimension: "time", factor: 3600},
+	"By":   {dimension: "bytes", factor: 1},
+	"KBy":  {dimension: "bytes", factor: 1e3},
+	"MBy":  {dimension: "bytes", factor: 1e6},
+	"GBy":  {dimension: "bytes", factor: 1e9},
+	"KiBy": {dimension: "bytes", factor: 1 << 10},
+	"MiBy": {dimension: "bytes", factor: 1 << 20},
+	"GiBy": {dimension: "bytes", factor: 1 << 30},
+}
+
+var metricUnitAliases = map[string]string{
+	"bytes": "By",
+	"KiB":   "KiBy",
+	"MiB":   "MiBy",
+	"GiB":   "GiBy",
+}
+
+func normalizeMetricUnit(unit string) string {
+	if alias, ok := metricUnitAliases[unit]; ok {
+		return alias
+	}
+	return unit
+}
+
+type metricsTransformRule struct {
+	*MetricsTransformRule
+	combine     *regexp.Regexp
+	keep        func(key string) bool
+	unit        string
+	aggregation string
+}
+
+type MetricsTransformProcessor struct {
+	rules       []*metricsTransformRule
+	selfMetrics *SelfMetrics
+}
+
+func NewMetricsTransformProcessor(rules []*MetricsTransformRule, selfMetrics *SelfMetrics) (*MetricsTransformProcessor, error) {
+	p := &MetricsTransformProcessor{selfMetrics: selfMetrics}
+	for i, rule := range rules {
+		compiled, err := rule.compile()
+		if err != nil {
+			return nil, fmt.Errorf("rules[%d]: %w", i, err)
+		}
+		p.rules = append(p.rules, compiled)
+	}
+	return p, nil
+}
+
+func (rule *MetricsTransformRule) compile() (*metricsTransformRule, error) {
+	compiled := &metricsTransformRule{MetricsTransformRule: rule, aggregation: rule.Aggregation}
+	if rule.Metric == "" {
+		return nil, fmt.Errorf("metric is required")
+	}
+	if _, err := path.Match(rule.Metric, ""); err != nil {
+		return nil, fmt.Errorf("invalid glob %q: %w", rule.Metric, err)
+	}
+	if rule.Combine != nil {
+		if rule.Combine.Name == "" || rule.Combine.Attribute == "" {
+			return nil, fmt.Errorf("combine requires name and attribute")
+		}
+		if rule.Combine.Pattern != "" {
+			re, err := regexp.Compile(rule.Combine.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("combine pattern: %w", err)
+			}
+			compiled.combine = re
+		}
+	}
+	if rule.Unit != "" {
+		compiled.unit = normalizeMetricUnit(rule.Unit)
+		if _, ok := metricUnits[compiled.unit]; !ok {
+			return nil, fmt.Errorf("unknown unit %q", rule.Unit)
+		}
+	}
+	switch {
+	case len(rule.KeepAttributes) > 0 && len(rule.DropAttributes) > 0:
+		return nil, fmt.Errorf("either keep_attributes or drop_attributes is allowed")
+	case len(rule.KeepAttributes) > 0:
+		compiled.keep = func(key string) bool { return slices.Contains(rule.KeepAttributes, key) }
+	case len(rule.DropAttributes) > 0:
+		compiled.keep = func(key string) bool { return !slices.Contains(rule.DropAttributes, key) }
+	}
+	switch compiled.aggregation {
+	case "":
+		compiled.aggregation = "sum"
+	case "sum", "mean", "min", "max":
+	default:
+		return nil, fmt.Errorf("unknown aggregation %q, expected sum, mean, min or max", rule.Aggregation)
+	}
+	return compiled, nil
+}
+
+func (rule *metricsTransformRule) match(metric *metricspb.Metric) bool {
+	matched, _ := path.Match(rule.Metric, metric.GetName())
+	return matched
+}
+
+func (p *MetricsTransformProcessor) Process(_ context.Context, results []*PaseResult) ([]*PaseResult, error) {
+	for _, result := range results {
+		for _, resourceMetrics := range result.Metrics.GetResourceMetrics() {
+			for _, scopeMetrics := range resourceMetrics.GetScopeMetrics() {
+				for _, rule := range p.rules {
+					scopeMetrics.Metrics = p.apply(rule, scopeMetrics.GetMetrics())
+				}
+			}
+		}
+		PruneResult(result)
+	}
+	return results, nil
+}
+
+func (p *MetricsTransformProcessor) apply(rule *metricsTransformRule, metrics []*metricspb.Metric) []*metricspb.Metric {
+	if rule.Combine != nil {
+		metrics = p.combine(rule, metrics)
+	}
+	for _, metric := range metrics {
+		if !rule.match(metric) && (rule.Combine == nil || metric.GetName() != rule.Combine.Name) {
+			continue
+		}
+		if rule.Rename != "" && metric.GetName() != rule.Rename {
+			metric.Name = rule.Rename
+			p.selfMetrics.Add("metrics_transform.renamed_metrics", 1)
+		}
+		if rule.unit != "" {
+			p.convertUnit(metric, rule.unit)
+		}
+		if rule.keep != nil {
+			before := countDataPoints(metric)
+			aggregateDataPoints(metric, rule.keep, rule.aggregation)
+			p.selfMetrics.Add("metrics_transform.aggregated_data_points", int64(before-countDataPoints(metric)))
+		}
+	}
+	return metrics
+}
+
+// combine merges the matched metrics into the first one, renamed to Combine.Name.
+// metrics whose type or unit differs from the first one are left as is.
+func (p *MetricsTransformProcessor) combine(rule *metricsTransformRule, metrics []*metricspb.Metric) []*metricspb.Metric {
+	var combined *metricspb.Metric
+	return slices.DeleteFunc(metrics, func(metric *metricspb.Metric) bool {
+		if !rule.match(metric) {
+			return false
+		}
+		value := metric.GetName()
+		if rule.combine != nil {
+			match := rule.combine.FindStringSubmatch(value)
+			if match == nil {
+				return false
+			}
+			if len(match) > 1 {
+				value = match[1]
+			}
+		}
+		if combined != nil && (metricTypeString(combined) != metricTypeString(metric) || combined.GetUnit() != metric.GetUnit()) {
+			p.selfMetrics.Add("metrics_transform.skipped_combines", 1)
+			return false
+		}
+		for _, attrs := range dataPointAttributes(metric) {
+			*attrs = applyAttributeRule(*attrs, "upsert", rule.Combine.Attribute, toAnyValue(value))
+		}
+		p.selfMetrics.Add("metrics_transform.combined_metrics", 1)
+		if combined == nil {
+			combined = metric
+			combined.Name = rule.Combine.Name
+			return false
+		}
+		toBatchMetricData(combined, metric)
+		return true
+	})
+}
+
+func (p *MetricsTransformProcessor) convertUnit(metric *metricspb.Metric, to string) {
+	from, ok := metricUnits[normalizeMetricUnit(metric.GetUnit())]
+	target := metricUnits[to]
+	if !ok || from.dimension != target.dimension {
+		p.selfMetrics.Add("metrics_transform.skipped_unit_conversions", 1)
+		return
+	}
+	factor := from.factor / target.factor
+	scale := func(v *float64) *float64 {
+		if v == nil {
+			return nil
+		}
+		return proto.Float64(*v * factor)
+	}
+	switch data := metric.GetData().(type) {
+	case *metricspb.Metric_Gauge:
+		scaleNumberDataPoints(data.Gauge.GetDataPoints(), factor)
+	case *metricspb.Metric_Sum:
+		scaleNumberDataPoints(data.Sum.GetDataPoints(), factor)
+	case *metricspb.Metric_Histogram:
+		for _, dp := range data.Histogram.GetDataPoints() {
+			dp.Sum, dp.Min, dp.Max = scale(dp.Sum), scale(dp.Min), scale(dp.Max)
+			for i := range dp.ExplicitBounds {
+				dp.ExplicitBounds[i] *= factor
+			}
+		}
+	case *metricspb.Metric_Summary:
+		for _, dp := range data.Summary.GetDataPoints() {
+			dp.Sum *= factor
+			for _, q := range dp.GetQuantileValues() {
+				q.Value *= factor
+			}
+		}
+	default:
+		// bucket boundaries of exponential histograms can not be scaled by an arbitrary factor.
+		p.selfMetrics.Add("metrics_transform.skipped_unit_conversions", 1)
+		return
+	}
+	metric.Unit = to
+}
+
+// scaleNumberDataPoints multiplies the values by factor. int values are kept int when the factor is an integer.
+func scaleNumberDataPoints(dps []*metricspb.NumberDataPoint, factor float64) {
+	for _, dp := range dps {
+		if v, ok := dp.GetValue().(*metricspb.NumberDataPoint_AsInt); ok && factor == math.Trunc(factor) {
+			v.AsInt *= int64(factor)
+			continue
+		}
+		dp.Value = &metricspb.NumberDataPoint_AsDouble{AsDouble: numberDataPointValue(dp) * factor}
+	}
+}
+
+// aggregateDataPoints removes the attributes that keep returns false, and aggregates the data points that share
+// the rest of attributes and the timestamp.
+func aggregateDataPoints(metric *metricspb.Metric, keep func(key string) bool, aggregation string) {
+	for _, attrs := range dataPointAttributes(metric) {
+		*attrs = slices.DeleteFunc(*attrs, func(kv *commonpb.KeyValue) bool {
+			return !keep(kv.GetKey())
+		})
+	}
+	switch data := metric.GetData().(type) {
+	case *metricspb.Metric_Gauge:
+		data.Gauge.DataPoints = aggregateNumberDataPoints(data.Gauge.GetDataPoints(), aggregation)
+	case *metricspb.Metric_Sum:
+		data.Sum.DataPoints = aggregateNumberDataPoints(data.Sum.GetDataPoints(), aggregation)
+	case *metricspb.Metric_Histogram:
+		data.Histogram.DataPoints = aggregateDataPointsBy(data.Histogram.GetDataPoints(), func(dp *metricspb.HistogramDataPoint) string {
+			// the data points with different buckets, e.g. without bucket counts, are not merged.
+			return fmt.Sprint(dp.GetExplicitBounds(), len(dp.GetBucketCounts()))
+		}, func(dst, elem *metricspb.HistogramDataPoint) {
+			for i := range dst.BucketCounts {
+				dst.BucketCounts[i] += elem.BucketCounts[i]
+			}
+			dst.Count += elem.GetCount()
+			if dst.Sum != nil && elem.Sum != nil {
+				dst.Sum = proto.Float64(dst.GetSum() + elem.GetSum())
+			}
+			dst.Min, dst.Max = addMinMax(dst.Min, dst.Max, elem.Min, elem.Max)
+		})
+	case *metricspb.Metric_ExponentialHistogram:
+		data.ExponentialHistogram.DataPoints = aggregateDataPointsBy(data.ExponentialHistogram.GetDataPoints(), func(dp *metricspb.ExponentialHistogramDataPoint) string {
+			return fmt.Sprint(dp.GetZeroThreshold())
+		}, func(dst, elem *metricspb.ExponentialHistogramDataPoint) {
+			merged, _ := exponentialHistogramTemporalityOps.add(dst, elem)
+			proto.Reset(dst)
+			proto.Merge(dst, merged)
+		})
+	case *metricspb.Metric_Summary:
+		data.Summary.DataPoints = aggregateDataPointsBy(data.Summary.GetDataPoints(), nil, func(dst, elem *metricspb.SummaryDataPoint) {
+			// quantiles can not be aggregated.
+			dst.Count += elem.GetCount()
+			dst.Sum += elem.GetSum()
+			dst.QuantileValues = nil
+		})
+	}
+}
+
+func aggregateNumberDataPoints(dps []*metricspb.NumberDataPoint, aggregation string) []*metricspb.NumberDataPoint {
+	counts := make(map[*metricspb.NumberDataPoint]int)
+	aggregated := aggregateDataPointsBy(dps, nil, func(dst, elem *metricspb.NumberDataPoint) {
+		counts[dst]++
+		d, e := numberDataPointValue(dst), numberDataPointValue(elem)
+		switch aggregation {
+		case "min":
+			if e < d {
+				dst.Value = elem.Value
+			}
+		case "max":
+			if e > d {
+				dst.Value = elem.Value
+			}
+		default:
+			dv, dok := dst.GetValue().(*metricspb.NumberDataPoint_AsInt)
+			ev, eok := elem.GetValue().(*metricspb.NumberDataPoint_AsInt)
+			if dok && eok {
+				dv.AsInt += ev.AsInt
+				break
+			}
+			dst.Value = &metricspb.NumberDataPoint_AsDouble{AsDouble: d + e}
+		}
+		dst.Exemplars = append(dst.GetExemplars(), elem.GetExemplars()...)
+	})
+	if aggregation == "mean" {
+		for _, dp := range aggregated {
+			if n, ok := counts[dp]; ok {
+				dp.Value = &metricspb.NumberDataPoint_AsDouble{AsDouble: numberDataPointValue(dp) / float64(n+1)}
+			}
+		}
+	}
+	return aggregated
+}
+
+// aggregateDataPointsBy merges the data points that share the attributes, the timestamp and the extra key into the first one.
+// the start timestamp of the merged data point is the earliest one.
+func aggregateDataPointsBy[T protoDataPoint](dps []T, extra func(dp T) string, merge func(dst, elem T)) []T {
+	index := make(map[string]T, len(dps))
+	return slices.DeleteFunc(dps, func(dp T) bool {
+		key := fmt.Sprintf("%s|%d", AttributesKey(dp.GetAttributes()), dp.GetTimeUnixNano())
+		if extra != nil {
+			key += "|" + extra(dp)
+		}
+		dst, ok := index[key]
+		if !ok {
+			index[key] = dp
+			return false
+		}
+		start := min(dst.GetStartTimeUnixNano(), dp.GetStartTimeUnixNano())
+		merge(dst, dp)
+		setStartTimeUnixNano(dst, start)
+		return true
+	})
+}
+
+func setStartTimeUnixNano(dp protoDataPoint, start uint64) {
+	m := dp.ProtoReflect()
+	m.Set(m.Descriptor().Fields().ByName("start_time_unix_nano"), protoreflect.ValueOfUint64(start))
+}
+
+func setTimeUnixNano(dp protoDataPoint, time uint64) {
+	m := dp.ProtoReflect()
+	m.Set(m.Descriptor().Fields().ByName("time_unix_nano"), protoreflect.ValueOfUint64(time))
+}
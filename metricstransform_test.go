@@ -0,0 +1,99 @@
+package jsonlotelforwarder_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/mashiike/go-otlp-helper/otlp"
+	jsonlotelforwarder "github.com/mashiike/jsonl-otel-forwarder"
+	"github.com/stretchr/testify/require"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+)
+
+func TestMetricsTransformProcessor(t *testing.T) {
+	config, err := jsonlotelforwarder.LoadConfig("testdata/metrics_transform_config.json")
+	require.NoError(t, err)
+	selfMetrics := jsonlotelforwarder.NewSelfMetrics()
+	p, err := jsonlotelforwarder.NewMetricsTransformProcessor(config.MetricsTransform, selfMetrics)
+	require.NoError(t, err)
+	results, err := p.Process(context.Background(), loadParseResults(t, "testdata/metrics_transform_metrics.json"))
+	require.NoError(t, err)
+	bs, err := otlp.MarshalIndentJSON(results[0].Metrics, "  ")
+	require.NoError(t, err)
+	t.Log("actual:", string(bs))
+	expected, err := os.ReadFile("testdata/metrics_transformed.json")
+	require.NoError(t, err)
+	require.JSONEq(t, string(expected), string(bs))
+	require.Equal(t, map[string]int64{
+		"metrics_transform.renamed_metrics":        1,
+		"metrics_transform.combined_metrics":       2,
+		"metrics_transform.aggregated_data_points": 2,
+	}, selfMetrics.Snapshot())
+}
+
+func TestParseMetricsRename(t *testing.T) {
+	rules, err := jsonlotelforwarder.ParseMetricsRename("http.server.duration=http.server.request.duration, process.runtime.*=runtime")
+	require.NoError(t, err)
+	require.Equal(t, []*jsonlotelforwarder.MetricsTransformRule{
+		{Metric: "http.server.duration", Rename: "http.server.request.duration"},
+		{Metric: "process.runtime.*", Rename: "runtime"},
+	}, rules)
+	_, err = jsonlotelforwarder.ParseMetricsRename("http.server.duration")
+	require.Error(t, err)
+}
+
+func TestNewMetricsTransformProcessor__Invalid(t *testing.T) {
+	cases := []*jsonlotelforwarder.MetricsTransformRule{
+		{Rename: "renamed"},
+		{Metric: "[", Rename: "renamed"},
+		{Metric: "http.*", Unit: "parsec"},
+		{Metric: "http.*", KeepAttributes: []string{"a"}, DropAttributes: []string{"b"}},
+		{Metric: "http.*", DropAttributes: []string{"b"}, Aggregation: "median"},
+		{Metric: "http.*", Combine: &jsonlotelforwarder.MetricsTransformCombine{Name: "http"}},
+		{Metric: "http.*", Combine: &jsonlotelforwarder.MetricsTransformCombine{Name: "http", Attribute: "kind", Pattern: "("}},
+	}
+	for _, rule := range cases {
+		_, err := jsonlotelforwarder.NewMetricsTransformProcessor([]*jsonlotelforwarder.MetricsTransformRule{rule}, nil)
+		require.Error(t, err, rule)
+	}
+}
+
+func TestMetricsTransformProcessor__HistogramBuckets(t *testing.T) {
+	newDataPoint := func(host string, bucketCounts ...uint64) *metricspb.HistogramDataPoint {
+		var count uint64
+		for _, c := range bucketCounts {
+			count += c
+		}
+		return &metricspb.HistogramDataPoint{
+			TimeUnixNano:   1,
+			Attributes:     []*commonpb.KeyValue{stringAttr("host", host)},
+			Count:          count,
+			ExplicitBounds: []float64{1},
+			BucketCounts:   bucketCounts,
+		}
+	}
+	result := &jsonlotelforwarder.PaseResult{Metrics: &metricspb.MetricsData{ResourceMetrics: []*metricspb.ResourceMetrics{{
+		ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: []*metricspb.Metric{{
+			Name: "latency",
+			Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{DataPoints: []*metricspb.HistogramDataPoint{
+				newDataPoint("a", 1, 2),
+				newDataPoint("b"),
+				newDataPoint("c", 3, 4),
+				newDataPoint("d", 1, 1, 1),
+			}}},
+		}}}},
+	}}}}
+	p, err := jsonlotelforwarder.NewMetricsTransformProcessor([]*jsonlotelforwarder.MetricsTransformRule{
+		{Metric: "latency", DropAttributes: []string{"host"}},
+	}, nil)
+	require.NoError(t, err)
+	results, err := p.Process(context.Background(), []*jsonlotelforwarder.PaseResult{result})
+	require.NoError(t, err)
+	var bucketCounts [][]uint64
+	for _, dp := range results[0].Metrics.GetResourceMetrics()[0].GetScopeMetrics()[0].GetMetrics()[0].GetHistogram().GetDataPoints() {
+		bucketCounts = append(bucketCounts, dp.GetBucketCounts())
+	}
+	require.Equal(t, [][]uint64{{4, 6}, nil, {1, 1, 1}}, bucketCounts, "the data points with different buckets are not merged")
+}
@@ -2,9 +2,13 @@ package jsonlotelforwarder
 
 import (
 	"flag"
+	"fmt"
+	"io"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/mashiike/go-otlp-helper/otlp"
 )
@@ -15,7 +19,13 @@ func DefaultOptions() *Options {
 		signals = "traces,metrics,logs"
 	}
 	return &Options{
-		Signals: signals,
+		Signals:           signals,
+		ExportConcurrency: 1,
+		BufferOptions: BufferOptions{
+			MaxAge:   5 * time.Second,
+			MaxItems: 10000,
+			MaxBytes: 4 * 1024 * 1024,
+		},
 		clientOptions: []otlp.ClientOption{
 			otlp.DefaultClientOptions("FORWARDER_", "OTEL_EXPORTER_"),
 			otlp.WithUserAgent("jsonl-otel-forwarder/" + Version),
@@ -24,18 +34,425 @@ func DefaultOptions() *Options {
 }
 
 type Options struct {
-	Signals       string
-	Batch         bool
-	clientOptions []otlp.ClientOption
+	ConfigFile string
+
+	TimestampsFillMissing    bool
+	TimestampsMaxPast        string
+	TimestampsMaxFuture      string
+	TimestampsOutOfRange     string
+	TimestampsSkewCorrection bool
+
+	Validation string
+	Attributes string
+
+	FilterSpans      string
+	FilterDataPoints string
+	FilterLogs       string
+
+	SpanMetrics           bool
+	SpanMetricsDimensions string
+	LogMetrics            bool
+	LogMetricsDimensions  string
+
+	HeadSamplingTraces        string
+	HeadSamplingLogs          string
+	HeadSamplingLogsAttribute string
+	RateLimitTraces           string
+	RateLimitMetrics          string
+	RateLimitLogs             string
+	RateLimitPerService       bool
+	RateLimitAction           string
+	TailSamplingPolicies      string
+
+	MetricsRename string
+
+	Temporality          string
+	TemporalityMetrics   string
+	TemporalityStateFile string
+	TemporalityStateTTL  string
+	TemporalityMaxSeries int
+	// TemporalityStateStore overrides the state store of temporality processor, e.g. a store backed by DynamoDB.
+	TemporalityStateStore TemporalityStateStore
+
+	RedactionPatterns    string
+	RedactionAllowedKeys string
+	Signals              string
+	Batch                bool
+	MergeDataPoints      bool
+	Buffer               bool
+	BufferOptions        BufferOptions
+
+	ExportConcurrency     int
+	ExportOrderedSignals  string
+	TenantHeader          string
+	TenantHeaderAttribute string
+	TenantHeaderDefault   string
+	clientOptions         []otlp.ClientOption
+
+	FilePath        string
+	FileTracesPath  string
+	FileMetricsPath string
+	FileLogsPath    string
+	FilePretty      bool
+	FileMaxBytes    int64
+	FileMaxFiles    int
+
+	DryRun bool
+	// DryRunWriter overrides stdout that the explanations of --dry-run are written to.
+	DryRunWriter io.Writer
+
+	flagSet *flag.FlagSet
 }
 
 func (o *Options) SetFlags(fs *flag.FlagSet) {
+	o.flagSet = fs
 	o.clientOptions = append(
 		o.clientOptions,
 		otlp.ClientOptionsWithFlagSet(fs, "", "FORWARDER_", "OTEL_EXPORTER_"),
 	)
+	fs.StringVar(&o.ConfigFile, "config", o.ConfigFile, "path to processors config file in JSON ($FORWARDER_CONFIG)")
+	fs.BoolVar(&o.TimestampsFillMissing, "timestamps-fill-missing", toBool(os.Getenv("FORWARDER_TIMESTAMPS_FILL_MISSING")), "fill zero time of log records and data points with the CloudWatch Logs event timestamp ($FORWARDER_TIMESTAMPS_FILL_MISSING)")
+	fs.StringVar(&o.TimestampsMaxPast, "timestamps-max-past", o.TimestampsMaxPast, "max age of timestamps from the CloudWatch Logs event timestamp or now, e.g. 24h ($FORWARDER_TIMESTAMPS_MAX_PAST)")
+	fs.StringVar(&o.TimestampsMaxFuture, "timestamps-max-future", o.TimestampsMaxFuture, "max distance of future timestamps from the CloudWatch Logs event timestamp or now, e.g. 5m ($FORWARDER_TIMESTAMPS_MAX_FUTURE)")
+	fs.StringVar(&o.TimestampsOutOfRange, "timestamps-out-of-range", o.TimestampsOutOfRange, "action for timestamps outside --timestamps-max-past and --timestamps-max-future [drop,clamp] ($FORWARDER_TIMESTAMPS_OUT_OF_RANGE)")
+	fs.BoolVar(&o.TimestampsSkewCorrection, "timestamps-skew-correction", toBool(os.Getenv("FORWARDER_TIMESTAMPS_SKEW_CORRECTION")), "shift spans ending after the CloudWatch Logs event timestamp back by the clock skew ($FORWARDER_TIMESTAMPS_SKEW_CORRECTION)")
+	fs.StringVar(&o.Validation, "validation", o.Validation, "comma separated validation rules with policy [pass,fix,drop] of trace_id, span_id, parent_span_id, timestamps and enums, e.g. trace_id:drop,span_id:fix,timestamps:fix ($FORWARDER_VALIDATION)")
+	fs.StringVar(&o.Attributes, "attributes", o.Attributes, "comma separated attribute rules, e.g. upsert:resource:deployment.environment=prod,insert:resource+log:team=env:TEAM ($FORWARDER_ATTRIBUTES)")
+	fs.StringVar(&o.FilterSpans, "filter-spans", o.FilterSpans, "filter expression to drop spans, e.g. 'kind == SERVER and attributes[\"http.route\"] == \"/health\"' ($FORWARDER_FILTER_SPANS)")
+	fs.StringVar(&o.FilterDataPoints, "filter-datapoints", o.FilterDataPoints, "filter expression to drop metric data points, e.g. 'name glob \"system.*\"' ($FORWARDER_FILTER_DATAPOINTS)")
+	fs.StringVar(&o.FilterLogs, "filter-logs", o.FilterLogs, "filter expression to drop log records, e.g. 'severity < INFO' ($FORWARDER_FILTER_LOGS)")
+	fs.BoolVar(&o.SpanMetrics, "span-metrics", toBool(os.Getenv("FORWARDER_SPAN_METRICS")), "generate request count, error count and duration histogram metrics from spans before sampling ($FORWARDER_SPAN_METRICS)")
+	fs.StringVar(&o.SpanMetricsDimensions, "span-metrics-dimensions", o.SpanMetricsDimensions, "comma separated span attribute keys added to span metrics dimensions, e.g. http.route ($FORWARDER_SPAN_METRICS_DIMENSIONS)")
+	fs.BoolVar(&o.LogMetrics, "log-metrics", toBool(os.Getenv("FORWARDER_LOG_METRICS")), "generate log record count metrics per resource and severity from logs ($FORWARDER_LOG_METRICS)")
+	fs.StringVar(&o.LogMetricsDimensions, "log-metrics-dimensions", o.LogMetricsDimensions, "comma separated log attribute keys added to log metrics dimensions, e.g. http.route ($FORWARDER_LOG_METRICS_DIMENSIONS)")
+	fs.StringVar(&o.HeadSamplingTraces, "head-sampling-traces", o.HeadSamplingTraces, "percentage of traces to keep, decided consistently by trace ID and W3C tracestate, e.g. 10 ($FORWARDER_HEAD_SAMPLING_TRACES)")
+	fs.StringVar(&o.HeadSamplingLogs, "head-sampling-logs", o.HeadSamplingLogs, "percentage of log records to keep, decided consistently by the attribute of --head-sampling-logs-attribute or trace ID, e.g. 10 ($FORWARDER_HEAD_SAMPLING_LOGS)")
+	fs.StringVar(&o.HeadSamplingLogsAttribute, "head-sampling-logs-attribute", o.HeadSamplingLogsAttribute, "log record attribute key whose value hash decides log sampling, e.g. user.id ($FORWARDER_HEAD_SAMPLING_LOGS_ATTRIBUTE)")
+	fs.StringVar(&o.RateLimitTraces, "rate-limit-traces", o.RateLimitTraces, "max spans per second, e.g. 1000 ($FORWARDER_RATE_LIMIT_TRACES)")
+	fs.StringVar(&o.RateLimitMetrics, "rate-limit-metrics", o.RateLimitMetrics, "max metric data points per second, e.g. 1000 ($FORWARDER_RATE_LIMIT_METRICS)")
+	fs.StringVar(&o.RateLimitLogs, "rate-limit-logs", o.RateLimitLogs, "max log records per second, e.g. 1000 ($FORWARDER_RATE_LIMIT_LOGS)")
+	fs.BoolVar(&o.RateLimitPerService, "rate-limit-per-service", toBool(os.Getenv("FORWARDER_RATE_LIMIT_PER_SERVICE")), "apply the rate limits to each service.name ($FORWARDER_RATE_LIMIT_PER_SERVICE)")
+	fs.StringVar(&o.RateLimitAction, "rate-limit-action", o.RateLimitAction, "action for the items exceeding the rate limits [drop,sample] ($FORWARDER_RATE_LIMIT_ACTION)")
+	fs.StringVar(&o.TailSamplingPolicies, "tail-sampling-policies", o.TailSamplingPolicies, "comma separated tail sampling policies, traces matching any policy are kept, e.g. status_code,latency:500ms,attribute:user.tier=vip,probabilistic:10 ($FORWARDER_TAIL_SAMPLING_POLICIES)")
+	fs.StringVar(&o.MetricsRename, "metrics-rename", o.MetricsRename, "comma separated metric renames, e.g. http.server.duration=http.server.request.duration ($FORWARDER_METRICS_RENAME)")
+	fs.StringVar(&o.Temporality, "temporality", o.Temporality, "convert sums and histograms to the aggregation temporality [delta,cumulative] ($FORWARDER_TEMPORALITY)")
+	fs.StringVar(&o.TemporalityMetrics, "temporality-metrics", o.TemporalityMetrics, "comma separated glob patterns of metric names to convert temporality, all metrics when empty ($FORWARDER_TEMPORALITY_METRICS)")
+	fs.StringVar(&o.TemporalityStateFile, "temporality-state-file", o.TemporalityStateFile, "path to file that keeps the temporality state of each series across invocations, in memory when empty ($FORWARDER_TEMPORALITY_STATE_FILE)")
+	fs.StringVar(&o.TemporalityStateTTL, "temporality-state-ttl", o.TemporalityStateTTL, "duration to keep the temporality state of a series not seen, 1h when empty and never expires when 0s ($FORWARDER_TEMPORALITY_STATE_TTL)")
+	fs.IntVar(&o.TemporalityMaxSeries, "temporality-max-series", o.TemporalityMaxSeries, "max number of series to keep the temporality state, 10000 when 0 ($FORWARDER_TEMPORALITY_MAX_SERIES)")
+	fs.StringVar(&o.RedactionPatterns, "redaction-patterns", o.RedactionPatterns, "comma separated builtin patterns to mask in attribute values, log bodies and span names, with optional :hash action [email,credit_card,bearer_token,jwt,aws_access_key] ($FORWARDER_REDACTION_PATTERNS)")
+	fs.StringVar(&o.RedactionAllowedKeys, "redaction-allowed-keys", o.RedactionAllowedKeys, "comma separated span, data point and log attribute keys to keep, others are dropped ($FORWARDER_REDACTION_ALLOWED_KEYS)")
 	fs.StringVar(&o.Signals, "signals", o.Signals, "comma separated list of signals to forward [traces,metrics,logs] ($FORWARDER_SIGNALS)")
 	fs.BoolVar(&o.Batch, "batch", toBool(os.Getenv("FOWARDER_BATCH")), "batch forward to export endpoint ($FORWARDER_BATCH)")
+	fs.BoolVar(&o.MergeDataPoints, "merge-data-points", toBool(os.Getenv("FORWARDER_MERGE_DATA_POINTS")), "merge metric data points that share attributes and timestamps when batching ($FORWARDER_MERGE_DATA_POINTS)")
+	fs.BoolVar(&o.Buffer, "buffer", toBool(os.Getenv("FORWARDER_BUFFER")), "buffer parse results across inputs and forward them as batches, for long-running modes ($FORWARDER_BUFFER)")
+	fs.DurationVar(&o.BufferOptions.MaxAge, "buffer-max-age", o.BufferOptions.MaxAge, "max age of buffered results before flush ($FORWARDER_BUFFER_MAX_AGE)")
+	fs.IntVar(&o.BufferOptions.MaxItems, "buffer-max-items", o.BufferOptions.MaxItems, "max spans, data points and log records in buffer before flush ($FORWARDER_BUFFER_MAX_ITEMS)")
+	fs.IntVar(&o.BufferOptions.MaxBytes, "buffer-max-bytes", o.BufferOptions.MaxBytes, "max bytes in buffer before flush ($FORWARDER_BUFFER_MAX_BYTES)")
+	fs.IntVar(&o.ExportConcurrency, "export-concurrency", o.ExportConcurrency, "max number of concurrent uploads to export endpoint ($FORWARDER_EXPORT_CONCURRENCY)")
+	fs.StringVar(&o.TenantHeader, "tenant-header", o.TenantHeader, "OTLP header whose value is the resource attribute of --tenant-header-attribute, e.g. X-Scope-OrgID ($FORWARDER_TENANT_HEADER)")
+	fs.StringVar(&o.TenantHeaderAttribute, "tenant-header-attribute", o.TenantHeaderAttribute, "resource attribute key of the tenant header value, e.g. service.namespace ($FORWARDER_TENANT_HEADER_ATTRIBUTE)")
+	fs.StringVar(&o.TenantHeaderDefault, "tenant-header-default", o.TenantHeaderDefault, "tenant header value of resources without the attribute, the header is omitted when empty ($FORWARDER_TENANT_HEADER_DEFAULT)")
+	fs.StringVar(&o.FilePath, "file-path", o.FilePath, "write OTLP JSON lines to the file instead of the OTLP endpoint, '-' for stdout and '.gz' suffix for gzip ($FORWARDER_FILE_PATH)")
+	fs.StringVar(&o.FileTracesPath, "file-traces-path", o.FileTracesPath, "write traces as OTLP JSON lines to the file, overrides --file-path ($FORWARDER_FILE_TRACES_PATH)")
+	fs.StringVar(&o.FileMetricsPath, "file-metrics-path", o.FileMetricsPath, "write metrics as OTLP JSON lines to the file, overrides --file-path ($FORWARDER_FILE_METRICS_PATH)")
+	fs.StringVar(&o.FileLogsPath, "file-logs-path", o.FileLogsPath, "write logs as OTLP JSON lines to the file, overrides --file-path ($FORWARDER_FILE_LOGS_PATH)")
+	fs.BoolVar(&o.FilePretty, "file-pretty", toBool(os.Getenv("FORWARDER_FILE_PRETTY")), "pretty-print the OTLP JSON written to files ($FORWARDER_FILE_PRETTY)")
+	fs.Int64Var(&o.FileMaxBytes, "file-max-bytes", o.FileMaxBytes, "rotate files before they exceed the bytes, no rotation when 0 ($FORWARDER_FILE_MAX_BYTES)")
+	fs.IntVar(&o.FileMaxFiles, "file-max-files", o.FileMaxFiles, "max number of rotated files to keep, 5 when 0 ($FORWARDER_FILE_MAX_FILES)")
+	fs.BoolVar(&o.DryRun, "dry-run", toBool(os.Getenv("FORWARDER_DRY_RUN")), "run parse, processors and batching without export, and print an explanation per input as JSON lines to stdout ($FORWARDER_DRY_RUN)")
+	fs.StringVar(&o.ExportOrderedSignals, "export-ordered-signals", o.ExportOrderedSignals, "comma separated list of signals uploaded one by one in order, when export concurrency is greater than 1 [traces,metrics,logs] ($FORWARDER_EXPORT_ORDERED_SIGNALS)")
+}
+
+func (o *Options) timestampsConfig(config *TimestampsConfig) *TimestampsConfig {
+	var merged TimestampsConfig
+	if config != nil {
+		merged = *config
+	}
+	if o.TimestampsFillMissing {
+		merged.FillMissing = true
+	}
+	if o.TimestampsMaxPast != "" {
+		merged.MaxPast = o.TimestampsMaxPast
+	}
+	if o.TimestampsMaxFuture != "" {
+		merged.MaxFuture = o.TimestampsMaxFuture
+	}
+	if o.TimestampsOutOfRange != "" {
+		merged.OutOfRange = o.TimestampsOutOfRange
+	}
+	if o.TimestampsSkewCorrection {
+		merged.SkewCorrection = true
+	}
+	return &merged
+}
+
+func (o *Options) validationConfig(config *ValidationConfig) (*ValidationConfig, error) {
+	var merged ValidationConfig
+	if config != nil {
+		merged = *config
+	}
+	flags, err := ParseValidationConfig(o.Validation)
+	if err != nil {
+		return nil, err
+	}
+	policies := merged.policies()
+	for rule, policy := range flags.policies() {
+		if *policy != "" {
+			*policies[rule] = *policy
+		}
+	}
+	return &merged, nil
+}
+
+func (o *Options) filterConfig(config *FilterConfig) *FilterConfig {
+	var merged FilterConfig
+	if config != nil {
+		merged = *config
+	}
+	if o.FilterSpans != "" {
+		merged.Spans = append(slices.Clip(merged.Spans), o.FilterSpans)
+	}
+	if o.FilterDataPoints != "" {
+		merged.DataPoints = append(slices.Clip(merged.DataPoints), o.FilterDataPoints)
+	}
+	if o.FilterLogs != "" {
+		merged.Logs = append(slices.Clip(merged.Logs), o.FilterLogs)
+	}
+	return &merged
+}
+
+func (o *Options) spanMetricsConfig(config *SpanMetricsConfig) *SpanMetricsConfig {
+	var merged SpanMetricsConfig
+	if config != nil {
+		merged = *config
+	}
+	if o.SpanMetrics {
+		merged.Enabled = true
+	}
+	if o.SpanMetricsDimensions != "" {
+		merged.Dimensions = append(slices.Clip(merged.Dimensions), splitList(o.SpanMetricsDimensions)...)
+	}
+	return &merged
+}
+
+func (o *Options) logMetricsConfig(config *LogMetricsConfig) *LogMetricsConfig {
+	var merged LogMetricsConfig
+	if config != nil {
+		merged = *config
+	}
+	if o.LogMetrics {
+		merged.Enabled = true
+	}
+	if o.LogMetricsDimensions != "" {
+		merged.Dimensions = append(slices.Clip(merged.Dimensions), splitList(o.LogMetricsDimensions)...)
+	}
+	return &merged
+}
+
+func (o *Options) headSamplingConfig(config *HeadSamplingConfig) (*HeadSamplingConfig, error) {
+	var merged HeadSamplingConfig
+	if config != nil {
+		merged = *config
+	}
+	if o.HeadSamplingTraces != "" {
+		percentage, err := strconv.ParseFloat(o.HeadSamplingTraces, 64)
+		if err != nil {
+			return nil, fmt.Errorf("traces percentage: %w", err)
+		}
+		merged.Traces = &HeadSamplingSignalConfig{Percentage: percentage}
+	}
+	if o.HeadSamplingLogs != "" {
+		percentage, err := strconv.ParseFloat(o.HeadSamplingLogs, 64)
+		if err != nil {
+			return nil, fmt.Errorf("logs percentage: %w", err)
+		}
+		merged.Logs = &HeadSamplingSignalConfig{Percentage: percentage}
+	}
+	if o.HeadSamplingLogsAttribute != "" && merged.Logs != nil {
+		logs := *merged.Logs
+		logs.Attribute = o.HeadSamplingLogsAttribute
+		merged.Logs = &logs
+	}
+	return &merged, nil
+}
+
+func (o *Options) rateLimitConfig(config *RateLimitConfig) (*RateLimitConfig, error) {
+	var merged RateLimitConfig
+	if config != nil {
+		merged = *config
+	}
+	signals := []struct {
+		name   string
+		value  string
+		target **RateLimitSignalConfig
+	}{
+		{"traces", o.RateLimitTraces, &merged.Traces},
+		{"metrics", o.RateLimitMetrics, &merged.Metrics},
+		{"logs", o.RateLimitLogs, &merged.Logs},
+	}
+	for _, signal := range signals {
+		if signal.value != "" {
+			rate, err := strconv.ParseFloat(signal.value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s rate: %w", signal.name, err)
+			}
+			*signal.target = &RateLimitSignalConfig{Rate: rate}
+		}
+		if *signal.target == nil || (!o.RateLimitPerService && o.RateLimitAction == "") {
+			continue
+		}
+		c := **signal.target
+		if o.RateLimitPerService {
+			c.PerService = true
+		}
+		if o.RateLimitAction != "" {
+			c.Action = o.RateLimitAction
+		}
+		*signal.target = &c
+	}
+	return &merged, nil
+}
+
+func (o *Options) tailSamplingConfig(config *TailSamplingConfig) (*TailSamplingConfig, error) {
+	var merged TailSamplingConfig
+	if config != nil {
+		merged = *config
+	}
+	policies, err := ParseTailSamplingPolicies(o.TailSamplingPolicies)
+	if err != nil {
+		return nil, err
+	}
+	merged.Policies = append(slices.Clip(merged.Policies), policies...)
+	return &merged, nil
+}
+
+func (o *Options) temporalityConfig(config *TemporalityConfig) *TemporalityConfig {
+	var merged TemporalityConfig
+	if config != nil {
+		merged = *config
+	}
+	if o.Temporality != "" {
+		merged.To = o.Temporality
+	}
+	if o.TemporalityMetrics != "" {
+		merged.Metrics = append(slices.Clip(merged.Metrics), splitList(o.TemporalityMetrics)...)
+	}
+	if o.TemporalityStateFile != "" {
+		merged.StateFile = o.TemporalityStateFile
+	}
+	if o.TemporalityStateTTL != "" {
+		merged.StateTTL = o.TemporalityStateTTL
+	}
+	if o.TemporalityMaxSeries != 0 {
+		merged.MaxSeries = o.TemporalityMaxSeries
+	}
+	return &merged
+}
+
+func (o *Options) redactionConfig(config *RedactionConfig) (*RedactionConfig, error) {
+	var merged RedactionConfig
+	if config != nil {
+		merged = *config
+	}
+	values, err := ParseRedactionPatterns(o.RedactionPatterns)
+	if err != nil {
+		return nil, err
+	}
+	merged.BlockedValues = append(slices.Clip(merged.BlockedValues), values...)
+	if o.RedactionAllowedKeys != "" {
+		merged.AllowedKeys = append(slices.Clip(merged.AllowedKeys), splitList(o.RedactionAllowedKeys)...)
+	}
+	return &merged, nil
+}
+
+// otlpSignalHeaders returns the headers of --otlp-traces-headers, --otlp-metrics-headers and --otlp-logs-headers by signal.
+// the OTLP client options can not be read back, so the tenant clients rebuild the signal headers from the flags and environment variables.
+func (o *Options) otlpSignalHeaders() (map[string]map[string]string, error) {
+	headers := make(map[string]map[string]string, 3)
+	for _, signal := range []string{"traces", "metrics", "logs"} {
+		var value string
+		for _, prefix := range []string{"FORWARDER_", "OTEL_EXPORTER_"} {
+			if v, ok := os.LookupEnv(prefix + "OTLP_" + strings.ToUpper(signal) + "_HEADERS"); ok {
+				value = v
+				break
+			}
+		}
+		if o.flagSet != nil {
+			if f := o.flagSet.Lookup("otlp-" + signal + "-headers"); f != nil && f.Value.String() != "" {
+				value = f.Value.String()
+			}
+		}
+		if value == "" {
+			continue
+		}
+		h := make(map[string]string)
+		for _, part := range strings.Split(value, ",") {
+			key, v, ok := strings.Cut(part, "=")
+			if !ok {
+				return nil, fmt.Errorf("%s headers: header %q is invalid", signal, part)
+			}
+			h[key] = v
+		}
+		headers[signal] = h
+	}
+	return headers, nil
+}
+
+func (o *Options) tenantHeaderConfig() *TenantHeaderConfig {
+	return &TenantHeaderConfig{
+		Header:    o.TenantHeader,
+		Attribute: o.TenantHeaderAttribute,
+		Default:   o.TenantHeaderDefault,
+	}
+}
+
+// fileExporterPaths returns the signals written to each file path, in order of traces, metrics and logs.
+func (o *Options) fileExporterPaths() ([]string, map[string][]string) {
+	var (
+		paths    []string
+		byPath   = make(map[string][]string)
+		signals  = o.SignalsList()
+		override = map[string]string{"traces": o.FileTracesPath, "metrics": o.FileMetricsPath, "logs": o.FileLogsPath}
+	)
+	for _, signal := range []string{"traces", "metrics", "logs"} {
+		if !containsSignal(signals, signal) {
+			continue
+		}
+		path := o.FilePath
+		if override[signal] != "" {
+			path = override[signal]
+		}
+		if path == "" {
+			continue
+		}
+		if _, ok := byPath[path]; !ok {
+			paths = append(paths, path)
+		}
+		byPath[path] = append(byPath[path], signal)
+	}
+	return paths, byPath
+}
+
+func (o *Options) fileExporterConfig(path string) *FileExporterConfig {
+	return &FileExporterConfig{
+		Path:     path,
+		Pretty:   o.FilePretty,
+		MaxBytes: o.FileMaxBytes,
+		MaxFiles: o.FileMaxFiles,
+	}
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, elem := range strings.Split(s, ",") {
+		elem = strings.TrimSpace(elem)
+		if elem == "" {
+			continue
+		}
+		list = append(list, elem)
+	}
+	return list
 }
 
 func toBool(s string) bool {
@@ -50,6 +467,18 @@ func (o *Options) SignalsList() []string {
 	return strings.Split(o.Signals, ",")
 }
 
+func (o *Options) OrderedSignal(signal string) bool {
+	if o.ExportOrderedSignals == "" {
+		return false
+	}
+	for _, s := range strings.Split(o.ExportOrderedSignals, ",") {
+		if strings.EqualFold(strings.TrimSuffix(strings.TrimSpace(s), "s"), strings.TrimSuffix(signal, "s")) {
+			return true
+		}
+	}
+	return false
+}
+
 func (o *Options) EnableTraces() bool {
 	for _, signal := range o.SignalsList() {
 		if strings.EqualFold(signal, "traces") {
@@ -89,5 +518,52 @@ func (o *Options) EnableLogs() bool {
 func (o *Options) Validate() error {
 	var err error
 	_, err = otlp.NewClient("http://localhost:4317", o.clientOptions...)
-	return err
+	if err != nil {
+		return err
+	}
+	if tenantHeader := o.tenantHeaderConfig(); tenantHeader.Enabled() {
+		if err := tenantHeader.validate(); err != nil {
+			return fmt.Errorf("tenant header: %w", err)
+		}
+	}
+	paths, _ := o.fileExporterPaths()
+	for _, path := range paths {
+		if err := o.fileExporterConfig(path).validate(); err != nil {
+			return fmt.Errorf("file %s: %w", path, err)
+		}
+	}
+	if _, err := NewTimestampsProcessor(o.timestampsConfig(nil), nil); err != nil {
+		return fmt.Errorf("timestamps: %w", err)
+	}
+	if validation, err := ParseValidationConfig(o.Validation); err != nil {
+		return fmt.Errorf("validation: %w", err)
+	} else if _, err := NewValidationProcessor(validation, nil); err != nil {
+		return fmt.Errorf("validation: %w", err)
+	}
+	if _, err := ParseAttributeRules(o.Attributes); err != nil {
+		return fmt.Errorf("attributes: %w", err)
+	}
+	if _, err := o.headSamplingConfig(nil); err != nil {
+		return fmt.Errorf("head sampling: %w", err)
+	}
+	if rateLimit, err := o.rateLimitConfig(nil); err != nil {
+		return fmt.Errorf("rate limit: %w", err)
+	} else if _, err := NewRateLimitProcessor(rateLimit, nil, nil); err != nil {
+		return fmt.Errorf("rate limit: %w", err)
+	}
+	if _, err := ParseTailSamplingPolicies(o.TailSamplingPolicies); err != nil {
+		return fmt.Errorf("tail sampling policies: %w", err)
+	}
+	if _, err := ParseMetricsRename(o.MetricsRename); err != nil {
+		return fmt.Errorf("metrics rename: %w", err)
+	}
+	switch strings.ToLower(o.Temporality) {
+	case "", "delta", "cumulative":
+	default:
+		return fmt.Errorf("temporality: unknown temporality %q, expected delta or cumulative", o.Temporality)
+	}
+	if _, err := ParseRedactionPatterns(o.RedactionPatterns); err != nil {
+		return fmt.Errorf("redaction patterns: %w", err)
+	}
+	return nil
 }
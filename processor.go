@@ -0,0 +1,187 @@
+package jsonlotelforwarder
+
+import (
+	"context"
+	"fmt"
+	"slices"
+)
+
+// Processor transforms parse results between Parse and export.
+// a processor may modify results in place, drop them or append new results.
+type Processor interface {
+	Process(ctx context.Context, results []*PaseResult) ([]*PaseResult, error)
+}
+
+type ProcessorFunc func(ctx context.Context, results []*PaseResult) ([]*PaseResult, error)
+
+func (f ProcessorFunc) Process(ctx context.Context, results []*PaseResult) ([]*PaseResult, error) {
+	return f(ctx, results)
+}
+
+type namedProcessor struct {
+	name string
+	Processor
+}
+
+func newProcessors(options *Options, config *Config, exporters []*namedExporter, selfMetrics *SelfMetrics) ([]namedProcessor, error) {
+	var processors []namedProcessor
+	timestamps := options.timestampsConfig(config.Timestamps)
+	if timestamps.Enabled() {
+		p, err := NewTimestampsProcessor(timestamps, selfMetrics)
+		if err != nil {
+			return nil, fmt.Errorf("timestamps processor: %w", err)
+		}
+		processors = append(processors, namedProcessor{name: "timestamps", Processor: p})
+	}
+	validation, err := options.validationConfig(config.Validation)
+	if err != nil {
+		return nil, fmt.Errorf("validation: %w", err)
+	}
+	if validation.Enabled() {
+		p, err := NewValidationProcessor(validation, selfMetrics)
+		if err != nil {
+			return nil, fmt.Errorf("validation processor: %w", err)
+		}
+		processors = append(processors, namedProcessor{name: "validation", Processor: p})
+	}
+	attributeRules, err := ParseAttributeRules(options.Attributes)
+	if err != nil {
+		return nil, fmt.Errorf("parse attributes: %w", err)
+	}
+	attributeRules = append(config.Attributes, attributeRules...)
+	if len(attributeRules) > 0 {
+		p, err := NewAttributesProcessor(attributeRules)
+		if err != nil {
+			return nil, fmt.Errorf("attributes processor: %w", err)
+		}
+		processors = append(processors, namedProcessor{name: "attributes", Processor: p})
+	}
+	filter := options.filterConfig(config.Filter)
+	if filter.Enabled() {
+		p, err := NewFilterProcessor(filter, selfMetrics)
+		if err != nil {
+			return nil, fmt.Errorf("filter processor: %w", err)
+		}
+		processors = append(processors, namedProcessor{name: "filter", Processor: p})
+	}
+	// redaction runs before the connectors, so that the generated metrics are built from the redacted telemetry,
+	// and their attributes such as span.name are not removed by the allowed keys.
+	redaction, err := options.redactionConfig(config.Redaction)
+	if err != nil {
+		return nil, fmt.Errorf("redaction: %w", err)
+	}
+	if redaction.Enabled() {
+		p, err := NewRedactionProcessor(redaction, selfMetrics)
+		if err != nil {
+			return nil, fmt.Errorf("redaction processor: %w", err)
+		}
+		processors = append(processors, namedProcessor{name: "redaction", Processor: p})
+	}
+	spanMetrics := options.spanMetricsConfig(config.SpanMetrics)
+	if spanMetrics.Enabled {
+		p, err := NewSpanMetricsConnector(spanMetrics, selfMetrics)
+		if err != nil {
+			return nil, fmt.Errorf("span metrics connector: %w", err)
+		}
+		processors = append(processors, namedProcessor{name: "span_metrics", Processor: p})
+	}
+	logMetrics := options.logMetricsConfig(config.LogMetrics)
+	if logMetrics.Enabled {
+		p, err := NewLogMetricsConnector(logMetrics, selfMetrics)
+		if err != nil {
+			return nil, fmt.Errorf("log metrics connector: %w", err)
+		}
+		processors = append(processors, namedProcessor{name: "log_metrics", Processor: p})
+	}
+	headSampling, err := options.headSamplingConfig(config.HeadSampling)
+	if err != nil {
+		return nil, fmt.Errorf("head sampling: %w", err)
+	}
+	if headSampling.Enabled() {
+		p, err := NewHeadSamplingProcessor(headSampling, selfMetrics)
+		if err != nil {
+			return nil, fmt.Errorf("head sampling processor: %w", err)
+		}
+		processors = append(processors, namedProcessor{name: "head_sampling", Processor: p})
+	}
+	tailSampling, err := options.tailSamplingConfig(config.TailSampling)
+	if err != nil {
+		return nil, fmt.Errorf("tail sampling: %w", err)
+	}
+	if tailSampling.Enabled() {
+		p, err := NewTailSamplingProcessor(tailSampling, selfMetrics)
+		if err != nil {
+			return nil, fmt.Errorf("tail sampling processor: %w", err)
+		}
+		processors = append(processors, namedProcessor{name: "tail_sampling", Processor: p})
+	}
+	metricsRenames, err := ParseMetricsRename(options.MetricsRename)
+	if err != nil {
+		return nil, fmt.Errorf("parse metrics rename: %w", err)
+	}
+	metricsTransformRules := append(slices.Clip(config.MetricsTransform), metricsRenames...)
+	if len(metricsTransformRules) > 0 {
+		p, err := NewMetricsTransformProcessor(metricsTransformRules, selfMetrics)
+		if err != nil {
+			return nil, fmt.Errorf("metrics transform processor: %w", err)
+		}
+		processors = append(processors, namedProcessor{name: "metrics_transform", Processor: p})
+	}
+	temporality := options.temporalityConfig(config.Temporality)
+	if temporality.Enabled() {
+		store := options.TemporalityStateStore
+		if store == nil && temporality.StateFile != "" {
+			store = NewFileTemporalityStateStore(temporality.StateFile)
+		}
+		if options.DryRun && store != nil {
+			store = newDryRunTemporalityStateStore(store)
+		}
+		p, err := NewTemporalityProcessor(temporality, store, selfMetrics)
+		if err != nil {
+			return nil, fmt.Errorf("temporality processor: %w", err)
+		}
+		processors = append(processors, namedProcessor{name: "temporality", Processor: p})
+	}
+	names := make([]string, 0, len(exporters))
+	for _, e := range exporters {
+		names = append(names, e.name)
+	}
+	if config.Routing.Enabled() {
+		p, err := NewRoutingProcessor(config.Routing, names, selfMetrics)
+		if err != nil {
+			return nil, fmt.Errorf("routing processor: %w", err)
+		}
+		processors = append(processors, namedProcessor{name: "routing", Processor: p})
+	}
+	// rate limit runs after routing, so that the items exceeding the limit are routed to the dead letter exporters.
+	rateLimit, err := options.rateLimitConfig(config.RateLimit)
+	if err != nil {
+		return nil, fmt.Errorf("rate limit: %w", err)
+	}
+	if rateLimit.Enabled() {
+		p, err := NewRateLimitProcessor(rateLimit, names, selfMetrics)
+		if err != nil {
+			return nil, fmt.Errorf("rate limit processor: %w", err)
+		}
+		processors = append(processors, namedProcessor{name: "rate_limit", Processor: p})
+	}
+	return processors, nil
+}
+
+func (f *Forwarder) process(ctx context.Context, results []*PaseResult) ([]*PaseResult, error) {
+	var err error
+	for _, p := range f.processors {
+		results, err = p.Process(ctx, results)
+		if err != nil {
+			return nil, fmt.Errorf("%s processor: %w", p.name, err)
+		}
+	}
+	filtered := results[:0]
+	for _, result := range results {
+		if result.Skip() {
+			continue
+		}
+		filtered = append(filtered, result)
+	}
+	return filtered, nil
+}
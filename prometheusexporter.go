@@ -0,0 +1,512 @@
+package jsonlotelforwarder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// PrometheusRemoteWriteExporterConfig is the configuration of the exporter that sends metrics with Prometheus remote write 1.0.
+type PrometheusRemoteWriteExporterConfig struct {
+	Endpoint     string
+	Headers      map[string]string
+	Timeout      time.Duration
+	TenantHeader *TenantHeaderConfig
+	// HTTPClient overrides the HTTP client, Timeout is ignored when set.
+	HTTPClient *http.Client
+}
+
+// PrometheusRemoteWriteExporter converts metrics to Prometheus time series, and sends them to the remote write endpoint.
+// traces and logs are ignored. delta sums and histograms are dropped, use the temporality processor to convert them to cumulative.
+type PrometheusRemoteWriteExporter struct {
+	config  PrometheusRemoteWriteExporterConfig
+	client  *http.Client
+	tenants tenantExports
+}
+
+func NewPrometheusRemoteWriteExporter(config *PrometheusRemoteWriteExporterConfig) (*PrometheusRemoteWriteExporter, error) {
+	if config.Endpoint == "" {
+		return nil, fmt.Errorf("endpoint is required")
+	}
+	if config.TenantHeader.Enabled() {
+		if err := config.TenantHeader.validate(); err != nil {
+			return nil, fmt.Errorf("tenant_header: %w", err)
+		}
+	}
+	return &PrometheusRemoteWriteExporter{config: *config, client: newHTTPExportClient(config.HTTPClient, config.Timeout)}, nil
+}
+
+func (e *PrometheusRemoteWriteExporter) Start(_ context.Context) error {
+	return nil
+}
+
+func (e *PrometheusRemoteWriteExporter) Stop(_ context.Context) error {
+	return nil
+}
+
+func (e *PrometheusRemoteWriteExporter) Export(ctx context.Context, result *PaseResult) error {
+	if result.Metrics == nil {
+		return nil
+	}
+	if !e.config.TenantHeader.Enabled() {
+		return e.send(ctx, "", result.Metrics)
+	}
+	tenants, byTenant := splitByResource(&PaseResult{Metrics: result.Metrics}, e.config.TenantHeader.tenantOf)
+	return e.tenants.export(result, tenants, func(tenant string) error {
+		return e.send(ctx, tenant, byTenant[tenant].Metrics)
+	})
+}
+
+func (e *PrometheusRemoteWriteExporter) send(ctx context.Context, tenant string, metrics *metricspb.MetricsData) error {
+	series := ToPrometheusTimeSeries(metrics)
+	if len(series) == 0 {
+		slog.DebugContext(ctx, "no time series to remote write")
+		return nil
+	}
+	body := snappy.Encode(nil, MarshalPrometheusWriteRequest(series))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.config.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return &permanentError{err: fmt.Errorf("create remote write request: %w", err)}
+	}
+	for key, value := range e.config.Headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("User-Agent", "jsonl-otel-forwarder/"+Version)
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if tenant != "" {
+		req.Header.Set(e.config.TenantHeader.Header, tenant)
+	}
+	slog.InfoContext(ctx, "remote write metrics", "time_series", len(series), "bytes", len(body), "tenant", tenant)
+	if err := doHTTPExport(e.client, req); err != nil {
+		return fmt.Errorf("remote write: %w", err)
+	}
+	return nil
+}
+
+func newNamedPrometheusRemoteWriteExporter(c *ExporterConfig) (*namedExporter, error) {
+	if c.Endpoint == "" {
+		return nil, fmt.Errorf("endpoint is required")
+	}
+	for _, signal := range c.Signals {
+		if !containsSignal([]string{"metrics"}, signal) {
+			return nil, fmt.Errorf("unsupported signal %q, only metrics are sent with remote write", signal)
+		}
+	}
+	timeout, err := parseExporterTimeout(c)
+	if err != nil {
+		return nil, err
+	}
+	config := &PrometheusRemoteWriteExporterConfig{
+		Endpoint:     os.ExpandEnv(c.Endpoint),
+		Headers:      expandHeaders(c.Headers),
+		Timeout:      timeout,
+		TenantHeader: c.TenantHeader,
+	}
+	if _, err := NewPrometheusRemoteWriteExporter(config); err != nil {
+		return nil, err
+	}
+	retry, err := newRetryPolicy(c.Retry)
+	if err != nil {
+		return nil, fmt.Errorf("retry: %w", err)
+	}
+	return &namedExporter{
+		name:    c.Name,
+		signals: []string{"metrics"},
+		retry:   retry,
+		new: func() (Exporter, error) {
+			return NewPrometheusRemoteWriteExporter(config)
+		},
+	}, nil
+}
+
+// PrometheusTimeSeries is a time series of the remote write request. Labels are sorted by name.
+type PrometheusTimeSeries struct {
+	Labels     []PrometheusLabel
+	Samples    []PrometheusSample
+	Histograms []PrometheusHistogram
+}
+
+type PrometheusLabel struct {
+	Name  string
+	Value string
+}
+
+type PrometheusSample struct {
+	Value     float64
+	Timestamp int64
+}
+
+// PrometheusHistogram is a native histogram with integer counts.
+// Spans and Deltas are the populated buckets, and the deltas between their counts.
+type PrometheusHistogram struct {
+	Count          uint64
+	Sum            float64
+	Schema         int32
+	ZeroThreshold  float64
+	ZeroCount      uint64
+	NegativeSpans  []PrometheusBucketSpan
+	NegativeDeltas []int64
+	PositiveSpans  []PrometheusBucketSpan
+	PositiveDeltas []int64
+	Timestamp      int64
+}
+
+// PrometheusBucketSpan is a run of buckets. Offset is the gap to the previous span, or the index of the first bucket.
+type PrometheusBucketSpan struct {
+	Offset int32
+	Length uint32
+}
+
+// prometheusStaleNaN marks the data points without recorded value, as Prometheus marks stale series.
+var prometheusStaleNaN = math.Float64frombits(0x7ff0000000000002)
+
+const (
+	prometheusMinSchema = -4
+	prometheusMaxSchema = 8
+)
+
+// ToPrometheusTimeSeries converts metrics to Prometheus time series in the order of data points.
+// the labels are the sanitized resource and data point attributes, with job and instance from the service attributes.
+// histograms are converted to `_bucket`, `_sum` and `_count` series, summaries to quantiles, `_sum` and `_count`,
+// exponential histograms to native histograms, and `_total` is appended to the names of monotonic sums.
+func ToPrometheusTimeSeries(metrics *metricspb.MetricsData) []*PrometheusTimeSeries {
+	var series []*PrometheusTimeSeries
+	for _, resourceMetrics := range metrics.GetResourceMetrics() {
+		resourceLabels := prometheusResourceLabels(resourceMetrics.GetResource())
+		for _, scopeMetrics := range resourceMetrics.GetScopeMetrics() {
+			for _, metric := range scopeMetrics.GetMetrics() {
+				series = append(series, metricToPrometheusTimeSeries(resourceLabels, metric)...)
+			}
+		}
+	}
+	return series
+}
+
+func metricToPrometheusTimeSeries(resourceLabels map[string]string, metric *metricspb.Metric) []*PrometheusTimeSeries {
+	var (
+		series []*PrometheusTimeSeries
+		name   = sanitizePrometheusMetricName(metric.GetName())
+	)
+	sample := func(name string, attrs []*commonpb.KeyValue, flags uint32, value float64, timeUnixNano uint64, extra ...string) {
+		if flags&uint32(metricspb.DataPointFlags_DATA_POINT_FLAGS_NO_RECORDED_VALUE_MASK) != 0 {
+			value = prometheusStaleNaN
+		}
+		series = append(series, &PrometheusTimeSeries{
+			Labels:  prometheusLabels(resourceLabels, attrs, append([]string{"__name__", name}, extra...)...),
+			Samples: []PrometheusSample{{Value: value, Timestamp: int64(timeUnixNano / uint64(time.Millisecond))}},
+		})
+	}
+	switch data := metric.GetData().(type) {
+	case *metricspb.Metric_Gauge:
+		for _, dp := range data.Gauge.GetDataPoints() {
+			sample(name, dp.GetAttributes(), dp.GetFlags(), numberDataPointValue(dp), dp.GetTimeUnixNano())
+		}
+	case *metricspb.Metric_Sum:
+		if data.Sum.GetAggregationTemporality() == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA {
+			slog.Warn("drop delta sum for remote write", "metric", metric.GetName())
+			return nil
+		}
+		if data.Sum.GetIsMonotonic() && !strings.HasSuffix(name, "_total") {
+			name += "_total"
+		}
+		for _, dp := range data.Sum.GetDataPoints() {
+			sample(name, dp.GetAttributes(), dp.GetFlags(), numberDataPointValue(dp), dp.GetTimeUnixNano())
+		}
+	case *metricspb.Metric_Histogram:
+		if data.Histogram.GetAggregationTemporality() == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA {
+			slog.Warn("drop delta histogram for remote write", "metric", metric.GetName())
+			return nil
+		}
+		for _, dp := range data.Histogram.GetDataPoints() {
+			var cumulative uint64
+			for i, bound := range dp.GetExplicitBounds() {
+				if i < len(dp.GetBucketCounts()) {
+					cumulative += dp.GetBucketCounts()[i]
+				}
+				sample(name+"_bucket", dp.GetAttributes(), dp.GetFlags(), float64(cumulative), dp.GetTimeUnixNano(), "le", formatPrometheusFloat(bound))
+			}
+			sample(name+"_bucket", dp.GetAttributes(), dp.GetFlags(), float64(dp.GetCount()), dp.GetTimeUnixNano(), "le", "+Inf")
+			if dp.Sum != nil {
+				sample(name+"_sum", dp.GetAttributes(), dp.GetFlags(), dp.GetSum(), dp.GetTimeUnixNano())
+			}
+			sample(name+"_count", dp.GetAttributes(), dp.GetFlags(), float64(dp.GetCount()), dp.GetTimeUnixNano())
+		}
+	case *metricspb.Metric_Summary:
+		for _, dp := range data.Summary.GetDataPoints() {
+			for _, quantile := range dp.GetQuantileValues() {
+				sample(name, dp.GetAttributes(), dp.GetFlags(), quantile.GetValue(), dp.GetTimeUnixNano(), "quantile", formatPrometheusFloat(quantile.GetQuantile()))
+			}
+			sample(name+"_sum", dp.GetAttributes(), dp.GetFlags(), dp.GetSum(), dp.GetTimeUnixNano())
+			sample(name+"_count", dp.GetAttributes(), dp.GetFlags(), float64(dp.GetCount()), dp.GetTimeUnixNano())
+		}
+	case *metricspb.Metric_ExponentialHistogram:
+		if data.ExponentialHistogram.GetAggregationTemporality() == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA {
+			slog.Warn("drop delta exponential histogram for remote write", "metric", metric.GetName())
+			return nil
+		}
+		for _, dp := range data.ExponentialHistogram.GetDataPoints() {
+			histogram, ok := toPrometheusNativeHistogram(dp)
+			if !ok {
+				slog.Warn("drop exponential histogram with too small scale for remote write", "metric", metric.GetName(), "scale", dp.GetScale())
+				continue
+			}
+			series = append(series, &PrometheusTimeSeries{
+				Labels:     prometheusLabels(resourceLabels, dp.GetAttributes(), "__name__", name),
+				Histograms: []PrometheusHistogram{histogram},
+			})
+		}
+	}
+	return series
+}
+
+// toPrometheusNativeHistogram converts the exponential histogram, whose scale is the schema of native histograms.
+// the buckets of scales greater than 8 are merged, and scales less than -4 are not supported.
+func toPrometheusNativeHistogram(dp *metricspb.ExponentialHistogramDataPoint) (PrometheusHistogram, bool) {
+	scale := dp.GetScale()
+	if scale < prometheusMinSchema {
+		return PrometheusHistogram{}, false
+	}
+	var shift int32
+	if scale > prometheusMaxSchema {
+		shift = scale - prometheusMaxSchema
+	}
+	h := PrometheusHistogram{
+		Count:         dp.GetCount(),
+		Sum:           dp.GetSum(),
+		Schema:        scale - shift,
+		ZeroThreshold: dp.GetZeroThreshold(),
+		ZeroCount:     dp.GetZeroCount(),
+		Timestamp:     int64(dp.GetTimeUnixNano() / uint64(time.Millisecond)),
+	}
+	if dp.GetFlags()&uint32(metricspb.DataPointFlags_DATA_POINT_FLAGS_NO_RECORDED_VALUE_MASK) != 0 {
+		h.Sum = prometheusStaleNaN
+	}
+	h.PositiveSpans, h.PositiveDeltas = toPrometheusBuckets(dp.GetPositive(), shift)
+	h.NegativeSpans, h.NegativeDeltas = toPrometheusBuckets(dp.GetNegative(), shift)
+	return h, true
+}
+
+// toPrometheusBuckets returns the spans and deltas of the populated buckets.
+// the OTLP bucket index i is (base^i, base^(i+1)], which is the Prometheus bucket index i+1.
+func toPrometheusBuckets(buckets *metricspb.ExponentialHistogramDataPoint_Buckets, shift int32) ([]PrometheusBucketSpan, []int64) {
+	if len(buckets.GetBucketCounts()) == 0 {
+		return nil, nil
+	}
+	var (
+		offset = buckets.GetOffset() >> shift
+		counts []uint64
+	)
+	for i, count := range buckets.GetBucketCounts() {
+		index := int(((buckets.GetOffset() + int32(i)) >> shift) - offset)
+		for len(counts) <= index {
+			counts = append(counts, 0)
+		}
+		counts[index] += count
+	}
+	var (
+		spans    []PrometheusBucketSpan
+		deltas   []int64
+		previous int64
+		next     = offset + 1
+	)
+	for i, count := range counts {
+		if count == 0 {
+			continue
+		}
+		index := offset + 1 + int32(i)
+		if len(spans) == 0 || index != next {
+			gap := index - next
+			if len(spans) == 0 {
+				gap = index
+			}
+			spans = append(spans, PrometheusBucketSpan{Offset: gap})
+		}
+		spans[len(spans)-1].Length++
+		deltas = append(deltas, int64(count)-previous)
+		previous = int64(count)
+		next = index + 1
+	}
+	return spans, deltas
+}
+
+func prometheusResourceLabels(resource *resourcepb.Resource) map[string]string {
+	labels := make(map[string]string)
+	addPrometheusLabels(labels, resource.GetAttributes())
+	attrs := resource.GetAttributes()
+	if serviceName, ok := lookupAttribute(attrs, "service.name"); ok {
+		if namespace, ok := lookupAttribute(attrs, "service.namespace"); ok && namespace != "" {
+			serviceName = namespace + "/" + serviceName
+		}
+		labels["job"] = serviceName
+	}
+	if instance, ok := lookupAttribute(attrs, "service.instance.id"); ok {
+		labels["instance"] = instance
+	}
+	return labels
+}
+
+// prometheusLabels returns the sorted labels. data point attributes override resource attributes, and the extra name value pairs override both.
+func prometheusLabels(resourceLabels map[string]string, attrs []*commonpb.KeyValue, extra ...string) []PrometheusLabel {
+	merged := make(map[string]string, len(resourceLabels)+len(attrs)+len(extra)/2)
+	for name, value := range resourceLabels {
+		merged[name] = value
+	}
+	pointLabels := make(map[string]string, len(attrs))
+	addPrometheusLabels(pointLabels, attrs)
+	for name, value := range pointLabels {
+		merged[name] = value
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		merged[extra[i]] = extra[i+1]
+	}
+	labels := make([]PrometheusLabel, 0, len(merged))
+	for name, value := range merged {
+		if value == "" {
+			continue
+		}
+		labels = append(labels, PrometheusLabel{Name: name, Value: value})
+	}
+	slices.SortFunc(labels, func(a, b PrometheusLabel) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return labels
+}
+
+// addPrometheusLabels adds the attributes as labels. the values of attributes sanitized to the same name are joined with `;`.
+func addPrometheusLabels(labels map[string]string, attrs []*commonpb.KeyValue) {
+	for _, attr := range attrs {
+		name := sanitizePrometheusLabelName(attr.GetKey())
+		value := AnyValueString(attr.GetValue())
+		if existing, ok := labels[name]; ok {
+			value = existing + ";" + value
+		}
+		labels[name] = value
+	}
+}
+
+func sanitizePrometheusMetricName(name string) string {
+	return sanitizePrometheusName(name, func(r rune) bool { return r == ':' })
+}
+
+func sanitizePrometheusLabelName(name string) string {
+	return sanitizePrometheusName(name, func(rune) bool { return false })
+}
+
+// sanitizePrometheusName replaces the characters other than [a-zA-Z0-9_] with `_`, and prefixes `_` to the name starting with a digit.
+func sanitizePrometheusName(name string, allowed func(rune) bool) string {
+	var b strings.Builder
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_', allowed(r):
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+func formatPrometheusFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// MarshalPrometheusWriteRequest encodes the time series as the protobuf WriteRequest of remote write 1.0, before snappy compression.
+func MarshalPrometheusWriteRequest(series []*PrometheusTimeSeries) []byte {
+	var b []byte
+	for _, s := range series {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, marshalPrometheusTimeSeries(s))
+	}
+	return b
+}
+
+func marshalPrometheusTimeSeries(s *PrometheusTimeSeries) []byte {
+	var b []byte
+	for _, label := range s.Labels {
+		var l []byte
+		l = protowire.AppendTag(l, 1, protowire.BytesType)
+		l = protowire.AppendString(l, label.Name)
+		l = protowire.AppendTag(l, 2, protowire.BytesType)
+		l = protowire.AppendString(l, label.Value)
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, l)
+	}
+	for _, sample := range s.Samples {
+		var m []byte
+		m = protowire.AppendTag(m, 1, protowire.Fixed64Type)
+		m = protowire.AppendFixed64(m, math.Float64bits(sample.Value))
+		m = protowire.AppendTag(m, 2, protowire.VarintType)
+		m = protowire.AppendVarint(m, uint64(sample.Timestamp))
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, m)
+	}
+	for _, h := range s.Histograms {
+		b = protowire.AppendTag(b, 4, protowire.BytesType)
+		b = protowire.AppendBytes(b, marshalPrometheusHistogram(&h))
+	}
+	return b
+}
+
+func marshalPrometheusHistogram(h *PrometheusHistogram) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, h.Count)
+	b = protowire.AppendTag(b, 3, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, math.Float64bits(h.Sum))
+	b = protowire.AppendTag(b, 4, protowire.VarintType)
+	b = protowire.AppendVarint(b, protowire.EncodeZigZag(int64(h.Schema)))
+	b = protowire.AppendTag(b, 5, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, math.Float64bits(h.ZeroThreshold))
+	b = protowire.AppendTag(b, 6, protowire.VarintType)
+	b = protowire.AppendVarint(b, h.ZeroCount)
+	b = appendPrometheusBuckets(b, 8, 9, h.NegativeSpans, h.NegativeDeltas)
+	b = appendPrometheusBuckets(b, 11, 12, h.PositiveSpans, h.PositiveDeltas)
+	b = protowire.AppendTag(b, 15, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(h.Timestamp))
+	return b
+}
+
+func appendPrometheusBuckets(b []byte, spansField, deltasField protowire.Number, spans []PrometheusBucketSpan, deltas []int64) []byte {
+	for _, span := range spans {
+		var s []byte
+		s = protowire.AppendTag(s, 1, protowire.VarintType)
+		s = protowire.AppendVarint(s, protowire.EncodeZigZag(int64(span.Offset)))
+		s = protowire.AppendTag(s, 2, protowire.VarintType)
+		s = protowire.AppendVarint(s, uint64(span.Length))
+		b = protowire.AppendTag(b, spansField, protowire.BytesType)
+		b = protowire.AppendBytes(b, s)
+	}
+	if len(deltas) > 0 {
+		var packed []byte
+		for _, delta := range deltas {
+			packed = protowire.AppendVarint(packed, protowire.EncodeZigZag(delta))
+		}
+		b = protowire.AppendTag(b, deltasField, protowire.BytesType)
+		b = protowire.AppendBytes(b, packed)
+	}
+	return b
+}
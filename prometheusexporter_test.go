@@ -0,0 +1,228 @@
+package jsonlotelforwarder_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/golang/snappy"
+	jsonlotelforwarder "github.com/mashiike/jsonl-otel-forwarder"
+	"github.com/stretchr/testify/require"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+)
+
+func TestToPrometheusTimeSeries(t *testing.T) {
+	const ts = uint64(1544712660300000000)
+	sum := 6.5
+	metrics := &metricspb.MetricsData{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+				stringAttr("service.name", "checkout"),
+				stringAttr("service.namespace", "shop"),
+				stringAttr("service.instance.id", "i-1"),
+			}},
+			ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: []*metricspb.Metric{
+				{Name: "http.requests", Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+					AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+					IsMonotonic:            true,
+					DataPoints: []*metricspb.NumberDataPoint{{
+						TimeUnixNano: ts,
+						Attributes:   []*commonpb.KeyValue{stringAttr("http.method", "GET")},
+						Value:        &metricspb.NumberDataPoint_AsInt{AsInt: 3},
+					}},
+				}}},
+				{Name: "dropped.delta", Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+					AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+					DataPoints:             []*metricspb.NumberDataPoint{{TimeUnixNano: ts}},
+				}}},
+				{Name: "latency", Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+					AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+					DataPoints: []*metricspb.HistogramDataPoint{{
+						TimeUnixNano:   ts,
+						Count:          6,
+						Sum:            &sum,
+						ExplicitBounds: []float64{0.5, 1},
+						BucketCounts:   []uint64{1, 2, 3},
+					}},
+				}}},
+				{Name: "size", Data: &metricspb.Metric_ExponentialHistogram{ExponentialHistogram: &metricspb.ExponentialHistogram{
+					AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+					DataPoints: []*metricspb.ExponentialHistogramDataPoint{{
+						TimeUnixNano: ts,
+						Count:        7,
+						Sum:          &sum,
+						Scale:        9,
+						ZeroCount:    1,
+						Positive: &metricspb.ExponentialHistogramDataPoint_Buckets{
+							Offset:       -2,
+							BucketCounts: []uint64{1, 1, 0, 0, 0, 0, 2, 2},
+						},
+					}},
+				}}},
+			}}},
+		}},
+	}
+	labels := func(pairs ...string) []jsonlotelforwarder.PrometheusLabel {
+		all := []jsonlotelforwarder.PrometheusLabel{}
+		for i := 0; i < len(pairs); i += 2 {
+			all = append(all, jsonlotelforwarder.PrometheusLabel{Name: pairs[i], Value: pairs[i+1]})
+		}
+		slices.SortFunc(all, func(a, b jsonlotelforwarder.PrometheusLabel) int {
+			return strings.Compare(a.Name, b.Name)
+		})
+		return all
+	}
+	sample := func(value float64) []jsonlotelforwarder.PrometheusSample {
+		return []jsonlotelforwarder.PrometheusSample{{Value: value, Timestamp: 1544712660300}}
+	}
+	resource := []string{"instance", "i-1", "job", "shop/checkout", "service_instance_id", "i-1", "service_name", "checkout", "service_namespace", "shop"}
+	with := func(name string, pairs ...string) []jsonlotelforwarder.PrometheusLabel {
+		return labels(append(append([]string{"__name__", name}, pairs...), resource...)...)
+	}
+	require.Equal(t, []*jsonlotelforwarder.PrometheusTimeSeries{
+		{Labels: with("http_requests_total", "http_method", "GET"), Samples: sample(3)},
+		{Labels: with("latency_bucket", "le", "0.5"), Samples: sample(1)},
+		{Labels: with("latency_bucket", "le", "1"), Samples: sample(3)},
+		{Labels: with("latency_bucket", "le", "+Inf"), Samples: sample(6)},
+		{Labels: with("latency_sum"), Samples: sample(6.5)},
+		{Labels: with("latency_count"), Samples: sample(6)},
+		{Labels: with("size"), Histograms: []jsonlotelforwarder.PrometheusHistogram{{
+			Count:     7,
+			Sum:       6.5,
+			Schema:    8,
+			ZeroCount: 1,
+			// the buckets -2..5 of scale 9 are merged to -1..2 of scale 8, and shifted by one.
+			PositiveSpans:  []jsonlotelforwarder.PrometheusBucketSpan{{Offset: 0, Length: 1}, {Offset: 2, Length: 1}},
+			PositiveDeltas: []int64{2, 2},
+			Timestamp:      1544712660300,
+		}}},
+	}, jsonlotelforwarder.ToPrometheusTimeSeries(metrics))
+}
+
+func TestForwarder__PrometheusRemoteWriteExporter(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests int
+		bodies   [][]byte
+		tenants  []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if requests == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Path == "/bad" {
+			http.Error(w, "out of order sample", http.StatusBadRequest)
+			return
+		}
+		require.Equal(t, "snappy", r.Header.Get("Content-Encoding"))
+		require.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+		require.Equal(t, "0.1.0", r.Header.Get("X-Prometheus-Remote-Write-Version"))
+		require.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		compressed, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		body, err := snappy.Decode(nil, compressed)
+		require.NoError(t, err)
+		bodies = append(bodies, body)
+		tenants = append(tenants, r.Header.Get("X-Scope-OrgID"))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	t.Setenv("REMOTE_WRITE_TOKEN", "secret")
+
+	opts := jsonlotelforwarder.DefaultOptions()
+	opts.ConfigFile = writeConfig(t, map[string]any{
+		"exporters": []map[string]any{
+			{
+				"name":          "prometheus",
+				"type":          "prometheus_remote_write",
+				"endpoint":      server.URL + "/api/v1/push",
+				"headers":       map[string]string{"Authorization": "Bearer ${REMOTE_WRITE_TOKEN}"},
+				"retry":         map[string]any{"initial_interval": "1ms"},
+				"tenant_header": map[string]any{"header": "X-Scope-OrgID", "attribute": "service.name"},
+			},
+		},
+	})
+	forwarder, err := jsonlotelforwarder.New(opts)
+	require.NoError(t, err)
+	for _, name := range []string{"testdata/metrics.json", "testdata/trace.json"} {
+		bs, err := os.ReadFile(name)
+		require.NoError(t, err)
+		_, err = forwarder.Invoke(context.Background(), bs)
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, 2, requests, "the unavailable endpoint is retried, and traces are not sent")
+	require.Equal(t, []string{"my.service"}, tenants)
+	expected := jsonlotelforwarder.ToPrometheusTimeSeries(loadParseResults(t, "testdata/metrics.json")[0].Metrics)
+	require.NotEmpty(t, expected)
+	require.Equal(t, jsonlotelforwarder.MarshalPrometheusWriteRequest(expected), bodies[0])
+
+	exporter, err := jsonlotelforwarder.NewPrometheusRemoteWriteExporter(&jsonlotelforwarder.PrometheusRemoteWriteExporterConfig{
+		Endpoint: server.URL + "/bad",
+	})
+	require.NoError(t, err)
+	require.ErrorContains(t, exporter.Export(context.Background(), loadParseResults(t, "testdata/metrics.json")[0]), "400 Bad Request")
+}
+
+func TestPrometheusRemoteWriteExporter__RetryFailedTenants(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		tenants []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		tenant := r.Header.Get("X-Scope-OrgID")
+		tenants = append(tenants, tenant)
+		switch {
+		case tenant == "bad":
+			http.Error(w, "out of order sample", http.StatusBadRequest)
+		case tenant == "flaky" && slices.Index(tenants, tenant) == len(tenants)-1:
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+	newResourceMetrics := func(serviceName string) *metricspb.ResourceMetrics {
+		return &metricspb.ResourceMetrics{
+			Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{stringAttr("service.name", serviceName)}},
+			ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: []*metricspb.Metric{{
+				Name: "queue.size",
+				Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: []*metricspb.NumberDataPoint{
+					{TimeUnixNano: 1544712660300000000, Value: &metricspb.NumberDataPoint_AsInt{AsInt: 1}},
+				}}},
+			}}}},
+		}
+	}
+	exporter, err := jsonlotelforwarder.NewPrometheusRemoteWriteExporter(&jsonlotelforwarder.PrometheusRemoteWriteExporterConfig{
+		Endpoint:     server.URL,
+		TenantHeader: &jsonlotelforwarder.TenantHeaderConfig{Header: "X-Scope-OrgID", Attribute: "service.name"},
+	})
+	require.NoError(t, err)
+	result := &jsonlotelforwarder.PaseResult{Metrics: &metricspb.MetricsData{ResourceMetrics: []*metricspb.ResourceMetrics{
+		newResourceMetrics("ok"),
+		newResourceMetrics("flaky"),
+		newResourceMetrics("bad"),
+	}}}
+
+	err = exporter.Export(context.Background(), result)
+	require.ErrorContains(t, err, "tenant flaky: remote write: 503 Service Unavailable")
+	require.ErrorContains(t, err, "tenant bad: remote write: 400 Bad Request")
+	err = exporter.Export(context.Background(), result)
+	require.ErrorContains(t, err, "tenant bad: remote write: 400 Bad Request", "the permanent error is reported without sending again")
+	require.NotContains(t, err.Error(), "tenant flaky")
+	require.Equal(t, []string{"ok", "flaky", "bad", "flaky"}, tenants)
+}
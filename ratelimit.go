@@ -0,0 +1,257 @@
+package jsonlotelforwarder
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	RateLimitActionDrop       = "drop"
+	RateLimitActionSample     = "sample"
+	RateLimitActionDeadLetter = "dead_letter"
+)
+
+// RateLimitConfig is the configuration of token bucket rate limits of spans, data points and log records.
+// the buckets are kept in memory, so the limits apply to each forwarder instance across invocations.
+type RateLimitConfig struct {
+	Traces  *RateLimitSignalConfig `json:"traces,omitempty"`
+	Metrics *RateLimitSignalConfig `json:"metrics,omitempty"`
+	Logs    *RateLimitSignalConfig `json:"logs,omitempty"`
+}
+
+// RateLimitSignalConfig is the rate limit of a signal.
+// Rate is the number of items per second, and Burst is the bucket size, the ceiling of Rate when zero.
+// with PerService, each service.name has its own bucket.
+// Action is for the items exceeding the limit: `drop` (default), `sample` keeps SamplePercentage of them,
+// and `dead_letter` routes them to DeadLetterExporters.
+type RateLimitSignalConfig struct {
+	Rate                float64  `json:"rate"`
+	Burst               int      `json:"burst,omitempty"`
+	PerService          bool     `json:"per_service,omitempty"`
+	Action              string   `json:"action,omitempty"`
+	SamplePercentage    float64  `json:"sample_percentage,omitempty"`
+	DeadLetterExporters []string `json:"dead_letter_exporters,omitempty"`
+}
+
+func (c *RateLimitConfig) Enabled() bool {
+	return c != nil && (c.Traces != nil || c.Metrics != nil || c.Logs != nil)
+}
+
+type RateLimitProcessor struct {
+	traces      *rateLimiter
+	metrics     *rateLimiter
+	logs        *rateLimiter
+	now         func() time.Time
+	selfMetrics *SelfMetrics
+}
+
+// NewRateLimitProcessor returns the rate limit processor. exporters are the names of the configured exporters.
+func NewRateLimitProcessor(config *RateLimitConfig, exporters []string, selfMetrics *SelfMetrics) (*RateLimitProcessor, error) {
+	p := &RateLimitProcessor{now: time.Now, selfMetrics: selfMetrics}
+	var err error
+	if p.traces, err = newRateLimiter(config.Traces, exporters); err != nil {
+		return nil, fmt.Errorf("traces: %w", err)
+	}
+	if p.metrics, err = newRateLimiter(config.Metrics, exporters); err != nil {
+		return nil, fmt.Errorf("metrics: %w", err)
+	}
+	if p.logs, err = newRateLimiter(config.Logs, exporters); err != nil {
+		return nil, fmt.Errorf("logs: %w", err)
+	}
+	return p, nil
+}
+
+func (p *RateLimitProcessor) Process(ctx context.Context, results []*PaseResult) ([]*PaseResult, error) {
+	limited := make([]*PaseResult, 0, len(results))
+	for _, result := range results {
+		var deadLetters []*PaseResult
+		if p.traces != nil && result.Traces != nil {
+			deadLetters = append(deadLetters, p.limit(ctx, "spans", p.traces, result, FilterSpans, func(item *FilterItem) []byte {
+				return item.Span.GetTraceId()
+			}))
+		}
+		if p.metrics != nil && result.Metrics != nil {
+			deadLetters = append(deadLetters, p.limit(ctx, "data_points", p.metrics, result, FilterDataPoints, func(*FilterItem) []byte {
+				return nil
+			}))
+		}
+		if p.logs != nil && result.Logs != nil {
+			deadLetters = append(deadLetters, p.limit(ctx, "log_records", p.logs, result, FilterLogRecords, func(item *FilterItem) []byte {
+				return item.LogRecord.GetTraceId()
+			}))
+		}
+		PruneResult(result)
+		limited = append(limited, result)
+		for _, deadLetter := range deadLetters {
+			if deadLetter == nil {
+				continue
+			}
+			PruneResult(deadLetter)
+			limited = append(limited, deadLetter)
+		}
+	}
+	return limited, nil
+}
+
+// limit filters the items of the signal by the limiter, and returns the result of the items exceeding the limit
+// for the dead_letter action. the exceeded items are decided once, and the same decisions are applied to the copy
+// of the signal in the same order, so the dead letter result has exactly the items removed from the result.
+func (p *RateLimitProcessor) limit(
+	ctx context.Context,
+	name string,
+	limiter *rateLimiter,
+	result *PaseResult,
+	filter func(result *PaseResult, keep func(item *FilterItem) bool) int,
+	traceIDOf func(item *FilterItem) []byte,
+) *PaseResult {
+	var deadLetter *PaseResult
+	if limiter.action == RateLimitActionDeadLetter {
+		// only the limited signal goes to the dead letter exporters.
+		deadLetter = &PaseResult{CloudWatch: result.CloudWatch, Exporters: limiter.deadLetterExporters}
+		switch name {
+		case "spans":
+			deadLetter.Traces = proto.Clone(result.Traces).(*tracepb.TracesData)
+		case "data_points":
+			deadLetter.Metrics = proto.Clone(result.Metrics).(*metricspb.MetricsData)
+		case "log_records":
+			deadLetter.Logs = proto.Clone(result.Logs).(*logspb.LogsData)
+		}
+	}
+	now := p.now()
+	var decisions []bool
+	dropped := filter(result, func(item *FilterItem) bool {
+		keep := limiter.allow(resourceServiceName(item.Resource), now) || limiter.sample(traceIDOf(item))
+		decisions = append(decisions, keep)
+		return keep
+	})
+	if dropped == 0 {
+		return nil
+	}
+	if deadLetter == nil {
+		slog.DebugContext(ctx, "rate limit exceeded", "signal", name, "dropped", dropped)
+		p.selfMetrics.Add("rate_limit.dropped_"+name, int64(dropped))
+		return nil
+	}
+	slog.DebugContext(ctx, "rate limit exceeded", "signal", name, "dead_lettered", dropped, "exporters", limiter.deadLetterExporters)
+	p.selfMetrics.Add("rate_limit.dead_lettered_"+name, int64(dropped))
+	var i int
+	filter(deadLetter, func(*FilterItem) bool {
+		keep := !decisions[i]
+		i++
+		return keep
+	})
+	return deadLetter
+}
+
+// rateLimiter is the token buckets of a signal, keyed by service.name with per_service, or a single bucket.
+type rateLimiter struct {
+	rate                float64
+	burst               float64
+	perService          bool
+	action              string
+	sampleThreshold     uint64
+	samplePercentage    float64
+	deadLetterExporters []string
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(c *RateLimitSignalConfig, exporters []string) (*rateLimiter, error) {
+	if c == nil {
+		return nil, nil
+	}
+	if c.Rate <= 0 {
+		return nil, fmt.Errorf("rate must be greater than 0")
+	}
+	if c.Burst < 0 {
+		return nil, fmt.Errorf("burst must not be negative")
+	}
+	l := &rateLimiter{
+		rate:       c.Rate,
+		burst:      float64(c.Burst),
+		perService: c.PerService,
+		action:     c.Action,
+		buckets:    make(map[string]*tokenBucket),
+	}
+	if l.burst == 0 {
+		l.burst = math.Ceil(c.Rate)
+	}
+	if l.action == "" {
+		l.action = RateLimitActionDrop
+	}
+	switch l.action {
+	case RateLimitActionDrop:
+	case RateLimitActionSample:
+		if c.SamplePercentage < 0 || c.SamplePercentage > 100 {
+			return nil, fmt.Errorf("sample_percentage must be between 0 and 100")
+		}
+		l.samplePercentage = c.SamplePercentage
+		l.sampleThreshold = probabilityToThreshold(c.SamplePercentage / 100)
+	case RateLimitActionDeadLetter:
+		if len(c.DeadLetterExporters) == 0 {
+			return nil, fmt.Errorf("dead_letter_exporters are required for the dead_letter action")
+		}
+		for _, name := range c.DeadLetterExporters {
+			if !slices.Contains(exporters, name) {
+				return nil, fmt.Errorf("dead_letter_exporters: unknown exporter %q", name)
+			}
+		}
+		l.deadLetterExporters = c.DeadLetterExporters
+	default:
+		return nil, fmt.Errorf("unknown action %q, expected drop, sample or dead_letter", c.Action)
+	}
+	return l, nil
+}
+
+// allow takes a token from the bucket of the service, refilled by the rate since the last call.
+func (l *rateLimiter) allow(serviceName string, now time.Time) bool {
+	key := ""
+	if l.perService {
+		key = serviceName
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(l.burst, b.tokens+elapsed.Seconds()*l.rate)
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// sample keeps the item exceeding the limit with the sample action, decided consistently by the trace ID,
+// or at random for the items without trace ID.
+func (l *rateLimiter) sample(traceID []byte) bool {
+	if l.action != RateLimitActionSample {
+		return false
+	}
+	if len(traceID) > 0 {
+		return TraceIDRandomness(traceID) >= l.sampleThreshold
+	}
+	return rand.Float64()*100 < l.samplePercentage
+}
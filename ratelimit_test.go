@@ -0,0 +1,108 @@
+package jsonlotelforwarder_test
+
+import (
+	"context"
+	"testing"
+
+	jsonlotelforwarder "github.com/mashiike/jsonl-otel-forwarder"
+	"github.com/stretchr/testify/require"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+func TestRateLimitProcessor__PerService(t *testing.T) {
+	newResourceLogs := func(serviceName string, bodies ...string) *logspb.ResourceLogs {
+		var records []*logspb.LogRecord
+		for _, body := range bodies {
+			records = append(records, &logspb.LogRecord{Body: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: body}}})
+		}
+		return &logspb.ResourceLogs{
+			Resource:  &resourcepb.Resource{Attributes: []*commonpb.KeyValue{stringAttr("service.name", serviceName)}},
+			ScopeLogs: []*logspb.ScopeLogs{{LogRecords: records}},
+		}
+	}
+	selfMetrics := jsonlotelforwarder.NewSelfMetrics()
+	p, err := jsonlotelforwarder.NewRateLimitProcessor(&jsonlotelforwarder.RateLimitConfig{
+		Logs: &jsonlotelforwarder.RateLimitSignalConfig{Rate: 0.001, Burst: 2, PerService: true},
+	}, nil, selfMetrics)
+	require.NoError(t, err)
+	result := &jsonlotelforwarder.PaseResult{
+		Logs: &logspb.LogsData{ResourceLogs: []*logspb.ResourceLogs{
+			newResourceLogs("noisy", "n1", "n2", "n3"),
+			newResourceLogs("quiet", "q1"),
+		}},
+	}
+	results, err := p.Process(context.Background(), []*jsonlotelforwarder.PaseResult{result})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	var bodies []string
+	for _, resourceLogs := range results[0].Logs.GetResourceLogs() {
+		for _, record := range resourceLogs.GetScopeLogs()[0].GetLogRecords() {
+			bodies = append(bodies, record.GetBody().GetStringValue())
+		}
+	}
+	require.Equal(t, []string{"n1", "n2", "q1"}, bodies, "the noisy service does not consume the budget of the quiet one")
+	require.EqualValues(t, 1, selfMetrics.Get("rate_limit.dropped_log_records"))
+
+	results, err = p.Process(context.Background(), []*jsonlotelforwarder.PaseResult{{
+		Logs: &logspb.LogsData{ResourceLogs: []*logspb.ResourceLogs{newResourceLogs("noisy", "n4")}},
+	}})
+	require.NoError(t, err)
+	require.Empty(t, results[0].Logs.GetResourceLogs(), "the buckets are kept across invocations")
+	require.EqualValues(t, 2, selfMetrics.Get("rate_limit.dropped_log_records"))
+}
+
+func TestRateLimitProcessor__Sample(t *testing.T) {
+	selfMetrics := jsonlotelforwarder.NewSelfMetrics()
+	p, err := jsonlotelforwarder.NewRateLimitProcessor(&jsonlotelforwarder.RateLimitConfig{
+		Traces: &jsonlotelforwarder.RateLimitSignalConfig{Rate: 0.001, Action: "sample", SamplePercentage: 50},
+	}, nil, selfMetrics)
+	require.NoError(t, err)
+	results, err := p.Process(context.Background(), []*jsonlotelforwarder.PaseResult{newTraceResult(
+		&tracepb.Span{TraceId: traceID(0x01), Name: "allowed"},
+		&tracepb.Span{TraceId: traceID(0x01), Name: "low"},
+		&tracepb.Span{TraceId: traceID(0xff), Name: "high"},
+	)})
+	require.NoError(t, err)
+	require.Equal(t, []string{"allowed", "high"}, spanNames(results))
+	require.EqualValues(t, 1, selfMetrics.Get("rate_limit.dropped_spans"))
+}
+
+func TestRateLimitProcessor__DeadLetter(t *testing.T) {
+	selfMetrics := jsonlotelforwarder.NewSelfMetrics()
+	p, err := jsonlotelforwarder.NewRateLimitProcessor(&jsonlotelforwarder.RateLimitConfig{
+		Traces: &jsonlotelforwarder.RateLimitSignalConfig{Rate: 0.001, Action: "dead_letter", DeadLetterExporters: []string{"dlq"}},
+	}, []string{"otlp", "dlq"}, selfMetrics)
+	require.NoError(t, err)
+	result := newTraceResult(
+		&tracepb.Span{TraceId: traceID(0x01), Name: "a"},
+		&tracepb.Span{TraceId: traceID(0x02), Name: "b"},
+		&tracepb.Span{TraceId: traceID(0x03), Name: "c"},
+	)
+	result.Exporters = []string{"otlp"}
+	results, err := p.Process(context.Background(), []*jsonlotelforwarder.PaseResult{result})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	require.Equal(t, []string{"otlp"}, results[0].Exporters)
+	require.Equal(t, []string{"a"}, spanNames(results[:1]))
+	require.Equal(t, []string{"dlq"}, results[1].Exporters)
+	require.Equal(t, []string{"b", "c"}, spanNames(results[1:]))
+	require.Equal(t, "checkout", results[1].Traces.GetResourceSpans()[0].GetResource().GetAttributes()[0].GetValue().GetStringValue())
+	require.EqualValues(t, 2, selfMetrics.Get("rate_limit.dead_lettered_spans"))
+	require.Zero(t, selfMetrics.Get("rate_limit.dropped_spans"))
+}
+
+func TestRateLimitProcessor__Invalid(t *testing.T) {
+	for name, c := range map[string]*jsonlotelforwarder.RateLimitSignalConfig{
+		"zero rate":           {Rate: 0},
+		"unknown action":      {Rate: 1, Action: "block"},
+		"sample percentage":   {Rate: 1, Action: "sample", SamplePercentage: 150},
+		"no dead letter":      {Rate: 1, Action: "dead_letter"},
+		"unknown dead letter": {Rate: 1, Action: "dead_letter", DeadLetterExporters: []string{"missing"}},
+	} {
+		_, err := jsonlotelforwarder.NewRateLimitProcessor(&jsonlotelforwarder.RateLimitConfig{Metrics: c}, []string{"otlp"}, nil)
+		require.Error(t, err, name)
+	}
+}
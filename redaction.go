@@ -0,0 +1,268 @@
+package jsonlotelforwarder
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+)
+
+// BuiltinRedactionPatterns are the patterns that can be referred by name in --redaction-patterns.
+var BuiltinRedactionPatterns = map[string]string{
+	"email":          `[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`,
+	"credit_card":    `\b(?:\d[ -]?){12,18}\d\b`,
+	"bearer_token":   `(?i)bearer\s+[A-Za-z0-9\-._~+/]+=*`,
+	"jwt":            `eyJ[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+`,
+	"aws_access_key": `\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`,
+}
+
+// builtinRedactionChecks are the checks of matches of BuiltinRedactionPatterns, the matches that fail the check are left as is.
+// e.g. credit card numbers must pass the Luhn check, not to mask numbers such as epoch milliseconds.
+var builtinRedactionChecks = map[string]func(match string) bool{
+	"credit_card": luhnValid,
+}
+
+// RedactionConfig is the configuration of redaction processor.
+// AllowedKeys restricts span, data point and log record attributes to the listed keys.
+// BlockedValues are applied to attribute values of all targets, log bodies and span names.
+// IgnoredKeys are never masked by BlockedValues.
+type RedactionConfig struct {
+	AllowedKeys   []string                `json:"allowed_keys,omitempty"`
+	IgnoredKeys   []string                `json:"ignored_keys,omitempty"`
+	BlockedValues []*RedactionValueConfig `json:"blocked_values,omitempty"`
+	Mask          string                  `json:"mask,omitempty"`
+	HashSalt      string                  `json:"hash_salt,omitempty"`
+	SkipLogBody   bool                    `json:"skip_log_body,omitempty"`
+	SkipSpanName  bool                    `json:"skip_span_name,omitempty"`
+}
+
+// RedactionValueConfig is a pattern of sensitive values.
+// Builtin is one of BuiltinRedactionPatterns keys, or Pattern is a regular expression.
+// Action is mask (default) or hash.
+type RedactionValueConfig struct {
+	Name    string `json:"name,omitempty"`
+	Builtin string `json:"builtin,omitempty"`
+	Pattern string `json:"pattern,omitempty"`
+	Action  string `json:"action,omitempty"`
+}
+
+func (c *RedactionConfig) Enabled() bool {
+	return c != nil && (len(c.AllowedKeys) > 0 || len(c.BlockedValues) > 0)
+}
+
+type redactionPattern struct {
+	name   string
+	re     *regexp.Regexp
+	check  func(match string) bool
+	action string
+}
+
+type RedactionProcessor struct {
+	allowedKeys  map[string]struct{}
+	ignoredKeys  map[string]struct{}
+	patterns     []*redactionPattern
+	mask         string
+	hashSalt     string
+	skipLogBody  bool
+	skipSpanName bool
+	selfMetrics  *SelfMetrics
+}
+
+func NewRedactionProcessor(config *RedactionConfig, selfMetrics *SelfMetrics) (*RedactionProcessor, error) {
+	p := &RedactionProcessor{
+		ignoredKeys:  make(map[string]struct{}, len(config.IgnoredKeys)),
+		mask:         config.Mask,
+		hashSalt:     config.HashSalt,
+		skipLogBody:  config.SkipLogBody,
+		skipSpanName: config.SkipSpanName,
+		selfMetrics:  selfMetrics,
+	}
+	if p.mask == "" {
+		p.mask = "****"
+	}
+	if len(config.AllowedKeys) > 0 {
+		p.allowedKeys = make(map[string]struct{}, len(config.AllowedKeys))
+		for _, key := range config.AllowedKeys {
+			p.allowedKeys[key] = struct{}{}
+		}
+	}
+	for _, key := range config.IgnoredKeys {
+		p.ignoredKeys[key] = struct{}{}
+	}
+	for i, value := range config.BlockedValues {
+		pattern := value.Pattern
+		name := value.Name
+		var check func(string) bool
+		if value.Builtin != "" {
+			var ok bool
+			pattern, ok = BuiltinRedactionPatterns[value.Builtin]
+			if !ok {
+				return nil, fmt.Errorf("blocked_values[%d]: unknown builtin pattern %q", i, value.Builtin)
+			}
+			if name == "" {
+				name = value.Builtin
+			}
+			check = builtinRedactionChecks[value.Builtin]
+		}
+		if pattern == "" {
+			return nil, fmt.Errorf("blocked_values[%d]: pattern or builtin is required", i)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("blocked_values[%d]: %w", i, err)
+		}
+		if name == "" {
+			name = fmt.Sprintf("pattern%d", i)
+		}
+		action := value.Action
+		switch action {
+		case "":
+			action = "mask"
+		case "mask", "hash":
+		default:
+			return nil, fmt.Errorf("blocked_values[%d]: unknown action %q, expected mask or hash", i, action)
+		}
+		p.patterns = append(p.patterns, &redactionPattern{name: name, re: re, check: check, action: action})
+	}
+	return p, nil
+}
+
+func (p *RedactionProcessor) Process(_ context.Context, results []*PaseResult) ([]*PaseResult, error) {
+	for _, result := range results {
+		for _, target := range allAttributeTargets {
+			restrict := p.allowedKeys != nil && target != AttributeTargetResource && target != AttributeTargetScope
+			for _, attrs := range AttributesOf(result, target, false) {
+				if restrict {
+					*attrs = p.dropNotAllowed(*attrs)
+				}
+				for _, attr := range *attrs {
+					if _, ok := p.ignoredKeys[attr.GetKey()]; ok {
+						continue
+					}
+					p.redactAnyValue(attr.GetValue(), "attribute")
+				}
+			}
+		}
+		for _, resourceSpans := range result.Traces.GetResourceSpans() {
+			for _, scopeSpans := range resourceSpans.GetScopeSpans() {
+				for _, span := range scopeSpans.GetSpans() {
+					if !p.skipSpanName {
+						span.Name = p.redactString(span.GetName(), "span_name")
+					}
+					for _, event := range span.GetEvents() {
+						for _, attr := range event.GetAttributes() {
+							if _, ok := p.ignoredKeys[attr.GetKey()]; ok {
+								continue
+							}
+							p.redactAnyValue(attr.GetValue(), "attribute")
+						}
+					}
+				}
+			}
+		}
+		if p.skipLogBody {
+			continue
+		}
+		for _, resourceLogs := range result.Logs.GetResourceLogs() {
+			for _, scopeLogs := range resourceLogs.GetScopeLogs() {
+				for _, record := range scopeLogs.GetLogRecords() {
+					p.redactAnyValue(record.GetBody(), "log_body")
+				}
+			}
+		}
+	}
+	return results, nil
+}
+
+func (p *RedactionProcessor) dropNotAllowed(attrs []*commonpb.KeyValue) []*commonpb.KeyValue {
+	before := len(attrs)
+	attrs = slices.DeleteFunc(attrs, func(kv *commonpb.KeyValue) bool {
+		_, ok := p.allowedKeys[kv.GetKey()]
+		return !ok
+	})
+	p.selfMetrics.Add("redaction.dropped_attributes", int64(before-len(attrs)))
+	return attrs
+}
+
+func (p *RedactionProcessor) redactAnyValue(value *commonpb.AnyValue, location string) {
+	switch v := value.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		v.StringValue = p.redactString(v.StringValue, location)
+	case *commonpb.AnyValue_ArrayValue:
+		for _, elem := range v.ArrayValue.GetValues() {
+			p.redactAnyValue(elem, location)
+		}
+	case *commonpb.AnyValue_KvlistValue:
+		for _, kv := range v.KvlistValue.GetValues() {
+			if _, ok := p.ignoredKeys[kv.GetKey()]; ok {
+				continue
+			}
+			p.redactAnyValue(kv.GetValue(), location)
+		}
+	}
+}
+
+func (p *RedactionProcessor) redactString(s string, location string) string {
+	for _, pattern := range p.patterns {
+		var n int64
+		s = pattern.re.ReplaceAllStringFunc(s, func(match string) string {
+			if pattern.check != nil && !pattern.check(match) {
+				return match
+			}
+			n++
+			if pattern.action == "hash" {
+				sum := sha256.Sum256([]byte(p.hashSalt + match))
+				return "sha256:" + hex.EncodeToString(sum[:])[:16]
+			}
+			return p.mask
+		})
+		p.selfMetrics.Add("redaction.redacted_fields."+location+"."+pattern.name, n)
+	}
+	return s
+}
+
+// luhnValid reports whether the digits of s pass the Luhn check, ignoring other characters.
+func luhnValid(s string) bool {
+	var sum int
+	double := false
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i] < '0' || s[i] > '9' {
+			continue
+		}
+		d := int(s[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// ParseRedactionPatterns parses comma separated builtin pattern names with optional action, e.g. email,credit_card:hash
+func ParseRedactionPatterns(s string) ([]*RedactionValueConfig, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var values []*RedactionValueConfig
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, action, _ := strings.Cut(part, ":")
+		if _, ok := BuiltinRedactionPatterns[name]; !ok {
+			return nil, fmt.Errorf("unknown builtin pattern %q", name)
+		}
+		values = append(values, &RedactionValueConfig{Builtin: name, Action: action})
+	}
+	return values, nil
+}
@@ -0,0 +1,154 @@
+package jsonlotelforwarder_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mashiike/go-otlp-helper/otlp"
+	jsonlotelforwarder "github.com/mashiike/jsonl-otel-forwarder"
+	"github.com/stretchr/testify/require"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+func TestRedactionProcessor(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		expected string
+		counters map[string]int64
+	}{
+		{
+			name:     "trace",
+			input:    "testdata/redaction_trace.json",
+			expected: "testdata/redacted_trace.json",
+			counters: map[string]int64{
+				"redaction.dropped_attributes":                     1,
+				"redaction.redacted_fields.attribute.email":        3,
+				"redaction.redacted_fields.attribute.credit_card":  2,
+				"redaction.redacted_fields.attribute.bearer_token": 1,
+				"redaction.redacted_fields.span_name.email":        1,
+			},
+		},
+		{
+			name:     "logs",
+			input:    "testdata/redaction_logs.json",
+			expected: "testdata/redacted_logs.json",
+			counters: map[string]int64{
+				"redaction.redacted_fields.attribute.email":         1,
+				"redaction.redacted_fields.log_body.email":          2,
+				"redaction.redacted_fields.log_body.jwt":            1,
+				"redaction.redacted_fields.log_body.aws_access_key": 1,
+			},
+		},
+	}
+	config, err := jsonlotelforwarder.LoadConfig("testdata/redaction_config.json")
+	require.NoError(t, err)
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			selfMetrics := jsonlotelforwarder.NewSelfMetrics()
+			p, err := jsonlotelforwarder.NewRedactionProcessor(config.Redaction, selfMetrics)
+			require.NoError(t, err)
+			results, err := p.Process(context.Background(), loadParseResults(t, tc.input))
+			require.NoError(t, err)
+			var bs []byte
+			if results[0].Traces != nil {
+				bs, err = otlp.MarshalIndentJSON(results[0].Traces, "  ")
+			} else {
+				bs, err = otlp.MarshalIndentJSON(results[0].Logs, "  ")
+			}
+			require.NoError(t, err)
+			t.Log("actual:", string(bs))
+			expected, err := os.ReadFile(tc.expected)
+			require.NoError(t, err)
+			require.JSONEq(t, string(expected), string(bs))
+			require.Equal(t, tc.counters, selfMetrics.Snapshot())
+		})
+	}
+}
+
+func TestRedactionProcessor__CreditCard(t *testing.T) {
+	selfMetrics := jsonlotelforwarder.NewSelfMetrics()
+	p, err := jsonlotelforwarder.NewRedactionProcessor(&jsonlotelforwarder.RedactionConfig{
+		BlockedValues: []*jsonlotelforwarder.RedactionValueConfig{{Builtin: "credit_card"}},
+	}, selfMetrics)
+	require.NoError(t, err)
+	span := &tracepb.Span{Name: "checkout", Attributes: []*commonpb.KeyValue{
+		stringAttr("payment.card", "4111-1111-1111-1111"),
+		stringAttr("order.id", "4111-1111-1111-1112"),
+		stringAttr("event.time", "1700000000000"),
+	}}
+	_, err = p.Process(context.Background(), []*jsonlotelforwarder.PaseResult{newTraceResult(span)})
+	require.NoError(t, err)
+	require.Equal(t, []*commonpb.KeyValue{
+		stringAttr("payment.card", "****"),
+		stringAttr("order.id", "4111-1111-1111-1112"),
+		stringAttr("event.time", "1700000000000"),
+	}, span.GetAttributes(), "only the numbers that pass the Luhn check are masked")
+	require.Equal(t, map[string]int64{"redaction.redacted_fields.attribute.credit_card": 1}, selfMetrics.Snapshot())
+}
+
+func TestNewRedactionProcessor__Invalid(t *testing.T) {
+	cases := []struct {
+		name   string
+		config *jsonlotelforwarder.RedactionConfig
+	}{
+		{name: "unknown builtin", config: &jsonlotelforwarder.RedactionConfig{BlockedValues: []*jsonlotelforwarder.RedactionValueConfig{{Builtin: "phone"}}}},
+		{name: "invalid regexp", config: &jsonlotelforwarder.RedactionConfig{BlockedValues: []*jsonlotelforwarder.RedactionValueConfig{{Pattern: "("}}}},
+		{name: "unknown action", config: &jsonlotelforwarder.RedactionConfig{BlockedValues: []*jsonlotelforwarder.RedactionValueConfig{{Builtin: "email", Action: "encrypt"}}}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := jsonlotelforwarder.NewRedactionProcessor(tc.config, nil)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestParseRedactionPatterns(t *testing.T) {
+	values, err := jsonlotelforwarder.ParseRedactionPatterns("email:hash, credit_card")
+	require.NoError(t, err)
+	require.Equal(t, []*jsonlotelforwarder.RedactionValueConfig{
+		{Builtin: "email", Action: "hash"},
+		{Builtin: "credit_card"},
+	}, values)
+	_, err = jsonlotelforwarder.ParseRedactionPatterns("phone")
+	require.Error(t, err)
+}
+
+func TestForwarder__RedactionWithSpanMetrics(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.jsonl")
+	opts := jsonlotelforwarder.DefaultOptions()
+	opts.Signals = "metrics"
+	opts.FileMetricsPath = path
+	opts.SpanMetrics = true
+	opts.RedactionAllowedKeys = "http.route"
+	forwarder, err := jsonlotelforwarder.New(opts)
+	require.NoError(t, err)
+	bs, err := os.ReadFile("testdata/trace.json")
+	require.NoError(t, err)
+	_, err = forwarder.Invoke(context.Background(), bs)
+	require.NoError(t, err)
+
+	results := loadParseResults(t, path)
+	require.NotEmpty(t, results)
+	var keys []string
+	for _, resourceMetrics := range results[0].Metrics.GetResourceMetrics() {
+		for _, scopeMetrics := range resourceMetrics.GetScopeMetrics() {
+			for _, metric := range scopeMetrics.GetMetrics() {
+				if metric.GetName() != "traces.span.metrics.calls" {
+					continue
+				}
+				for _, dp := range metric.GetSum().GetDataPoints() {
+					for key := range attributesToMap(dp.GetAttributes()) {
+						keys = append(keys, key)
+					}
+				}
+			}
+		}
+	}
+	require.Contains(t, keys, "span.name", "the attributes of span metrics are not removed by the allowed keys")
+	require.Contains(t, keys, "span.kind")
+}
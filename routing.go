@@ -0,0 +1,212 @@
+package jsonlotelforwarder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// RoutingConfig selects the exporters of each resource by the first matching route.
+// resources without matching route are sent to the Default exporters, or all exporters when Default is empty.
+type RoutingConfig struct {
+	Routes  []*RoutingRoute `json:"routes"`
+	Default []string        `json:"default,omitempty"`
+}
+
+// RoutingRoute matches the resource attribute, or the CloudWatch Logs metadata with `cloudwatch:NAME` (e.g. cloudwatch:log_group), against the glob patterns of Values.
+type RoutingRoute struct {
+	Attribute string   `json:"attribute"`
+	Values    []string `json:"values"`
+	Exporters []string `json:"exporters"`
+}
+
+func (c *RoutingConfig) Enabled() bool {
+	return c != nil && len(c.Routes) > 0
+}
+
+type RoutingProcessor struct {
+	routes      []*RoutingRoute
+	defaults    []string
+	selfMetrics *SelfMetrics
+}
+
+// NewRoutingProcessor returns the routing processor. exporters are the names of the configured exporters.
+func NewRoutingProcessor(config *RoutingConfig, exporters []string, selfMetrics *SelfMetrics) (*RoutingProcessor, error) {
+	checkExporters := func(names []string) error {
+		for _, name := range names {
+			if !slices.Contains(exporters, name) {
+				return fmt.Errorf("unknown exporter %q", name)
+			}
+		}
+		return nil
+	}
+	for i, route := range config.Routes {
+		if route.Attribute == "" {
+			return nil, fmt.Errorf("routes[%d]: attribute is required", i)
+		}
+		if len(route.Values) == 0 {
+			return nil, fmt.Errorf("routes[%d]: values are required", i)
+		}
+		for _, pattern := range route.Values {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("routes[%d]: invalid pattern %q: %w", i, pattern, err)
+			}
+		}
+		if len(route.Exporters) == 0 {
+			return nil, fmt.Errorf("routes[%d]: exporters are required", i)
+		}
+		if err := checkExporters(route.Exporters); err != nil {
+			return nil, fmt.Errorf("routes[%d]: %w", i, err)
+		}
+	}
+	if err := checkExporters(config.Default); err != nil {
+		return nil, fmt.Errorf("default: %w", err)
+	}
+	return &RoutingProcessor{routes: config.Routes, defaults: config.Default, selfMetrics: selfMetrics}, nil
+}
+
+func (p *RoutingProcessor) Process(_ context.Context, results []*PaseResult) ([]*PaseResult, error) {
+	routed := make([]*PaseResult, 0, len(results))
+	for _, result := range results {
+		routed = append(routed, p.split(result)...)
+	}
+	return routed, nil
+}
+
+// route returns the exporters of the resource.
+func (p *RoutingProcessor) route(resource *resourcepb.Resource, cloudWatch *CloudWatchMetadata) []string {
+	for _, route := range p.routes {
+		var value string
+		var ok bool
+		if name, found := strings.CutPrefix(route.Attribute, "cloudwatch:"); found {
+			value, ok = cloudWatch.Lookup(name)
+		} else {
+			value, ok = lookupAttribute(resource.GetAttributes(), route.Attribute)
+		}
+		if !ok {
+			continue
+		}
+		for _, pattern := range route.Values {
+			if matched, _ := path.Match(pattern, value); matched {
+				p.selfMetrics.Add("routing.matched_resources", 1)
+				return route.Exporters
+			}
+		}
+	}
+	p.selfMetrics.Add("routing.default_resources", 1)
+	return p.defaults
+}
+
+// split splits the result into results per route, in order of appearance.
+func (p *RoutingProcessor) split(result *PaseResult) []*PaseResult {
+	routes := make(map[string][]string)
+	keys, byKey := splitByResource(result, func(resource *resourcepb.Resource) string {
+		exporters := p.route(resource, result.CloudWatch)
+		key := strings.Join(exporters, ",")
+		routes[key] = exporters
+		return key
+	})
+	routed := make([]*PaseResult, 0, len(keys))
+	for _, key := range keys {
+		r := byKey[key]
+		r.Exporters = routes[key]
+		routed = append(routed, r)
+	}
+	return routed
+}
+
+// splitByResource groups the resources of the result by keyOf, and returns the keys in order of appearance.
+func splitByResource(result *PaseResult, keyOf func(*resourcepb.Resource) string) ([]string, map[string]*PaseResult) {
+	var (
+		keys  []string
+		byKey = make(map[string]*PaseResult)
+	)
+	resultOf := func(resource *resourcepb.Resource) *PaseResult {
+		key := keyOf(resource)
+		if r, ok := byKey[key]; ok {
+			return r
+		}
+		r := &PaseResult{CloudWatch: result.CloudWatch, Exporters: result.Exporters}
+		byKey[key] = r
+		keys = append(keys, key)
+		return r
+	}
+	for _, resourceSpans := range result.Traces.GetResourceSpans() {
+		r := resultOf(resourceSpans.GetResource())
+		if r.Traces == nil {
+			r.Traces = &tracepb.TracesData{}
+		}
+		r.Traces.ResourceSpans = append(r.Traces.ResourceSpans, resourceSpans)
+	}
+	for _, resourceMetrics := range result.Metrics.GetResourceMetrics() {
+		r := resultOf(resourceMetrics.GetResource())
+		if r.Metrics == nil {
+			r.Metrics = &metricspb.MetricsData{}
+		}
+		r.Metrics.ResourceMetrics = append(r.Metrics.ResourceMetrics, resourceMetrics)
+	}
+	for _, resourceLogs := range result.Logs.GetResourceLogs() {
+		r := resultOf(resourceLogs.GetResource())
+		if r.Logs == nil {
+			r.Logs = &logspb.LogsData{}
+		}
+		r.Logs.ResourceLogs = append(r.Logs.ResourceLogs, resourceLogs)
+	}
+	return keys, byKey
+}
+
+func lookupAttribute(attrs []*commonpb.KeyValue, key string) (string, bool) {
+	for _, attr := range attrs {
+		if attr.GetKey() == key {
+			return AnyValueString(attr.GetValue()), true
+		}
+	}
+	return "", false
+}
+
+// routeKey identifies the exporters of the result, empty for all exporters.
+func routeKey(result *PaseResult) string {
+	return strings.Join(result.Exporters, ",")
+}
+
+// toBatchParseResultsByRoute batches the results that are routed to the same exporters.
+func toBatchParseResultsByRoute(results []*PaseResult) []*PaseResult {
+	var (
+		keys    []string
+		byRoute = make(map[string][]*PaseResult)
+	)
+	for _, result := range results {
+		key := routeKey(result)
+		if _, ok := byRoute[key]; !ok {
+			keys = append(keys, key)
+		}
+		byRoute[key] = append(byRoute[key], result)
+	}
+	batched := make([]*PaseResult, 0, len(keys))
+	for _, key := range keys {
+		batch := ToBatchParseResult(byRoute[key]...)
+		batch.Exporters = byRoute[key][0].Exporters
+		batched = append(batched, batch)
+	}
+	return batched
+}
+
+// flushByRoute calls fn with a batch per route, and returns the joined errors.
+func flushByRoute(results []*PaseResult, fn func(*PaseResult) error) error {
+	var errs []error
+	for _, batch := range toBatchParseResultsByRoute(results) {
+		if err := fn(batch); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,125 @@
+package jsonlotelforwarder_test
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+
+	otlpmux "github.com/mashiike/go-otlp-helper/otlp"
+	"github.com/mashiike/go-otlp-helper/otlp/otlptest"
+	jsonlotelforwarder "github.com/mashiike/jsonl-otel-forwarder"
+	"github.com/stretchr/testify/require"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+)
+
+func newResourceLogs(attrs ...*commonpb.KeyValue) *logspb.ResourceLogs {
+	return &logspb.ResourceLogs{
+		Resource:  &resourcepb.Resource{Attributes: attrs},
+		ScopeLogs: []*logspb.ScopeLogs{{LogRecords: []*logspb.LogRecord{{TimeUnixNano: 1}}}},
+	}
+}
+
+func TestRoutingProcessor(t *testing.T) {
+	selfMetrics := jsonlotelforwarder.NewSelfMetrics()
+	p, err := jsonlotelforwarder.NewRoutingProcessor(&jsonlotelforwarder.RoutingConfig{
+		Routes: []*jsonlotelforwarder.RoutingRoute{
+			{Attribute: "service.namespace", Values: []string{"payments"}, Exporters: []string{"payments"}},
+			{Attribute: "cloudwatch:log_group", Values: []string{"/aws/lambda/search-*"}, Exporters: []string{"search", "archive"}},
+		},
+		Default: []string{"archive"},
+	}, []string{"payments", "search", "archive"}, selfMetrics)
+	require.NoError(t, err)
+
+	result := &jsonlotelforwarder.PaseResult{
+		Logs: &logspb.LogsData{ResourceLogs: []*logspb.ResourceLogs{
+			newResourceLogs(stringAttr("service.namespace", "payments"), stringAttr("service.name", "checkout")),
+			newResourceLogs(stringAttr("service.name", "batch")),
+			newResourceLogs(stringAttr("service.namespace", "payments"), stringAttr("service.name", "refund")),
+		}},
+	}
+	fromCloudWatch := &jsonlotelforwarder.PaseResult{
+		Logs:       &logspb.LogsData{ResourceLogs: []*logspb.ResourceLogs{newResourceLogs(stringAttr("service.name", "indexer"))}},
+		CloudWatch: &jsonlotelforwarder.CloudWatchMetadata{LogGroup: "/aws/lambda/search-indexer"},
+	}
+	results, err := p.Process(context.Background(), []*jsonlotelforwarder.PaseResult{result, fromCloudWatch})
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+
+	require.Equal(t, []string{"payments"}, results[0].Exporters)
+	require.Len(t, results[0].Logs.GetResourceLogs(), 2)
+	require.Equal(t, []string{"archive"}, results[1].Exporters)
+	require.Len(t, results[1].Logs.GetResourceLogs(), 1)
+	require.Equal(t, []string{"search", "archive"}, results[2].Exporters)
+	require.Equal(t, "/aws/lambda/search-indexer", results[2].CloudWatch.LogGroup)
+	require.Equal(t, map[string]int64{
+		"routing.matched_resources": 3,
+		"routing.default_resources": 1,
+	}, selfMetrics.Snapshot())
+}
+
+func TestNewRoutingProcessor__Invalid(t *testing.T) {
+	exporters := []string{"default"}
+	for _, config := range []*jsonlotelforwarder.RoutingConfig{
+		{Routes: []*jsonlotelforwarder.RoutingRoute{{Values: []string{"a"}, Exporters: exporters}}},
+		{Routes: []*jsonlotelforwarder.RoutingRoute{{Attribute: "service.namespace", Exporters: exporters}}},
+		{Routes: []*jsonlotelforwarder.RoutingRoute{{Attribute: "service.namespace", Values: []string{"["}, Exporters: exporters}}},
+		{Routes: []*jsonlotelforwarder.RoutingRoute{{Attribute: "service.namespace", Values: []string{"a"}}}},
+		{Routes: []*jsonlotelforwarder.RoutingRoute{{Attribute: "service.namespace", Values: []string{"a"}, Exporters: []string{"unknown"}}}},
+		{Routes: []*jsonlotelforwarder.RoutingRoute{{Attribute: "service.namespace", Values: []string{"a"}, Exporters: exporters}}, Default: []string{"unknown"}},
+	} {
+		_, err := jsonlotelforwarder.NewRoutingProcessor(config, exporters, nil)
+		require.Error(t, err)
+	}
+}
+
+func TestForwarder__Routing(t *testing.T) {
+	newServer := func(services *[]string, mu *sync.Mutex) *otlptest.Server {
+		mux := otlpmux.NewServerMux()
+		mux.Logs().HandleFunc(func(ctx context.Context, request *otlpmux.LogsRequest) (*otlpmux.LogsResponse, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			for _, resourceLogs := range request.GetResourceLogs() {
+				*services = append(*services, attributesToMap(resourceLogs.GetResource().GetAttributes())["service.name"].(string))
+			}
+			return &otlpmux.LogsResponse{}, nil
+		})
+		return otlptest.NewServer(mux)
+	}
+	var (
+		mu             sync.Mutex
+		teamA, archive []string
+	)
+	teamAServer := newServer(&teamA, &mu)
+	defer teamAServer.Close()
+	archiveServer := newServer(&archive, &mu)
+	defer archiveServer.Close()
+
+	logs, err := os.ReadFile("testdata/logs.json")
+	require.NoError(t, err)
+	opts := jsonlotelforwarder.DefaultOptions()
+	opts.Batch = true
+	opts.ConfigFile = writeConfig(t, map[string]any{
+		"exporters": []map[string]any{
+			{"name": "team-a", "endpoint": teamAServer.URL, "protocol": "grpc"},
+			{"name": "archive", "endpoint": archiveServer.URL, "protocol": "grpc"},
+		},
+		"routing": map[string]any{
+			"routes": []map[string]any{
+				{"attribute": "cloudwatch:log_group", "values": []string{"test-*"}, "exporters": []string{"team-a"}},
+			},
+			"default": []string{"archive"},
+		},
+	})
+	forwarder, err := jsonlotelforwarder.New(opts)
+	require.NoError(t, err)
+
+	_, err = forwarder.Invoke(context.Background(), EncodeSubscriptionFilterEvent(t, [][]byte{logs}))
+	require.NoError(t, err)
+	_, err = forwarder.Invoke(context.Background(), logs)
+	require.NoError(t, err)
+	require.Len(t, teamA, 1, "logs delivered by the subscription filter are routed by the log group")
+	require.Len(t, archive, 1, "others are routed to the default")
+}
@@ -0,0 +1,120 @@
+package jsonlotelforwarder
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// maxSamplingThreshold is 2^56, the number of possible randomness values of W3C trace context level 2.
+const maxSamplingThreshold = uint64(1) << 56
+
+// TraceIDRandomness returns the 56 bits randomness of the trace ID, the least significant 7 bytes.
+func TraceIDRandomness(traceID []byte) uint64 {
+	if len(traceID) < 7 {
+		var buf [8]byte
+		copy(buf[8-len(traceID):], traceID)
+		return binary.BigEndian.Uint64(buf[:]) & (maxSamplingThreshold - 1)
+	}
+	var buf [8]byte
+	copy(buf[1:], traceID[len(traceID)-7:])
+	return binary.BigEndian.Uint64(buf[:])
+}
+
+// probabilityToThreshold returns the rejection threshold of the probability.
+// the item is kept when its randomness is greater than or equal to the threshold.
+func probabilityToThreshold(probability float64) uint64 {
+	if probability >= 1 {
+		return 0
+	}
+	if probability <= 0 {
+		return maxSamplingThreshold
+	}
+	return uint64(math.Round((1 - probability) * float64(maxSamplingThreshold)))
+}
+
+// otelTraceState is the OpenTelemetry `ot` entry of W3C tracestate, e.g. `ot=th:c;rv:1234567890abcd`.
+type otelTraceState struct {
+	threshold     uint64
+	hasThreshold  bool
+	randomness    uint64
+	hasRandomness bool
+}
+
+func parseOTelTraceState(traceState string) otelTraceState {
+	var ts otelTraceState
+	for _, member := range strings.Split(traceState, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(member), "=")
+		if !ok || key != "ot" {
+			continue
+		}
+		for _, field := range strings.Split(value, ";") {
+			name, v, ok := strings.Cut(field, ":")
+			if !ok {
+				continue
+			}
+			switch name {
+			case "th":
+				if threshold, ok := parseSamplingThreshold(v); ok {
+					ts.threshold, ts.hasThreshold = threshold, true
+				}
+			case "rv":
+				if len(v) != 14 {
+					continue
+				}
+				if randomness, err := strconv.ParseUint(v, 16, 64); err == nil {
+					ts.randomness, ts.hasRandomness = randomness, true
+				}
+			}
+		}
+	}
+	return ts
+}
+
+// parseSamplingThreshold parses the th value, 1 to 14 hex digits with trailing zeros omitted.
+func parseSamplingThreshold(s string) (uint64, bool) {
+	if s == "" || len(s) > 14 {
+		return 0, false
+	}
+	threshold, err := strconv.ParseUint(s+strings.Repeat("0", 14-len(s)), 16, 64)
+	if err != nil {
+		return 0, false
+	}
+	return threshold, true
+}
+
+func formatSamplingThreshold(threshold uint64) string {
+	if threshold == 0 {
+		return "0"
+	}
+	return strings.TrimRight(fmt.Sprintf("%014x", threshold), "0")
+}
+
+// withSamplingThreshold returns the trace state whose `ot` entry has th of the threshold.
+// the `ot` entry is moved to the front as W3C tracestate requires for modified entries.
+func withSamplingThreshold(traceState string, threshold uint64) string {
+	th := "th:" + formatSamplingThreshold(threshold)
+	entry := "ot=" + th
+	var others []string
+	for _, member := range strings.Split(traceState, ",") {
+		member = strings.TrimSpace(member)
+		if member == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(member, "=")
+		if key != "ot" {
+			others = append(others, member)
+			continue
+		}
+		fields := []string{th}
+		for _, field := range strings.Split(value, ";") {
+			if field != "" && !strings.HasPrefix(field, "th:") {
+				fields = append(fields, field)
+			}
+		}
+		entry = "ot=" + strings.Join(fields, ";")
+	}
+	return strings.Join(append([]string{entry}, others...), ",")
+}
@@ -0,0 +1,15 @@
+package jsonlotelforwarder_test
+
+import (
+	"testing"
+
+	jsonlotelforwarder "github.com/mashiike/jsonl-otel-forwarder"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTraceIDRandomness(t *testing.T) {
+	id := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}
+	require.Equal(t, uint64(1), jsonlotelforwarder.TraceIDRandomness(id))
+	id[9] = 0x80
+	require.Equal(t, uint64(0x80000000000001), jsonlotelforwarder.TraceIDRandomness(id))
+}
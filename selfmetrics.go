@@ -0,0 +1,78 @@
+package jsonlotelforwarder
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+)
+
+// SelfMetrics holds counters about the forwarder itself, e.g. the number of redacted fields.
+type SelfMetrics struct {
+	mu       sync.Mutex
+	counters map[string]int64
+	reported map[string]int64
+}
+
+func NewSelfMetrics() *SelfMetrics {
+	return &SelfMetrics{
+		counters: make(map[string]int64),
+		reported: make(map[string]int64),
+	}
+}
+
+func (m *SelfMetrics) Add(name string, delta int64) {
+	if m == nil || delta == 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counters[name] += delta
+}
+
+func (m *SelfMetrics) Get(name string) int64 {
+	if m == nil {
+		return 0
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.counters[name]
+}
+
+func (m *SelfMetrics) Snapshot() map[string]int64 {
+	if m == nil {
+		return map[string]int64{}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snapshot := make(map[string]int64, len(m.counters))
+	for name, value := range m.counters {
+		snapshot[name] = value
+	}
+	return snapshot
+}
+
+// Report logs the counters that changed since the last report.
+func (m *SelfMetrics) Report(ctx context.Context) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	names := make([]string, 0, len(m.counters))
+	for name, value := range m.counters {
+		if m.reported[name] != value {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	attrs := make([]any, 0, len(names))
+	for _, name := range names {
+		attrs = append(attrs, slog.Int64(name, m.counters[name]-m.reported[name]))
+		m.reported[name] = m.counters[name]
+	}
+	m.mu.Unlock()
+	if len(attrs) == 0 {
+		return
+	}
+	slog.InfoContext(ctx, "self metrics", attrs...)
+}
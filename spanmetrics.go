@@ -0,0 +1,218 @@
+package jsonlotelforwarder
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// DefaultSpanMetricsBuckets are the explicit bounds of the duration histogram.
+var DefaultSpanMetricsBuckets = []string{"2ms", "4ms", "6ms", "8ms", "10ms", "50ms", "100ms", "200ms", "400ms", "800ms", "1s", "1400ms", "2s", "5s", "10s", "15s"}
+
+// SpanMetricsConfig is the configuration of span metrics connector.
+// it generates request count, error count and duration histogram per resource, span name, span kind and Dimensions.
+// the generated metrics have the resource attributes of the spans, so routing and tenant headers apply to them.
+type SpanMetricsConfig struct {
+	Enabled    bool     `json:"enabled"`
+	Namespace  string   `json:"namespace,omitempty"`
+	Buckets    []string `json:"buckets,omitempty"`
+	Dimensions []string `json:"dimensions,omitempty"`
+}
+
+type SpanMetricsConnector struct {
+	namespace   string
+	bounds      []float64
+	dimensions  []string
+	selfMetrics *SelfMetrics
+}
+
+func NewSpanMetricsConnector(config *SpanMetricsConfig, selfMetrics *SelfMetrics) (*SpanMetricsConnector, error) {
+	c := &SpanMetricsConnector{
+		namespace:   config.Namespace,
+		dimensions:  config.Dimensions,
+		selfMetrics: selfMetrics,
+	}
+	if c.namespace == "" {
+		c.namespace = "traces.span.metrics"
+	}
+	buckets := config.Buckets
+	if len(buckets) == 0 {
+		buckets = DefaultSpanMetricsBuckets
+	}
+	for i, bucket := range buckets {
+		d, err := time.ParseDuration(bucket)
+		if err != nil {
+			return nil, fmt.Errorf("buckets[%d]: %w", i, err)
+		}
+		bound := float64(d) / float64(time.Millisecond)
+		if len(c.bounds) > 0 && bound <= c.bounds[len(c.bounds)-1] {
+			return nil, fmt.Errorf("buckets[%d]: %s must be greater than the previous bucket", i, bucket)
+		}
+		c.bounds = append(c.bounds, bound)
+	}
+	return c, nil
+}
+
+type spanMetricsSeries struct {
+	attributes []*commonpb.KeyValue
+	start      uint64
+	end        uint64
+	calls      uint64
+	errors     uint64
+	sum        float64
+	min        float64
+	max        float64
+	counts     []uint64
+}
+
+type spanMetricsResource struct {
+	resource *resourcepb.Resource
+	series   map[string]*spanMetricsSeries
+	order    []string
+}
+
+// Process appends the metrics generated from each traces result, next to it.
+// the traces results are left as is, so it should be placed before sampling processors.
+func (c *SpanMetricsConnector) Process(_ context.Context, results []*PaseResult) ([]*PaseResult, error) {
+	generated := make([]*PaseResult, 0, len(results))
+	for _, result := range results {
+		generated = append(generated, result)
+		if result.Traces == nil {
+			continue
+		}
+		metrics := c.generate(result.Traces)
+		if metrics == nil {
+			continue
+		}
+		generated = append(generated, &PaseResult{Metrics: metrics, CloudWatch: result.CloudWatch})
+	}
+	return generated, nil
+}
+
+func (c *SpanMetricsConnector) generate(traces *tracepb.TracesData) *metricspb.MetricsData {
+	resources := make(map[string]*spanMetricsResource)
+	var resourceOrder []string
+	for _, resourceSpans := range traces.GetResourceSpans() {
+		key := AttributesKey(resourceSpans.GetResource().GetAttributes())
+		resource, ok := resources[key]
+		if !ok {
+			resource = &spanMetricsResource{
+				resource: &resourcepb.Resource{Attributes: cloneMessages(resourceSpans.GetResource().GetAttributes())},
+				series:   make(map[string]*spanMetricsSeries),
+			}
+			resources[key] = resource
+			resourceOrder = append(resourceOrder, key)
+		}
+		for _, scopeSpans := range resourceSpans.GetScopeSpans() {
+			for _, span := range scopeSpans.GetSpans() {
+				c.record(resource, span)
+			}
+		}
+	}
+	if len(resourceOrder) == 0 {
+		return nil
+	}
+	metrics := &metricspb.MetricsData{}
+	for _, key := range resourceOrder {
+		resource := resources[key]
+		if len(resource.order) == 0 {
+			continue
+		}
+		metrics.ResourceMetrics = append(metrics.ResourceMetrics, &metricspb.ResourceMetrics{
+			Resource: resource.resource,
+			ScopeMetrics: []*metricspb.ScopeMetrics{
+				{
+					Scope:   &commonpb.InstrumentationScope{Name: "jsonl-otel-forwarder/spanmetrics", Version: Version},
+					Metrics: c.metrics(resource),
+				},
+			},
+		})
+	}
+	if len(metrics.ResourceMetrics) == 0 {
+		return nil
+	}
+	return metrics
+}
+
+func (c *SpanMetricsConnector) record(resource *spanMetricsResource, span *tracepb.Span) {
+	attrs := []*commonpb.KeyValue{
+		{Key: "span.name", Value: toAnyValue(span.GetName())},
+		{Key: "span.kind", Value: toAnyValue(span.GetKind().String())},
+	}
+	attrs = append(attrs, lookupAttributes(span.GetAttributes(), c.dimensions)...)
+	key := AttributesKey(attrs)
+	series, ok := resource.series[key]
+	if !ok {
+		series = &spanMetricsSeries{
+			attributes: attrs,
+			start:      span.GetStartTimeUnixNano(),
+			counts:     make([]uint64, len(c.bounds)+1),
+		}
+		resource.series[key] = series
+		resource.order = append(resource.order, key)
+	}
+	var duration float64
+	if span.GetEndTimeUnixNano() > span.GetStartTimeUnixNano() {
+		duration = float64(span.GetEndTimeUnixNano()-span.GetStartTimeUnixNano()) / float64(time.Millisecond)
+	}
+	if series.calls == 0 || duration < series.min {
+		series.min = duration
+	}
+	if series.calls == 0 || duration > series.max {
+		series.max = duration
+	}
+	series.start = min(series.start, span.GetStartTimeUnixNano())
+	series.end = max(series.end, span.GetEndTimeUnixNano())
+	series.calls++
+	if span.GetStatus().GetCode() == tracepb.Status_STATUS_CODE_ERROR {
+		series.errors++
+	}
+	series.sum += duration
+	// bucket i counts durations in (bounds[i-1], bounds[i]].
+	i, _ := slices.BinarySearch(c.bounds, duration)
+	series.counts[i]++
+	c.selfMetrics.Add("span_metrics.spans", 1)
+}
+
+func (c *SpanMetricsConnector) metrics(resource *spanMetricsResource) []*metricspb.Metric {
+	calls := &metricspb.Sum{AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA, IsMonotonic: true}
+	errors := &metricspb.Sum{AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA, IsMonotonic: true}
+	duration := &metricspb.Histogram{AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA}
+	for _, key := range resource.order {
+		series := resource.series[key]
+		calls.DataPoints = append(calls.DataPoints, &metricspb.NumberDataPoint{
+			Attributes:        cloneMessages(series.attributes),
+			StartTimeUnixNano: series.start,
+			TimeUnixNano:      series.end,
+			Value:             &metricspb.NumberDataPoint_AsInt{AsInt: int64(series.calls)},
+		})
+		errors.DataPoints = append(errors.DataPoints, &metricspb.NumberDataPoint{
+			Attributes:        cloneMessages(series.attributes),
+			StartTimeUnixNano: series.start,
+			TimeUnixNano:      series.end,
+			Value:             &metricspb.NumberDataPoint_AsInt{AsInt: int64(series.errors)},
+		})
+		duration.DataPoints = append(duration.DataPoints, &metricspb.HistogramDataPoint{
+			Attributes:        cloneMessages(series.attributes),
+			StartTimeUnixNano: series.start,
+			TimeUnixNano:      series.end,
+			Count:             series.calls,
+			Sum:               &series.sum,
+			Min:               &series.min,
+			Max:               &series.max,
+			BucketCounts:      series.counts,
+			ExplicitBounds:    slices.Clone(c.bounds),
+		})
+	}
+	return []*metricspb.Metric{
+		{Name: c.namespace + ".calls", Description: "number of spans", Unit: "{span}", Data: &metricspb.Metric_Sum{Sum: calls}},
+		{Name: c.namespace + ".errors", Description: "number of spans with ERROR status", Unit: "{span}", Data: &metricspb.Metric_Sum{Sum: errors}},
+		{Name: c.namespace + ".duration", Description: "duration of spans", Unit: "ms", Data: &metricspb.Metric_Histogram{Histogram: duration}},
+	}
+}
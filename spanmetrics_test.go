@@ -0,0 +1,75 @@
+package jsonlotelforwarder_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/mashiike/go-otlp-helper/otlp"
+	jsonlotelforwarder "github.com/mashiike/jsonl-otel-forwarder"
+	"github.com/stretchr/testify/require"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+func TestSpanMetricsConnector(t *testing.T) {
+	selfMetrics := jsonlotelforwarder.NewSelfMetrics()
+	c, err := jsonlotelforwarder.NewSpanMetricsConnector(&jsonlotelforwarder.SpanMetricsConfig{
+		Enabled:    true,
+		Buckets:    []string{"10ms", "100ms", "1s"},
+		Dimensions: []string{"http.route"},
+	}, selfMetrics)
+	require.NoError(t, err)
+	input := loadParseResults(t, "testdata/span_metrics_trace.json")
+	results, err := c.Process(context.Background(), input)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	require.Same(t, input[0], results[0], "traces are left as is")
+	require.NotNil(t, results[1].Metrics)
+	bs, err := otlp.MarshalIndentJSON(results[1].Metrics, "  ")
+	require.NoError(t, err)
+	t.Log("actual:", string(bs))
+	expected, err := os.ReadFile("testdata/span_metrics.json")
+	require.NoError(t, err)
+	require.JSONEq(t, string(expected), string(bs))
+	require.EqualValues(t, 4, selfMetrics.Get("span_metrics.spans"))
+}
+
+func TestSpanMetricsConnector__Resource(t *testing.T) {
+	newResourceSpans := func(namespace string, names ...string) *tracepb.ResourceSpans {
+		var spans []*tracepb.Span
+		for _, name := range names {
+			spans = append(spans, &tracepb.Span{Name: name})
+		}
+		return &tracepb.ResourceSpans{
+			Resource:   &resourcepb.Resource{Attributes: []*commonpb.KeyValue{stringAttr("service.namespace", namespace), stringAttr("service.name", "checkout")}},
+			ScopeSpans: []*tracepb.ScopeSpans{{Spans: spans}},
+		}
+	}
+	c, err := jsonlotelforwarder.NewSpanMetricsConnector(&jsonlotelforwarder.SpanMetricsConfig{Enabled: true}, nil)
+	require.NoError(t, err)
+	results, err := c.Process(context.Background(), []*jsonlotelforwarder.PaseResult{{
+		Traces: &tracepb.TracesData{ResourceSpans: []*tracepb.ResourceSpans{
+			newResourceSpans("team-a", "a1"),
+			newResourceSpans("team-b", "b1"),
+			newResourceSpans("team-a", "a2"),
+		}},
+	}})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	resourceMetrics := results[1].Metrics.GetResourceMetrics()
+	require.Len(t, resourceMetrics, 2, "the metrics are grouped by the full resource")
+	for i, namespace := range []string{"team-a", "team-b"} {
+		require.Equal(t, map[string]any{"service.namespace": namespace, "service.name": "checkout"}, attributesToMap(resourceMetrics[i].GetResource().GetAttributes()))
+	}
+	require.Len(t, resourceMetrics[0].GetScopeMetrics()[0].GetMetrics()[0].GetSum().GetDataPoints(), 2)
+	require.Len(t, resourceMetrics[1].GetScopeMetrics()[0].GetMetrics()[0].GetSum().GetDataPoints(), 1)
+}
+
+func TestNewSpanMetricsConnector__Invalid(t *testing.T) {
+	for _, buckets := range [][]string{{"fast"}, {"100ms", "10ms"}} {
+		_, err := jsonlotelforwarder.NewSpanMetricsConnector(&jsonlotelforwarder.SpanMetricsConfig{Enabled: true, Buckets: buckets}, nil)
+		require.Error(t, err, buckets)
+	}
+}
@@ -0,0 +1,241 @@
+package jsonlotelforwarder
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// TailSamplingConfig is the configuration of tail sampling processor.
+// a trace is kept when any policy samples it, and the first matched policy name is recorded as DecisionAttribute on its spans.
+type TailSamplingConfig struct {
+	Policies          []*TailSamplingPolicy `json:"policies,omitempty"`
+	DecisionAttribute string                `json:"decision_attribute,omitempty"`
+}
+
+func (c *TailSamplingConfig) Enabled() bool {
+	return c != nil && len(c.Policies) > 0
+}
+
+// TailSamplingPolicy is one of the following types.
+//
+//   - status_code: any span has ERROR status.
+//   - latency: the root span (or the whole trace if the root span is missing) takes Threshold or longer.
+//   - attribute: any span has the attribute Key, matching one of Values or the Pattern.
+//   - probabilistic: Percentage of traces, decided by trace ID.
+type TailSamplingPolicy struct {
+	Name       string   `json:"name,omitempty"`
+	Type       string   `json:"type"`
+	Threshold  string   `json:"threshold,omitempty"`
+	Key        string   `json:"key,omitempty"`
+	Values     []string `json:"values,omitempty"`
+	Pattern    string   `json:"pattern,omitempty"`
+	Percentage float64  `json:"percentage,omitempty"`
+}
+
+// ParseTailSamplingPolicies parses comma separated policies of `type[:arg]` form,
+// e.g. status_code,latency:500ms,attribute:user.tier=vip,probabilistic:10
+func ParseTailSamplingPolicies(s string) ([]*TailSamplingPolicy, error) {
+	var policies []*TailSamplingPolicy
+	for _, part := range splitList(s) {
+		typ, arg, _ := strings.Cut(part, ":")
+		policy := &TailSamplingPolicy{Type: typ}
+		switch typ {
+		case "status_code":
+		case "latency":
+			policy.Threshold = arg
+		case "attribute":
+			key, value, ok := strings.Cut(arg, "=")
+			if !ok {
+				return nil, fmt.Errorf("attribute policy %q requires key=value", part)
+			}
+			policy.Key = key
+			policy.Values = []string{value}
+		case "probabilistic":
+			percentage, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				return nil, fmt.Errorf("probabilistic policy %q requires percentage: %w", part, err)
+			}
+			policy.Percentage = percentage
+		default:
+			return nil, fmt.Errorf("unknown tail sampling policy type %q", typ)
+		}
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
+
+type tailSamplingPolicy struct {
+	name   string
+	sample func(spans []*tracepb.Span) bool
+}
+
+type TailSamplingProcessor struct {
+	policies          []*tailSamplingPolicy
+	decisionAttribute string
+	selfMetrics       *SelfMetrics
+}
+
+func NewTailSamplingProcessor(config *TailSamplingConfig, selfMetrics *SelfMetrics) (*TailSamplingProcessor, error) {
+	p := &TailSamplingProcessor{
+		decisionAttribute: config.DecisionAttribute,
+		selfMetrics:       selfMetrics,
+	}
+	if p.decisionAttribute == "" {
+		p.decisionAttribute = "sampling.tail.policy"
+	}
+	for i, policy := range config.Policies {
+		compiled, err := policy.compile()
+		if err != nil {
+			return nil, fmt.Errorf("policies[%d]: %w", i, err)
+		}
+		p.policies = append(p.policies, compiled)
+	}
+	return p, nil
+}
+
+func (policy *TailSamplingPolicy) compile() (*tailSamplingPolicy, error) {
+	compiled := &tailSamplingPolicy{name: policy.Name}
+	if compiled.name == "" {
+		compiled.name = policy.Type
+	}
+	switch policy.Type {
+	case "status_code":
+		compiled.sample = func(spans []*tracepb.Span) bool {
+			return slices.ContainsFunc(spans, func(span *tracepb.Span) bool {
+				return span.GetStatus().GetCode() == tracepb.Status_STATUS_CODE_ERROR
+			})
+		}
+	case "latency":
+		threshold, err := time.ParseDuration(policy.Threshold)
+		if err != nil {
+			return nil, fmt.Errorf("latency policy requires threshold duration: %w", err)
+		}
+		compiled.sample = func(spans []*tracepb.Span) bool {
+			return traceDuration(spans) >= threshold
+		}
+	case "attribute":
+		if policy.Key == "" {
+			return nil, fmt.Errorf("attribute policy requires key")
+		}
+		var re *regexp.Regexp
+		if policy.Pattern != "" {
+			var err error
+			if re, err = regexp.Compile(policy.Pattern); err != nil {
+				return nil, fmt.Errorf("attribute policy pattern: %w", err)
+			}
+		}
+		compiled.sample = func(spans []*tracepb.Span) bool {
+			return slices.ContainsFunc(spans, func(span *tracepb.Span) bool {
+				return slices.ContainsFunc(span.GetAttributes(), func(attr *commonpb.KeyValue) bool {
+					if attr.GetKey() != policy.Key {
+						return false
+					}
+					value := AnyValueString(attr.GetValue())
+					if re != nil && re.MatchString(value) {
+						return true
+					}
+					return slices.Contains(policy.Values, value)
+				})
+			})
+		}
+	case "probabilistic":
+		if policy.Percentage < 0 || policy.Percentage > 100 {
+			return nil, fmt.Errorf("probabilistic policy percentage must be between 0 and 100")
+		}
+		threshold := probabilityToThreshold(policy.Percentage / 100)
+		compiled.sample = func(spans []*tracepb.Span) bool {
+			return TraceIDRandomness(spans[0].GetTraceId()) >= threshold
+		}
+	default:
+		return nil, fmt.Errorf("unknown policy type %q", policy.Type)
+	}
+	return compiled, nil
+}
+
+// traceDuration returns the duration of the root span, or from the earliest start to the latest end of the spans if
+// the root span is not in spans or has not ended. unended spans (without end time) have no duration and are ignored,
+// and an end before the start is the duration 0.
+func traceDuration(spans []*tracepb.Span) time.Duration {
+	var start, end uint64
+	var ended bool
+	for _, span := range spans {
+		if span.GetEndTimeUnixNano() == 0 {
+			continue
+		}
+		if len(span.GetParentSpanId()) == 0 {
+			return durationBetween(span.GetStartTimeUnixNano(), span.GetEndTimeUnixNano())
+		}
+		if !ended || span.GetStartTimeUnixNano() < start {
+			start = span.GetStartTimeUnixNano()
+		}
+		end = max(end, span.GetEndTimeUnixNano())
+		ended = true
+	}
+	if !ended {
+		return 0
+	}
+	return durationBetween(start, end)
+}
+
+// durationBetween returns the duration from start to end, clamped to 0 when end is before start.
+func durationBetween(start, end uint64) time.Duration {
+	if end < start {
+		return 0
+	}
+	return time.Duration(end - start)
+}
+
+func (p *TailSamplingProcessor) Process(_ context.Context, results []*PaseResult) ([]*PaseResult, error) {
+	var resourceSpans []*tracepb.ResourceSpans
+	for _, result := range results {
+		resourceSpans = append(resourceSpans, result.Traces.GetResourceSpans()...)
+	}
+	if len(resourceSpans) == 0 {
+		return results, nil
+	}
+	spansByTraceID, traceIDs := groupSpansByTraceID(resourceSpans)
+	keep := make(map[*tracepb.Span]struct{})
+	for _, traceID := range traceIDs {
+		spans := spansByTraceID[traceID]
+		policy := p.decide(spans)
+		if policy == nil {
+			p.selfMetrics.Add("tail_sampling.dropped_traces", 1)
+			p.selfMetrics.Add("tail_sampling.dropped_spans", int64(len(spans)))
+			continue
+		}
+		p.selfMetrics.Add("tail_sampling.sampled_traces", 1)
+		p.selfMetrics.Add("tail_sampling.sampled_traces."+policy.name, 1)
+		for _, span := range spans {
+			span.Attributes = applyAttributeRule(span.GetAttributes(), "upsert", p.decisionAttribute, toAnyValue(policy.name))
+			keep[span] = struct{}{}
+		}
+	}
+	for _, result := range results {
+		if result.Traces == nil {
+			continue
+		}
+		FilterSpans(result, func(item *FilterItem) bool {
+			_, ok := keep[item.Span]
+			return ok
+		})
+		PruneResult(result)
+	}
+	return results, nil
+}
+
+func (p *TailSamplingProcessor) decide(spans []*tracepb.Span) *tailSamplingPolicy {
+	for _, policy := range p.policies {
+		if policy.sample(spans) {
+			return policy
+		}
+	}
+	return nil
+}
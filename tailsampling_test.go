@@ -0,0 +1,138 @@
+package jsonlotelforwarder_test
+
+import (
+	"context"
+	"testing"
+
+	jsonlotelforwarder "github.com/mashiike/jsonl-otel-forwarder"
+	"github.com/stretchr/testify/require"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+func traceID(b byte) []byte {
+	id := make([]byte, 16)
+	for i := range id {
+		id[i] = b
+	}
+	return id
+}
+
+func newTraceResult(spans ...*tracepb.Span) *jsonlotelforwarder.PaseResult {
+	return &jsonlotelforwarder.PaseResult{
+		Traces: &tracepb.TracesData{
+			ResourceSpans: []*tracepb.ResourceSpans{
+				{
+					Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{stringAttr("service.name", "checkout")}},
+					ScopeSpans: []*tracepb.ScopeSpans{
+						{Spans: spans},
+					},
+				},
+			},
+		},
+	}
+}
+
+func spanNames(results []*jsonlotelforwarder.PaseResult) []string {
+	var names []string
+	for _, result := range results {
+		for _, resourceSpans := range result.Traces.GetResourceSpans() {
+			for _, scopeSpans := range resourceSpans.GetScopeSpans() {
+				for _, span := range scopeSpans.GetSpans() {
+					name := span.GetName()
+					if policy, ok := attributesToMap(span.GetAttributes())["sampling.tail.policy"]; ok {
+						name += ":" + policy.(string)
+					}
+					names = append(names, name)
+				}
+			}
+		}
+	}
+	return names
+}
+
+func TestTailSamplingProcessor(t *testing.T) {
+	const ms = uint64(1000000)
+	results := []*jsonlotelforwarder.PaseResult{
+		newTraceResult(
+			&tracepb.Span{TraceId: traceID(1), SpanId: []byte{1, 1, 1, 1, 1, 1, 1, 1}, Name: "error-root", StartTimeUnixNano: 0, EndTimeUnixNano: 10 * ms},
+			&tracepb.Span{TraceId: traceID(2), SpanId: []byte{2, 2, 2, 2, 2, 2, 2, 2}, Name: "slow-root", StartTimeUnixNano: 0, EndTimeUnixNano: 600 * ms},
+			&tracepb.Span{TraceId: traceID(4), SpanId: []byte{4, 4, 4, 4, 4, 4, 4, 4}, Name: "fast-root", StartTimeUnixNano: 0, EndTimeUnixNano: 10 * ms},
+		),
+		newTraceResult(
+			&tracepb.Span{TraceId: traceID(1), ParentSpanId: []byte{1, 1, 1, 1, 1, 1, 1, 1}, Name: "error-child", Status: &tracepb.Status{Code: tracepb.Status_STATUS_CODE_ERROR}},
+			&tracepb.Span{TraceId: traceID(3), Name: "vip-root", Attributes: []*commonpb.KeyValue{stringAttr("user.tier", "vip")}},
+			&tracepb.Span{TraceId: traceID(4), ParentSpanId: []byte{4, 4, 4, 4, 4, 4, 4, 4}, Name: "fast-child"},
+		),
+	}
+	selfMetrics := jsonlotelforwarder.NewSelfMetrics()
+	policies, err := jsonlotelforwarder.ParseTailSamplingPolicies("status_code,latency:500ms,attribute:user.tier=vip,probabilistic:0")
+	require.NoError(t, err)
+	p, err := jsonlotelforwarder.NewTailSamplingProcessor(&jsonlotelforwarder.TailSamplingConfig{Policies: policies}, selfMetrics)
+	require.NoError(t, err)
+	results, err = p.Process(context.Background(), results)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"error-root:status_code",
+		"slow-root:latency",
+		"error-child:status_code",
+		"vip-root:attribute",
+	}, spanNames(results))
+	require.Equal(t, map[string]int64{
+		"tail_sampling.sampled_traces":             3,
+		"tail_sampling.sampled_traces.status_code": 1,
+		"tail_sampling.sampled_traces.latency":     1,
+		"tail_sampling.sampled_traces.attribute":   1,
+		"tail_sampling.dropped_traces":             1,
+		"tail_sampling.dropped_spans":              2,
+	}, selfMetrics.Snapshot())
+}
+
+func TestTailSamplingProcessor__ProbabilisticFallback(t *testing.T) {
+	p, err := jsonlotelforwarder.NewTailSamplingProcessor(&jsonlotelforwarder.TailSamplingConfig{
+		Policies: []*jsonlotelforwarder.TailSamplingPolicy{
+			{Name: "errors", Type: "status_code"},
+			{Name: "rest", Type: "probabilistic", Percentage: 100},
+		},
+		DecisionAttribute: "sampling.tail.policy",
+	}, nil)
+	require.NoError(t, err)
+	results, err := p.Process(context.Background(), []*jsonlotelforwarder.PaseResult{
+		newTraceResult(&tracepb.Span{TraceId: traceID(5), Name: "root"}),
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"root:rest"}, spanNames(results))
+}
+
+func TestTailSamplingProcessor__LatencyWithInvalidTimes(t *testing.T) {
+	const ms = uint64(1000000)
+	parent := []byte{1, 1, 1, 1, 1, 1, 1, 1}
+	p, err := jsonlotelforwarder.NewTailSamplingProcessor(&jsonlotelforwarder.TailSamplingConfig{
+		Policies:          []*jsonlotelforwarder.TailSamplingPolicy{{Type: "latency", Threshold: "500ms"}},
+		DecisionAttribute: "sampling.tail.policy",
+	}, nil)
+	require.NoError(t, err)
+	results, err := p.Process(context.Background(), []*jsonlotelforwarder.PaseResult{newTraceResult(
+		&tracepb.Span{TraceId: traceID(1), Name: "unended-root", StartTimeUnixNano: 100 * ms},
+		&tracepb.Span{TraceId: traceID(2), Name: "reversed-root", StartTimeUnixNano: 100 * ms, EndTimeUnixNano: 50 * ms},
+		&tracepb.Span{TraceId: traceID(3), ParentSpanId: parent, Name: "unended-child", StartTimeUnixNano: 100 * ms},
+		&tracepb.Span{TraceId: traceID(3), ParentSpanId: parent, Name: "fast-child", StartTimeUnixNano: 100 * ms, EndTimeUnixNano: 110 * ms},
+		&tracepb.Span{TraceId: traceID(4), ParentSpanId: parent, Name: "reversed-child", StartTimeUnixNano: 100 * ms, EndTimeUnixNano: 50 * ms},
+		&tracepb.Span{TraceId: traceID(5), Name: "unended-slow-root", StartTimeUnixNano: 100 * ms},
+		&tracepb.Span{TraceId: traceID(5), ParentSpanId: parent, Name: "slow-child", StartTimeUnixNano: 100 * ms, EndTimeUnixNano: 700 * ms},
+	)})
+	require.NoError(t, err)
+	require.Equal(t, []string{"unended-slow-root:latency", "slow-child:latency"}, spanNames(results), "end before start and unended spans do not wrap around")
+}
+
+func TestParseTailSamplingPolicies__Invalid(t *testing.T) {
+	for _, s := range []string{"unknown", "attribute:key", "probabilistic:x"} {
+		_, err := jsonlotelforwarder.ParseTailSamplingPolicies(s)
+		require.Error(t, err, s)
+	}
+	_, err := jsonlotelforwarder.NewTailSamplingProcessor(&jsonlotelforwarder.TailSamplingConfig{
+		Policies: []*jsonlotelforwarder.TailSamplingPolicy{{Type: "latency", Threshold: "slow"}},
+	}, nil)
+	require.Error(t, err)
+}
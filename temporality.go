@@ -0,0 +1,726 @@
+package jsonlotelforwarder
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+)
+
+// TemporalityConfig is the configuration of temporality processor.
+// To is delta or cumulative, and Metrics are glob patterns of metric names to convert, all metrics when empty.
+// StateFile persists the state of each series across processes, e.g. /tmp on AWS Lambda.
+// the state of a series not seen for StateTTL (1h by default, 0s never expires) is removed,
+// and the least recently seen series beyond MaxSeries (10000 by default) are removed.
+type TemporalityConfig struct {
+	To        string   `json:"to"`
+	Metrics   []string `json:"metrics,omitempty"`
+	StateFile string   `json:"state_file,omitempty"`
+	StateTTL  string   `json:"state_ttl,omitempty"`
+	MaxSeries int      `json:"max_series,omitempty"`
+}
+
+const (
+	DefaultTemporalityStateTTL  = time.Hour
+	DefaultTemporalityMaxSeries = 10000
+)
+
+func (c *TemporalityConfig) Enabled() bool {
+	return c != nil && c.To != ""
+}
+
+// TemporalityStateStore keeps the last data point of each series.
+// keys are hex encoded hashes of the series identity, and values are protobuf encoded data points.
+type TemporalityStateStore interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Put(ctx context.Context, key string, value []byte) error
+	// Expire removes the values last put before the time, unless it is zero, and the least recently put values
+	// beyond maxSeries, unless it is zero. it returns the number of removed values.
+	Expire(ctx context.Context, before time.Time, maxSeries int) (int, error)
+	// Commit persists the values put since the last commit. it is called once per Process.
+	Commit(ctx context.Context) error
+}
+
+// temporalityState is a value of the state store with the time it was last put.
+type temporalityState struct {
+	Value    []byte `json:"value"`
+	LastSeen int64  `json:"last_seen"`
+}
+
+// expireTemporalityStates removes the expired states, see TemporalityStateStore.Expire.
+func expireTemporalityStates(states map[string]temporalityState, before time.Time, maxSeries int) int {
+	var removed int
+	if !before.IsZero() {
+		for key, state := range states {
+			if state.LastSeen < before.UnixNano() {
+				delete(states, key)
+				removed++
+			}
+		}
+	}
+	if maxSeries <= 0 || len(states) <= maxSeries {
+		return removed
+	}
+	keys := make([]string, 0, len(states))
+	for key := range states {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if states[keys[i]].LastSeen != states[keys[j]].LastSeen {
+			return states[keys[i]].LastSeen < states[keys[j]].LastSeen
+		}
+		return keys[i] < keys[j]
+	})
+	for _, key := range keys[:len(keys)-maxSeries] {
+		delete(states, key)
+		removed++
+	}
+	return removed
+}
+
+// MemoryTemporalityStateStore is a TemporalityStateStore for long-running modes.
+type MemoryTemporalityStateStore struct {
+	mu     sync.Mutex
+	states map[string]temporalityState
+}
+
+func NewMemoryTemporalityStateStore() *MemoryTemporalityStateStore {
+	return &MemoryTemporalityStateStore{states: make(map[string]temporalityState)}
+}
+
+func (s *MemoryTemporalityStateStore) Get(_ context.Context, key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.states[key]
+	return state.Value, ok, nil
+}
+
+func (s *MemoryTemporalityStateStore) Put(_ context.Context, key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.states[key] = temporalityState{Value: value, LastSeen: time.Now().UnixNano()}
+	return nil
+}
+
+func (s *MemoryTemporalityStateStore) Expire(_ context.Context, before time.Time, maxSeries int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return expireTemporalityStates(s.states, before, maxSeries), nil
+}
+
+func (s *MemoryTemporalityStateStore) Commit(_ context.Context) error {
+	return nil
+}
+
+// dryRunTemporalityStateStore reads the state of the base store, but keeps the changes in memory,
+// so a dry run does not change the state of the real runs.
+type dryRunTemporalityStateStore struct {
+	base    TemporalityStateStore
+	changes *MemoryTemporalityStateStore
+}
+
+func newDryRunTemporalityStateStore(base TemporalityStateStore) *dryRunTemporalityStateStore {
+	return &dryRunTemporalityStateStore{base: base, changes: NewMemoryTemporalityStateStore()}
+}
+
+func (s *dryRunTemporalityStateStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	if value, ok, _ := s.changes.Get(ctx, key); ok {
+		return value, true, nil
+	}
+	return s.base.Get(ctx, key)
+}
+
+func (s *dryRunTemporalityStateStore) Put(ctx context.Context, key string, value []byte) error {
+	return s.changes.Put(ctx, key, value)
+}
+
+func (s *dryRunTemporalityStateStore) Expire(ctx context.Context, before time.Time, maxSeries int) (int, error) {
+	return s.changes.Expire(ctx, before, maxSeries)
+}
+
+func (s *dryRunTemporalityStateStore) Commit(_ context.Context) error {
+	return nil
+}
+
+type TemporalityProcessor struct {
+	mu          sync.Mutex
+	to          metricspb.AggregationTemporality
+	metrics     []string
+	stateTTL    time.Duration
+	maxSeries   int
+	store       TemporalityStateStore
+	selfMetrics *SelfMetrics
+}
+
+// NewTemporalityProcessor returns a TemporalityProcessor. store may be nil, then the state is kept in memory,
+// or in StateFile if it is configured.
+func NewTemporalityProcessor(config *TemporalityConfig, store TemporalityStateStore, selfMetrics *SelfMetrics) (*TemporalityProcessor, error) {
+	p := &TemporalityProcessor{
+		metrics:     config.Metrics,
+		stateTTL:    DefaultTemporalityStateTTL,
+		maxSeries:   config.MaxSeries,
+		store:       store,
+		selfMetrics: selfMetrics,
+	}
+	switch strings.ToLower(config.To) {
+	case "delta":
+		p.to = metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+	case "cumulative":
+		p.to = metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+	default:
+		return nil, fmt.Errorf("unknown temporality %q, expected delta or cumulative", config.To)
+	}
+	for _, pattern := range p.metrics {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob %q: %w", pattern, err)
+		}
+	}
+	if config.StateTTL != "" {
+		ttl, err := time.ParseDuration(config.StateTTL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid state_ttl %q: %w", config.StateTTL, err)
+		}
+		if ttl < 0 {
+			return nil, fmt.Errorf("state_ttl must not be negative")
+		}
+		p.stateTTL = ttl
+	}
+	if p.maxSeries < 0 {
+		return nil, fmt.Errorf("max_series must not be negative")
+	}
+	if p.maxSeries == 0 {
+		p.maxSeries = DefaultTemporalityMaxSeries
+	}
+	if p.store == nil {
+		if config.StateFile != "" {
+			p.store = NewFileTemporalityStateStore(config.StateFile)
+		} else {
+			p.store = NewMemoryTemporalityStateStore()
+		}
+	}
+	return p, nil
+}
+
+func (p *TemporalityProcessor) match(metric *metricspb.Metric) bool {
+	if len(p.metrics) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(p.metrics, func(pattern string) bool {
+		matched, _ := path.Match(pattern, metric.GetName())
+		return matched
+	})
+}
+
+func (p *TemporalityProcessor) Process(ctx context.Context, results []*PaseResult) ([]*PaseResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// the states not seen for the ttl are removed before use, and the series beyond max series after the new ones are put.
+	if p.stateTTL > 0 {
+		if err := p.expire(ctx, time.Now().Add(-p.stateTTL), 0); err != nil {
+			return nil, err
+		}
+	}
+	for _, result := range results {
+		for _, resourceMetrics := range result.Metrics.GetResourceMetrics() {
+			for _, scopeMetrics := range resourceMetrics.GetScopeMetrics() {
+				prefix := strings.Join([]string{
+					AttributesKey(resourceMetrics.GetResource().GetAttributes()),
+					scopeMetrics.GetScope().GetName(),
+					scopeMetrics.GetScope().GetVersion(),
+					AttributesKey(scopeMetrics.GetScope().GetAttributes()),
+				}, "\x00")
+				for _, metric := range scopeMetrics.GetMetrics() {
+					if !p.match(metric) {
+						continue
+					}
+					if err := p.convert(ctx, prefix+"\x00"+metricIdentity(metric), metric); err != nil {
+						return nil, fmt.Errorf("metric %q: %w", metric.GetName(), err)
+					}
+				}
+			}
+		}
+		PruneResult(result)
+	}
+	if err := p.expire(ctx, time.Time{}, p.maxSeries); err != nil {
+		return nil, err
+	}
+	if err := p.store.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit state: %w", err)
+	}
+	return results, nil
+}
+
+func (p *TemporalityProcessor) expire(ctx context.Context, before time.Time, maxSeries int) error {
+	expired, err := p.store.Expire(ctx, before, maxSeries)
+	if err != nil {
+		return fmt.Errorf("expire state: %w", err)
+	}
+	if expired > 0 {
+		slog.DebugContext(ctx, "expire temporality state", "series", expired)
+		p.selfMetrics.Add("temporality.expired_series", int64(expired))
+	}
+	return nil
+}
+
+// convertible reports whether the temporality is the other one of the target, unspecified temporality is left as is.
+func (p *TemporalityProcessor) convertible(temporality metricspb.AggregationTemporality) bool {
+	return temporality != p.to && temporality != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_UNSPECIFIED
+}
+
+func (p *TemporalityProcessor) convert(ctx context.Context, identity string, metric *metricspb.Metric) error {
+	var err error
+	switch data := metric.GetData().(type) {
+	case *metricspb.Metric_Sum:
+		if !p.convertible(data.Sum.GetAggregationTemporality()) {
+			return nil
+		}
+		ops := numberTemporalityOps(data.Sum.GetIsMonotonic())
+		data.Sum.DataPoints, err = convertTemporality(ctx, p, identity, data.Sum.GetAggregationTemporality(), data.Sum.GetDataPoints(), ops)
+		data.Sum.AggregationTemporality = p.to
+	case *metricspb.Metric_Histogram:
+		if !p.convertible(data.Histogram.GetAggregationTemporality()) {
+			return nil
+		}
+		data.Histogram.DataPoints, err = convertTemporality(ctx, p, identity, data.Histogram.GetAggregationTemporality(), data.Histogram.GetDataPoints(), histogramTemporalityOps)
+		data.Histogram.AggregationTemporality = p.to
+	case *metricspb.Metric_ExponentialHistogram:
+		if !p.convertible(data.ExponentialHistogram.GetAggregationTemporality()) {
+			return nil
+		}
+		data.ExponentialHistogram.DataPoints, err = convertTemporality(ctx, p, identity, data.ExponentialHistogram.GetAggregationTemporality(), data.ExponentialHistogram.GetDataPoints(), exponentialHistogramTemporalityOps)
+		data.ExponentialHistogram.AggregationTemporality = p.to
+	}
+	return err
+}
+
+type protoDataPoint interface {
+	dataPoint
+	proto.Message
+}
+
+// temporalityOps are the operations of a data point type.
+// sub and add return false when the data points are not compatible, e.g. a counter reset or changed bucket bounds.
+type temporalityOps[T protoDataPoint] struct {
+	new      func() T
+	setTimes func(dp T, start, time uint64)
+	sub      func(cur, prev T) (T, bool)
+	add      func(acc, cur T) (T, bool)
+}
+
+func convertTemporality[T protoDataPoint](ctx context.Context, p *TemporalityProcessor, identity string, from metricspb.AggregationTemporality, dps []T, ops temporalityOps[T]) ([]T, error) {
+	sort.SliceStable(dps, func(i, j int) bool {
+		return dps[i].GetTimeUnixNano() < dps[j].GetTimeUnixNano()
+	})
+	converted := dps[:0]
+	for _, dp := range dps {
+		sum := sha256.Sum256([]byte(identity + "\x00" + AttributesKey(dp.GetAttributes())))
+		key := hex.EncodeToString(sum[:])
+		bs, ok, err := p.store.Get(ctx, key)
+		if err != nil {
+			return nil, fmt.Errorf("get state: %w", err)
+		}
+		var prev T
+		hasPrev := ok
+		if hasPrev {
+			prev = ops.new()
+			if err := proto.Unmarshal(bs, prev); err != nil {
+				return nil, fmt.Errorf("unmarshal state: %w", err)
+			}
+			if dp.GetTimeUnixNano() <= prev.GetTimeUnixNano() {
+				// a late delta data point is added to the state and reported with the next data point,
+				// a late cumulative data point has nothing to add, so it is dropped.
+				late, ok := lateDeltaToCumulative(dp, prev, from, ops)
+				if !ok {
+					p.selfMetrics.Add("temporality.dropped_data_points", 1)
+					continue
+				}
+				if bs, err = proto.Marshal(late); err != nil {
+					return nil, fmt.Errorf("marshal state: %w", err)
+				}
+				if err := p.store.Put(ctx, key, bs); err != nil {
+					return nil, fmt.Errorf("put state: %w", err)
+				}
+				p.selfMetrics.Add("temporality.late_data_points", 1)
+				continue
+			}
+		}
+		var out, state T
+		if from == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+			out, ok = cumulativeToDelta(p, dp, prev, hasPrev, ops)
+			state = dp
+		} else {
+			out, state = deltaToCumulative(p, dp, prev, hasPrev, ops)
+			ok = true
+		}
+		if bs, err = proto.Marshal(state); err != nil {
+			return nil, fmt.Errorf("marshal state: %w", err)
+		}
+		if err := p.store.Put(ctx, key, bs); err != nil {
+			return nil, fmt.Errorf("put state: %w", err)
+		}
+		if !ok {
+			p.selfMetrics.Add("temporality.dropped_data_points", 1)
+			continue
+		}
+		p.selfMetrics.Add("temporality.converted_data_points", 1)
+		converted = append(converted, out)
+	}
+	return converted, nil
+}
+
+// cumulativeToDelta returns the difference from the previous data point.
+// the first data point of a series is dropped because there is no baseline, and
+// a reset (new start time or decreased counts) reports the whole value as the delta.
+func cumulativeToDelta[T protoDataPoint](p *TemporalityProcessor, cur, prev T, hasPrev bool, ops temporalityOps[T]) (T, bool) {
+	if !hasPrev {
+		var zero T
+		return zero, false
+	}
+	if cur.GetStartTimeUnixNano() == prev.GetStartTimeUnixNano() || cur.GetStartTimeUnixNano() == 0 {
+		if delta, ok := ops.sub(cur, prev); ok {
+			ops.setTimes(delta, prev.GetTimeUnixNano(), cur.GetTimeUnixNano())
+			return delta, true
+		}
+	}
+	p.selfMetrics.Add("temporality.resets", 1)
+	reset := proto.Clone(cur).(T)
+	if cur.GetStartTimeUnixNano() == 0 || cur.GetStartTimeUnixNano() == prev.GetStartTimeUnixNano() {
+		ops.setTimes(reset, prev.GetTimeUnixNano(), cur.GetTimeUnixNano())
+	}
+	return reset, true
+}
+
+// lateDeltaToCumulative returns the state with the late delta data point added, keeping the times of the state.
+func lateDeltaToCumulative[T protoDataPoint](cur, prev T, from metricspb.AggregationTemporality, ops temporalityOps[T]) (T, bool) {
+	if from != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA {
+		var zero T
+		return zero, false
+	}
+	acc, ok := ops.add(prev, cur)
+	if !ok {
+		return acc, false
+	}
+	ops.setTimes(acc, prev.GetStartTimeUnixNano(), prev.GetTimeUnixNano())
+	return acc, true
+}
+
+// deltaToCumulative returns the accumulated data point and the state to keep, which are not shared.
+func deltaToCumulative[T protoDataPoint](p *TemporalityProcessor, cur, prev T, hasPrev bool, ops temporalityOps[T]) (T, T) {
+	var acc T
+	var ok bool
+	if hasPrev {
+		if acc, ok = ops.add(prev, cur); ok {
+			ops.setTimes(acc, prev.GetStartTimeUnixNano(), cur.GetTimeUnixNano())
+		} else {
+			p.selfMetrics.Add("temporality.resets", 1)
+		}
+	}
+	if !ok {
+		acc = proto.Clone(cur).(T)
+		if acc.GetStartTimeUnixNano() == 0 {
+			ops.setTimes(acc, cur.GetTimeUnixNano(), cur.GetTimeUnixNano())
+		}
+	}
+	return acc, proto.Clone(acc).(T)
+}
+
+func numberTemporalityOps(monotonic bool) temporalityOps[*metricspb.NumberDataPoint] {
+	return temporalityOps[*metricspb.NumberDataPoint]{
+		new: func() *metricspb.NumberDataPoint { return &metricspb.NumberDataPoint{} },
+		setTimes: func(dp *metricspb.NumberDataPoint, start, time uint64) {
+			dp.StartTimeUnixNano, dp.TimeUnixNano = start, time
+		},
+		sub: func(cur, prev *metricspb.NumberDataPoint) (*metricspb.NumberDataPoint, bool) {
+			delta := proto.Clone(cur).(*metricspb.NumberDataPoint)
+			if cur.GetValue() == nil || prev.GetValue() == nil {
+				return delta, true
+			}
+			if c, ok := cur.GetValue().(*metricspb.NumberDataPoint_AsInt); ok {
+				if p, ok := prev.GetValue().(*metricspb.NumberDataPoint_AsInt); ok {
+					if monotonic && c.AsInt < p.AsInt {
+						return nil, false
+					}
+					delta.Value = &metricspb.NumberDataPoint_AsInt{AsInt: c.AsInt - p.AsInt}
+					return delta, true
+				}
+			}
+			v := numberDataPointValue(cur) - numberDataPointValue(prev)
+			if monotonic && v < 0 {
+				return nil, false
+			}
+			delta.Value = &metricspb.NumberDataPoint_AsDouble{AsDouble: v}
+			return delta, true
+		},
+		add: func(acc, cur *metricspb.NumberDataPoint) (*metricspb.NumberDataPoint, bool) {
+			sum := proto.Clone(cur).(*metricspb.NumberDataPoint)
+			if a, ok := acc.GetValue().(*metricspb.NumberDataPoint_AsInt); ok {
+				if c, ok := cur.GetValue().(*metricspb.NumberDataPoint_AsInt); ok {
+					sum.Value = &metricspb.NumberDataPoint_AsInt{AsInt: a.AsInt + c.AsInt}
+					return sum, true
+				}
+			}
+			sum.Value = &metricspb.NumberDataPoint_AsDouble{AsDouble: numberDataPointValue(acc) + numberDataPointValue(cur)}
+			return sum, true
+		},
+	}
+}
+
+var histogramTemporalityOps = temporalityOps[*metricspb.HistogramDataPoint]{
+	new: func() *metricspb.HistogramDataPoint { return &metricspb.HistogramDataPoint{} },
+	setTimes: func(dp *metricspb.HistogramDataPoint, start, time uint64) {
+		dp.StartTimeUnixNano, dp.TimeUnixNano = start, time
+	},
+	sub: func(cur, prev *metricspb.HistogramDataPoint) (*metricspb.HistogramDataPoint, bool) {
+		if !slices.Equal(cur.GetExplicitBounds(), prev.GetExplicitBounds()) || len(cur.GetBucketCounts()) != len(prev.GetBucketCounts()) || cur.GetCount() < prev.GetCount() {
+			return nil, false
+		}
+		delta := proto.Clone(cur).(*metricspb.HistogramDataPoint)
+		for i, count := range prev.GetBucketCounts() {
+			if delta.BucketCounts[i] < count {
+				return nil, false
+			}
+			delta.BucketCounts[i] -= count
+		}
+		delta.Count -= prev.GetCount()
+		if cur.Sum != nil && prev.Sum != nil {
+			delta.Sum = proto.Float64(cur.GetSum() - prev.GetSum())
+		}
+		// min and max of the interval can not be derived from cumulative data points.
+		delta.Min, delta.Max = nil, nil
+		return delta, true
+	},
+	add: func(acc, cur *metricspb.HistogramDataPoint) (*metricspb.HistogramDataPoint, bool) {
+		if !slices.Equal(cur.GetExplicitBounds(), acc.GetExplicitBounds()) || len(cur.GetBucketCounts()) != len(acc.GetBucketCounts()) {
+			return nil, false
+		}
+		sum := proto.Clone(cur).(*metricspb.HistogramDataPoint)
+		for i, count := range acc.GetBucketCounts() {
+			sum.BucketCounts[i] += count
+		}
+		sum.Count += acc.GetCount()
+		if cur.Sum != nil && acc.Sum != nil {
+			sum.Sum = proto.Float64(cur.GetSum() + acc.GetSum())
+		}
+		sum.Min, sum.Max = addMinMax(acc.Min, acc.Max, cur.Min, cur.Max)
+		return sum, true
+	},
+}
+
+var exponentialHistogramTemporalityOps = temporalityOps[*metricspb.ExponentialHistogramDataPoint]{
+	new: func() *metricspb.ExponentialHistogramDataPoint { return &metricspb.ExponentialHistogramDataPoint{} },
+	setTimes: func(dp *metricspb.ExponentialHistogramDataPoint, start, time uint64) {
+		dp.StartTimeUnixNano, dp.TimeUnixNano = start, time
+	},
+	sub: func(cur, prev *metricspb.ExponentialHistogramDataPoint) (*metricspb.ExponentialHistogramDataPoint, bool) {
+		if cur.GetZeroThreshold() != prev.GetZeroThreshold() || cur.GetCount() < prev.GetCount() || cur.GetZeroCount() < prev.GetZeroCount() {
+			return nil, false
+		}
+		scale := min(cur.GetScale(), prev.GetScale())
+		positive, ok := subExponentialBuckets(cur.GetPositive(), cur.GetScale(), prev.GetPositive(), prev.GetScale(), scale)
+		if !ok {
+			return nil, false
+		}
+		negative, ok := subExponentialBuckets(cur.GetNegative(), cur.GetScale(), prev.GetNegative(), prev.GetScale(), scale)
+		if !ok {
+			return nil, false
+		}
+		delta := proto.Clone(cur).(*metricspb.ExponentialHistogramDataPoint)
+		delta.Scale, delta.Positive, delta.Negative = scale, positive, negative
+		delta.Count -= prev.GetCount()
+		delta.ZeroCount -= prev.GetZeroCount()
+		if cur.Sum != nil && prev.Sum != nil {
+			delta.Sum = proto.Float64(cur.GetSum() - prev.GetSum())
+		}
+		delta.Min, delta.Max = nil, nil
+		return delta, true
+	},
+	add: func(acc, cur *metricspb.ExponentialHistogramDataPoint) (*metricspb.ExponentialHistogramDataPoint, bool) {
+		if cur.GetZeroThreshold() != acc.GetZeroThreshold() {
+			return nil, false
+		}
+		scale := min(cur.GetScale(), acc.GetScale())
+		sum := proto.Clone(cur).(*metricspb.ExponentialHistogramDataPoint)
+		sum.Scale = scale
+		sum.Positive = addExponentialBuckets(acc.GetPositive(), acc.GetScale(), cur.GetPositive(), cur.GetScale(), scale)
+		sum.Negative = addExponentialBuckets(acc.GetNegative(), acc.GetScale(), cur.GetNegative(), cur.GetScale(), scale)
+		sum.Count += acc.GetCount()
+		sum.ZeroCount += acc.GetZeroCount()
+		if cur.Sum != nil && acc.Sum != nil {
+			sum.Sum = proto.Float64(cur.GetSum() + acc.GetSum())
+		}
+		sum.Min, sum.Max = addMinMax(acc.Min, acc.Max, cur.Min, cur.Max)
+		return sum, true
+	},
+}
+
+func addMinMax(min1, max1, min2, max2 *float64) (*float64, *float64) {
+	if min1 == nil || min2 == nil || max1 == nil || max2 == nil {
+		return nil, nil
+	}
+	return proto.Float64(math.Min(*min1, *min2)), proto.Float64(math.Max(*max1, *max2))
+}
+
+// exponentialBucketCounts returns the counts by bucket index, downscaled from scale to the target scale.
+func exponentialBucketCounts(buckets *metricspb.ExponentialHistogramDataPoint_Buckets, scale, target int32) map[int32]uint64 {
+	counts := make(map[int32]uint64, len(buckets.GetBucketCounts()))
+	for i, count := range buckets.GetBucketCounts() {
+		if count == 0 {
+			continue
+		}
+		counts[(buckets.GetOffset()+int32(i))>>(scale-target)] += count
+	}
+	return counts
+}
+
+func exponentialBucketsOf(counts map[int32]uint64) *metricspb.ExponentialHistogramDataPoint_Buckets {
+	buckets := &metricspb.ExponentialHistogramDataPoint_Buckets{}
+	if len(counts) == 0 {
+		return buckets
+	}
+	first, last := int32(math.MaxInt32), int32(math.MinInt32)
+	for index := range counts {
+		first, last = min(first, index), max(last, index)
+	}
+	buckets.Offset = first
+	buckets.BucketCounts = make([]uint64, last-first+1)
+	for index, count := range counts {
+		buckets.BucketCounts[index-first] = count
+	}
+	return buckets
+}
+
+func subExponentialBuckets(cur *metricspb.ExponentialHistogramDataPoint_Buckets, curScale int32, prev *metricspb.ExponentialHistogramDataPoint_Buckets, prevScale int32, scale int32) (*metricspb.ExponentialHistogramDataPoint_Buckets, bool) {
+	counts := exponentialBucketCounts(cur, curScale, scale)
+	for index, count := range exponentialBucketCounts(prev, prevScale, scale) {
+		if counts[index] < count {
+			return nil, false
+		}
+		counts[index] -= count
+		if counts[index] == 0 {
+			delete(counts, index)
+		}
+	}
+	return exponentialBucketsOf(counts), true
+}
+
+func addExponentialBuckets(acc *metricspb.ExponentialHistogramDataPoint_Buckets, accScale int32, cur *metricspb.ExponentialHistogramDataPoint_Buckets, curScale int32, scale int32) *metricspb.ExponentialHistogramDataPoint_Buckets {
+	counts := exponentialBucketCounts(acc, accScale, scale)
+	for index, count := range exponentialBucketCounts(cur, curScale, scale) {
+		counts[index] += count
+	}
+	return exponentialBucketsOf(counts)
+}
+
+// FileTemporalityStateStore is a TemporalityStateStore persisted in a JSON file, e.g. /tmp/temporality.json on AWS Lambda
+// to keep the state across invocations of the same execution environment.
+type FileTemporalityStateStore struct {
+	mu     sync.Mutex
+	path   string
+	states map[string]temporalityState
+	// dirty is true when the states are changed since the last commit.
+	dirty bool
+}
+
+func NewFileTemporalityStateStore(path string) *FileTemporalityStateStore {
+	return &FileTemporalityStateStore{path: path}
+}
+
+func (s *FileTemporalityStateStore) load() error {
+	if s.states != nil {
+		return nil
+	}
+	s.states = make(map[string]temporalityState)
+	bs, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read state file: %w", err)
+	}
+	if err := json.Unmarshal(bs, &s.states); err != nil {
+		slog.Warn("ignore broken temporality state file", "path", s.path, "error", err)
+		s.states = make(map[string]temporalityState)
+	}
+	return nil
+}
+
+func (s *FileTemporalityStateStore) Get(_ context.Context, key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return nil, false, err
+	}
+	state, ok := s.states[key]
+	return state.Value, ok, nil
+}
+
+func (s *FileTemporalityStateStore) Put(_ context.Context, key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return err
+	}
+	s.states[key] = temporalityState{Value: value, LastSeen: time.Now().UnixNano()}
+	s.dirty = true
+	return nil
+}
+
+func (s *FileTemporalityStateStore) Expire(_ context.Context, before time.Time, maxSeries int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return 0, err
+	}
+	removed := expireTemporalityStates(s.states, before, maxSeries)
+	if removed > 0 {
+		s.dirty = true
+	}
+	return removed, nil
+}
+
+// Commit writes the state file atomically by renaming a temporary file, only when the states are changed.
+func (s *FileTemporalityStateStore) Commit(_ context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty {
+		return nil
+	}
+	bs, err := json.Marshal(s.states)
+	if err != nil {
+		return fmt.Errorf("marshal state: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("create temporary state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(bs); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write temporary state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close temporary state file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("rename state file: %w", err)
+	}
+	s.dirty = false
+	return nil
+}
@@ -0,0 +1,253 @@
+package jsonlotelforwarder_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	jsonlotelforwarder "github.com/mashiike/jsonl-otel-forwarder"
+	"github.com/stretchr/testify/require"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func newMetricResult(metric *metricspb.Metric) *jsonlotelforwarder.PaseResult {
+	return &jsonlotelforwarder.PaseResult{
+		Metrics: &metricspb.MetricsData{
+			ResourceMetrics: []*metricspb.ResourceMetrics{
+				{
+					Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{stringAttr("service.name", "checkout")}},
+					ScopeMetrics: []*metricspb.ScopeMetrics{
+						{Metrics: []*metricspb.Metric{metric}},
+					},
+				},
+			},
+		},
+	}
+}
+
+func newSumMetric(temporality metricspb.AggregationTemporality, dps ...*metricspb.NumberDataPoint) *metricspb.Metric {
+	return &metricspb.Metric{
+		Name: "http.server.requests",
+		Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{AggregationTemporality: temporality, IsMonotonic: true, DataPoints: dps}},
+	}
+}
+
+func intDataPoint(start, time uint64, value int64) *metricspb.NumberDataPoint {
+	return &metricspb.NumberDataPoint{
+		Attributes:        []*commonpb.KeyValue{stringAttr("http.route", "/checkout")},
+		StartTimeUnixNano: start,
+		TimeUnixNano:      time,
+		Value:             &metricspb.NumberDataPoint_AsInt{AsInt: value},
+	}
+}
+
+func processMetric(t *testing.T, p jsonlotelforwarder.Processor, metric *metricspb.Metric) *metricspb.Metric {
+	t.Helper()
+	results, err := p.Process(context.Background(), []*jsonlotelforwarder.PaseResult{newMetricResult(metric)})
+	require.NoError(t, err)
+	if results[0].Metrics == nil {
+		return nil
+	}
+	return results[0].Metrics.GetResourceMetrics()[0].GetScopeMetrics()[0].GetMetrics()[0]
+}
+
+type sumPoint struct {
+	Start, Time uint64
+	Value       int64
+}
+
+func sumPoints(metric *metricspb.Metric) []sumPoint {
+	var points []sumPoint
+	for _, dp := range metric.GetSum().GetDataPoints() {
+		points = append(points, sumPoint{Start: dp.GetStartTimeUnixNano(), Time: dp.GetTimeUnixNano(), Value: dp.GetAsInt()})
+	}
+	return points
+}
+
+func TestTemporalityProcessor__CumulativeToDelta(t *testing.T) {
+	cumulative := metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+	selfMetrics := jsonlotelforwarder.NewSelfMetrics()
+	p, err := jsonlotelforwarder.NewTemporalityProcessor(&jsonlotelforwarder.TemporalityConfig{To: "delta"}, nil, selfMetrics)
+	require.NoError(t, err)
+
+	require.Nil(t, processMetric(t, p, newSumMetric(cumulative, intDataPoint(100, 200, 10))), "the first data point has no baseline")
+
+	metric := processMetric(t, p, newSumMetric(cumulative, intDataPoint(100, 400, 18), intDataPoint(100, 300, 15)))
+	require.Equal(t, metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA, metric.GetSum().GetAggregationTemporality())
+	require.Equal(t, []sumPoint{{Start: 200, Time: 300, Value: 5}, {Start: 300, Time: 400, Value: 3}}, sumPoints(metric), "data points are converted in time order")
+
+	require.Nil(t, processMetric(t, p, newSumMetric(cumulative, intDataPoint(100, 350, 16))), "out of order data point is dropped")
+
+	metric = processMetric(t, p, newSumMetric(cumulative, intDataPoint(100, 500, 4), intDataPoint(550, 600, 2)))
+	require.Equal(t, []sumPoint{{Start: 400, Time: 500, Value: 4}, {Start: 550, Time: 600, Value: 2}}, sumPoints(metric), "resets")
+
+	require.Equal(t, map[string]int64{
+		"temporality.converted_data_points": 4,
+		"temporality.dropped_data_points":   2,
+		"temporality.resets":                2,
+	}, selfMetrics.Snapshot())
+}
+
+func TestTemporalityProcessor__DeltaToCumulative(t *testing.T) {
+	delta := metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+	newHistogram := func(start, time uint64, bounds []float64, counts []uint64, sum float64) *metricspb.Metric {
+		var count uint64
+		for _, c := range counts {
+			count += c
+		}
+		return &metricspb.Metric{
+			Name: "http.server.duration",
+			Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+				AggregationTemporality: delta,
+				DataPoints: []*metricspb.HistogramDataPoint{
+					{StartTimeUnixNano: start, TimeUnixNano: time, Count: count, Sum: proto.Float64(sum), BucketCounts: counts, ExplicitBounds: bounds},
+				},
+			}},
+		}
+	}
+	p, err := jsonlotelforwarder.NewTemporalityProcessor(&jsonlotelforwarder.TemporalityConfig{To: "cumulative"}, nil, nil)
+	require.NoError(t, err)
+	bounds := []float64{10, 100}
+
+	dp := processMetric(t, p, newHistogram(100, 200, bounds, []uint64{1, 2, 0}, 120)).GetHistogram().GetDataPoints()[0]
+	require.Equal(t, []uint64{1, 2, 0}, dp.GetBucketCounts())
+
+	metric := processMetric(t, p, newHistogram(200, 300, bounds, []uint64{0, 1, 1}, 250))
+	require.Equal(t, metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE, metric.GetHistogram().GetAggregationTemporality())
+	dp = metric.GetHistogram().GetDataPoints()[0]
+	require.Equal(t, uint64(100), dp.GetStartTimeUnixNano())
+	require.Equal(t, uint64(300), dp.GetTimeUnixNano())
+	require.Equal(t, []uint64{1, 3, 1}, dp.GetBucketCounts())
+	require.Equal(t, uint64(5), dp.GetCount())
+	require.Equal(t, 370.0, dp.GetSum())
+
+	dp = processMetric(t, p, newHistogram(300, 400, []float64{50}, []uint64{1, 0}, 5)).GetHistogram().GetDataPoints()[0]
+	require.Equal(t, uint64(300), dp.GetStartTimeUnixNano(), "changed bounds start a new series")
+	require.Equal(t, []uint64{1, 0}, dp.GetBucketCounts())
+}
+
+func TestTemporalityProcessor__ExponentialHistogramScaleChange(t *testing.T) {
+	newExponentialHistogram := func(time uint64, scale int32, offset int32, counts []uint64) *metricspb.Metric {
+		var count uint64
+		for _, c := range counts {
+			count += c
+		}
+		return &metricspb.Metric{
+			Name: "rpc.duration",
+			Data: &metricspb.Metric_ExponentialHistogram{ExponentialHistogram: &metricspb.ExponentialHistogram{
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+				DataPoints: []*metricspb.ExponentialHistogramDataPoint{
+					{
+						StartTimeUnixNano: 100,
+						TimeUnixNano:      time,
+						Count:             count,
+						Scale:             scale,
+						Positive:          &metricspb.ExponentialHistogramDataPoint_Buckets{Offset: offset, BucketCounts: counts},
+					},
+				},
+			}},
+		}
+	}
+	p, err := jsonlotelforwarder.NewTemporalityProcessor(&jsonlotelforwarder.TemporalityConfig{To: "delta"}, nil, nil)
+	require.NoError(t, err)
+	require.Nil(t, processMetric(t, p, newExponentialHistogram(200, 1, 2, []uint64{1, 1, 1})))
+	// scale 1 buckets 2, 3 and 4 are buckets 1, 1 and 2 in scale 0.
+	dp := processMetric(t, p, newExponentialHistogram(300, 0, 1, []uint64{3, 4})).GetExponentialHistogram().GetDataPoints()[0]
+	require.Equal(t, int32(0), dp.GetScale())
+	require.Equal(t, int32(1), dp.GetPositive().GetOffset())
+	require.Equal(t, []uint64{1, 3}, dp.GetPositive().GetBucketCounts())
+	require.Equal(t, uint64(4), dp.GetCount())
+}
+
+func TestTemporalityProcessor__FileStateStore(t *testing.T) {
+	cumulative := metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+	config := &jsonlotelforwarder.TemporalityConfig{To: "delta", StateFile: filepath.Join(t.TempDir(), "state.json")}
+	p, err := jsonlotelforwarder.NewTemporalityProcessor(config, nil, nil)
+	require.NoError(t, err)
+	require.Nil(t, processMetric(t, p, newSumMetric(cumulative, intDataPoint(100, 200, 10))))
+
+	// a new processor, e.g. the next invocation, continues from the state file.
+	p, err = jsonlotelforwarder.NewTemporalityProcessor(config, nil, nil)
+	require.NoError(t, err)
+	metric := processMetric(t, p, newSumMetric(cumulative, intDataPoint(100, 300, 25)))
+	require.Equal(t, []sumPoint{{Start: 200, Time: 300, Value: 15}}, sumPoints(metric))
+}
+
+func TestTemporalityProcessor__LateDeltaDataPoint(t *testing.T) {
+	delta := metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+	selfMetrics := jsonlotelforwarder.NewSelfMetrics()
+	p, err := jsonlotelforwarder.NewTemporalityProcessor(&jsonlotelforwarder.TemporalityConfig{To: "cumulative"}, nil, selfMetrics)
+	require.NoError(t, err)
+	metric := processMetric(t, p, newSumMetric(delta, intDataPoint(100, 200, 10)))
+	require.Equal(t, []sumPoint{{Start: 100, Time: 200, Value: 10}}, sumPoints(metric))
+
+	require.Nil(t, processMetric(t, p, newSumMetric(delta, intDataPoint(150, 180, 3))), "late data point is not reported")
+
+	metric = processMetric(t, p, newSumMetric(delta, intDataPoint(200, 300, 5)))
+	require.Equal(t, []sumPoint{{Start: 100, Time: 300, Value: 18}}, sumPoints(metric), "late data point is accumulated")
+	require.EqualValues(t, 1, selfMetrics.Get("temporality.late_data_points"))
+	require.Zero(t, selfMetrics.Get("temporality.dropped_data_points"))
+}
+
+func TestTemporalityProcessor__ExpireState(t *testing.T) {
+	cumulative := metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+	withRoute := func(dp *metricspb.NumberDataPoint, route string) *metricspb.NumberDataPoint {
+		dp.Attributes = []*commonpb.KeyValue{stringAttr("http.route", route)}
+		return dp
+	}
+	selfMetrics := jsonlotelforwarder.NewSelfMetrics()
+	p, err := jsonlotelforwarder.NewTemporalityProcessor(&jsonlotelforwarder.TemporalityConfig{To: "delta", MaxSeries: 1}, nil, selfMetrics)
+	require.NoError(t, err)
+	require.Nil(t, processMetric(t, p, newSumMetric(cumulative, withRoute(intDataPoint(100, 200, 10), "/a"))))
+	require.Nil(t, processMetric(t, p, newSumMetric(cumulative, withRoute(intDataPoint(100, 200, 10), "/b"))))
+	require.EqualValues(t, 1, selfMetrics.Get("temporality.expired_series"))
+	require.Nil(t, processMetric(t, p, newSumMetric(cumulative, withRoute(intDataPoint(100, 300, 15), "/a"))), "the least recently seen series is removed")
+	metric := processMetric(t, p, newSumMetric(cumulative, withRoute(intDataPoint(100, 400, 18), "/a")))
+	require.Equal(t, []sumPoint{{Start: 300, Time: 400, Value: 3}}, sumPoints(metric))
+	require.EqualValues(t, 2, selfMetrics.Get("temporality.expired_series"))
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	p, err = jsonlotelforwarder.NewTemporalityProcessor(&jsonlotelforwarder.TemporalityConfig{To: "delta", StateFile: path, StateTTL: "1ms"}, nil, selfMetrics)
+	require.NoError(t, err)
+	require.Nil(t, processMetric(t, p, newSumMetric(cumulative, intDataPoint(100, 200, 10))))
+	time.Sleep(10 * time.Millisecond)
+	require.Nil(t, processMetric(t, p, newSumMetric(cumulative, intDataPoint(100, 300, 15))), "the state not seen for the ttl is removed")
+
+	// the state file is not written without changes.
+	p, err = jsonlotelforwarder.NewTemporalityProcessor(&jsonlotelforwarder.TemporalityConfig{To: "delta", StateFile: path, StateTTL: "0s"}, nil, selfMetrics)
+	require.NoError(t, err)
+	stat, err := os.Stat(path)
+	require.NoError(t, err)
+	_, err = p.Process(context.Background(), []*jsonlotelforwarder.PaseResult{{}})
+	require.NoError(t, err)
+	after, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, stat.ModTime(), after.ModTime())
+}
+
+func TestTemporalityProcessor__Metrics(t *testing.T) {
+	cumulative := metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+	p, err := jsonlotelforwarder.NewTemporalityProcessor(&jsonlotelforwarder.TemporalityConfig{To: "delta", Metrics: []string{"rpc.*"}}, nil, nil)
+	require.NoError(t, err)
+	metric := processMetric(t, p, newSumMetric(cumulative, intDataPoint(100, 200, 10)))
+	require.Equal(t, cumulative, metric.GetSum().GetAggregationTemporality(), "not matched metrics are left as is")
+	require.Equal(t, []sumPoint{{Start: 100, Time: 200, Value: 10}}, sumPoints(metric))
+}
+
+func TestNewTemporalityProcessor__Invalid(t *testing.T) {
+	for _, config := range []*jsonlotelforwarder.TemporalityConfig{
+		{To: "gauge"},
+		{To: "delta", Metrics: []string{"["}},
+		{To: "delta", StateTTL: "1 hour"},
+		{To: "delta", StateTTL: "-1h"},
+		{To: "delta", MaxSeries: -1},
+	} {
+		_, err := jsonlotelforwarder.NewTemporalityProcessor(config, nil, nil)
+		require.Error(t, err)
+	}
+}
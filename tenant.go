@@ -0,0 +1,125 @@
+package jsonlotelforwarder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
+
+	"github.com/mashiike/go-otlp-helper/otlp"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+)
+
+// TenantHeaderConfig derives an OTLP header, e.g. X-Scope-OrgID, from a resource attribute.
+// resources are grouped by the attribute value, so each export call carries the header of its tenant.
+// resources without the attribute use Default, or are exported without the header when Default is empty.
+type TenantHeaderConfig struct {
+	Header    string `json:"header"`
+	Attribute string `json:"attribute"`
+	Default   string `json:"default,omitempty"`
+}
+
+func (c *TenantHeaderConfig) Enabled() bool {
+	return c != nil && (c.Header != "" || c.Attribute != "")
+}
+
+func (c *TenantHeaderConfig) validate() error {
+	if c.Header == "" {
+		return fmt.Errorf("header is required")
+	}
+	if c.Attribute == "" {
+		return fmt.Errorf("attribute is required")
+	}
+	return nil
+}
+
+func (c *TenantHeaderConfig) tenantOf(resource *resourcepb.Resource) string {
+	if value, ok := lookupAttribute(resource.GetAttributes(), c.Attribute); ok && value != "" {
+		return value
+	}
+	return c.Default
+}
+
+// tenantClient returns the started client of the tenant, creating it on first use.
+// the tenant header is added to the copies of the signal headers, because the signal headers replace the configured ones,
+// and the headers of --otlp-headers are merged into them by the client.
+func (e *OTLPExporter) tenantClient(ctx context.Context, tenant string) (*otlp.Client, error) {
+	if tenant == "" {
+		return e.client, nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if client, ok := e.tenantClients[tenant]; ok {
+		return client, nil
+	}
+	headers := func(signal string) map[string]string {
+		h := maps.Clone(e.signalHeaders[signal])
+		if h == nil {
+			h = make(map[string]string, 1)
+		}
+		h[e.tenant.Header] = tenant
+		return h
+	}
+	opts := append(e.opts[:len(e.opts):len(e.opts)],
+		otlp.WithTracesHeaders(headers("traces")),
+		otlp.WithMetricsHeaders(headers("metrics")),
+		otlp.WithLogsHeaders(headers("logs")),
+	)
+	client, err := otlp.NewClient("http://localhost:4317", opts...)
+	if err != nil {
+		return nil, fmt.Errorf("create otlp client of tenant %s: %w", tenant, err)
+	}
+	if err := client.Start(ctx); err != nil {
+		return nil, fmt.Errorf("start otlp client of tenant %s: %w", tenant, err)
+	}
+	e.tenantClients[tenant] = client
+	return client, nil
+}
+
+// tenantExports sends the tenants of a result one by one, and remembers the tenants that are sent or failed permanently,
+// so a retried export of the same result sends only the tenants that failed with a retryable error.
+type tenantExports struct {
+	mu   sync.Mutex
+	done map[*PaseResult]map[string]error
+}
+
+// export sends all tenants, and returns the joined errors of the tenants. the error is permanent when all failures are.
+func (t *tenantExports) export(result *PaseResult, tenants []string, send func(tenant string) error) error {
+	t.mu.Lock()
+	if t.done == nil {
+		t.done = make(map[*PaseResult]map[string]error)
+	}
+	done, ok := t.done[result]
+	if !ok {
+		done = make(map[string]error, len(tenants))
+		t.done[result] = done
+	}
+	t.mu.Unlock()
+	var (
+		errs      []error
+		retryable bool
+	)
+	for _, tenant := range tenants {
+		err, ok := done[tenant]
+		if !ok {
+			var permanent *permanentError
+			if err = send(tenant); err == nil || errors.As(err, &permanent) {
+				done[tenant] = err
+			} else {
+				retryable = true
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("tenant %s: %w", tenant, err))
+		}
+	}
+	if !retryable {
+		t.mu.Lock()
+		delete(t.done, result)
+		t.mu.Unlock()
+		return errors.Join(errs...)
+	}
+	// the permanent errors are not unwrapped, so the export is retried for the retryable ones.
+	return errors.New(errors.Join(errs...).Error())
+}
@@ -0,0 +1,173 @@
+package jsonlotelforwarder_test
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	otlpmux "github.com/mashiike/go-otlp-helper/otlp"
+	"github.com/mashiike/go-otlp-helper/otlp/otlptest"
+	jsonlotelforwarder "github.com/mashiike/jsonl-otel-forwarder"
+	"github.com/stretchr/testify/require"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+)
+
+func TestForwarder__TenantHeader(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests []string
+	)
+	mux := otlpmux.NewServerMux()
+	mux.Logs().HandleFunc(func(ctx context.Context, request *otlpmux.LogsRequest) (*otlpmux.LogsResponse, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		headers, _ := otlpmux.HeadersFromContext(ctx)
+		var services []string
+		for _, resourceLogs := range request.GetResourceLogs() {
+			services = append(services, attributesToMap(resourceLogs.GetResource().GetAttributes())["service.name"].(string))
+		}
+		sort.Strings(services)
+		requests = append(requests, headers.Get("X-Scope-OrgID")+":"+headers.Get("Api-Key")+":"+strings.Join(services, ","))
+		return &otlpmux.LogsResponse{}, nil
+	})
+	server := otlptest.NewServer(mux)
+	defer server.Close()
+
+	payload, err := otlpmux.MarshalJSON(&logspb.LogsData{ResourceLogs: []*logspb.ResourceLogs{
+		newResourceLogs(stringAttr("service.namespace", "team-a"), stringAttr("service.name", "checkout")),
+		newResourceLogs(stringAttr("service.namespace", "team-b"), stringAttr("service.name", "search")),
+		newResourceLogs(stringAttr("service.namespace", "team-a"), stringAttr("service.name", "refund")),
+		newResourceLogs(stringAttr("service.name", "batch")),
+	}})
+	require.NoError(t, err)
+
+	t.Setenv("FORWARDER_OTLP_ENDPOINT", server.URL)
+	t.Setenv("FORWARDER_OTLP_PROTOCOL", "grpc")
+	t.Setenv("FORWARDER_OTLP_HEADERS", "Api-Key=dummy")
+	opts := jsonlotelforwarder.DefaultOptions()
+	opts.TenantHeader = "X-Scope-OrgID"
+	opts.TenantHeaderAttribute = "service.namespace"
+	opts.TenantHeaderDefault = "shared"
+	forwarder, err := jsonlotelforwarder.New(opts)
+	require.NoError(t, err)
+	_, err = forwarder.Invoke(context.Background(), payload)
+	require.NoError(t, err)
+
+	sort.Strings(requests)
+	require.Equal(t, []string{
+		"shared:dummy:batch",
+		"team-a:dummy:checkout,refund",
+		"team-b:dummy:search",
+	}, requests)
+}
+
+func TestForwarder__TenantHeaderWithSignalHeaders(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests []string
+	)
+	mux := otlpmux.NewServerMux()
+	mux.Logs().HandleFunc(func(ctx context.Context, request *otlpmux.LogsRequest) (*otlpmux.LogsResponse, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		headers, _ := otlpmux.HeadersFromContext(ctx)
+		requests = append(requests, headers.Get("X-Scope-OrgID")+":"+headers.Get("Api-Key")+":"+headers.Get("Logs-Key"))
+		return &otlpmux.LogsResponse{}, nil
+	})
+	server := otlptest.NewServer(mux)
+	defer server.Close()
+
+	payload, err := otlpmux.MarshalJSON(&logspb.LogsData{ResourceLogs: []*logspb.ResourceLogs{
+		newResourceLogs(stringAttr("service.namespace", "team-a"), stringAttr("service.name", "checkout")),
+	}})
+	require.NoError(t, err)
+
+	t.Setenv("FORWARDER_OTLP_ENDPOINT", server.URL)
+	t.Setenv("FORWARDER_OTLP_PROTOCOL", "grpc")
+	t.Setenv("FORWARDER_OTLP_HEADERS", "Api-Key=dummy")
+	opts := jsonlotelforwarder.DefaultOptions()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	opts.SetFlags(fs)
+	require.NoError(t, fs.Parse([]string{
+		"--otlp-logs-headers", "Logs-Key=secret",
+		"--tenant-header", "X-Scope-OrgID",
+		"--tenant-header-attribute", "service.namespace",
+	}))
+	forwarder, err := jsonlotelforwarder.New(opts)
+	require.NoError(t, err)
+	_, err = forwarder.Invoke(context.Background(), payload)
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"team-a:dummy:secret"}, requests, "the logs headers are kept with the tenant header")
+}
+
+func TestForwarder__TenantHeaderRetryFailedTenants(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		failed    bool
+		succeeded = map[string]int{}
+	)
+	mux := otlpmux.NewServerMux()
+	mux.Logs().HandleFunc(func(ctx context.Context, request *otlpmux.LogsRequest) (*otlpmux.LogsResponse, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		headers, _ := otlpmux.HeadersFromContext(ctx)
+		tenant := headers.Get("X-Scope-OrgID")
+		if tenant == "team-b" && !failed {
+			failed = true
+			return nil, errors.New("unavailable")
+		}
+		succeeded[tenant]++
+		return &otlpmux.LogsResponse{}, nil
+	})
+	server := otlptest.NewServer(mux)
+	defer server.Close()
+
+	payload, err := otlpmux.MarshalJSON(&logspb.LogsData{ResourceLogs: []*logspb.ResourceLogs{
+		newResourceLogs(stringAttr("service.namespace", "team-a"), stringAttr("service.name", "checkout")),
+		newResourceLogs(stringAttr("service.namespace", "team-b"), stringAttr("service.name", "search")),
+		newResourceLogs(stringAttr("service.namespace", "team-c"), stringAttr("service.name", "refund")),
+	}})
+	require.NoError(t, err)
+
+	opts := jsonlotelforwarder.DefaultOptions()
+	opts.ConfigFile = writeConfig(t, map[string]any{
+		"exporters": []map[string]any{
+			{
+				"name":          "tempo",
+				"endpoint":      server.URL,
+				"protocol":      "grpc",
+				"retry":         map[string]any{"initial_interval": "1ms"},
+				"tenant_header": map[string]any{"header": "X-Scope-OrgID", "attribute": "service.namespace"},
+			},
+		},
+	})
+	forwarder, err := jsonlotelforwarder.New(opts)
+	require.NoError(t, err)
+	_, err = forwarder.Invoke(context.Background(), payload)
+	require.NoError(t, err)
+
+	require.True(t, failed)
+	require.Equal(t, map[string]int{"team-a": 1, "team-b": 1, "team-c": 1}, succeeded, "the retry uploads only the failed tenant")
+	require.Equal(t, int64(1), forwarder.SelfMetrics().Get("exporters.tempo.retries"))
+}
+
+func TestNew__InvalidTenantHeader(t *testing.T) {
+	opts := jsonlotelforwarder.DefaultOptions()
+	opts.TenantHeader = "X-Scope-OrgID"
+	_, err := jsonlotelforwarder.New(opts)
+	require.Error(t, err, "attribute is required")
+
+	opts = jsonlotelforwarder.DefaultOptions()
+	opts.ConfigFile = writeConfig(t, map[string]any{
+		"exporters": []map[string]any{
+			{"name": "mimir", "endpoint": "http://localhost:4317", "tenant_header": map[string]any{"attribute": "service.namespace"}},
+		},
+	})
+	_, err = jsonlotelforwarder.New(opts)
+	require.Error(t, err, "header is required")
+}
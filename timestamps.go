@@ -0,0 +1,258 @@
+package jsonlotelforwarder
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+const (
+	TimestampsOutOfRangeDrop  = "drop"
+	TimestampsOutOfRangeClamp = "clamp"
+)
+
+// TimestampsConfig is the configuration of timestamp normalization.
+// the reference time is the CloudWatch Logs event timestamp, or the current time when the result was not delivered by CloudWatch Logs.
+type TimestampsConfig struct {
+	// FillMissing fills zero time of log records and data points with the CloudWatch Logs event timestamp.
+	FillMissing bool `json:"fill_missing,omitempty"`
+	// MaxPast and MaxFuture are the window around the reference time, e.g. 24h and 5m. empty means unlimited.
+	MaxPast   string `json:"max_past,omitempty"`
+	MaxFuture string `json:"max_future,omitempty"`
+	// OutOfRange is the action for timestamps outside the window, drop (default) or clamp.
+	OutOfRange string `json:"out_of_range,omitempty"`
+	// SkewCorrection shifts the spans of a CloudWatch Logs event back when they end after the event timestamp by more than SkewTolerance.
+	SkewCorrection bool   `json:"skew_correction,omitempty"`
+	SkewTolerance  string `json:"skew_tolerance,omitempty"`
+}
+
+func (c *TimestampsConfig) Enabled() bool {
+	return c != nil && (c.FillMissing || c.MaxPast != "" || c.MaxFuture != "" || c.SkewCorrection)
+}
+
+type TimestampsProcessor struct {
+	fillMissing    bool
+	maxPast        time.Duration
+	maxFuture      time.Duration
+	clamp          bool
+	skewCorrection bool
+	skewTolerance  time.Duration
+	now            func() time.Time
+	selfMetrics    *SelfMetrics
+}
+
+func NewTimestampsProcessor(config *TimestampsConfig, selfMetrics *SelfMetrics) (*TimestampsProcessor, error) {
+	p := &TimestampsProcessor{
+		fillMissing:    config.FillMissing,
+		skewCorrection: config.SkewCorrection,
+		now:            time.Now,
+		selfMetrics:    selfMetrics,
+	}
+	duration := func(name string, s string) (time.Duration, error) {
+		if s == "" {
+			return 0, nil
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", name, err)
+		}
+		if d < 0 {
+			return 0, fmt.Errorf("%s: must not be negative", name)
+		}
+		return d, nil
+	}
+	var err error
+	if p.maxPast, err = duration("max_past", config.MaxPast); err != nil {
+		return nil, err
+	}
+	if p.maxFuture, err = duration("max_future", config.MaxFuture); err != nil {
+		return nil, err
+	}
+	if p.skewTolerance, err = duration("skew_tolerance", config.SkewTolerance); err != nil {
+		return nil, err
+	}
+	switch config.OutOfRange {
+	case "", TimestampsOutOfRangeDrop:
+	case TimestampsOutOfRangeClamp:
+		p.clamp = true
+	default:
+		return nil, fmt.Errorf("out_of_range: unknown action %q, expected drop or clamp", config.OutOfRange)
+	}
+	return p, nil
+}
+
+// timestampsWindow is the range of valid timestamps in unix nano. zero bound means unlimited.
+type timestampsWindow struct {
+	min, max uint64
+}
+
+func (w timestampsWindow) contains(t uint64) bool {
+	return (w.min == 0 || t >= w.min) && (w.max == 0 || t <= w.max)
+}
+
+func (w timestampsWindow) clamp(t uint64) uint64 {
+	if w.min != 0 && t < w.min {
+		return w.min
+	}
+	if w.max != 0 && t > w.max {
+		return w.max
+	}
+	return t
+}
+
+func (p *TimestampsProcessor) Process(_ context.Context, results []*PaseResult) ([]*PaseResult, error) {
+	for _, result := range results {
+		reference := p.now()
+		if result.CloudWatch != nil {
+			reference = result.CloudWatch.Timestamp
+		}
+		if p.fillMissing && result.CloudWatch != nil {
+			p.fill(result, uint64(result.CloudWatch.Timestamp.UnixNano()))
+		}
+		if p.skewCorrection && result.CloudWatch != nil {
+			p.correctSkew(result, result.CloudWatch.Timestamp)
+		}
+		var window timestampsWindow
+		if p.maxPast > 0 {
+			window.min = uint64(reference.Add(-p.maxPast).UnixNano())
+		}
+		if p.maxFuture > 0 {
+			window.max = uint64(reference.Add(p.maxFuture).UnixNano())
+		}
+		if window.min != 0 || window.max != 0 {
+			p.selfMetrics.Add("timestamps.dropped_spans", int64(FilterSpans(result, func(item *FilterItem) bool {
+				return p.normalizeSpan(item.Span, window)
+			})))
+			p.selfMetrics.Add("timestamps.dropped_log_records", int64(FilterLogRecords(result, func(item *FilterItem) bool {
+				return p.normalizeLogRecord(item.LogRecord, window)
+			})))
+			p.selfMetrics.Add("timestamps.dropped_data_points", int64(FilterDataPoints(result, func(item *FilterItem) bool {
+				return p.normalizeDataPoint(item.DataPoint, window)
+			})))
+			PruneResult(result)
+		}
+	}
+	return results, nil
+}
+
+func (p *TimestampsProcessor) fill(result *PaseResult, timestamp uint64) {
+	var filled int64
+	FilterLogRecords(result, func(item *FilterItem) bool {
+		if item.LogRecord.GetTimeUnixNano() == 0 {
+			item.LogRecord.TimeUnixNano = timestamp
+			filled++
+		}
+		if item.LogRecord.GetObservedTimeUnixNano() == 0 {
+			item.LogRecord.ObservedTimeUnixNano = timestamp
+			filled++
+		}
+		return true
+	})
+	FilterDataPoints(result, func(item *FilterItem) bool {
+		if item.DataPoint.GetTimeUnixNano() == 0 {
+			setTimeUnixNano(item.DataPoint.(protoDataPoint), timestamp)
+			filled++
+		}
+		return true
+	})
+	p.selfMetrics.Add("timestamps.filled", filled)
+}
+
+// correctSkew shifts the spans back when the latest end time is after the CloudWatch Logs event timestamp.
+// spans can not end after they are ingested, so the difference is the clock skew of the host that emitted them.
+// spans ending before the timestamp are not shifted, because the delay of the delivery is unknown.
+func (p *TimestampsProcessor) correctSkew(result *PaseResult, timestamp time.Time) {
+	var latest uint64
+	var spans []*tracepb.Span
+	FilterSpans(result, func(item *FilterItem) bool {
+		latest = max(latest, item.Span.GetEndTimeUnixNano())
+		spans = append(spans, item.Span)
+		return true
+	})
+	skew := time.Duration(int64(latest) - timestamp.UnixNano())
+	if latest == 0 || skew <= p.skewTolerance {
+		return
+	}
+	slog.Debug("correct clock skew of spans", "skew", skew, "spans", len(spans), "log_stream", result.CloudWatch.LogStream)
+	for _, span := range spans {
+		shiftSpan(span, -int64(skew))
+	}
+	p.selfMetrics.Add("timestamps.skew_corrected_spans", int64(len(spans)))
+}
+
+// normalizeSpan returns whether the span is kept. clamp shifts the span into the window to keep the duration.
+func (p *TimestampsProcessor) normalizeSpan(span *tracepb.Span, window timestampsWindow) bool {
+	start, end := span.GetStartTimeUnixNano(), span.GetEndTimeUnixNano()
+	if (start == 0 || window.contains(start)) && (end == 0 || window.contains(end)) {
+		return true
+	}
+	if !p.clamp {
+		return false
+	}
+	switch {
+	case end != 0 && window.max != 0 && end > window.max:
+		shiftSpan(span, -int64(end-window.max))
+	case start != 0 && window.min != 0 && start < window.min:
+		shiftSpan(span, int64(window.min-start))
+	}
+	// the span longer than the window is cut.
+	span.StartTimeUnixNano = window.clamp(span.GetStartTimeUnixNano())
+	span.EndTimeUnixNano = window.clamp(span.GetEndTimeUnixNano())
+	p.selfMetrics.Add("timestamps.clamped", 1)
+	return true
+}
+
+func (p *TimestampsProcessor) normalizeLogRecord(record *logspb.LogRecord, window timestampsWindow) bool {
+	t, observed := record.GetTimeUnixNano(), record.GetObservedTimeUnixNano()
+	if (t == 0 || window.contains(t)) && (observed == 0 || window.contains(observed)) {
+		return true
+	}
+	if !p.clamp {
+		return false
+	}
+	if t != 0 {
+		record.TimeUnixNano = window.clamp(t)
+	}
+	if observed != 0 {
+		record.ObservedTimeUnixNano = window.clamp(observed)
+	}
+	p.selfMetrics.Add("timestamps.clamped", 1)
+	return true
+}
+
+func (p *TimestampsProcessor) normalizeDataPoint(dp dataPoint, window timestampsWindow) bool {
+	t := dp.GetTimeUnixNano()
+	if t == 0 || window.contains(t) {
+		return true
+	}
+	if !p.clamp {
+		return false
+	}
+	clamped := window.clamp(t)
+	setTimeUnixNano(dp.(protoDataPoint), clamped)
+	if dp.GetStartTimeUnixNano() > clamped {
+		// zero start time means unknown.
+		setStartTimeUnixNano(dp.(protoDataPoint), 0)
+	}
+	p.selfMetrics.Add("timestamps.clamped", 1)
+	return true
+}
+
+// shiftSpan shifts the start, end and event times of the span by delta nanoseconds.
+func shiftSpan(span *tracepb.Span, delta int64) {
+	shift := func(t uint64) uint64 {
+		if t == 0 {
+			return 0
+		}
+		return uint64(int64(t) + delta)
+	}
+	span.StartTimeUnixNano = shift(span.GetStartTimeUnixNano())
+	span.EndTimeUnixNano = shift(span.GetEndTimeUnixNano())
+	for _, event := range span.GetEvents() {
+		event.TimeUnixNano = shift(event.GetTimeUnixNano())
+	}
+}
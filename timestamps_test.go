@@ -0,0 +1,127 @@
+package jsonlotelforwarder_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	jsonlotelforwarder "github.com/mashiike/jsonl-otel-forwarder"
+	"github.com/stretchr/testify/require"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+func newTimestampsProcessor(t *testing.T, config *jsonlotelforwarder.TimestampsConfig, selfMetrics *jsonlotelforwarder.SelfMetrics) jsonlotelforwarder.Processor {
+	t.Helper()
+	p, err := jsonlotelforwarder.NewTimestampsProcessor(config, selfMetrics)
+	require.NoError(t, err)
+	return p
+}
+
+func TestTimestampsProcessor__FillMissing(t *testing.T) {
+	ingested := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	selfMetrics := jsonlotelforwarder.NewSelfMetrics()
+	p := newTimestampsProcessor(t, &jsonlotelforwarder.TimestampsConfig{FillMissing: true}, selfMetrics)
+
+	record := &logspb.LogRecord{ObservedTimeUnixNano: 100}
+	logs := &jsonlotelforwarder.PaseResult{
+		Logs: &logspb.LogsData{
+			ResourceLogs: []*logspb.ResourceLogs{
+				{ScopeLogs: []*logspb.ScopeLogs{{LogRecords: []*logspb.LogRecord{record}}}},
+			},
+		},
+		CloudWatch: &jsonlotelforwarder.CloudWatchMetadata{Timestamp: ingested},
+	}
+	metrics := newMetricResult(newSumMetric(metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA, intDataPoint(0, 0, 1)))
+	metrics.CloudWatch = &jsonlotelforwarder.CloudWatchMetadata{Timestamp: ingested}
+	withoutCloudWatch := newMetricResult(newSumMetric(metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA, intDataPoint(0, 0, 1)))
+
+	_, err := p.Process(context.Background(), []*jsonlotelforwarder.PaseResult{logs, metrics, withoutCloudWatch})
+	require.NoError(t, err)
+	require.Equal(t, uint64(ingested.UnixNano()), record.GetTimeUnixNano())
+	require.Equal(t, uint64(100), record.GetObservedTimeUnixNano(), "existing timestamps are kept")
+	require.Equal(t, []sumPoint{{Time: uint64(ingested.UnixNano()), Value: 1}}, sumPoints(metrics.Metrics.GetResourceMetrics()[0].GetScopeMetrics()[0].GetMetrics()[0]))
+	require.Equal(t, []sumPoint{{Value: 1}}, sumPoints(withoutCloudWatch.Metrics.GetResourceMetrics()[0].GetScopeMetrics()[0].GetMetrics()[0]), "results without CloudWatch Logs event are not filled")
+	require.Equal(t, map[string]int64{"timestamps.filled": 2}, selfMetrics.Snapshot())
+}
+
+func TestTimestampsProcessor__Window(t *testing.T) {
+	ingested := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	at := func(d time.Duration) uint64 {
+		return uint64(ingested.Add(d).UnixNano())
+	}
+	newResult := func() *jsonlotelforwarder.PaseResult {
+		result := newTraceResult(
+			&tracepb.Span{Name: "in-window", StartTimeUnixNano: at(-time.Minute), EndTimeUnixNano: at(-time.Second)},
+			&tracepb.Span{Name: "future", StartTimeUnixNano: at(time.Hour), EndTimeUnixNano: at(time.Hour + time.Second)},
+			&tracepb.Span{Name: "past", StartTimeUnixNano: at(-48 * time.Hour), EndTimeUnixNano: at(-48*time.Hour + time.Second)},
+		)
+		result.CloudWatch = &jsonlotelforwarder.CloudWatchMetadata{Timestamp: ingested}
+		return result
+	}
+	config := &jsonlotelforwarder.TimestampsConfig{MaxPast: "24h", MaxFuture: "5m"}
+
+	selfMetrics := jsonlotelforwarder.NewSelfMetrics()
+	results, err := newTimestampsProcessor(t, config, selfMetrics).Process(context.Background(), []*jsonlotelforwarder.PaseResult{newResult()})
+	require.NoError(t, err)
+	require.Equal(t, []string{"in-window"}, spanNames(results))
+	require.Equal(t, map[string]int64{"timestamps.dropped_spans": 2}, selfMetrics.Snapshot())
+
+	config.OutOfRange = "clamp"
+	result := newResult()
+	_, err = newTimestampsProcessor(t, config, nil).Process(context.Background(), []*jsonlotelforwarder.PaseResult{result})
+	require.NoError(t, err)
+	spans := result.Traces.GetResourceSpans()[0].GetScopeSpans()[0].GetSpans()
+	require.Len(t, spans, 3)
+	require.Equal(t, at(5*time.Minute-time.Second), spans[1].GetStartTimeUnixNano(), "clamped span keeps the duration")
+	require.Equal(t, at(5*time.Minute), spans[1].GetEndTimeUnixNano())
+	require.Equal(t, at(-24*time.Hour), spans[2].GetStartTimeUnixNano())
+	require.Equal(t, at(-24*time.Hour+time.Second), spans[2].GetEndTimeUnixNano())
+
+	metrics := newMetricResult(newSumMetric(metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA, intDataPoint(at(time.Hour), at(time.Hour), 1)))
+	metrics.CloudWatch = &jsonlotelforwarder.CloudWatchMetadata{Timestamp: ingested}
+	_, err = newTimestampsProcessor(t, config, nil).Process(context.Background(), []*jsonlotelforwarder.PaseResult{metrics})
+	require.NoError(t, err)
+	metric := metrics.Metrics.GetResourceMetrics()[0].GetScopeMetrics()[0].GetMetrics()[0]
+	require.Equal(t, []sumPoint{{Start: 0, Time: at(5 * time.Minute), Value: 1}}, sumPoints(metric), "start time after the clamped time is cleared")
+}
+
+func TestTimestampsProcessor__SkewCorrection(t *testing.T) {
+	ingested := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	at := func(d time.Duration) uint64 {
+		return uint64(ingested.Add(d).UnixNano())
+	}
+	selfMetrics := jsonlotelforwarder.NewSelfMetrics()
+	p := newTimestampsProcessor(t, &jsonlotelforwarder.TimestampsConfig{SkewCorrection: true, SkewTolerance: "1s"}, selfMetrics)
+
+	skewed := newTraceResult(
+		&tracepb.Span{Name: "parent", StartTimeUnixNano: at(9 * time.Second), EndTimeUnixNano: at(30 * time.Second)},
+		&tracepb.Span{Name: "child", StartTimeUnixNano: at(10 * time.Second), EndTimeUnixNano: at(20 * time.Second), Events: []*tracepb.Span_Event{{TimeUnixNano: at(15 * time.Second)}}},
+	)
+	skewed.CloudWatch = &jsonlotelforwarder.CloudWatchMetadata{Timestamp: ingested}
+	tolerated := newTraceResult(&tracepb.Span{Name: "tolerated", StartTimeUnixNano: at(0), EndTimeUnixNano: at(time.Second)})
+	tolerated.CloudWatch = &jsonlotelforwarder.CloudWatchMetadata{Timestamp: ingested}
+
+	_, err := p.Process(context.Background(), []*jsonlotelforwarder.PaseResult{skewed, tolerated})
+	require.NoError(t, err)
+	spans := skewed.Traces.GetResourceSpans()[0].GetScopeSpans()[0].GetSpans()
+	require.Equal(t, at(-21*time.Second), spans[0].GetStartTimeUnixNano())
+	require.Equal(t, at(0), spans[0].GetEndTimeUnixNano(), "the latest span ends at the CloudWatch Logs event timestamp")
+	require.Equal(t, at(-20*time.Second), spans[1].GetStartTimeUnixNano())
+	require.Equal(t, at(-10*time.Second), spans[1].GetEndTimeUnixNano())
+	require.Equal(t, at(-15*time.Second), spans[1].GetEvents()[0].GetTimeUnixNano())
+	require.Equal(t, at(time.Second), tolerated.Traces.GetResourceSpans()[0].GetScopeSpans()[0].GetSpans()[0].GetEndTimeUnixNano())
+	require.Equal(t, map[string]int64{"timestamps.skew_corrected_spans": 2}, selfMetrics.Snapshot())
+}
+
+func TestNewTimestampsProcessor__Invalid(t *testing.T) {
+	for _, config := range []*jsonlotelforwarder.TimestampsConfig{
+		{MaxPast: "1 day"},
+		{MaxFuture: "-5m"},
+		{MaxPast: "1h", OutOfRange: "shift"},
+	} {
+		_, err := jsonlotelforwarder.NewTimestampsProcessor(config, nil)
+		require.Error(t, err)
+	}
+}
@@ -0,0 +1,321 @@
+package jsonlotelforwarder
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+const (
+	ValidationPolicyPass = "pass"
+	ValidationPolicyFix  = "fix"
+	ValidationPolicyDrop = "drop"
+)
+
+// ValidationConfig is the policy of each validation rule, one of pass (count only), fix and drop.
+// fix repairs the item, e.g. pads short IDs or regenerates missing span IDs, and drops the items that can not be repaired.
+// rules without policy are not validated.
+type ValidationConfig struct {
+	TraceID      string `json:"trace_id,omitempty"`
+	SpanID       string `json:"span_id,omitempty"`
+	ParentSpanID string `json:"parent_span_id,omitempty"`
+	Timestamps   string `json:"timestamps,omitempty"`
+	Enums        string `json:"enums,omitempty"`
+}
+
+func (c *ValidationConfig) Enabled() bool {
+	return c != nil && (c.TraceID != "" || c.SpanID != "" || c.ParentSpanID != "" || c.Timestamps != "" || c.Enums != "")
+}
+
+func (c *ValidationConfig) policies() map[string]*string {
+	return map[string]*string{
+		"trace_id":       &c.TraceID,
+		"span_id":        &c.SpanID,
+		"parent_span_id": &c.ParentSpanID,
+		"timestamps":     &c.Timestamps,
+		"enums":          &c.Enums,
+	}
+}
+
+// ParseValidationConfig parses comma separated `rule:policy`, e.g. trace_id:drop,span_id:fix,timestamps:fix,enums:pass
+func ParseValidationConfig(s string) (*ValidationConfig, error) {
+	config := &ValidationConfig{}
+	policies := config.policies()
+	for _, part := range splitList(s) {
+		rule, policy, ok := strings.Cut(part, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid validation rule %q, expected rule:policy", part)
+		}
+		p, ok := policies[rule]
+		if !ok {
+			return nil, fmt.Errorf("unknown validation rule %q", rule)
+		}
+		*p = policy
+	}
+	return config, nil
+}
+
+type ValidationProcessor struct {
+	config      ValidationConfig
+	now         func() time.Time
+	selfMetrics *SelfMetrics
+}
+
+func NewValidationProcessor(config *ValidationConfig, selfMetrics *SelfMetrics) (*ValidationProcessor, error) {
+	p := &ValidationProcessor{config: *config, now: time.Now, selfMetrics: selfMetrics}
+	for rule, policy := range p.config.policies() {
+		switch *policy {
+		case "", ValidationPolicyPass, ValidationPolicyFix, ValidationPolicyDrop:
+		default:
+			return nil, fmt.Errorf("%s: unknown policy %q, expected pass, fix or drop", rule, *policy)
+		}
+	}
+	return p, nil
+}
+
+// violation counts the violation of the rule, and returns whether the item is kept.
+// fix repairs the item for fix policy, and returns false when the item can not be repaired.
+func (p *ValidationProcessor) violation(rule string, policy string, fix func() bool) bool {
+	p.selfMetrics.Add("validation.violations."+rule, 1)
+	switch policy {
+	case ValidationPolicyDrop:
+		p.selfMetrics.Add("validation.dropped."+rule, 1)
+		return false
+	case ValidationPolicyFix:
+		if fix == nil || !fix() {
+			p.selfMetrics.Add("validation.dropped."+rule, 1)
+			return false
+		}
+		p.selfMetrics.Add("validation.fixed."+rule, 1)
+	}
+	return true
+}
+
+func (p *ValidationProcessor) Process(_ context.Context, results []*PaseResult) ([]*PaseResult, error) {
+	for _, result := range results {
+		traceIDs := make(map[repairedIDKey][]byte)
+		FilterSpans(result, func(item *FilterItem) bool {
+			return p.validateSpan(item, traceIDs)
+		})
+		FilterLogRecords(result, func(item *FilterItem) bool {
+			return p.validateLogRecord(item.LogRecord)
+		})
+		if p.config.Enums != "" {
+			p.validateMetricEnums(result)
+		}
+		FilterDataPoints(result, func(item *FilterItem) bool {
+			return p.validateDataPoint(item.DataPoint)
+		})
+		PruneResult(result)
+	}
+	return results, nil
+}
+
+// repairedIDKey is the original invalid ID, and the resource for empty IDs.
+type repairedIDKey struct {
+	resource *resourcepb.Resource
+	id       string
+}
+
+// validateSpan validates the span of the item.
+// the repaired trace IDs are shared by the spans with the same invalid trace ID in the result, so the spans stay in the same trace.
+// empty trace IDs are shared in the resource.
+func (p *ValidationProcessor) validateSpan(item *FilterItem, traceIDs map[repairedIDKey][]byte) bool {
+	span := item.Span
+	if policy := p.config.TraceID; policy != "" && !validID(span.GetTraceId(), 16) {
+		if !p.violation("trace_id", policy, func() bool {
+			key := repairedIDKey{id: string(span.GetTraceId())}
+			if len(span.GetTraceId()) == 0 {
+				key.resource = item.Resource
+			}
+			repaired, ok := traceIDs[key]
+			if !ok {
+				repaired = repairID(span.GetTraceId(), 16)
+				traceIDs[key] = repaired
+			}
+			span.TraceId = slices.Clone(repaired)
+			return true
+		}) {
+			return false
+		}
+	}
+	if policy := p.config.SpanID; policy != "" && !validID(span.GetSpanId(), 8) {
+		if !p.violation("span_id", policy, func() bool {
+			span.SpanId = repairID(span.GetSpanId(), 8)
+			return true
+		}) {
+			return false
+		}
+	}
+	if policy := p.config.ParentSpanID; policy != "" && len(span.GetParentSpanId()) > 0 && !validID(span.GetParentSpanId(), 8) {
+		if !p.violation("parent_span_id", policy, func() bool {
+			span.ParentSpanId = repairOptionalID(span.GetParentSpanId(), 8)
+			return true
+		}) {
+			return false
+		}
+	}
+	if policy := p.config.Timestamps; policy != "" {
+		start, end := span.GetStartTimeUnixNano(), span.GetEndTimeUnixNano()
+		if start == 0 || end == 0 || end < start {
+			if !p.violation("timestamps", policy, func() bool {
+				switch {
+				case start == 0 && end == 0:
+					return false
+				case start == 0:
+					span.StartTimeUnixNano = end
+				case end < start:
+					span.EndTimeUnixNano = start
+				}
+				return true
+			}) {
+				return false
+			}
+		}
+	}
+	if policy := p.config.Enums; policy != "" {
+		if _, ok := tracepb.Span_SpanKind_name[int32(span.GetKind())]; !ok {
+			if !p.violation("enums", policy, func() bool {
+				span.Kind = tracepb.Span_SPAN_KIND_UNSPECIFIED
+				return true
+			}) {
+				return false
+			}
+		}
+		if _, ok := tracepb.Status_StatusCode_name[int32(span.GetStatus().GetCode())]; !ok {
+			if !p.violation("enums", policy, func() bool {
+				span.Status.Code = tracepb.Status_STATUS_CODE_UNSET
+				return true
+			}) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (p *ValidationProcessor) validateLogRecord(record *logspb.LogRecord) bool {
+	// trace and span IDs of log records are optional, so invalid IDs are fixed by clearing them when they can not be padded.
+	if policy := p.config.TraceID; policy != "" && len(record.GetTraceId()) > 0 && !validID(record.GetTraceId(), 16) {
+		if !p.violation("trace_id", policy, func() bool {
+			record.TraceId = repairOptionalID(record.GetTraceId(), 16)
+			return true
+		}) {
+			return false
+		}
+	}
+	if policy := p.config.SpanID; policy != "" && len(record.GetSpanId()) > 0 && !validID(record.GetSpanId(), 8) {
+		if !p.violation("span_id", policy, func() bool {
+			record.SpanId = repairOptionalID(record.GetSpanId(), 8)
+			return true
+		}) {
+			return false
+		}
+	}
+	if policy := p.config.Timestamps; policy != "" && record.GetTimeUnixNano() == 0 && record.GetObservedTimeUnixNano() == 0 {
+		if !p.violation("timestamps", policy, func() bool {
+			// the forwarder observes the log record now.
+			record.ObservedTimeUnixNano = uint64(p.now().UnixNano())
+			return true
+		}) {
+			return false
+		}
+	}
+	if policy := p.config.Enums; policy != "" {
+		if _, ok := logspb.SeverityNumber_name[int32(record.GetSeverityNumber())]; !ok {
+			if !p.violation("enums", policy, func() bool {
+				record.SeverityNumber = logspb.SeverityNumber_SEVERITY_NUMBER_UNSPECIFIED
+				return true
+			}) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (p *ValidationProcessor) validateDataPoint(dp dataPoint) bool {
+	policy := p.config.Timestamps
+	if policy == "" {
+		return true
+	}
+	if dp.GetTimeUnixNano() == 0 {
+		return p.violation("timestamps", policy, nil)
+	}
+	if dp.GetStartTimeUnixNano() > dp.GetTimeUnixNano() {
+		return p.violation("timestamps", policy, func() bool {
+			// zero start time means unknown.
+			setStartTimeUnixNano(dp.(protoDataPoint), 0)
+			return true
+		})
+	}
+	return true
+}
+
+// validateMetricEnums validates the aggregation temporality, and drops the metric by clearing its data points.
+func (p *ValidationProcessor) validateMetricEnums(result *PaseResult) {
+	for _, resourceMetrics := range result.Metrics.GetResourceMetrics() {
+		for _, scopeMetrics := range resourceMetrics.GetScopeMetrics() {
+			for _, metric := range scopeMetrics.GetMetrics() {
+				var temporality *metricspb.AggregationTemporality
+				switch data := metric.GetData().(type) {
+				case *metricspb.Metric_Sum:
+					temporality = &data.Sum.AggregationTemporality
+				case *metricspb.Metric_Histogram:
+					temporality = &data.Histogram.AggregationTemporality
+				case *metricspb.Metric_ExponentialHistogram:
+					temporality = &data.ExponentialHistogram.AggregationTemporality
+				}
+				if temporality == nil {
+					continue
+				}
+				if _, ok := metricspb.AggregationTemporality_name[int32(*temporality)]; ok {
+					continue
+				}
+				if !p.violation("enums", p.config.Enums, func() bool {
+					*temporality = metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_UNSPECIFIED
+					return true
+				}) {
+					filterMetricDataPoints(metric, func(dataPoint) bool { return false })
+				}
+			}
+		}
+	}
+}
+
+// validID reports whether the ID has the length and is not all zeros.
+func validID(id []byte, length int) bool {
+	return len(id) == length && !bytes.Equal(id, make([]byte, length))
+}
+
+// repairID pads short IDs with leading zeros, keeps the last bytes of long IDs, and regenerates empty or all zero IDs.
+func repairID(id []byte, length int) []byte {
+	repaired := repairOptionalID(id, length)
+	if repaired == nil {
+		repaired = make([]byte, length)
+		rand.Read(repaired)
+	}
+	return repaired
+}
+
+// repairOptionalID is the same as repairID, but returns nil for empty or all zero IDs.
+func repairOptionalID(id []byte, length int) []byte {
+	if len(id) > length {
+		id = id[len(id)-length:]
+	}
+	repaired := make([]byte, length)
+	copy(repaired[length-len(id):], id)
+	if bytes.Equal(repaired, make([]byte, length)) {
+		return nil
+	}
+	return repaired
+}
@@ -0,0 +1,147 @@
+package jsonlotelforwarder_test
+
+import (
+	"context"
+	"testing"
+
+	jsonlotelforwarder "github.com/mashiike/jsonl-otel-forwarder"
+	"github.com/stretchr/testify/require"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+func newValidationProcessor(t *testing.T, config string, selfMetrics *jsonlotelforwarder.SelfMetrics) jsonlotelforwarder.Processor {
+	t.Helper()
+	c, err := jsonlotelforwarder.ParseValidationConfig(config)
+	require.NoError(t, err)
+	p, err := jsonlotelforwarder.NewValidationProcessor(c, selfMetrics)
+	require.NoError(t, err)
+	return p
+}
+
+func TestValidationProcessor__Spans(t *testing.T) {
+	selfMetrics := jsonlotelforwarder.NewSelfMetrics()
+	p := newValidationProcessor(t, "trace_id:fix,span_id:fix,parent_span_id:fix,timestamps:fix,enums:fix", selfMetrics)
+	spanID := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	short := &tracepb.Span{Name: "short", TraceId: []byte{1, 2}, SpanId: spanID, StartTimeUnixNano: 100, EndTimeUnixNano: 200}
+	long := &tracepb.Span{Name: "long", TraceId: append([]byte{9, 9}, traceID(1)...), SpanId: spanID, ParentSpanId: make([]byte, 8), StartTimeUnixNano: 100, EndTimeUnixNano: 200}
+	missing := &tracepb.Span{Name: "missing", TraceId: traceID(1), StartTimeUnixNano: 0, EndTimeUnixNano: 200}
+	reversed := &tracepb.Span{Name: "reversed", TraceId: traceID(1), SpanId: spanID, StartTimeUnixNano: 300, EndTimeUnixNano: 200, Kind: 99, Status: &tracepb.Status{Code: 99}}
+	unfixable := &tracepb.Span{Name: "unfixable", TraceId: traceID(1), SpanId: spanID}
+
+	results, err := p.Process(context.Background(), []*jsonlotelforwarder.PaseResult{newTraceResult(short, long, missing, reversed, unfixable)})
+	require.NoError(t, err)
+	require.Equal(t, []string{"short", "long", "missing", "reversed"}, spanNames(results))
+
+	require.Equal(t, append(make([]byte, 14), 1, 2), short.GetTraceId(), "short IDs are padded with leading zeros")
+	require.Equal(t, traceID(1), long.GetTraceId(), "long IDs keep the last bytes")
+	require.Nil(t, long.GetParentSpanId(), "all zero parent span ID is cleared")
+	require.Len(t, missing.GetSpanId(), 8, "missing span ID is regenerated")
+	require.NotEqual(t, make([]byte, 8), missing.GetSpanId())
+	require.Equal(t, uint64(200), missing.GetStartTimeUnixNano())
+	require.Equal(t, uint64(300), reversed.GetEndTimeUnixNano())
+	require.Equal(t, tracepb.Span_SPAN_KIND_UNSPECIFIED, reversed.GetKind())
+	require.Equal(t, tracepb.Status_STATUS_CODE_UNSET, reversed.GetStatus().GetCode())
+
+	require.Equal(t, map[string]int64{
+		"validation.violations.trace_id":       2,
+		"validation.fixed.trace_id":            2,
+		"validation.violations.span_id":        1,
+		"validation.fixed.span_id":             1,
+		"validation.violations.parent_span_id": 1,
+		"validation.fixed.parent_span_id":      1,
+		"validation.violations.timestamps":     3,
+		"validation.fixed.timestamps":          2,
+		"validation.dropped.timestamps":        1,
+		"validation.violations.enums":          2,
+		"validation.fixed.enums":               2,
+	}, selfMetrics.Snapshot())
+}
+
+func TestValidationProcessor__RepairedTraceIDs(t *testing.T) {
+	p := newValidationProcessor(t, "trace_id:fix", jsonlotelforwarder.NewSelfMetrics())
+	zero1 := &tracepb.Span{Name: "zero1", TraceId: make([]byte, 16)}
+	zero2 := &tracepb.Span{Name: "zero2", TraceId: make([]byte, 16)}
+	empty1 := &tracepb.Span{Name: "empty1"}
+	empty2 := &tracepb.Span{Name: "empty2"}
+	other := &tracepb.Span{Name: "other", TraceId: make([]byte, 16)}
+	_, err := p.Process(context.Background(), []*jsonlotelforwarder.PaseResult{
+		newTraceResult(zero1, zero2, empty1, empty2),
+		newTraceResult(other),
+	})
+	require.NoError(t, err)
+	require.Len(t, zero1.GetTraceId(), 16)
+	require.NotEqual(t, make([]byte, 16), zero1.GetTraceId())
+	require.Equal(t, zero1.GetTraceId(), zero2.GetTraceId(), "the spans with the same invalid trace ID are kept in the same trace")
+	require.Len(t, empty1.GetTraceId(), 16)
+	require.Equal(t, empty1.GetTraceId(), empty2.GetTraceId(), "the spans without trace ID in the same resource are kept in the same trace")
+	require.NotEqual(t, zero1.GetTraceId(), empty1.GetTraceId())
+	require.NotEqual(t, zero1.GetTraceId(), other.GetTraceId(), "the trace IDs are repaired per result")
+}
+
+func TestValidationProcessor__DropAndPass(t *testing.T) {
+	selfMetrics := jsonlotelforwarder.NewSelfMetrics()
+	p := newValidationProcessor(t, "trace_id:drop,span_id:pass", selfMetrics)
+	results, err := p.Process(context.Background(), []*jsonlotelforwarder.PaseResult{newTraceResult(
+		&tracepb.Span{Name: "invalid-trace-id", TraceId: []byte{1}, SpanId: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		&tracepb.Span{Name: "invalid-span-id", TraceId: traceID(1), SpanId: []byte{1}},
+	)})
+	require.NoError(t, err)
+	require.Equal(t, []string{"invalid-span-id"}, spanNames(results))
+	require.Equal(t, []byte{1}, results[0].Traces.GetResourceSpans()[0].GetScopeSpans()[0].GetSpans()[0].GetSpanId(), "pass policy counts only")
+	require.Equal(t, map[string]int64{
+		"validation.violations.trace_id": 1,
+		"validation.dropped.trace_id":    1,
+		"validation.violations.span_id":  1,
+	}, selfMetrics.Snapshot())
+}
+
+func TestValidationProcessor__LogRecords(t *testing.T) {
+	p := newValidationProcessor(t, "trace_id:fix,span_id:fix,timestamps:fix,enums:fix", nil)
+	record := &logspb.LogRecord{TraceId: make([]byte, 16), SpanId: []byte{1, 2, 3}, SeverityNumber: 99}
+	result := &jsonlotelforwarder.PaseResult{
+		Logs: &logspb.LogsData{
+			ResourceLogs: []*logspb.ResourceLogs{
+				{ScopeLogs: []*logspb.ScopeLogs{{LogRecords: []*logspb.LogRecord{record}}}},
+			},
+		},
+	}
+	_, err := p.Process(context.Background(), []*jsonlotelforwarder.PaseResult{result})
+	require.NoError(t, err)
+	require.Nil(t, record.GetTraceId(), "all zero trace ID of log record is cleared")
+	require.Equal(t, []byte{0, 0, 0, 0, 0, 1, 2, 3}, record.GetSpanId())
+	require.NotZero(t, record.GetObservedTimeUnixNano(), "missing timestamps are observed now")
+	require.Equal(t, logspb.SeverityNumber_SEVERITY_NUMBER_UNSPECIFIED, record.GetSeverityNumber())
+}
+
+func TestValidationProcessor__DataPoints(t *testing.T) {
+	p := newValidationProcessor(t, "timestamps:fix", nil)
+	metric := processMetric(t, p, newSumMetric(
+		metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+		intDataPoint(100, 0, 1),
+		intDataPoint(300, 200, 2),
+		intDataPoint(100, 200, 3),
+	))
+	require.Equal(t, []sumPoint{{Start: 0, Time: 200, Value: 2}, {Start: 100, Time: 200, Value: 3}}, sumPoints(metric))
+
+	p = newValidationProcessor(t, "enums:drop", nil)
+	require.Nil(t, processMetric(t, p, newSumMetric(99, intDataPoint(100, 200, 1))), "metric with invalid temporality is dropped")
+
+	p = newValidationProcessor(t, "enums:fix", nil)
+	metric = processMetric(t, p, newSumMetric(99, intDataPoint(100, 200, 1)))
+	require.Equal(t, metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_UNSPECIFIED, metric.GetSum().GetAggregationTemporality())
+}
+
+func TestParseValidationConfig(t *testing.T) {
+	config, err := jsonlotelforwarder.ParseValidationConfig("trace_id:drop, timestamps:fix")
+	require.NoError(t, err)
+	require.Equal(t, &jsonlotelforwarder.ValidationConfig{TraceID: "drop", Timestamps: "fix"}, config)
+
+	for _, s := range []string{"trace_id", "unknown:fix"} {
+		_, err := jsonlotelforwarder.ParseValidationConfig(s)
+		require.Error(t, err, s)
+	}
+	_, err = jsonlotelforwarder.NewValidationProcessor(&jsonlotelforwarder.ValidationConfig{TraceID: "repair"}, nil)
+	require.Error(t, err)
+}
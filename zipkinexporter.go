@@ -0,0 +1,230 @@
+package jsonlotelforwarder
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// ZipkinExporterConfig is the configuration of the exporter that sends traces as Zipkin v2 JSON spans.
+type ZipkinExporterConfig struct {
+	Endpoint string
+	Headers  map[string]string
+	Timeout  time.Duration
+	Gzip     bool
+	// HTTPClient overrides the HTTP client, Timeout is ignored when set.
+	HTTPClient *http.Client
+}
+
+// ZipkinExporter converts traces to Zipkin v2 spans, and posts them to the Zipkin API, or the Zipkin compatible endpoint of Jaeger collectors.
+// metrics and logs are ignored.
+type ZipkinExporter struct {
+	config ZipkinExporterConfig
+	client *http.Client
+}
+
+func NewZipkinExporter(config *ZipkinExporterConfig) (*ZipkinExporter, error) {
+	if config.Endpoint == "" {
+		return nil, fmt.Errorf("endpoint is required")
+	}
+	return &ZipkinExporter{config: *config, client: newHTTPExportClient(config.HTTPClient, config.Timeout)}, nil
+}
+
+func (e *ZipkinExporter) Start(_ context.Context) error {
+	return nil
+}
+
+func (e *ZipkinExporter) Stop(_ context.Context) error {
+	return nil
+}
+
+func (e *ZipkinExporter) Export(ctx context.Context, result *PaseResult) error {
+	if result.Traces == nil {
+		return nil
+	}
+	spans := ToZipkinSpans(result.Traces)
+	if len(spans) == 0 {
+		slog.DebugContext(ctx, "no spans to post to zipkin")
+		return nil
+	}
+	bs, err := json.Marshal(spans)
+	if err != nil {
+		return &permanentError{err: fmt.Errorf("marshal zipkin spans: %w", err)}
+	}
+	body, err := newHTTPExportBody(bs, e.config.Gzip)
+	if err != nil {
+		return &permanentError{err: fmt.Errorf("gzip zipkin spans: %w", err)}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.config.Endpoint, body)
+	if err != nil {
+		return &permanentError{err: fmt.Errorf("create zipkin request: %w", err)}
+	}
+	for key, value := range e.config.Headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.config.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	req.Header.Set("User-Agent", "jsonl-otel-forwarder/"+Version)
+	slog.InfoContext(ctx, "post spans to zipkin", "spans", len(spans), "bytes", body.Len(), "trace_ids", distinctListTraceIDs(result.Traces.GetResourceSpans()))
+	if err := doHTTPExport(e.client, req); err != nil {
+		return fmt.Errorf("zipkin: %w", err)
+	}
+	return nil
+}
+
+func newNamedZipkinExporter(c *ExporterConfig) (*namedExporter, error) {
+	for _, signal := range c.Signals {
+		if !containsSignal([]string{"traces"}, signal) {
+			return nil, fmt.Errorf("unsupported signal %q, only traces are sent to zipkin", signal)
+		}
+	}
+	timeout, err := parseExporterTimeout(c)
+	if err != nil {
+		return nil, err
+	}
+	config := &ZipkinExporterConfig{
+		Endpoint: os.ExpandEnv(c.Endpoint),
+		Headers:  expandHeaders(c.Headers),
+		Timeout:  timeout,
+		Gzip:     c.Gzip,
+	}
+	if _, err := NewZipkinExporter(config); err != nil {
+		return nil, err
+	}
+	retry, err := newRetryPolicy(c.Retry)
+	if err != nil {
+		return nil, fmt.Errorf("retry: %w", err)
+	}
+	return &namedExporter{
+		name:    c.Name,
+		signals: []string{"traces"},
+		retry:   retry,
+		new: func() (Exporter, error) {
+			return NewZipkinExporter(config)
+		},
+	}, nil
+}
+
+// ZipkinSpan is a span of the Zipkin v2 API. Timestamp and Duration are in microseconds.
+type ZipkinSpan struct {
+	TraceID        string             `json:"traceId"`
+	ID             string             `json:"id"`
+	ParentID       string             `json:"parentId,omitempty"`
+	Name           string             `json:"name,omitempty"`
+	Kind           string             `json:"kind,omitempty"`
+	Timestamp      uint64             `json:"timestamp,omitempty"`
+	Duration       uint64             `json:"duration,omitempty"`
+	LocalEndpoint  *ZipkinEndpoint    `json:"localEndpoint,omitempty"`
+	RemoteEndpoint *ZipkinEndpoint    `json:"remoteEndpoint,omitempty"`
+	Annotations    []ZipkinAnnotation `json:"annotations,omitempty"`
+	Tags           map[string]string  `json:"tags,omitempty"`
+}
+
+type ZipkinEndpoint struct {
+	ServiceName string `json:"serviceName,omitempty"`
+}
+
+type ZipkinAnnotation struct {
+	Timestamp uint64 `json:"timestamp"`
+	Value     string `json:"value"`
+}
+
+var zipkinKinds = map[tracepb.Span_SpanKind]string{
+	tracepb.Span_SPAN_KIND_SERVER:   "SERVER",
+	tracepb.Span_SPAN_KIND_CLIENT:   "CLIENT",
+	tracepb.Span_SPAN_KIND_PRODUCER: "PRODUCER",
+	tracepb.Span_SPAN_KIND_CONSUMER: "CONSUMER",
+}
+
+// ToZipkinSpans converts traces to Zipkin v2 spans, as the OpenTelemetry specification of the Zipkin exporter.
+// the local endpoint is service.name of the resource, and the remote endpoint of client and producer spans is peer.service.
+// the resource, scope and span attributes are tags, and events are annotations. links are dropped.
+func ToZipkinSpans(traces *tracepb.TracesData) []*ZipkinSpan {
+	var spans []*ZipkinSpan
+	for _, resourceSpans := range traces.GetResourceSpans() {
+		resource := resourceSpans.GetResource()
+		localEndpoint := &ZipkinEndpoint{ServiceName: resourceServiceName(resource)}
+		for _, scopeSpans := range resourceSpans.GetScopeSpans() {
+			scope := scopeSpans.GetScope()
+			for _, span := range scopeSpans.GetSpans() {
+				z := &ZipkinSpan{
+					TraceID:       hex.EncodeToString(span.GetTraceId()),
+					ID:            hex.EncodeToString(span.GetSpanId()),
+					ParentID:      hex.EncodeToString(span.GetParentSpanId()),
+					Name:          span.GetName(),
+					Kind:          zipkinKinds[span.GetKind()],
+					Timestamp:     span.GetStartTimeUnixNano() / uint64(time.Microsecond),
+					LocalEndpoint: localEndpoint,
+					Tags:          make(map[string]string),
+				}
+				if end, start := span.GetEndTimeUnixNano(), span.GetStartTimeUnixNano(); end > start {
+					// Zipkin requires at least 1 microsecond for spans shorter than it.
+					z.Duration = max((end-start)/uint64(time.Microsecond), 1)
+				}
+				for _, attr := range resource.GetAttributes() {
+					if attr.GetKey() != "service.name" {
+						z.Tags[attr.GetKey()] = AnyValueString(attr.GetValue())
+					}
+				}
+				if scope.GetName() != "" {
+					z.Tags["otel.scope.name"] = scope.GetName()
+				}
+				if scope.GetVersion() != "" {
+					z.Tags["otel.scope.version"] = scope.GetVersion()
+				}
+				for _, attr := range span.GetAttributes() {
+					z.Tags[attr.GetKey()] = AnyValueString(attr.GetValue())
+				}
+				switch span.GetStatus().GetCode() {
+				case tracepb.Status_STATUS_CODE_OK:
+					z.Tags["otel.status_code"] = "OK"
+				case tracepb.Status_STATUS_CODE_ERROR:
+					z.Tags["otel.status_code"] = "ERROR"
+					z.Tags["error"] = span.GetStatus().GetMessage()
+				}
+				if z.Kind == "CLIENT" || z.Kind == "PRODUCER" {
+					if peer, ok := lookupAttribute(span.GetAttributes(), "peer.service"); ok && peer != "" {
+						z.RemoteEndpoint = &ZipkinEndpoint{ServiceName: peer}
+					}
+				}
+				for _, event := range span.GetEvents() {
+					z.Annotations = append(z.Annotations, ZipkinAnnotation{
+						Timestamp: event.GetTimeUnixNano() / uint64(time.Microsecond),
+						Value:     zipkinAnnotationValue(event),
+					})
+				}
+				if len(z.Tags) == 0 {
+					z.Tags = nil
+				}
+				spans = append(spans, z)
+			}
+		}
+	}
+	return spans
+}
+
+// zipkinAnnotationValue returns the event name, or `"name":{attributes}` for the event with attributes.
+func zipkinAnnotationValue(event *tracepb.Span_Event) string {
+	if len(event.GetAttributes()) == 0 {
+		return event.GetName()
+	}
+	attrs := make(map[string]any, len(event.GetAttributes()))
+	for _, attr := range event.GetAttributes() {
+		attrs[attr.GetKey()] = anyValueToInterface(attr.GetValue())
+	}
+	bs, err := json.Marshal(map[string]any{event.GetName(): attrs})
+	if err != nil {
+		return event.GetName()
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(string(bs), "{"), "}")
+}
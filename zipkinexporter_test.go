@@ -0,0 +1,98 @@
+package jsonlotelforwarder_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	jsonlotelforwarder "github.com/mashiike/jsonl-otel-forwarder"
+	"github.com/stretchr/testify/require"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+func TestToZipkinSpans(t *testing.T) {
+	traces := loadParseResults(t, "testdata/trace.json")[0].Traces
+	span := traces.GetResourceSpans()[0].GetScopeSpans()[0].GetSpans()[0]
+	span.Kind = tracepb.Span_SPAN_KIND_CLIENT
+	span.Attributes = append(span.Attributes, stringAttr("peer.service", "payment"))
+	span.Status = &tracepb.Status{Code: tracepb.Status_STATUS_CODE_ERROR, Message: "timeout"}
+	span.Events = []*tracepb.Span_Event{
+		{TimeUnixNano: 1544712660500000000, Name: "retry"},
+		{TimeUnixNano: 1544712660600000000, Name: "exception", Attributes: []*commonpb.KeyValue{stringAttr("exception.type", "Timeout")}},
+	}
+
+	spans := jsonlotelforwarder.ToZipkinSpans(traces)
+	require.Len(t, spans, 1)
+	require.Equal(t, &jsonlotelforwarder.ZipkinSpan{
+		TraceID:        "5b8efff798038103d269b633813fc60c",
+		ID:             "eee19b7ec3c1b174",
+		ParentID:       "eee19b7ec3c1b173",
+		Name:           "I'm a server span",
+		Kind:           "CLIENT",
+		Timestamp:      1544712660000000,
+		Duration:       1000000,
+		LocalEndpoint:  &jsonlotelforwarder.ZipkinEndpoint{ServiceName: "my.service"},
+		RemoteEndpoint: &jsonlotelforwarder.ZipkinEndpoint{ServiceName: "payment"},
+		Annotations: []jsonlotelforwarder.ZipkinAnnotation{
+			{Timestamp: 1544712660500000, Value: "retry"},
+			{Timestamp: 1544712660600000, Value: `"exception":{"exception.type":"Timeout"}`},
+		},
+		Tags: map[string]string{
+			"my.span.attr":       "some value",
+			"peer.service":       "payment",
+			"otel.scope.name":    "my.library",
+			"otel.scope.version": "1.0.0",
+			"otel.status_code":   "ERROR",
+			"error":              "timeout",
+		},
+	}, spans[0])
+}
+
+func TestForwarder__ZipkinExporter(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests int
+		posted   [][]*jsonlotelforwarder.ZipkinSpan
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if requests == 1 {
+			http.Error(w, "unavailable", http.StatusBadGateway)
+			return
+		}
+		require.Equal(t, "/api/v2/spans", r.URL.Path)
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		var spans []*jsonlotelforwarder.ZipkinSpan
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&spans))
+		posted = append(posted, spans)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	opts := jsonlotelforwarder.DefaultOptions()
+	opts.Batch = true
+	opts.ConfigFile = writeConfig(t, map[string]any{
+		"exporters": []map[string]any{
+			{"name": "zipkin", "type": "zipkin", "endpoint": server.URL + "/api/v2/spans", "retry": map[string]any{"initial_interval": "1ms"}},
+		},
+	})
+	forwarder, err := jsonlotelforwarder.New(opts)
+	require.NoError(t, err)
+	trace, err := os.ReadFile("testdata/trace.json")
+	require.NoError(t, err)
+	logs, err := os.ReadFile("testdata/logs.json")
+	require.NoError(t, err)
+	_, err = forwarder.Invoke(context.Background(), EncodeSubscriptionFilterEvent(t, [][]byte{trace, logs, trace}))
+	require.NoError(t, err)
+
+	require.Equal(t, 2, requests, "the failed post is retried, and logs are not sent")
+	require.Len(t, posted, 1, "the traces of log events are batched")
+	require.Len(t, posted[0], 2)
+}